@@ -16,9 +16,93 @@ const docTemplate_swagger = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/admin/dead-letters/": {
+            "get": {
+                "description": "List the messages their handlers kept failing on",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List the dead letters",
+                "operationId": "get-dead-letters",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/deadletter.DeadLetter"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/deadletter.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/dead-letters/{id}/replay": {
+            "post": {
+                "description": "Publish the dead letter to its original topic again to reprocess it",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Replay a dead letter",
+                "operationId": "replay-dead-letter",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Dead Letter ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/deadletter.DeadLetter"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/deadletter.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/deadletter.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/deadletter.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
         "/articles/": {
             "get": {
-                "description": "Get all articles",
+                "description": "Get a page of the articles, the Link and X-Next-Cursor headers point to the next page",
                 "consumes": [
                     "application/json"
                 ],
@@ -28,8 +112,52 @@ const docTemplate_swagger = `{
                 "tags": [
                     "articles"
                 ],
-                "summary": "Get all articles",
+                "summary": "Get a page of the articles",
                 "operationId": "list-articles",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Number of articles in the page, 50 by default and 500 at most",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the page, the X-Next-Cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated fields to sort by, descending when prefixed with -",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name contains, case insensitively",
+                        "name": "name~",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Stock is less than, _lte, _gt and _gte are alike",
+                        "name": "stock_lt",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Articles kept in the warehouse",
+                        "name": "warehouse_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 date the articles are created after, created_before is alike",
+                        "name": "created_after",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -38,6 +166,28 @@ const docTemplate_swagger = `{
                             "items": {
                                 "$ref": "#/definitions/article.Article"
                             }
+                        },
+                        "headers": {
+                            "Link": {
+                                "type": "string",
+                                "description": "Link to the next page"
+                            },
+                            "X-Next-Cursor": {
+                                "type": "string",
+                                "description": "Cursor of the next page"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/article.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/article.ErrorResponse"
                         }
                     }
                 }
@@ -222,9 +372,9 @@ const docTemplate_swagger = `{
                 }
             }
         },
-        "/orders/": {
+        "/articles/{id}/movements": {
             "get": {
-                "description": "Get all orders",
+                "description": "Get the stock movements of an article in the order they happened, optionally within [from, to)",
                 "consumes": [
                     "application/json"
                 ],
@@ -232,24 +382,53 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "orders"
+                    "articles"
+                ],
+                "summary": "Get the stock movements of an article",
+                "operationId": "list-article-movements",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Article ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 date the movements start from",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 date the movements end before",
+                        "name": "to",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Get all orders",
-                "operationId": "list-orders",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/order.Order"
+                                "$ref": "#/definitions/article.StockMovement"
                             }
                         }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/article.ErrorResponse"
+                        }
                     }
                 }
-            },
-            "post": {
-                "description": "Create a order with given data",
+            }
+        },
+        "/articles/{id}/stock": {
+            "put": {
+                "description": "Set the stock of an article in a warehouse, the article's total stock is adjusted by the difference",
                 "consumes": [
                     "application/json"
                 ],
@@ -257,18 +436,25 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "orders"
+                    "articles"
                 ],
-                "summary": "Create a order with given data",
-                "operationId": "create-order",
+                "summary": "Set the stock of an article in a warehouse",
+                "operationId": "set-article-warehouse-stock",
                 "parameters": [
                     {
-                        "description": "Order",
-                        "name": "order",
+                        "type": "integer",
+                        "description": "Article ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Warehouse stock",
+                        "name": "stock",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/order.RequestBody"
+                            "$ref": "#/definitions/article.WarehouseStockRequestBody"
                         }
                     }
                 ],
@@ -276,90 +462,124 @@ const docTemplate_swagger = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/order.Order"
+                            "$ref": "#/definitions/article.WarehouseStock"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/order.ErrorResponse"
+                            "$ref": "#/definitions/article.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/article.ErrorResponse"
                         }
                     }
                 }
             }
         },
-        "/orders/{id}": {
+        "/exports/{resource}": {
             "get": {
-                "description": "Get single order by id",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Download all the records of the listing as a CSV or XLSX file, the listing filters such as name~, status or created_after select the records. The articles come with their reserved and available inventory, the products with their sellable inventory and the orders with their total cost",
                 "produces": [
-                    "application/json"
+                    "text/csv",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
                 ],
                 "tags": [
-                    "orders"
+                    "exports"
                 ],
-                "summary": "Get single order by id",
-                "operationId": "get-order",
+                "summary": "Export the articles, the products or the orders",
+                "operationId": "export-resource",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "Order ID",
-                        "name": "id",
+                        "enum": [
+                            "articles",
+                            "products",
+                            "orders"
+                        ],
+                        "type": "string",
+                        "description": "Listing to export",
+                        "name": "resource",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "enum": [
+                            "csv",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "Format of the file, csv by default",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated fields to sort by, descending when prefixed with -",
+                        "name": "sort",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/order.Order"
+                            "type": "file"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/order.ErrorResponse"
+                            "$ref": "#/definitions/exporter.ErrorResponse"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/order.ErrorResponse"
+                            "$ref": "#/definitions/exporter.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/exporter.ErrorResponse"
                         }
                     }
                 }
-            },
-            "put": {
-                "description": "Update a order with given data",
+            }
+        },
+        "/imports/inventory": {
+            "post": {
+                "description": "Create the articles of a JSON or CSV inventory file and update the existing ones by their art_id in a single transaction, the stock of the file is set as their stock in the warehouse",
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "text/csv"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "orders"
+                    "imports"
                 ],
-                "summary": "Update a order with given data",
-                "operationId": "update-order",
+                "summary": "Import the articles of an inventory file",
+                "operationId": "import-inventory",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Order ID",
-                        "name": "id",
-                        "in": "path",
+                        "description": "Warehouse the stock of the file is in",
+                        "name": "warehouse_id",
+                        "in": "query",
                         "required": true
                     },
                     {
-                        "description": "Order",
-                        "name": "order",
+                        "description": "Inventory file with the inventory array of the art_id, name and stock fields, or CSV with these columns",
+                        "name": "file",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/order.RequestBody"
+                            "type": "string"
                         }
                     }
                 ],
@@ -367,70 +587,75 @@ const docTemplate_swagger = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/order.Order"
+                            "$ref": "#/definitions/importer.Report"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/order.ErrorResponse"
+                            "$ref": "#/definitions/importer.ErrorResponse"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
-                            "$ref": "#/definitions/order.ErrorResponse"
+                            "$ref": "#/definitions/importer.ErrorResponse"
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Delete a order by id",
+            }
+        },
+        "/imports/products": {
+            "post": {
+                "description": "Create the products of a JSON or CSV products file and update the existing ones by their prod_id, or their name, in a single transaction",
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "text/csv"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "orders"
+                    "imports"
                 ],
-                "summary": "Delete a order by id",
-                "operationId": "delete-order",
+                "summary": "Import the products of a products file",
+                "operationId": "import-products",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "Order ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Products file with the products array of the prod_id, name, price and contain_articles fields, or CSV with the prod_id, name, price, art_id and amount_of columns",
+                        "name": "file",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "NoContent",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/importer.Report"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/order.ErrorResponse"
+                            "$ref": "#/definitions/importer.ErrorResponse"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
-                            "$ref": "#/definitions/order.ErrorResponse"
+                            "$ref": "#/definitions/importer.ErrorResponse"
                         }
                     }
                 }
             }
         },
-        "/products/": {
+        "/orders/": {
             "get": {
-                "description": "Get all products",
+                "description": "Get a page of the orders, the Link and X-Next-Cursor headers point to the next page",
                 "consumes": [
                     "application/json"
                 ],
@@ -438,43 +663,109 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "products"
+                    "orders"
+                ],
+                "summary": "Get a page of the orders",
+                "operationId": "list-orders",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Number of orders in the page, 50 by default and 500 at most",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the page, the X-Next-Cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated fields to sort by, descending when prefixed with -",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Orders of the warehouse",
+                        "name": "warehouse_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Orders in the status, repeated values match any of them",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Customer contains, case insensitively",
+                        "name": "customer~",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 date the orders are created after, created_before is alike",
+                        "name": "created_after",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Get all products",
-                "operationId": "list-products",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/product.ProductArticle"
+                                "$ref": "#/definitions/order.Order"
+                            }
+                        },
+                        "headers": {
+                            "Link": {
+                                "type": "string",
+                                "description": "Link to the next page"
+                            },
+                            "X-Next-Cursor": {
+                                "type": "string",
+                                "description": "Cursor of the next page"
                             }
                         }
-                    }
-                }
-            },
-            "post": {
-                "description": "Create a article with given data",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/order.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/order.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a order with given data",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "products"
+                    "orders"
                 ],
-                "summary": "Create a article with given data",
-                "operationId": "create-product",
+                "summary": "Create a order with given data",
+                "operationId": "create-order",
                 "parameters": [
                     {
-                        "description": "Product",
-                        "name": "article",
+                        "description": "Order",
+                        "name": "order",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/product.ProductRequestBody"
+                            "$ref": "#/definitions/order.RequestBody"
                         }
                     }
                 ],
@@ -482,21 +773,21 @@ const docTemplate_swagger = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/product.Product"
+                            "$ref": "#/definitions/order.Order"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/product.ErrorResponse"
+                            "$ref": "#/definitions/order.ErrorResponse"
                         }
                     }
                 }
             }
         },
-        "/products/{id}": {
+        "/orders/{id}": {
             "get": {
-                "description": "Get single product by id",
+                "description": "Get single order by id",
                 "consumes": [
                     "application/json"
                 ],
@@ -504,14 +795,14 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "products"
+                    "orders"
                 ],
-                "summary": "Get single product by id",
-                "operationId": "get-product",
+                "summary": "Get single order by id",
+                "operationId": "get-order",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Product ID",
+                        "description": "Order ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -521,25 +812,25 @@ const docTemplate_swagger = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/product.Product"
+                            "$ref": "#/definitions/order.Order"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/product.ErrorResponse"
+                            "$ref": "#/definitions/order.ErrorResponse"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/product.ErrorResponse"
+                            "$ref": "#/definitions/order.ErrorResponse"
                         }
                     }
                 }
             },
             "put": {
-                "description": "Update a product with given data",
+                "description": "Update a order with given data, the increased quantities of a confirmed or picking order are reserved in its warehouse",
                 "consumes": [
                     "application/json"
                 ],
@@ -547,25 +838,25 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "products"
+                    "orders"
                 ],
-                "summary": "Update a product with given data",
-                "operationId": "update-product",
+                "summary": "Update a order with given data",
+                "operationId": "update-order",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Product ID",
+                        "description": "Order ID",
                         "name": "id",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "Product",
-                        "name": "article",
+                        "description": "Order",
+                        "name": "order",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/product.ProductRequestBody"
+                            "$ref": "#/definitions/order.RequestBody"
                         }
                     }
                 ],
@@ -573,25 +864,31 @@ const docTemplate_swagger = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/product.Product"
+                            "$ref": "#/definitions/order.Order"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/product.ErrorResponse"
+                            "$ref": "#/definitions/order.ErrorResponse"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/product.ErrorResponse"
+                            "$ref": "#/definitions/order.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/order.StockErrorResponse"
                         }
                     }
                 }
             },
             "delete": {
-                "description": "Delete a product by id",
+                "description": "Delete a order by id",
                 "consumes": [
                     "application/json"
                 ],
@@ -599,14 +896,14 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "products"
+                    "orders"
                 ],
-                "summary": "Delete a product by id",
-                "operationId": "delete-product",
+                "summary": "Delete a order by id",
+                "operationId": "delete-order",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Product ID",
+                        "description": "Order ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -622,21 +919,27 @@ const docTemplate_swagger = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/product.ErrorResponse"
+                            "$ref": "#/definitions/order.ErrorResponse"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/product.ErrorResponse"
+                            "$ref": "#/definitions/order.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/order.ErrorResponse"
                         }
                     }
                 }
             }
         },
-        "/warehouses/": {
-            "get": {
-                "description": "Get all warehouses",
+        "/orders/{id}/cancel": {
+            "post": {
+                "description": "Cancel an order which is not shipped yet, its reserved stock is released",
                 "consumes": [
                     "application/json"
                 ],
@@ -644,24 +947,50 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "warehouses"
+                    "orders"
+                ],
+                "summary": "Cancel an order",
+                "operationId": "cancel-order",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "Get all warehouses",
-                "operationId": "list-warehouse",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/warehouse.Warehouse"
-                            }
+                            "$ref": "#/definitions/order.Order"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/order.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/order.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/order.ErrorResponse"
                         }
                     }
                 }
-            },
+            }
+        },
+        "/orders/{id}/confirm": {
             "post": {
-                "description": "Create a warehouse with given data",
+                "description": "Confirm a draft order, the stock of its lines is reserved in the order's warehouse",
                 "consumes": [
                     "application/json"
                 ],
@@ -669,40 +998,50 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "warehouses"
+                    "orders"
                 ],
-                "summary": "Create a warehouse with given data",
-                "operationId": "create-warehouse",
+                "summary": "Confirm a draft order and reserve its stock",
+                "operationId": "confirm-order",
                 "parameters": [
                     {
-                        "description": "Warehouse",
-                        "name": "warehouse",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/warehouse.RequestBody"
-                        }
+                        "type": "integer",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/warehouse.Warehouse"
+                            "$ref": "#/definitions/order.Order"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                            "$ref": "#/definitions/order.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/order.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/order.StockErrorResponse"
                         }
                     }
                 }
             }
         },
-        "/warehouses/{id}": {
-            "get": {
-                "description": "Get single warehouse by id",
+        "/orders/{id}/deliver": {
+            "post": {
+                "description": "Deliver a shipped order",
                 "consumes": [
                     "application/json"
                 ],
@@ -710,14 +1049,14 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "warehouses"
+                    "orders"
                 ],
-                "summary": "Get single warehouse by id",
-                "operationId": "get-warehouse",
+                "summary": "Deliver a shipped order",
+                "operationId": "deliver-order",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Warehouse ID",
+                        "description": "Order ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -727,25 +1066,33 @@ const docTemplate_swagger = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/warehouse.Warehouse"
+                            "$ref": "#/definitions/order.Order"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                            "$ref": "#/definitions/order.ErrorResponse"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                            "$ref": "#/definitions/order.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/order.ErrorResponse"
                         }
                     }
                 }
-            },
-            "put": {
-                "description": "Update a warehouse with given data",
+            }
+        },
+        "/orders/{id}/pick": {
+            "post": {
+                "description": "Start picking a confirmed order",
                 "consumes": [
                     "application/json"
                 ],
@@ -753,51 +1100,50 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "warehouses"
+                    "orders"
                 ],
-                "summary": "Update a warehouse with given data",
-                "operationId": "update-warehouse",
+                "summary": "Start picking a confirmed order",
+                "operationId": "pick-order",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Warehouse ID",
+                        "description": "Order ID",
                         "name": "id",
                         "in": "path",
                         "required": true
-                    },
-                    {
-                        "description": "Warehouse",
-                        "name": "warehouse",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/warehouse.RequestBody"
-                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/warehouse.Warehouse"
+                            "$ref": "#/definitions/order.Order"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                            "$ref": "#/definitions/order.ErrorResponse"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                            "$ref": "#/definitions/order.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/order.ErrorResponse"
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Delete a warehouse by id",
+            }
+        },
+        "/orders/{id}/return": {
+            "post": {
+                "description": "Return a shipped or delivered order, its stock is increased back",
                 "consumes": [
                     "application/json"
                 ],
@@ -805,92 +1151,1907 @@ const docTemplate_swagger = `{
                     "application/json"
                 ],
                 "tags": [
-                    "warehouses"
+                    "orders"
                 ],
-                "summary": "Delete a warehouse by id",
-                "operationId": "delete-warehouse",
+                "summary": "Return a shipped or delivered order",
+                "operationId": "return-order",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Warehouse ID",
+                        "description": "Order ID",
                         "name": "id",
                         "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "NoContent",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/order.Order"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                            "$ref": "#/definitions/order.ErrorResponse"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                            "$ref": "#/definitions/order.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/order.ErrorResponse"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "article.Article": {
-            "type": "object",
-            "properties": {
-                "amount_of": {
-                    "type": "integer"
-                },
-                "available_inventory": {
-                    "type": "integer"
-                },
-                "created_at": {
-                    "type": "string"
-                },
-                "id": {
-                    "type": "integer"
+        },
+        "/orders/{id}/ship": {
+            "post": {
+                "description": "Ship a confirmed or picking order, its reserved stock is consumed",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Ship an order",
+                "operationId": "ship-order",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/order.Order"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/order.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/order.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/order.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/": {
+            "get": {
+                "description": "Get a page of the products, the Link and X-Next-Cursor headers point to the next page",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Get a page of the products",
+                "operationId": "list-products",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Number of products in the page, 50 by default and 500 at most",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the page, the X-Next-Cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated fields to sort by, descending when prefixed with -",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name contains, case insensitively",
+                        "name": "name~",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Price is less than, _lte, _gt and _gte are alike",
+                        "name": "price_lt",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 date the products are created after, created_before is alike",
+                        "name": "created_after",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/product.ProductArticle"
+                            }
+                        },
+                        "headers": {
+                            "Link": {
+                                "type": "string",
+                                "description": "Link to the next page"
+                            },
+                            "X-Next-Cursor": {
+                                "type": "string",
+                                "description": "Cursor of the next page"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a article with given data",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Create a article with given data",
+                "operationId": "create-product",
+                "parameters": [
+                    {
+                        "description": "Product",
+                        "name": "article",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/product.ProductRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/product.Product"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/sell": {
+            "post": {
+                "description": "Sell the products of the basket in a single transaction, either all of them are sold or none when the warehouse stock can't serve one of them",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Sell a basket of products from a warehouse without an order",
+                "operationId": "sell-basket",
+                "parameters": [
+                    {
+                        "description": "Basket",
+                        "name": "basket",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/product.BasketRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/product.Product"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/product.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/product.StockErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}": {
+            "get": {
+                "description": "Get single product by id",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Get single product by id",
+                "operationId": "get-product",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Calculate sellable inventory for the given warehouse",
+                        "name": "warehouse_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/product.Product"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/product.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Update a product with given data",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Update a product with given data",
+                "operationId": "update-product",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Product",
+                        "name": "article",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/product.ProductRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/product.Product"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/product.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a product by id, a product used as a component of other products can't be deleted",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Delete a product by id",
+                "operationId": "delete-product",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "NoContent",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/product.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/product.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}/sell": {
+            "post": {
+                "description": "Decrease the warehouse stock of the product's articles by the sold quantity and record it as a sale, the product is returned with its articles and components and its sellable inventory in the warehouse after the sale. A product without articles can't be sold",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Sell a product from a warehouse without an order",
+                "operationId": "sell-product",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Sale",
+                        "name": "sale",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/product.SaleRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/product.Product"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/product.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/product.StockErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/reservations/": {
+            "post": {
+                "description": "Hold the stock of a product in a warehouse for ttl seconds without decreasing it",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservations"
+                ],
+                "summary": "Hold the stock of a product for a while",
+                "operationId": "create-reservation",
+                "parameters": [
+                    {
+                        "description": "Reservation",
+                        "name": "reservation",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/reservation.RequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/reservation.Reservation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/reservation.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/reservation.StockErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/reservations/{id}": {
+            "get": {
+                "description": "Get single reservation by id",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservations"
+                ],
+                "summary": "Get single reservation by id",
+                "operationId": "get-reservation",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Reservation ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/reservation.Reservation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/reservation.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/reservation.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Release an active reservation, the stock it holds is available again",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservations"
+                ],
+                "summary": "Release an active reservation",
+                "operationId": "release-reservation",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Reservation ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/reservation.Reservation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/reservation.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/reservation.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/reservation.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/reservations/{id}/convert": {
+            "post": {
+                "description": "Add the reserved product to a draft order of the same warehouse at its price, the held stock moves to the order",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservations"
+                ],
+                "summary": "Convert an active reservation into an order line",
+                "operationId": "convert-reservation",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Reservation ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Order",
+                        "name": "order",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/reservation.ConvertRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/reservation.Reservation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/reservation.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/reservation.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/reservation.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/stream/inventory": {
+            "get": {
+                "description": "Stream the article stock and product sellable inventory changes as Server-Sent Events,\nthe article_stock events hold the stock level of an article and the product_inventory\nevents hold the sellable inventory of a product. Streams resume after the Last-Event-ID\nwith the latest 1000 events the instance keeps in memory, they are lost when it restarts.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "stream"
+                ],
+                "summary": "Stream the inventory changes",
+                "operationId": "stream-inventory",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Product ID",
+                        "name": "product_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Article ID",
+                        "name": "article_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Warehouse ID",
+                        "name": "warehouse_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID of the last received event",
+                        "name": "Last-Event-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/inventory.ProductInventory"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/inventory.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/warehouses/": {
+            "get": {
+                "description": "Get a page of the warehouses, the Link and X-Next-Cursor headers point to the next page",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "warehouses"
+                ],
+                "summary": "Get a page of the warehouses",
+                "operationId": "list-warehouse",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Number of warehouses in the page, 50 by default and 500 at most",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the page, the X-Next-Cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated fields to sort by, descending when prefixed with -",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name contains, case insensitively",
+                        "name": "name~",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 date the warehouses are created after, created_before is alike",
+                        "name": "created_after",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/warehouse.Warehouse"
+                            }
+                        },
+                        "headers": {
+                            "Link": {
+                                "type": "string",
+                                "description": "Link to the next page"
+                            },
+                            "X-Next-Cursor": {
+                                "type": "string",
+                                "description": "Cursor of the next page"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a warehouse with given data",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "warehouses"
+                ],
+                "summary": "Create a warehouse with given data",
+                "operationId": "create-warehouse",
+                "parameters": [
+                    {
+                        "description": "Warehouse",
+                        "name": "warehouse",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.RequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.Warehouse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/warehouses/{id}": {
+            "get": {
+                "description": "Get single warehouse by id",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "warehouses"
+                ],
+                "summary": "Get single warehouse by id",
+                "operationId": "get-warehouse",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Warehouse ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.Warehouse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Update a warehouse with given data",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "warehouses"
+                ],
+                "summary": "Update a warehouse with given data",
+                "operationId": "update-warehouse",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Warehouse ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Warehouse",
+                        "name": "warehouse",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.RequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.Warehouse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a warehouse by id, a warehouse still holding stock or reservations can't be deleted",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "warehouses"
+                ],
+                "summary": "Delete a warehouse by id",
+                "operationId": "delete-warehouse",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Warehouse ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "NoContent",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/warehouses/{id}/stock": {
+            "get": {
+                "description": "Get the article stock of a warehouse",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "warehouses"
+                ],
+                "summary": "Get the article stock of a warehouse",
+                "operationId": "get-warehouse-stock",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Warehouse ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/warehouse.Stock"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/warehouse.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/": {
+            "get": {
+                "description": "Get all webhook subscriptions",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Get all webhook subscriptions",
+                "operationId": "list-webhooks",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/webhook.Subscription"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/webhook.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Subscribe a url to the given event types, \"*\" subscribes to every event.\nThe deliveries are signed with the secret in the X-Horreum-Signature header.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Create a webhook subscription with given data",
+                "operationId": "create-webhook",
+                "parameters": [
+                    {
+                        "description": "Webhook",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/webhook.RequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/webhook.Subscription"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/webhook.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/{id}": {
+            "get": {
+                "description": "Get single webhook subscription by id",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Get single webhook subscription by id",
+                "operationId": "get-webhook",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/webhook.Subscription"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/webhook.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/webhook.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Update a webhook subscription with given data, the secret is kept when it is left out",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Update a webhook subscription with given data",
+                "operationId": "update-webhook",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Webhook",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/webhook.RequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/webhook.Subscription"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/webhook.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/webhook.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a webhook subscription and its deliveries by id",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Delete a webhook subscription by id",
+                "operationId": "delete-webhook",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "NoContent",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/webhook.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/webhook.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/{id}/deliveries": {
+            "get": {
+                "description": "Get the deliveries of a webhook subscription with their status and last error, latest first",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Get the deliveries of a webhook subscription",
+                "operationId": "list-webhook-deliveries",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/webhook.Delivery"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/webhook.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/webhook.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "article.Article": {
+            "type": "object",
+            "properties": {
+                "amount_of": {
+                    "type": "integer"
+                },
+                "available_inventory": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "external_id": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "reserved": {
+                    "type": "integer"
+                },
+                "stock": {
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "warehouse_id": {
+                    "type": "integer"
+                },
+                "warehouse_reserved": {
+                    "type": "integer"
+                },
+                "warehouse_stock": {
+                    "type": "integer"
+                }
+            }
+        },
+        "article.ArticleRequestBody": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "stock": {
+                    "type": "integer"
+                }
+            }
+        },
+        "article.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "article.StockMovement": {
+            "type": "object",
+            "properties": {
+                "actor": {
+                    "type": "string"
+                },
+                "article_id": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "delta": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "order_id": {
+                    "type": "integer"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "receipt_id": {
+                    "type": "integer"
+                },
+                "warehouse_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "article.WarehouseStock": {
+            "type": "object",
+            "properties": {
+                "article_id": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "quantity": {
+                    "type": "integer"
+                },
+                "reserved": {
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "warehouse_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "article.WarehouseStockRequestBody": {
+            "type": "object",
+            "properties": {
+                "quantity": {
+                    "type": "integer"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "receipt_id": {
+                    "type": "integer"
+                },
+                "warehouse_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "deadletter.DeadLetter": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "handler": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "metadata": {
+                    "type": "string"
+                },
+                "payload": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "replayed_at": {
+                    "type": "string"
+                },
+                "topic": {
+                    "type": "string"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "deadletter.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "exporter.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "importer.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "importer.Report": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "rows": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/importer.Row"
+                    }
+                },
+                "updated": {
+                    "type": "integer"
+                }
+            }
+        },
+        "importer.Row": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "external_id": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "row": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "inventory.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "inventory.ProductInventory": {
+            "type": "object",
+            "properties": {
+                "product_id": {
+                    "type": "integer"
+                },
+                "sellable_inventory": {
+                    "type": "integer"
+                },
+                "warehouse_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "order.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "order.LineArticle": {
+            "type": "object",
+            "properties": {
+                "amount_of": {
+                    "type": "integer"
+                },
+                "article_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "order.Order": {
+            "type": "object",
+            "properties": {
+                "allow_backorder": {
+                    "type": "boolean"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "customer": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "lines": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/order.OrderLine"
+                    }
+                },
+                "previous_lines": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/order.OrderLine"
+                    }
+                },
+                "previous_status": {
+                    "type": "string"
+                },
+                "previous_warehouse_id": {
+                    "description": "PreviousWarehouseID is set by the updates moving a reservation\nto another warehouse, PreviousLines are reserved in it",
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "warehouse_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "order.OrderLine": {
+            "type": "object",
+            "properties": {
+                "articles": {
+                    "description": "Articles are the articles one of the product takes, kept when the\nstock of the line is reserved so it is released, shipped and\nreturned in the same amounts even when the product changes later.\nThe lines a reservation is converted into keep the articles of the\nreservation, they hold its stock while the order is a draft.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/order.LineArticle"
+                    }
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "product_id": {
+                    "type": "integer"
+                },
+                "quantity": {
+                    "type": "integer"
+                },
+                "unit_cost": {
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "order.RequestBody": {
+            "type": "object",
+            "properties": {
+                "allow_backorder": {
+                    "type": "boolean"
+                },
+                "customer": {
+                    "type": "string"
+                },
+                "lines": {
+                    "type": "array",
+                    "items": {
+                        "type": "object",
+                        "properties": {
+                            "product_id": {
+                                "type": "integer"
+                            },
+                            "quantity": {
+                                "type": "integer"
+                            },
+                            "unit_cost": {
+                                "type": "integer"
+                            }
+                        }
+                    }
+                },
+                "warehouse_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "order.StockErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "shortages": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/product.StockShortage"
+                    }
+                }
+            }
+        },
+        "product.BasketRequestBody": {
+            "type": "object",
+            "properties": {
+                "lines": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/product.SaleLine"
+                    }
+                },
+                "warehouse_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "product.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "product.Product": {
+            "type": "object",
+            "properties": {
+                "amount_of": {
+                    "type": "integer"
+                },
+                "articles": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/article.Article"
+                    }
+                },
+                "components": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/product.Product"
+                    }
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "external_id": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "price": {
+                    "type": "integer"
+                },
+                "sellable_inventory": {
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "warehouse_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "product.ProductArticle": {
+            "type": "object",
+            "properties": {
+                "amount_of": {
+                    "type": "integer"
+                },
+                "available_inventory": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "external_id": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
                 },
                 "name": {
                     "type": "string"
                 },
+                "productID": {
+                    "type": "integer"
+                },
+                "reserved": {
+                    "type": "integer"
+                },
                 "stock": {
                     "type": "integer"
                 },
                 "updated_at": {
                     "type": "string"
+                },
+                "warehouse_id": {
+                    "type": "integer"
+                },
+                "warehouse_reserved": {
+                    "type": "integer"
+                },
+                "warehouse_stock": {
+                    "type": "integer"
                 }
             }
         },
-        "article.ArticleRequestBody": {
+        "product.ProductRequestBody": {
             "type": "object",
             "properties": {
+                "articles": {
+                    "type": "array",
+                    "items": {
+                        "type": "object",
+                        "properties": {
+                            "amount_of": {
+                                "type": "integer"
+                            },
+                            "id": {
+                                "type": "integer"
+                            }
+                        }
+                    }
+                },
+                "components": {
+                    "type": "array",
+                    "items": {
+                        "type": "object",
+                        "properties": {
+                            "amount_of": {
+                                "type": "integer"
+                            },
+                            "id": {
+                                "type": "integer"
+                            }
+                        }
+                    }
+                },
                 "name": {
                     "type": "string"
                 },
-                "stock": {
+                "price": {
                     "type": "integer"
                 }
             }
         },
-        "article.ErrorResponse": {
+        "product.SaleLine": {
             "type": "object",
             "properties": {
-                "code": {
+                "product_id": {
                     "type": "integer"
                 },
-                "message": {
-                    "type": "string"
+                "quantity": {
+                    "type": "integer"
                 }
             }
         },
-        "order.ErrorResponse": {
+        "product.SaleRequestBody": {
+            "type": "object",
+            "properties": {
+                "quantity": {
+                    "type": "integer"
+                },
+                "warehouse_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "product.StockErrorResponse": {
             "type": "object",
             "properties": {
                 "code": {
@@ -898,87 +3059,128 @@ const docTemplate_swagger = `{
                 },
                 "message": {
                     "type": "string"
+                },
+                "shortages": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/product.StockShortage"
+                    }
                 }
             }
         },
-        "order.Order": {
+        "product.StockShortage": {
             "type": "object",
             "properties": {
-                "created_at": {
-                    "type": "string"
+                "limiting_article_id": {
+                    "type": "integer"
                 },
-                "customer": {
+                "limiting_article_name": {
                     "type": "string"
                 },
-                "id": {
+                "product_id": {
                     "type": "integer"
                 },
-                "lines": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/order.OrderLine"
-                    }
+                "requested": {
+                    "type": "integer"
                 },
-                "updated_at": {
+                "sellable_inventory": {
+                    "type": "integer"
+                }
+            }
+        },
+        "reservation.ConvertRequestBody": {
+            "type": "object",
+            "properties": {
+                "order_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "reservation.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "message": {
                     "type": "string"
+                }
+            }
+        },
+        "reservation.RequestBody": {
+            "type": "object",
+            "properties": {
+                "product_id": {
+                    "type": "integer"
+                },
+                "quantity": {
+                    "type": "integer"
+                },
+                "ttl": {
+                    "type": "integer"
                 },
                 "warehouse_id": {
                     "type": "integer"
                 }
             }
         },
-        "order.OrderLine": {
+        "reservation.Reservation": {
             "type": "object",
             "properties": {
+                "articles": {
+                    "description": "Articles are the articles one of the product takes, kept when the\nstock is held so it is released or moved to an order in the same amounts",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/order.LineArticle"
+                    }
+                },
                 "created_at": {
                     "type": "string"
                 },
+                "expires_at": {
+                    "type": "string"
+                },
                 "id": {
                     "type": "integer"
                 },
+                "order_id": {
+                    "type": "integer"
+                },
                 "product_id": {
                     "type": "integer"
                 },
                 "quantity": {
                     "type": "integer"
                 },
-                "unit_cost": {
-                    "type": "integer"
+                "status": {
+                    "type": "string"
                 },
                 "updated_at": {
                     "type": "string"
+                },
+                "warehouse_id": {
+                    "type": "integer"
                 }
             }
         },
-        "order.RequestBody": {
+        "reservation.StockErrorResponse": {
             "type": "object",
             "properties": {
-                "customer": {
+                "code": {
+                    "type": "integer"
+                },
+                "message": {
                     "type": "string"
                 },
-                "lines": {
+                "shortages": {
                     "type": "array",
                     "items": {
-                        "type": "object",
-                        "properties": {
-                            "product_id": {
-                                "type": "integer"
-                            },
-                            "quantity": {
-                                "type": "integer"
-                            },
-                            "unit_cost": {
-                                "type": "integer"
-                            }
-                        }
+                        "$ref": "#/definitions/product.StockShortage"
                     }
-                },
-                "warehouse_id": {
-                    "type": "integer"
                 }
             }
         },
-        "product.ErrorResponse": {
+        "warehouse.ErrorResponse": {
             "type": "object",
             "properties": {
                 "code": {
@@ -989,28 +3191,24 @@ const docTemplate_swagger = `{
                 }
             }
         },
-        "product.Product": {
+        "warehouse.RequestBody": {
             "type": "object",
             "properties": {
-                "articles": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/article.Article"
-                    }
-                },
-                "created_at": {
+                "name": {
                     "type": "string"
-                },
-                "id": {
+                }
+            }
+        },
+        "warehouse.Stock": {
+            "type": "object",
+            "properties": {
+                "article_id": {
                     "type": "integer"
                 },
                 "name": {
                     "type": "string"
                 },
-                "price": {
-                    "type": "integer"
-                },
-                "sellable_inventory": {
+                "quantity": {
                     "type": "integer"
                 },
                 "updated_at": {
@@ -1018,15 +3216,9 @@ const docTemplate_swagger = `{
                 }
             }
         },
-        "product.ProductArticle": {
+        "warehouse.Warehouse": {
             "type": "object",
             "properties": {
-                "amount_of": {
-                    "type": "integer"
-                },
-                "available_inventory": {
-                    "type": "integer"
-                },
                 "created_at": {
                     "type": "string"
                 },
@@ -1036,43 +3228,53 @@ const docTemplate_swagger = `{
                 "name": {
                     "type": "string"
                 },
-                "productID": {
-                    "type": "integer"
-                },
-                "stock": {
-                    "type": "integer"
-                },
                 "updated_at": {
                     "type": "string"
                 }
             }
         },
-        "product.ProductRequestBody": {
+        "webhook.Delivery": {
             "type": "object",
             "properties": {
-                "articles": {
-                    "type": "array",
-                    "items": {
-                        "type": "object",
-                        "properties": {
-                            "amount_of": {
-                                "type": "integer"
-                            },
-                            "id": {
-                                "type": "integer"
-                            }
-                        }
-                    }
+                "attempts": {
+                    "type": "integer"
                 },
-                "name": {
+                "created_at": {
                     "type": "string"
                 },
-                "price": {
+                "delivered_at": {
+                    "type": "string"
+                },
+                "event_id": {
+                    "type": "string"
+                },
+                "event_name": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "next_attempt_at": {
+                    "type": "string"
+                },
+                "payload": {
+                    "type": "string"
+                },
+                "response_code": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "subscription_id": {
                     "type": "integer"
                 }
             }
         },
-        "warehouse.ErrorResponse": {
+        "webhook.ErrorResponse": {
             "type": "object",
             "properties": {
                 "code": {
@@ -1083,27 +3285,42 @@ const docTemplate_swagger = `{
                 }
             }
         },
-        "warehouse.RequestBody": {
+        "webhook.RequestBody": {
             "type": "object",
             "properties": {
-                "name": {
+                "event_types": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "secret": {
+                    "type": "string"
+                },
+                "url": {
                     "type": "string"
                 }
             }
         },
-        "warehouse.Warehouse": {
+        "webhook.Subscription": {
             "type": "object",
             "properties": {
                 "created_at": {
                     "type": "string"
                 },
+                "event_types": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "id": {
                     "type": "integer"
                 },
-                "name": {
+                "updated_at": {
                     "type": "string"
                 },
-                "updated_at": {
+                "url": {
                     "type": "string"
                 }
             }
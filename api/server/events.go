@@ -1,59 +1,272 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/unicod3/horreum/internal/article"
 	"github.com/unicod3/horreum/internal/order"
+	"github.com/unicod3/horreum/internal/product"
 	"github.com/unicod3/horreum/pkg/streamer"
+	"sort"
 )
 
 // RegisterEventHandlers registers the package's events handlers to streamer package
-func (h *Handler) RegisterEventHandlers(s *streamer.Stream) {
+func (h *Handler) RegisterEventHandlers(s *streamer.Stream) error {
 	s.RegisterHandler(
 		h.OrderService.StreamChannel,
 		h.OrderService.StreamTopic,
 		h.HandleOrderEvents,
+		streamer.Idempotent(h.dataStore, "order_events"),
 	)
-}
 
-func (h *Handler) HandleOrderEvents(msg *message.Message) error {
-	fmt.Printf(
-		"\n> Received message: %s\n> %s\n> metadata: %v\n\n",
-		msg.UUID, string(msg.Payload), msg.Metadata,
+	// The inventory streams are served by every instance, so every instance
+	// consumes the events pushed to them on its own, from its start on
+	streams, err := h.ArticleService.StreamChannel.Ephemeral("sse")
+	if err != nil {
+		return err
+	}
+	s.RegisterHandler(
+		streams,
+		article.StockTopic,
+		h.HandleStockEvents,
+	)
+	s.RegisterHandler(
+		streams,
+		h.ProductService.StreamTopic,
+		h.HandleProductEvents,
 	)
-	var message streamer.Message
-	err := json.Unmarshal(msg.Payload, &message)
+
+	// Webhooks get every event regardless of the other handlers of the topics
+	webhooks, err := h.OrderService.StreamChannel.ForConsumer("webhooks")
 	if err != nil {
 		return err
 	}
+	topics := []string{
+		h.OrderService.StreamTopic,
+		h.ReservationService.StreamTopic,
+		h.ArticleService.StreamTopic,
+		h.ProductService.StreamTopic,
+		h.WarehouseService.StreamTopic,
+		article.StockTopic,
+	}
+	for _, topic := range topics {
+		s.RegisterHandler(
+			webhooks,
+			topic,
+			h.HandleWebhookEvents,
+			streamer.Idempotent(h.dataStore, "webhooks"),
+		)
+	}
+
+	// The handlers of every topic above poison their failed messages to
+	// the dead letter topic of the topic, every consumer to the same one
+	for _, topic := range topics {
+		s.RegisterDeadLetterHandler(
+			h.OrderService.StreamChannel,
+			topic,
+			h.DeadLetterService.Store,
+		)
+	}
+	return nil
+}
+
+// inTx returns the Handler whose services work in the transaction
+// the message is handled in, h itself is returned outside of one
+func (h *Handler) inTx(msg *message.Message) *Handler {
+	tx, ok := streamer.Tx(msg)
+	if !ok {
+		return h
+	}
+	handler := NewHandler(&tx, h.OrderService.StreamChannel)
+	// The streams, the registry and the logger are shared by all the handlers
+	handler.InventoryService = h.InventoryService
+	handler.EventRegistry = h.EventRegistry
+	handler.Logger = h.Logger
+	return handler
+}
+
+// withContext returns a copy of the handler whose stock changes carry the
+// correlation id of ctx, so they can be traced back to the request the
+// handled event is caused by
+func (h *Handler) withContext(ctx context.Context) *Handler {
+	scoped := *h
+	if articles, ok := h.articles.(*article.ArticleService); ok {
+		scoped.articles = articles.WithContext(ctx)
+	}
+	return &scoped
+}
+
+// HandleWebhookEvents enqueues the deliveries of the event
+// to the webhooks subscribing to it
+func (h *Handler) HandleWebhookEvents(msg *message.Message) error {
+	return h.inTx(msg).WebhookService.Enqueue(msg)
+}
+
+// HandleStockEvents pushes the stock changes of the articles
+// to the inventory streams
+func (h *Handler) HandleStockEvents(msg *message.Message) error {
+	message, err := h.EventRegistry.Decode(msg.Payload)
+	if err != nil {
+		return err
+	}
+	level, ok := message.Data.(*article.StockLevel)
+	if !ok {
+		return fmt.Errorf("unexpected data %T for event %s", message.Data, message.EventName)
+	}
+	return h.InventoryService.HandleStockLevel(level)
+}
+
+// HandleProductEvents pushes the sellable inventory of the created
+// and updated products to the inventory streams
+func (h *Handler) HandleProductEvents(msg *message.Message) error {
+	message, err := h.EventRegistry.Decode(msg.Payload)
+	if err != nil {
+		return err
+	}
+	snapshot, ok := message.Data.(*product.Snapshot)
+	if !ok {
+		return fmt.Errorf("unexpected data %T for event %s", message.Data, message.EventName)
+	}
+	if snapshot.After != nil {
+		h.InventoryService.HandleProduct(snapshot.After)
+	}
+	return nil
+}
 
-	var o order.Order
-	byteOrder, _ := json.Marshal(message.Data)
-	err = json.Unmarshal(byteOrder, &o)
+// HandleOrderEvents changes the stock of the order's products for the
+// order event, the event is logged with the correlation id it carries
+func (h *Handler) HandleOrderEvents(msg *message.Message) error {
+	message, o, err := h.decodeOrderEvent(msg.Payload)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("EVENT: ", message.EventName)
-	switch message.EventName {
-	case order.OrderCreated:
-		for _, line := range o.Lines {
-			product, _ := h.ProductService.GetById(line.ProductID)
-			err := product.DecreaseStockBy(h.ArticleService, int64(line.Quantity))
-			if err != nil {
-				return err
-			}
+	h.Logger.Info("Handling order event", watermill.LogFields{
+		"message_uuid":   msg.UUID,
+		"event":          message.EventName,
+		"order_id":       o.ID,
+		"correlation_id": streamer.MessageCorrelationID(msg),
+	})
+	return h.inTx(msg).withContext(msg.Context()).applyOrderEvent(message.EventName, o)
+}
+
+// decodeOrderEvent decodes the payload of an order event
+func (h *Handler) decodeOrderEvent(payload []byte) (*streamer.Message, *order.Order, error) {
+	message, err := h.EventRegistry.Decode(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	o, ok := message.Data.(*order.Order)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected data %T for event %s", message.Data, message.EventName)
+	}
+	return message, o, nil
+}
+
+// applyOrderEvent changes the stock of the order's products for the event
+func (h *Handler) applyOrderEvent(event string, o *order.Order) error {
+	switch event {
+	case order.OrderUpdated:
+		if o.PreviousWarehouseID != 0 {
+			// The update has reserved the stock of the lines in the new warehouse
+			lines := order.ReservedLines(o.Status, o.PreviousLines)
+			return h.adjustLinesStock(o, o.PreviousWarehouseID, lines, (*product.Product).ReleaseStockBy, "")
 		}
+		return h.releaseRemovedStock(o)
 	case order.OrderDeleted:
-		for _, line := range o.Lines {
-			product, _ := h.ProductService.GetById(line.ProductID)
-			err := product.IncreaseStockBy(h.ArticleService, int64(line.Quantity))
-			if err != nil {
-				return err
-			}
+		lines := order.ReservedLines(o.Status, o.Lines)
+		return h.adjustLinesStock(o, o.WarehouseID, lines, (*product.Product).ReleaseStockBy, "")
+	case order.OrderShipped:
+		return h.adjustOrderStock(o, (*product.Product).ConsumeStockBy, article.ReasonShipment)
+	case order.OrderCancelled:
+		lines := order.ReservedLines(o.PreviousStatus, o.Lines)
+		return h.adjustLinesStock(o, o.WarehouseID, lines, (*product.Product).ReleaseStockBy, "")
+	case order.OrderReturned:
+		return h.adjustOrderStock(o, (*product.Product).IncreaseStockBy, article.ReasonReturn)
+	}
+
+	return nil
+}
+
+// adjustOrderStock applies adjust to the products of the order's lines
+// in the order's warehouse, the stock movements refer to the order with given reason
+func (h *Handler) adjustOrderStock(o *order.Order, adjust func(*product.Product, article.ArticleRepository, int64, article.StockChange) error, reason string) error {
+	return h.adjustLinesStock(o, o.WarehouseID, o.Lines, adjust, reason)
+}
+
+// adjustLinesStock applies adjust to the products of the given lines of the
+// order in the warehouse, the stock movements refer to the order with given reason
+func (h *Handler) adjustLinesStock(o *order.Order, warehouseID uint64, lines []order.OrderLine, adjust func(*product.Product, article.ArticleRepository, int64, article.StockChange) error, reason string) error {
+	change := orderStockChange(o, reason)
+	for _, line := range lines {
+		p, err := h.lineProduct(warehouseID, line)
+		if err != nil {
+			return err
 		}
+		err = adjust(p, h.articles, int64(line.Quantity), change)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseRemovedStock releases the reserved stock of the quantities
+// removed from the order's lines holding reserved stock by an update,
+// the update has reserved the stock of the increased quantities
+func (h *Handler) releaseRemovedStock(o *order.Order) error {
+	deltas := o.ReservedDeltas()
+	var productIDs []uint64
+	for productID := range deltas {
+		productIDs = append(productIDs, productID)
 	}
+	sort.Slice(productIDs, func(i, j int) bool { return productIDs[i] < productIDs[j] })
 
+	lines := make(map[uint64]order.OrderLine)
+	for _, line := range o.PreviousLines {
+		lines[line.ProductID] = line
+	}
+	for _, productID := range productIDs {
+		if deltas[productID] >= 0 {
+			continue
+		}
+		p, err := h.lineProduct(o.WarehouseID, lines[productID])
+		if err != nil {
+			return err
+		}
+		err = p.ReleaseStockBy(h.articles, -deltas[productID], orderStockChange(o, ""))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// lineProduct returns the product of an order line in the warehouse with
+// the articles the stock of the line was reserved with, the lines reserved
+// before the articles were kept get the current articles of the product
+func (h *Handler) lineProduct(warehouseID uint64, line order.OrderLine) (*product.Product, error) {
+	if len(line.Articles) == 0 {
+		return h.ProductService.GetByIdForWarehouse(line.ProductID, warehouseID)
+	}
+	p := &product.Product{ID: line.ProductID, WarehouseID: warehouseID}
+	for _, art := range line.Articles {
+		p.Articles = append(p.Articles, article.Article{
+			ID:          art.ArticleID,
+			AmountOf:    art.AmountOf,
+			WarehouseID: warehouseID,
+		})
+	}
+	return p, nil
+}
+
+// orderStockChange returns the StockChange the handlers make for the order
+func orderStockChange(o *order.Order, reason string) article.StockChange {
+	return article.StockChange{
+		Reason:  reason,
+		OrderID: &o.ID,
+		Actor:   article.ActorSystem,
+	}
+}
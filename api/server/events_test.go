@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/internal/article"
+	articleMock "github.com/unicod3/horreum/internal/article/mocks"
+	"github.com/unicod3/horreum/internal/order"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	dbMock "github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"github.com/unicod3/horreum/pkg/streamer"
+	"testing"
+)
+
+func TestHandler_ApplyOrderUpdated(t *testing.T) {
+	previousLines := []order.OrderLine{
+		{ProductID: 1, Quantity: 2, Articles: order.LineArticles{{ArticleID: 7, AmountOf: 4}}},
+		{ProductID: 2, Quantity: 3, Articles: order.LineArticles{{ArticleID: 8, AmountOf: 1}}},
+	}
+
+	t.Run("Test can release the removed lines", func(t *testing.T) {
+		assert := assert.New(t)
+
+		articles := articleMock.ArticleRepository{}
+		h := &Handler{articles: &articles}
+		o := &order.Order{
+			ID:            1,
+			WarehouseID:   3,
+			Status:        order.StatusConfirmed,
+			Lines:         []order.OrderLine{{ProductID: 1, Quantity: 1, Articles: previousLines[0].Articles}},
+			PreviousLines: previousLines,
+		}
+		change := article.StockChange{OrderID: &o.ID, Actor: article.ActorSystem}
+		articles.On("AdjustWarehouseStock", uint64(7), uint64(3), int64(0), int64(-4), change).Return(nil).Once()
+		articles.On("AdjustWarehouseStock", uint64(8), uint64(3), int64(0), int64(-3), change).Return(nil).Once()
+
+		assert.Nil(h.applyOrderEvent(order.OrderUpdated, o))
+		articles.AssertExpectations(t)
+	})
+
+	t.Run("Test can leave the increased lines reserved by the update", func(t *testing.T) {
+		assert := assert.New(t)
+
+		articles := articleMock.ArticleRepository{}
+		h := &Handler{articles: &articles}
+		o := &order.Order{
+			ID:          1,
+			WarehouseID: 3,
+			Status:      order.StatusConfirmed,
+			Lines: []order.OrderLine{
+				{ProductID: 1, Quantity: 5, Articles: previousLines[0].Articles},
+				{ProductID: 2, Quantity: 3, Articles: previousLines[1].Articles},
+			},
+			PreviousLines: previousLines,
+		}
+
+		assert.Nil(h.applyOrderEvent(order.OrderUpdated, o))
+		articles.AssertNotCalled(t, "AdjustWarehouseStock", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Test can release the previous warehouse", func(t *testing.T) {
+		assert := assert.New(t)
+
+		articles := articleMock.ArticleRepository{}
+		h := &Handler{articles: &articles}
+		o := &order.Order{
+			ID:                  1,
+			WarehouseID:         4,
+			Status:              order.StatusConfirmed,
+			Lines:               previousLines,
+			PreviousLines:       previousLines,
+			PreviousWarehouseID: 3,
+		}
+		change := article.StockChange{OrderID: &o.ID, Actor: article.ActorSystem}
+		articles.On("AdjustWarehouseStock", uint64(7), uint64(3), int64(0), int64(-8), change).Return(nil).Once()
+		articles.On("AdjustWarehouseStock", uint64(8), uint64(3), int64(0), int64(-3), change).Return(nil).Once()
+
+		assert.Nil(h.applyOrderEvent(order.OrderUpdated, o))
+		articles.AssertExpectations(t)
+	})
+
+	t.Run("Test can release the converted lines of a deleted draft", func(t *testing.T) {
+		assert := assert.New(t)
+
+		articles := articleMock.ArticleRepository{}
+		h := &Handler{articles: &articles}
+		o := &order.Order{
+			ID:          1,
+			WarehouseID: 3,
+			Status:      order.StatusDraft,
+			Lines: []order.OrderLine{
+				{ProductID: 1, Quantity: 2, Articles: order.LineArticles{{ArticleID: 7, AmountOf: 4}}},
+				{ProductID: 2, Quantity: 3},
+			},
+		}
+		change := article.StockChange{OrderID: &o.ID, Actor: article.ActorSystem}
+		articles.On("AdjustWarehouseStock", uint64(7), uint64(3), int64(0), int64(-8), change).Return(nil).Once()
+
+		assert.Nil(h.applyOrderEvent(order.OrderDeleted, o))
+		articles.AssertExpectations(t)
+	})
+}
+
+func TestHandler_WithContext(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := streamer.WithCorrelationID(context.Background(), "request-1")
+	articles := &article.ArticleService{}
+	scoped := (&Handler{articles: articles}).withContext(ctx)
+	assert.IsType(&article.ArticleService{}, scoped.articles)
+	assert.NotSame(articles, scoped.articles, "the stock changes must carry the id of the event")
+
+	projection := article.NewProjection()
+	scoped = (&Handler{articles: projection}).withContext(ctx)
+	assert.Same(projection, scoped.articles, "the replayed stock must stay in the projection")
+}
+
+func TestHandler_HandleOrderEventsLogsCorrelationID(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := watermill.NewCaptureLogger()
+	h := &Handler{EventRegistry: newEventRegistry(), Logger: logger}
+	msg, err := streamer.NewMessage(&streamer.Message{
+		EventName:     order.OrderCreated,
+		AggregateType: "order",
+		AggregateID:   5,
+		CorrelationID: "request-1",
+		Data:          &order.Order{ID: 5},
+	})
+	assert.Nil(err)
+
+	assert.Nil(h.HandleOrderEvents(msg))
+	assert.True(logger.Has(watermill.CapturedMessage{
+		Level: watermill.InfoLogLevel,
+		Fields: watermill.LogFields{
+			"message_uuid":   msg.UUID,
+			"event":          order.OrderCreated,
+			"order_id":       uint64(5),
+			"correlation_id": "request-1",
+		},
+		Msg: "Handling order event",
+	}))
+}
+
+func TestHandler_RegisterEventHandlers(t *testing.T) {
+	assert := assert.New(t)
+
+	storage := &dbMock.DataStorage{}
+	storage.On("NewDataCollection", mock.Anything).Return(&dbMock.DataTable{})
+	var client dbclient.DataStorage = storage
+	channel := streamer.NewChannel()
+	defer channel.Close()
+	h := NewHandler(&client, channel)
+	stream := streamer.NewStreamer()
+
+	assert.Nil(h.RegisterEventHandlers(stream))
+	handlers := stream.Router.Handlers()
+	assert.Contains(handlers, "sse_"+article.StockTopic, "every instance must get the stock changes")
+	assert.Contains(handlers, "sse_products", "every instance must get the product changes")
+	for _, topic := range []string{"orders", "reservations", "articles", "products", "warehouses", article.StockTopic} {
+		assert.Contains(handlers, streamer.DeadLetterTopic(topic), "the dead letters of %s must be stored", topic)
+	}
+}
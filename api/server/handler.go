@@ -1,44 +1,114 @@
 package server
 
 import (
+	"github.com/ThreeDotsLabs/watermill"
 	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/internal/deadletter"
+	"github.com/unicod3/horreum/internal/exporter"
+	"github.com/unicod3/horreum/internal/importer"
+	"github.com/unicod3/horreum/internal/inventory"
 	"github.com/unicod3/horreum/internal/order"
 	"github.com/unicod3/horreum/internal/product"
+	"github.com/unicod3/horreum/internal/reservation"
 	"github.com/unicod3/horreum/internal/warehouse"
+	"github.com/unicod3/horreum/internal/webhook"
 	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/outbox"
 	"github.com/unicod3/horreum/pkg/streamer"
 )
 
 // Handler holds services that are exposed
 type Handler struct {
-	WarehouseService *warehouse.WarehouseService
-	OrderService     *order.OrderService
-	ArticleService   *article.ArticleService
-	ProductService   *product.ProductService
+	WarehouseService   *warehouse.WarehouseService
+	OrderService       *order.OrderService
+	ArticleService     *article.ArticleService
+	ProductService     *product.ProductService
+	ReservationService *reservation.ReservationService
+	DeadLetterService  *deadletter.DeadLetterService
+	InventoryService   *inventory.InventoryService
+	WebhookService     *webhook.WebhookService
+	WebhookDispatcher  *webhook.Dispatcher
+	ImportService      *importer.ImportService
+	ExportService      *exporter.ExportService
+	OutboxRelay        *outbox.Relay
+	EventRegistry      *streamer.Registry
+	// Logger logs the handled events along with their correlation id
+	Logger    watermill.LoggerAdapter
+	dataStore dbclient.DataStorage
+	// articles is where the order events change the stock,
+	// it is the ArticleService unless the events are replayed
+	articles article.ArticleRepository
 }
 
 // NewHandler returns a new Handler
 func NewHandler(client *dbclient.DataStorage, streamChannel streamer.Channel) *Handler {
+	productService := &product.ProductService{
+		DataTable:     (*client).NewDataCollection("products"),
+		StreamChannel: streamChannel,
+		StreamTopic:   "products",
+	}
+	articleService := &article.ArticleService{
+		DataTable:     (*client).NewDataCollection("articles"),
+		StreamChannel: streamChannel,
+		StreamTopic:   "articles",
+	}
+	orderService := &order.OrderService{
+		DataTable:     (*client).NewDataCollection("orders"),
+		Inventory:     productService,
+		StreamChannel: streamChannel,
+		StreamTopic:   "orders",
+	}
 	return &Handler{
-		OrderService: &order.OrderService{
-			DataTable:     (*client).NewDataCollection("orders"),
-			StreamChannel: streamChannel,
-			StreamTopic:   "orders",
-		},
+		dataStore:     *client,
+		articles:      articleService,
+		EventRegistry: newEventRegistry(),
+		OrderService:  orderService,
 		WarehouseService: &warehouse.WarehouseService{
 			DataTable:     (*client).NewDataCollection("warehouses"),
 			StreamChannel: streamChannel,
 			StreamTopic:   "warehouses",
 		},
-		ArticleService: &article.ArticleService{
-			DataTable:     (*client).NewDataCollection("articles"),
+		ArticleService: articleService,
+		ProductService: productService,
+		ReservationService: &reservation.ReservationService{
+			DataTable:     (*client).NewDataCollection("reservations"),
+			Inventory:     productService,
 			StreamChannel: streamChannel,
-			StreamTopic:   "articles",
+			StreamTopic:   "reservations",
 		},
-		ProductService: &product.ProductService{
-			DataTable:     (*client).NewDataCollection("products"),
-			StreamChannel: streamChannel,
-			StreamTopic:   "products",
+		DeadLetterService: &deadletter.DeadLetterService{
+			DataTable: (*client).NewDataCollection("dead_letters"),
 		},
+		InventoryService: &inventory.InventoryService{
+			Products: productService,
+			Broker:   inventory.NewBroker(inventory.DefaultHistorySize),
+		},
+		WebhookService: &webhook.WebhookService{
+			DataTable: (*client).NewDataCollection(webhook.SubscriptionsTable),
+		},
+		WebhookDispatcher: webhook.NewDispatcher((*client).NewDataCollection(webhook.DeliveriesTable)),
+		ImportService: &importer.ImportService{
+			DataStorage:  *client,
+			ArticleTopic: articleService.StreamTopic,
+			ProductTopic: productService.StreamTopic,
+		},
+		ExportService: &exporter.ExportService{
+			Articles: articleService,
+			Products: productService,
+			Orders:   orderService,
+		},
+		OutboxRelay: outbox.NewRelay((*client).NewDataCollection(outbox.TableName), streamChannel),
+		Logger:      streamer.Logger(),
 	}
 }
+
+// newEventRegistry returns a Registry holding the events of the services
+func newEventRegistry() *streamer.Registry {
+	registry := streamer.NewRegistry()
+	order.RegisterEvents(registry)
+	reservation.RegisterEvents(registry)
+	article.RegisterEvents(registry)
+	product.RegisterEvents(registry)
+	warehouse.RegisterEvents(registry)
+	return registry
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/outbox"
+	"time"
+)
+
+// ActorReplay is the actor of the stock adjustments applied by a replay
+const ActorReplay = "replay"
+
+// ErrReplayBeyondRetention is returned when a replay would start before the
+// outbox retention, the order events published before it are pruned
+var ErrReplayBeyondRetention = errors.New("the replay can't start before the outbox retention")
+
+// ReplayResult holds the outcome of replaying the order events
+type ReplayResult struct {
+	// Events is the number of the replayed events
+	Events int
+	// Skipped holds the ids of the events whose products don't exist anymore
+	Skipped []string
+	// Diffs are the differences of the current stock to the replayed one
+	Diffs []article.StockDiff
+}
+
+// ReplayStock recomputes the stock of the articles by replaying the order
+// events stored in the outbox from the given time on, through the same code
+// HandleOrderEvents runs, into an article.Projection. The database is left
+// untouched, the differences to the current stock are returned to be applied
+// with ApplyStockDiffs. The stock should not change while it is replayed.
+// A replay starting before the outbox retention is rejected with
+// ErrReplayBeyondRetention, it would miss the pruned order events and
+// put back the stock they moved.
+func (h *Handler) ReplayStock(from time.Time) (*ReplayResult, error) {
+	if oldest := time.Now().Add(-h.OutboxRelay.Retention); from.Before(oldest) {
+		return nil, fmt.Errorf("%w: %s is before %s", ErrReplayBeyondRetention,
+			from.Format(time.RFC3339), oldest.Format(time.RFC3339))
+	}
+	projection, err := h.ArticleService.ProjectStock(from)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := outbox.History(h.OutboxRelay.DataTable, h.OrderService.StreamTopic, from)
+	if err != nil {
+		return nil, err
+	}
+
+	replay := *h
+	replay.articles = projection
+	result := &ReplayResult{}
+	for _, msg := range messages {
+		message, o, err := replay.decodeOrderEvent(msg.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("event %s: %w", msg.UUID, err)
+		}
+		err = replay.applyOrderEvent(message.EventName, o)
+		if errors.Is(err, dbclient.ErrNoMoreRows) {
+			result.Skipped = append(result.Skipped, msg.UUID)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("event %s: %w", msg.UUID, err)
+		}
+		result.Events++
+	}
+
+	articles, err := h.ArticleService.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	warehouseStock, err := h.ArticleService.GetAllWarehouseStock()
+	if err != nil {
+		return nil, err
+	}
+	result.Diffs = projection.Diff(articles, warehouseStock)
+	return result, nil
+}
+
+// ApplyStockDiffs adjusts the stock to the replayed one in a single transaction,
+// so either all the differences are applied or none of them. Every difference
+// is recorded as an adjustment movement made by ActorReplay.
+func (h *Handler) ApplyStockDiffs(diffs []article.StockDiff) error {
+	change := article.StockChange{Reason: article.ReasonAdjustment, Actor: ActorReplay}
+	return h.ArticleService.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		articles := &article.ArticleService{DataTable: tx, StreamTopic: h.ArticleService.StreamTopic}
+		for _, d := range diffs {
+			var err error
+			if d.WarehouseID != 0 {
+				err = articles.AdjustWarehouseStock(d.ArticleID, d.WarehouseID, d.Delta(), 0, change)
+			} else {
+				err = articles.AdjustStock(d.ArticleID, d.Delta(), change)
+			}
+			if err != nil {
+				return fmt.Errorf("article %d: %w", d.ArticleID, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	dbMock "github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"github.com/unicod3/horreum/pkg/outbox"
+	"github.com/unicod3/horreum/pkg/streamer"
+	"testing"
+	"time"
+)
+
+func TestHandler_ReplayStockRejectsBeyondRetention(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := dbMock.DataTable{}
+	h := &Handler{
+		ArticleService: &article.ArticleService{DataTable: &dataTable},
+		OutboxRelay:    outbox.NewRelay(&dataTable, streamer.NewChannel()),
+	}
+
+	for _, from := range []time.Time{{}, time.Now().Add(-outbox.DefaultRetention - time.Hour)} {
+		result, err := h.ReplayStock(from)
+		assert.Nil(result)
+		assert.ErrorIs(err, ErrReplayBeyondRetention)
+	}
+	dataTable.AssertNotCalled(t, "FindRelated", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandler_ApplyStockDiffsInOneTransaction(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := dbMock.DataTable{}
+	tx := dbMock.DataTable{}
+	h := &Handler{ArticleService: &article.ArticleService{DataTable: &dataTable}}
+	diffs := []article.StockDiff{
+		{StockKey: article.StockKey{ArticleID: 1}, Current: 5, Projected: 3},
+		{StockKey: article.StockKey{ArticleID: 2}, Current: 1, Projected: 4},
+	}
+	failure := errors.New("connection lost")
+
+	var txErr error
+	dataTable.On("WithTx", mock.Anything).Return(func(fn func(dbclient.DataTable) error) error {
+		txErr = fn(&tx)
+		return txErr
+	}).Once()
+	tx.On("WithTx", mock.Anything).Return(func(fn func(dbclient.DataTable) error) error {
+		return fn(&tx)
+	})
+	tx.On("Increment", dbclient.Condition{"id": uint64(1)}, map[string]int64{"stock": -2}).Return(nil).Once()
+	tx.On("CreateRelated", "stock_movements", mock.MatchedBy(func(m *article.StockMovement) bool {
+		return m.ArticleID == 1 && m.Actor == ActorReplay
+	})).Return(nil).Once()
+	tx.On("FindOne", dbclient.Condition{"id": uint64(1)}, mock.Anything).Return(nil).Once()
+	tx.On("CreateRelated", "outbox", mock.Anything).Return(nil).Once()
+	tx.On("Increment", dbclient.Condition{"id": uint64(2)}, map[string]int64{"stock": 3}).Return(failure).Once()
+
+	err := h.ApplyStockDiffs(diffs)
+	assert.ErrorIs(err, failure)
+	assert.Equal(err, txErr, "the adjustments must be rolled back together")
+	dataTable.AssertNumberOfCalls(t, "WithTx", 1)
+	tx.AssertExpectations(t)
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/gin-gonic/gin"
+	"github.com/unicod3/horreum/pkg/streamer"
+)
+
+// RequestIDHeader is the header the request id is read from and echoed back in
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the length of the longest request id taken from a client
+const maxRequestIDLength = 128
+
+// RequestID returns the middleware which takes the request id from the
+// X-Request-ID header, or generates one when it is missing or invalid,
+// echoes it back and stores it in the request context as the correlation
+// id of the events published for the request
+func RequestID() gin.HandlerFunc {
+	return func(g *gin.Context) {
+		id := g.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = watermill.NewUUID()
+		}
+		g.Header(RequestIDHeader, id)
+		g.Set(RequestIDHeader, id)
+		g.Request = g.Request.WithContext(streamer.WithCorrelationID(g.Request.Context(), id))
+		g.Next()
+	}
+}
+
+// validRequestID reports whether id is a non-empty, reasonably short
+// string of printable ascii characters without spaces
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
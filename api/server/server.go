@@ -2,14 +2,22 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	docs "github.com/unicod3/horreum/api/docs"
 	"github.com/unicod3/horreum/pkg/dbclient"
 	"github.com/unicod3/horreum/pkg/streamer"
+	"net/http"
+	"sync"
+	"time"
 )
 
+// DefaultShutdownTimeout is the deadline of the shutdown when none is configured
+const DefaultShutdownTimeout = 30 * time.Second
+
 // Config provides the configuration for the API server
 type Config struct {
 	SwaggerTitle       string
@@ -17,6 +25,8 @@ type Config struct {
 	SwaggerDescription string
 	BasePath           string
 	Addr               string
+	// ShutdownTimeout bounds Shutdown when its context has no deadline
+	ShutdownTimeout time.Duration
 }
 
 // Server contains server details
@@ -24,20 +34,35 @@ type Server struct {
 	cfg           *Config
 	DataStore     *dbclient.DataStorage
 	StreamService *streamer.Stream
+	StreamChannel streamer.Channel
+
+	httpServer *http.Server
+
+	// mu guards the state Serve starts and Shutdown stops
+	mu           sync.Mutex
+	shuttingDown bool
+	handler      *Handler
+	stopRouter   context.CancelFunc
+	stopWorkers  context.CancelFunc
+	workers      sync.WaitGroup
 }
 
 // New returns a new instance of the server
-func New(cfg *Config, db *dbclient.DataStorage, streamService *streamer.Stream) *Server {
+func New(cfg *Config, db *dbclient.DataStorage, streamService *streamer.Stream, streamChannel streamer.Channel) *Server {
 	return &Server{
 		cfg:           cfg,
 		DataStore:     db,
 		StreamService: streamService,
+		StreamChannel: streamChannel,
+		httpServer:    &http.Server{Addr: cfg.Addr, Handler: ginRouter},
 	}
 }
 
 var ginRouter = gin.Default()
 
-// Serve registers the ginRouter and runs it
+// Serve registers the ginRouter and the event handlers, runs the streaming
+// router with the background workers and serves the HTTP requests until
+// Shutdown is called
 func (srv *Server) Serve() error {
 	docs.SwaggerInfo_swagger.Title = srv.cfg.SwaggerTitle
 	docs.SwaggerInfo_swagger.Description = srv.cfg.SwaggerDescription
@@ -47,23 +72,153 @@ func (srv *Server) Serve() error {
 	router := registerGinRouter(srv.cfg.BasePath)
 
 	// Register all the internal services
-	handler := NewHandler(srv.DataStore, streamer.NewChannel())
-	handler.RegisterEventHandlers(srv.StreamService)
+	handler := NewHandler(srv.DataStore, srv.StreamChannel)
+	if err := handler.RegisterEventHandlers(srv.StreamService); err != nil {
+		return err
+	}
 
 	handler.OrderService.RegisterHTTPRoutes(router)
 	handler.WarehouseService.RegisterHTTPRoutes(router)
 	handler.ArticleService.RegisterHTTPRoutes(router)
 	handler.ProductService.RegisterHTTPRoutes(router)
+	handler.ReservationService.RegisterHTTPRoutes(router)
+	handler.DeadLetterService.RegisterHTTPRoutes(router)
+	handler.InventoryService.RegisterHTTPRoutes(router)
+	handler.WebhookService.RegisterHTTPRoutes(router)
+	handler.ImportService.RegisterHTTPRoutes(router)
+	handler.ExportService.RegisterHTTPRoutes(router)
 
-	// Ideally this should live in its own package
-	// with proper error handler under the cmd/ folder
-	// Just left here for the demo purposes
-	go srv.StreamService.Router.Run(context.Background())
+	if !srv.start(handler) {
+		return nil
+	}
+
+	if err := srv.httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// start runs the streaming router and the background workers of handler
+// unless the server is shutting down already, which is reported by false
+func (srv *Server) start(handler *Handler) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.shuttingDown {
+		return false
+	}
+	srv.handler = handler
+	routerCtx, stopRouter := context.WithCancel(context.Background())
+	srv.stopRouter = stopRouter
+	go func() {
+		if err := srv.StreamService.Router.Run(routerCtx); err != nil {
+			fmt.Println("Error: couldn't run the stream router: ", err.Error())
+		}
+	}()
+	ctx, stopWorkers := context.WithCancel(context.Background())
+	srv.stopWorkers = stopWorkers
+	srv.runWorker(func() { handler.ReservationService.Sweep(ctx, time.Minute) })
+	srv.runWorker(func() { handler.OutboxRelay.Run(ctx, time.Second) })
+	srv.runWorker(func() { handler.WebhookDispatcher.Run(ctx, time.Second) })
+	// The inventory streams never end by themselves
+	srv.httpServer.RegisterOnShutdown(handler.InventoryService.Broker.Close)
+	return true
+}
+
+// runWorker runs the background worker fn in a goroutine Shutdown waits for
+func (srv *Server) runWorker(fn func()) {
+	srv.workers.Add(1)
+	go func() {
+		defer srv.workers.Done()
+		fn()
+	}()
+}
+
+// Shutdown stops the server in order: the HTTP server stops accepting
+// requests and waits for the ones in flight, the background workers stop,
+// the streaming router stops taking messages and waits for its handlers,
+// the outbox is flushed to a durable stream channel and finally the stream
+// channel and the database session are closed. Waiting stops when ctx is done, a ctx without a deadline gets
+// the ShutdownTimeout of the Config. The first error is returned after all
+// the steps are run.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		timeout := srv.cfg.ShutdownTimeout
+		if timeout <= 0 {
+			timeout = DefaultShutdownTimeout
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	return ginRouter.Run(srv.cfg.Addr)
+	srv.mu.Lock()
+	srv.shuttingDown = true
+	handler := srv.handler
+	srv.mu.Unlock()
+
+	var errs []error
+	if err := srv.httpServer.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("http server: %w", err))
+	}
+
+	if handler != nil {
+		srv.stopWorkers()
+		if err := wait(ctx, srv.workers.Wait); err != nil {
+			errs = append(errs, fmt.Errorf("workers: %w", err))
+		}
+
+		// The event handlers store events in the outbox as well, so the
+		// router stops taking messages and its handlers are waited for
+		// before the outbox is flushed
+		srv.stopRouter()
+		var closeErr error
+		err := wait(ctx, func() { closeErr = srv.StreamService.Router.Close() })
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("stream router: %w", err))
+		}
+
+		// The in-memory channel would drop the messages nobody subscribes
+		// to anymore, they are left in the outbox for the next start then
+		if srv.StreamChannel.Durable() {
+			if _, err := handler.OutboxRelay.Flush(); err != nil {
+				errs = append(errs, fmt.Errorf("outbox: %w", err))
+			}
+		}
+	}
+
+	if err := srv.StreamChannel.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("stream channel: %w", err))
+	}
+	if err := (*srv.DataStore).Close(); err != nil {
+		errs = append(errs, fmt.Errorf("database: %w", err))
+	}
+
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// wait runs fn and waits for it to return until ctx is done
+func wait(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func registerGinRouter(basePath string) *gin.RouterGroup {
+	ginRouter.Use(RequestID())
 	ginRouter.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
 	return ginRouter.Group(basePath)
 }
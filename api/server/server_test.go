@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"github.com/ThreeDotsLabs/watermill/message"
+	natsServer "github.com/nats-io/nats-server/v2/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	dbMock "github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"github.com/unicod3/horreum/pkg/outbox"
+	"github.com/unicod3/horreum/pkg/streamer"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServer_Shutdown(t *testing.T) {
+	// shutdown runs a server on channel whose handler is busy when it
+	// shuts down and returns the steps of the shutdown in their order
+	shutdown := func(t *testing.T, channel streamer.Channel) []string {
+		assert := assert.New(t)
+
+		var mu sync.Mutex
+		var steps []string
+		step := func(name string) {
+			mu.Lock()
+			defer mu.Unlock()
+			steps = append(steps, name)
+		}
+
+		outboxTable := dbMock.DataTable{}
+		outboxTable.On("WithTx", mock.Anything).Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&outboxTable)
+		})
+		outboxTable.On("FindForUpdateSkipLocked", mock.Anything, mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) { step("outbox flushed") }).Return(nil)
+		storage := &dbMock.DataStorage{}
+		storage.On("NewDataCollection", outbox.TableName).Return(&outboxTable)
+		storage.On("NewDataCollection", mock.Anything).Return(&dbMock.DataTable{})
+		storage.On("Close").Run(func(args mock.Arguments) { step("database closed") }).Return(nil)
+		var client dbclient.DataStorage = storage
+
+		srv := New(&Config{}, &client, streamer.NewStreamer(), channel)
+
+		started := make(chan struct{})
+		srv.StreamService.Router.AddNoPublisherHandler("slow", "slow", channel, func(msg *message.Message) error {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			step("handler done")
+			return nil
+		})
+		assert.True(srv.start(NewHandler(&client, channel)))
+		<-srv.StreamService.Router.Running()
+		assert.Nil(channel.Publish("slow", message.NewMessage("1", nil)))
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the handler is not started")
+		}
+
+		assert.Nil(srv.Shutdown(context.Background()))
+		assert.False(srv.start(NewHandler(&client, channel)), "a server shutting down must not start again")
+		return steps
+	}
+
+	t.Run("Test flushes the outbox to a durable channel once the handlers are done", func(t *testing.T) {
+		channel, err := streamer.NewNATSChannel(runNATSServer(t), "horreum")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, []string{"handler done", "outbox flushed", "database closed"}, shutdown(t, channel),
+			"the outbox must be flushed after the handlers writing to it are done")
+	})
+
+	t.Run("Test leaves the outbox to the next start on the in-memory channel", func(t *testing.T) {
+		assert.Equal(t, []string{"handler done", "database closed"}, shutdown(t, streamer.NewChannel()),
+			"the in-memory channel would drop the flushed messages")
+	})
+}
+
+// runNATSServer runs an embedded NATS server with JetStream
+// enabled for the test and returns its url
+func runNATSServer(t *testing.T) string {
+	server, err := natsServer.NewServer(&natsServer.Options{
+		Host:      "127.0.0.1",
+		Port:      natsServer.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoSigs:    true,
+		NoLog:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Start()
+	if !server.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats server is not ready")
+	}
+	t.Cleanup(server.Shutdown)
+	return server.ClientURL()
+}
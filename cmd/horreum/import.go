@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/unicod3/horreum/api/server"
+	"github.com/unicod3/horreum/internal/importer"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/streamer"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// importFiles imports the inventory and the products files, the inventory
+// is imported first so the products can contain the articles it creates
+func importFiles(db dbclient.DataStorage, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	inventory := flags.String("inventory", "", "inventory file to import the articles from")
+	products := flags.String("products", "", "products file to import the products from")
+	warehouseID := flags.Uint64("warehouse", 0, "warehouse the stock of the inventory file is in")
+	format := flags.String("format", "", "format of the files, json or csv, taken from their extensions when empty")
+	actor := flags.String("actor", "import", "actor the stock movements of the articles are recorded with")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *inventory == "" && *products == "" {
+		return fmt.Errorf("nothing to import, give an -inventory or a -products file")
+	}
+
+	// The events are stored in the outbox and relayed by the server
+	handler := server.NewHandler(&db, streamer.NewChannel())
+	if *inventory != "" {
+		err := importFile(*inventory, *format, func(r io.Reader, format importer.Format) (*importer.Report, error) {
+			return handler.ImportService.Inventory(r, format, *warehouseID, *actor)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if *products != "" {
+		return importFile(*products, *format, handler.ImportService.Products)
+	}
+	return nil
+}
+
+// importFile imports the file with given import and prints its report
+func importFile(name, format string, importFn func(io.Reader, importer.Format) (*importer.Report, error)) error {
+	if format == "" {
+		format = name
+	}
+	f, err := importer.FormatOf(format)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	report, err := importFn(file, f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	fmt.Printf("%s: %d created, %d updated, %d failed\n", name, report.Created, report.Updated, report.Failed)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROW\tEXTERNAL ID\tSTATUS\tID\tERROR")
+	for _, row := range report.Rows {
+		id := "-"
+		if row.ID != 0 {
+			id = fmt.Sprint(row.ID)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", row.Row, row.ExternalID, row.Status, id, row.Error)
+	}
+	return w.Flush()
+}
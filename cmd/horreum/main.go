@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/joho/godotenv"
+	"github.com/nats-io/nats.go"
 	"github.com/unicod3/horreum/api/server"
 	"github.com/unicod3/horreum/pkg/dbclient"
 	"github.com/unicod3/horreum/pkg/streamer"
@@ -10,6 +12,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -24,27 +27,86 @@ func main() {
 		os.Getenv("DATABASE_NAME"),
 		os.Getenv("DATABASE_PASS"))
 
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := replay(db, os.Args[2:]); err != nil {
+			log.Fatalf("replay: %q\n", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := importFiles(db, os.Args[2:]); err != nil {
+			log.Fatalf("import: %q\n", err)
+		}
+		return
+	}
+
+	shutdownTimeout := server.DefaultShutdownTimeout
+	if timeout := os.Getenv("SHUTDOWN_TIMEOUT"); timeout != "" {
+		if shutdownTimeout, err = time.ParseDuration(timeout); err != nil {
+			log.Fatalf("SHUTDOWN_TIMEOUT: %q\n", err)
+		}
+	}
+
 	config := &server.Config{
 		Addr:               ":8080",
 		SwaggerURL:         "localhost:8080",
 		BasePath:           "/api/v1",
 		SwaggerTitle:       "Horreum",
 		SwaggerDescription: "Horreum, is an application to manage products and their stock information.",
+		ShutdownTimeout:    shutdownTimeout,
 	}
 
 	streamService := streamer.NewStreamer()
+	streamChannel, err := newStreamChannel(db)
+	if err != nil {
+		log.Fatalf("streamer: %q\n", err)
+	}
 
 	// Register http server and run
+	srv := server.New(config, &db, streamService, streamChannel)
+	served := make(chan error, 1)
 	go func() {
-		srv := server.New(config, &db, streamService)
-		if err = srv.Serve(); err != nil {
-			fmt.Println("Error: ", err.Error())
-			return
-		}
+		served <- srv.Serve()
 	}()
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	select {
+	case <-quit:
+	case err := <-served:
+		if err != nil {
+			fmt.Println("Error: ", err.Error())
+		}
+	}
+
 	log.Println("Shutting down server...")
+	if err := srv.Shutdown(context.Background()); err != nil {
+		log.Fatalf("shutdown: %q\n", err)
+	}
+	log.Println("Server stopped")
+}
+
+// newStreamChannel returns the streamer.Channel of the STREAM_DRIVER,
+// the in-memory gochannel is used unless it is set to postgres or nats
+func newStreamChannel(db dbclient.DataStorage) (streamer.Channel, error) {
+	consumerGroup := os.Getenv("STREAM_CONSUMER_GROUP")
+	if consumerGroup == "" {
+		consumerGroup = "horreum"
+	}
+
+	switch driver := os.Getenv("STREAM_DRIVER"); driver {
+	case "", "gochannel":
+		return streamer.NewChannel(), nil
+	case "postgres":
+		return streamer.NewPostgresChannel(db.SQLDB(), consumerGroup)
+	case "nats":
+		url := os.Getenv("NATS_URL")
+		if url == "" {
+			url = nats.DefaultURL
+		}
+		return streamer.NewNATSChannel(url, consumerGroup, nats.Name("horreum"))
+	default:
+		return streamer.Channel{}, fmt.Errorf("unknown stream driver %q", driver)
+	}
 }
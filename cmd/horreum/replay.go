@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/unicod3/horreum/api/server"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/streamer"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// replay recomputes the stock from the order events stored in the outbox,
+// prints its differences to the current stock and applies them when asked
+func replay(db dbclient.DataStorage, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	since := flags.String("since", "", "replay the events stored from this RFC3339 time on, required and within the outbox retention")
+	apply := flags.Bool("apply", false, "apply the differences to the stock as adjustments")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *since == "" {
+		return errors.New("-since is required")
+	}
+	from, err := time.Parse(time.RFC3339, *since)
+	if err != nil {
+		return fmt.Errorf("invalid -since: %w", err)
+	}
+
+	// The events are replayed in memory, nothing is published on the channel
+	handler := server.NewHandler(&db, streamer.NewChannel())
+	result, err := handler.ReplayStock(from)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Replayed %d events, skipped %d whose products don't exist anymore\n", result.Events, len(result.Skipped))
+	for _, id := range result.Skipped {
+		fmt.Println("  skipped:", id)
+	}
+	if len(result.Diffs) == 0 {
+		fmt.Println("The stock matches the replayed one")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "ARTICLE\tWAREHOUSE\tCURRENT\tREPLAYED\tDIFF\t")
+	for _, d := range result.Diffs {
+		warehouse := "-"
+		if d.WarehouseID != 0 {
+			warehouse = fmt.Sprint(d.WarehouseID)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%+d\t\n", d.ArticleID, warehouse, d.Current, d.Projected, d.Delta())
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !*apply {
+		fmt.Println("Run with -apply to adjust the stock to the replayed one")
+		return nil
+	}
+	if err := handler.ApplyStockDiffs(result.Diffs); err != nil {
+		return err
+	}
+	fmt.Printf("Applied %d adjustments\n", len(result.Diffs))
+	return nil
+}
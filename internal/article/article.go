@@ -1,9 +1,12 @@
 package article
 
 import (
+	"context"
 	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/outbox"
 	"github.com/unicod3/horreum/pkg/streamer"
 	"math"
+	"sort"
 	"time"
 )
 
@@ -14,25 +17,145 @@ type ArticleRepository interface {
 	Create(*Article) error
 	Update(*Article) error
 	Delete(*Article) error
+	AdjustStock(articleID uint64, delta int64, change StockChange) error
+	SetWarehouseStock(*WarehouseStock, StockChange) error
+	AdjustWarehouseStock(articleID, warehouseID uint64, quantityDelta, reservedDelta int64, change StockChange) error
+	GetMovements(articleID uint64, from, to time.Time) ([]StockMovement, error)
 }
 
+const (
+	ArticleCreated      string = "ArticleCreated"
+	ArticleUpdated             = "ArticleUpdated"
+	ArticleDeleted             = "ArticleDeleted"
+	ArticleStockChanged        = "ArticleStockChanged"
+)
+
+// StockTopic is the topic the ArticleStockChanged events are published on
+const StockTopic = "article_stock"
+
+// StockLevel is the data of the ArticleStockChanged event, it holds the
+// stock of the article in the warehouse after the change along with the
+// deltas of the change. When WarehouseID is zero Quantity is the total
+// stock of the article and the reserved stock is left out.
+type StockLevel struct {
+	ArticleID     uint64 `json:"article_id"`
+	WarehouseID   uint64 `json:"warehouse_id,omitempty"`
+	Quantity      int64  `json:"quantity"`
+	Reserved      int64  `json:"reserved"`
+	QuantityDelta int64  `json:"quantity_delta"`
+	ReservedDelta int64  `json:"reserved_delta"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+// Snapshot is the data of the article events, Before is nil
+// for the created articles and After is nil for the deleted ones
+type Snapshot struct {
+	Before *Article `json:"before"`
+	After  *Article `json:"after"`
+}
+
+// ArticleID returns the pk id of the article the snapshot belongs to
+func (s Snapshot) ArticleID() uint64 {
+	if s.After != nil {
+		return s.After.ID
+	}
+	if s.Before != nil {
+		return s.Before.ID
+	}
+	return 0
+}
+
+// RegisterEvents registers the schemas of the package's events to the registry
+func RegisterEvents(registry *streamer.Registry) {
+	for _, event := range []string{ArticleCreated, ArticleUpdated, ArticleDeleted} {
+		registry.Register(event, 1, Snapshot{})
+	}
+	registry.Register(ArticleStockChanged, 1, StockLevel{})
+}
+
+const (
+	ReasonInitial    string = "initial"
+	ReasonAdjustment        = "adjustment"
+	ReasonReceipt           = "receipt"
+	ReasonShipment          = "shipment"
+	ReasonReturn            = "return"
+	ReasonSale              = "sale"
+	ReasonImport            = "import"
+)
+
+// ActorSystem is the actor of the stock changes Horreum makes on its own
+const ActorSystem = "system"
+
 // Article represents a record from articles table
 type Article struct {
 	ID                 uint64    `json:"id" uri:"id" db:"id,omitempty"`
 	CreatedAt          time.Time `json:"created_at,omitempty" db:"created_at,omitempty"`
 	UpdatedAt          time.Time `json:"updated_at,omitempty" db:"updated_at,omitempty"`
 	Name               string    `json:"name" db:"name,omitempty"`
+	ExternalID         string    `json:"external_id,omitempty" db:"external_id,omitempty"`
 	Stock              int64     `json:"stock" db:"stock"`
-	AmountOf           int64     `json:"amount_of,omitempty" db:"amount_of,omitempty"`
+	Reserved           int64     `json:"reserved,omitempty" db:"-"`
+	AmountOf           int64     `json:"amount_of,omitempty" db:"-"`
+	WarehouseID        uint64    `json:"warehouse_id,omitempty" db:"-"`
+	WarehouseStock     int64     `json:"warehouse_stock,omitempty" db:"-"`
+	WarehouseReserved  int64     `json:"warehouse_reserved,omitempty" db:"-"`
 	AvailableInventory int64     `json:"available_inventory,omitempty" db:"-"`
+	Actor              string    `json:"-" db:"-"`
 }
 
+// CalculateAvailableInventory calculates how many times the article's
+// AmountOf can be served from its unreserved stock, when the article is
+// loaded for a warehouse only the stock of that warehouse is taken into account
 func (a *Article) CalculateAvailableInventory() {
 	if a.AmountOf == 0 {
 		a.AvailableInventory = 0
 		return
 	}
-	a.AvailableInventory = int64(math.Floor(float64(a.Stock / a.AmountOf)))
+	stock := a.Stock - a.Reserved
+	if a.WarehouseID != 0 {
+		stock = a.WarehouseStock - a.WarehouseReserved
+	}
+	a.AvailableInventory = int64(math.Floor(float64(stock / a.AmountOf)))
+}
+
+// WarehouseStock represents a record from warehouse_stock table
+type WarehouseStock struct {
+	ID          uint64    `json:"-" db:"id,omitempty"`
+	WarehouseID uint64    `json:"warehouse_id" db:"warehouse_id"`
+	ArticleID   uint64    `json:"article_id" uri:"id" db:"article_id"`
+	CreatedAt   time.Time `json:"created_at,omitempty" db:"created_at,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at,omitempty" db:"updated_at,omitempty"`
+	Quantity    int64     `json:"quantity" db:"quantity"`
+	Reserved    int64     `json:"reserved" db:"reserved"`
+}
+
+// StockChange describes why and by whom the stock of an article is changed
+type StockChange struct {
+	Reason    string
+	OrderID   *uint64
+	ReceiptID *uint64
+	Actor     string
+}
+
+// StockMovement represents a record from stock_movements table,
+// the stock of an article is the sum of its movements' deltas
+type StockMovement struct {
+	ID          uint64    `json:"id" db:"id,omitempty"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at,omitempty"`
+	ArticleID   uint64    `json:"article_id" db:"article_id"`
+	WarehouseID *uint64   `json:"warehouse_id,omitempty" db:"warehouse_id,omitempty"`
+	Delta       int64     `json:"delta" db:"delta"`
+	Reason      string    `json:"reason" db:"reason"`
+	OrderID     *uint64   `json:"order_id,omitempty" db:"order_id,omitempty"`
+	ReceiptID   *uint64   `json:"receipt_id,omitempty" db:"receipt_id,omitempty"`
+	Actor       string    `json:"actor" db:"actor"`
+}
+
+// MovementQuery represents the date range the movements are filtered by,
+// dates are given in RFC3339 and the range is [from, to)
+type MovementQuery struct {
+	From time.Time `form:"from"`
+	To   time.Time `form:"to"`
 }
 
 // ArticleRequestBody represents the data type that needs to be sent over request
@@ -41,6 +164,14 @@ type ArticleRequestBody struct {
 	Stock int64  `json:"stock" db:"stock"`
 }
 
+// WarehouseStockRequestBody represents the data type that needs to be sent over request
+type WarehouseStockRequestBody struct {
+	WarehouseID uint64  `json:"warehouse_id"`
+	Quantity    int64   `json:"quantity"`
+	Reason      string  `json:"reason,omitempty"`
+	ReceiptID   *uint64 `json:"receipt_id,omitempty"`
+}
+
 // ErrorResponse contains information about error
 type ErrorResponse struct {
 	Code    int    `json:"code"`
@@ -53,6 +184,22 @@ type ArticleService struct {
 	DataTable     dbclient.DataTable
 	StreamChannel streamer.Channel
 	StreamTopic   string
+	// correlationID is carried by the events of the service
+	correlationID string
+}
+
+// WithContext returns a copy of the service whose events carry
+// the correlation id of ctx, when ctx carries one
+func (service *ArticleService) WithContext(ctx context.Context) *ArticleService {
+	return service.WithCorrelationID(streamer.CorrelationIDFrom(ctx))
+}
+
+// WithCorrelationID returns a copy of the service whose events carry the
+// given correlation id, the events get a new one when it is empty
+func (service *ArticleService) WithCorrelationID(id string) *ArticleService {
+	scoped := *service
+	scoped.correlationID = id
+	return &scoped
 }
 
 // GetAll returns all the records
@@ -65,6 +212,56 @@ func (service *ArticleService) GetAll() ([]Article, error) {
 	return articles, nil
 }
 
+// ArticleFields are the fields the articles can be filtered and sorted by,
+// warehouse_id filters the articles kept in the warehouse
+var ArticleFields = dbclient.Fields{
+	"name":       {Type: dbclient.StringField},
+	"stock":      {Type: dbclient.IntField},
+	"created_at": {Type: dbclient.TimeField},
+	"updated_at": {Type: dbclient.TimeField},
+	"warehouse_id": {
+		Type: dbclient.IntField,
+		Condition: func(operator string, value interface{}) dbclient.Expr {
+			return dbclient.Raw("id IN (SELECT article_id FROM warehouse_stock WHERE warehouse_id "+operator+" ?)", value)
+		},
+	},
+}
+
+// GetPage returns the records of the page and the cursor of the next one
+func (service *ArticleService) GetPage(query dbclient.PageQuery) ([]Article, string, error) {
+	var articles []Article
+	next, err := service.DataTable.FindPage(query, &articles)
+	if err != nil {
+		return nil, "", err
+	}
+	return articles, next, nil
+}
+
+// PopulateReserved sets the Reserved of the articles to the stock reserved
+// for them over all the warehouses, it is read with a single query
+func (service *ArticleService) PopulateReserved(articles []Article) error {
+	if len(articles) == 0 {
+		return nil
+	}
+	var ids []uint64
+	for _, a := range articles {
+		ids = append(ids, a.ID)
+	}
+	var stocks []WarehouseStock
+	err := service.DataTable.FindRelated("warehouse_stock", dbclient.Condition{"article_id IN": ids}, &stocks)
+	if err != nil {
+		return err
+	}
+	reserved := make(map[uint64]int64, len(articles))
+	for _, ws := range stocks {
+		reserved[ws.ArticleID] += ws.Reserved
+	}
+	for i := range articles {
+		articles[i].Reserved = reserved[articles[i].ID]
+	}
+	return nil
+}
+
 // GetById returns single record for given pk id
 func (service *ArticleService) GetById(id uint64) (*Article, error) {
 	var article Article
@@ -74,27 +271,317 @@ func (service *ArticleService) GetById(id uint64) (*Article, error) {
 	return &article, nil
 }
 
-// Create creates a new record on the datastore with given struct
-func (service *ArticleService) Create(a *Article) error {
-	if err := service.DataTable.InsertReturning(a); err != nil {
-		return err
+// GetByExternalID returns the record imported with given external id
+func (service *ArticleService) GetByExternalID(externalID string) (*Article, error) {
+	var article Article
+	if err := service.DataTable.FindOne(dbclient.Condition{"external_id": externalID}, &article); err != nil {
+		return nil, err
 	}
-	return nil
+	return &article, nil
 }
 
-// Update updates given record on the datastore by finding it with its pk
+// GetByExternalIDForUpdate returns the record imported with given external
+// id and locks it until the end of the transaction the service works in
+func (service *ArticleService) GetByExternalIDForUpdate(externalID string) (*Article, error) {
+	var articles []Article
+	if err := service.DataTable.FindForUpdate(dbclient.Condition{"external_id": externalID}, &articles); err != nil {
+		return nil, err
+	}
+	if len(articles) == 0 {
+		return nil, dbclient.ErrNoMoreRows
+	}
+	return &articles[0], nil
+}
+
+// GetWarehouseStock returns the stock record of the article with given pk id
+// in the warehouse, an empty one when the article has no stock there yet
+func (service *ArticleService) GetWarehouseStock(articleID, warehouseID uint64) (*WarehouseStock, error) {
+	cond := dbclient.Condition{"article_id": articleID, "warehouse_id": warehouseID}
+	var stock []WarehouseStock
+	if err := service.DataTable.FindRelated("warehouse_stock", cond, &stock); err != nil {
+		return nil, err
+	}
+	if len(stock) == 0 {
+		return &WarehouseStock{ArticleID: articleID, WarehouseID: warehouseID}, nil
+	}
+	return &stock[0], nil
+}
+
+// Create creates a new record on the datastore with given struct, its initial
+// stock is recorded as a movement and its event is stored in the same transaction
+func (service *ArticleService) Create(a *Article) error {
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		if err := tx.InsertReturning(a); err != nil {
+			return err
+		}
+		if err := recordMovement(tx, a.ID, 0, a.Stock, StockChange{Reason: ReasonInitial, Actor: a.Actor}); err != nil {
+			return err
+		}
+		err := service.recordStockLevel(tx, StockLevel{
+			ArticleID:     a.ID,
+			Quantity:      a.Stock,
+			QuantityDelta: a.Stock,
+			Reason:        ReasonInitial,
+		})
+		if err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, ArticleCreated, nil, a)
+	})
+}
+
+// Update updates given record on the datastore by finding it with its pk, the
+// change of its stock is recorded as a movement and its event is stored in
+// the same transaction
 func (service *ArticleService) Update(a *Article) error {
 	a.UpdatedAt = time.Now().UTC()
-	if err := service.DataTable.UpdateReturning(a); err != nil {
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		current, err := findForUpdate(tx, a.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.UpdateReturning(a); err != nil {
+			return err
+		}
+		delta := a.Stock - current.Stock
+		if err := recordMovement(tx, a.ID, 0, delta, StockChange{Reason: ReasonAdjustment, Actor: a.Actor}); err != nil {
+			return err
+		}
+		err = service.recordStockLevel(tx, StockLevel{
+			ArticleID:     a.ID,
+			Quantity:      a.Stock,
+			QuantityDelta: delta,
+			Reason:        ReasonAdjustment,
+		})
+		if err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, ArticleUpdated, current, a)
+	})
+}
+
+// Delete deletes the given struct from database by finding it with its pk,
+// its event is stored in the same transaction
+func (service *ArticleService) Delete(a *Article) error {
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		current, err := findForUpdate(tx, a.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(dbclient.Condition{"id": a.ID}); err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, ArticleDeleted, current, nil)
+	})
+}
+
+// AdjustStock adds delta to the stock of the article with given pk id in a
+// single relative update, so concurrent adjustments never lose each other,
+// and records the movement in the same transaction
+func (service *ArticleService) AdjustStock(articleID uint64, delta int64, change StockChange) error {
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		err := tx.Increment(dbclient.Condition{"id": articleID}, map[string]int64{"stock": delta})
+		if err != nil {
+			return err
+		}
+		if err := recordMovement(tx, articleID, 0, delta, change); err != nil {
+			return err
+		}
+		// The incremented row stays locked until the end of the transaction
+		var current Article
+		if err := tx.FindOne(dbclient.Condition{"id": articleID}, &current); err != nil {
+			return err
+		}
+		return service.recordStockLevel(tx, StockLevel{
+			ArticleID:     articleID,
+			Quantity:      current.Stock,
+			QuantityDelta: delta,
+			Reason:        change.Reason,
+		})
+	})
+}
+
+// SetWarehouseStock sets the quantity of an article in a warehouse,
+// the difference to the previous quantity is applied to the article's
+// stock so that it keeps representing the total over all warehouses.
+// The stock reserved in the warehouse is left untouched.
+func (service *ArticleService) SetWarehouseStock(ws *WarehouseStock, change StockChange) error {
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		updated, err := service.updateWarehouseStock(tx, ws.ArticleID, ws.WarehouseID, change, func(current *WarehouseStock) {
+			current.Quantity = ws.Quantity
+		})
+		if err != nil {
+			return err
+		}
+		*ws = *updated
+		return nil
+	})
+}
+
+// AdjustWarehouseStock adds the given deltas to the quantity and the reserved
+// stock of an article in a warehouse, the quantity delta is applied to the
+// article's stock as well
+func (service *ArticleService) AdjustWarehouseStock(articleID, warehouseID uint64, quantityDelta, reservedDelta int64, change StockChange) error {
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		_, err := service.updateWarehouseStock(tx, articleID, warehouseID, change, func(current *WarehouseStock) {
+			current.Quantity += quantityDelta
+			current.Reserved += reservedDelta
+		})
 		return err
+	})
+}
+
+// GetMovements returns the stock movements of the article with given pk id
+// in the order they happened, zero from and to leave the range open
+func (service *ArticleService) GetMovements(articleID uint64, from, to time.Time) ([]StockMovement, error) {
+	cond := dbclient.Condition{"article_id": articleID}
+	if !from.IsZero() {
+		cond["created_at >="] = from.UTC()
 	}
-	return nil
+	if !to.IsZero() {
+		cond["created_at <"] = to.UTC()
+	}
+
+	var movements []StockMovement
+	if err := service.DataTable.FindRelated("stock_movements", cond, &movements); err != nil {
+		return nil, err
+	}
+	sort.Slice(movements, func(i, j int) bool { return movements[i].ID < movements[j].ID })
+	return movements, nil
 }
 
-// Delete deletes the given struct from database by finding it with its pk
-func (service *ArticleService) Delete(a *Article) error {
-	if err := service.DataTable.Delete(dbclient.Condition{"id": a.ID}); err != nil {
+// updateWarehouseStock locks the article until the end of the transaction
+// and lets update change its stock record in the warehouse, the difference
+// in quantity is applied to the article's stock and recorded as a movement
+func (service *ArticleService) updateWarehouseStock(tx dbclient.DataTable, articleID, warehouseID uint64, change StockChange, update func(*WarehouseStock)) (*WarehouseStock, error) {
+	// The lock serializes the changes of the article's warehouse stock
+	if _, err := findForUpdate(tx, articleID); err != nil {
+		return nil, err
+	}
+
+	cond := dbclient.Condition{"article_id": articleID, "warehouse_id": warehouseID}
+	var current []WarehouseStock
+	if err := tx.FindRelated("warehouse_stock", cond, &current); err != nil {
+		return nil, err
+	}
+	ws := WarehouseStock{ArticleID: articleID, WarehouseID: warehouseID}
+	if len(current) > 0 {
+		ws = current[0]
+	}
+	previous, previousReserved := ws.Quantity, ws.Reserved
+	update(&ws)
+
+	// The record is updated in place, so it keeps its creation time
+	ws.UpdatedAt = time.Now().UTC()
+	if ws.ID == 0 {
+		if err := tx.CreateRelated("warehouse_stock", &ws); err != nil {
+			return nil, err
+		}
+	} else if err := tx.Related("warehouse_stock").UpdateReturning(&ws); err != nil {
+		return nil, err
+	}
+
+	if ws.Quantity != previous {
+		err := tx.Increment(dbclient.Condition{"id": articleID}, map[string]int64{"stock": ws.Quantity - previous})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := recordMovement(tx, articleID, warehouseID, ws.Quantity-previous, change); err != nil {
+		return nil, err
+	}
+	err := service.recordStockLevel(tx, StockLevel{
+		ArticleID:     articleID,
+		WarehouseID:   warehouseID,
+		Quantity:      ws.Quantity,
+		Reserved:      ws.Reserved,
+		QuantityDelta: ws.Quantity - previous,
+		ReservedDelta: ws.Reserved - previousReserved,
+		Reason:        change.Reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ws, nil
+}
+
+// PublishEvent writes the event with the snapshots of the article before and
+// after the change to the outbox within the transaction of tx, the
+// outbox.Relay publishes it on the StreamChannel once it is committed
+func (service *ArticleService) PublishEvent(tx dbclient.DataTable, event string, before, after *Article) error {
+	snapshot := Snapshot{Before: before, After: after}
+	msg, err := streamer.NewMessage(&streamer.Message{
+		EventName:     event,
+		AggregateType: "article",
+		AggregateID:   snapshot.ArticleID(),
+		CorrelationID: service.correlationID,
+		Data:          snapshot,
+	})
+	if err != nil {
 		return err
 	}
-	return nil
+	return outbox.Store(tx, service.StreamTopic, msg)
+}
+
+// findForUpdate returns the article with given pk id and
+// locks it until the end of the transaction
+func findForUpdate(tx dbclient.DataTable, id uint64) (*Article, error) {
+	var articles []Article
+	if err := tx.FindForUpdate(dbclient.Condition{"id": id}, &articles); err != nil {
+		return nil, err
+	}
+	if len(articles) == 0 {
+		return nil, dbclient.ErrNoMoreRows
+	}
+	return &articles[0], nil
+}
+
+// recordMovement writes the stock movement of the given delta,
+// warehouseID is left empty when zero and nothing is written for a zero delta
+func recordMovement(tx dbclient.DataTable, articleID, warehouseID uint64, delta int64, change StockChange) error {
+	if delta == 0 {
+		return nil
+	}
+	movement := StockMovement{
+		CreatedAt: time.Now().UTC(),
+		ArticleID: articleID,
+		Delta:     delta,
+		Reason:    change.Reason,
+		OrderID:   change.OrderID,
+		ReceiptID: change.ReceiptID,
+		Actor:     change.Actor,
+	}
+	if warehouseID != 0 {
+		movement.WarehouseID = &warehouseID
+	}
+	if movement.Reason == "" {
+		movement.Reason = ReasonAdjustment
+	}
+	if movement.Actor == "" {
+		movement.Actor = ActorSystem
+	}
+	return tx.CreateRelated("stock_movements", &movement)
+}
+
+// recordStockLevel writes the ArticleStockChanged event of the given level to
+// the outbox, nothing is written when neither the stock nor the reserved stock
+// is changed
+func (service *ArticleService) recordStockLevel(tx dbclient.DataTable, level StockLevel) error {
+	if level.QuantityDelta == 0 && level.ReservedDelta == 0 {
+		return nil
+	}
+	if level.QuantityDelta != 0 && level.Reason == "" {
+		level.Reason = ReasonAdjustment
+	}
+	msg, err := streamer.NewMessage(&streamer.Message{
+		EventName:     ArticleStockChanged,
+		AggregateType: "article",
+		AggregateID:   level.ArticleID,
+		CorrelationID: service.correlationID,
+		Data:          level,
+	})
+	if err != nil {
+		return err
+	}
+	return outbox.Store(tx, StockTopic, msg)
 }
@@ -2,23 +2,47 @@ package article
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/unicod3/horreum/pkg/apierror"
+	"github.com/unicod3/horreum/pkg/dbclient"
 	"net/http"
 )
 
 // ListArticles example
 // @Tags articles
-// @Summary Get all articles
-// @Description Get all articles
+// @Summary Get a page of the articles
+// @Description Get a page of the articles, the Link and X-Next-Cursor headers point to the next page
 // @ID list-articles
 // @Accept  json
 // @Produce  json
+// @Param limit query int false "Number of articles in the page, 50 by default and 500 at most"
+// @Param cursor query string false "Cursor of the page, the X-Next-Cursor of the previous page"
+// @Param sort query string false "Comma separated fields to sort by, descending when prefixed with -"
+// @Param name~ query string false "Name contains, case insensitively"
+// @Param stock_lt query int false "Stock is less than, _lte, _gt and _gte are alike"
+// @Param warehouse_id query int false "Articles kept in the warehouse"
+// @Param created_after query string false "RFC3339 date the articles are created after, created_before is alike"
 // @Success 200 {array} Article
+// @Header 200 {string} Link "Link to the next page"
+// @Header 200 {string} X-Next-Cursor "Cursor of the next page"
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Router /articles/ [get]
 func (service *ArticleService) ListArticles(g *gin.Context) {
-	articles, err := service.GetAll()
+	query, err := dbclient.ParsePageQuery(g.Request.URL.Query(), ArticleFields)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	articles, next, err := service.GetPage(query)
 	if err != nil {
+		writeError(g, err)
 		return
 	}
+	dbclient.WritePageHeaders(g.Writer.Header(), g.Request.URL, next)
 	g.JSON(http.StatusOK, articles)
 }
 
@@ -77,7 +101,8 @@ func (service *ArticleService) CreateArticle(g *gin.Context) {
 		return
 	}
 
-	err := service.Create(&article)
+	article.Actor = actor(g)
+	err := service.WithContext(g.Request.Context()).Create(&article)
 	if err != nil {
 		g.JSON(http.StatusInternalServerError, ErrorResponse{
 			Code:    http.StatusInternalServerError,
@@ -121,12 +146,10 @@ func (service *ArticleService) UpdateArticle(g *gin.Context) {
 		return
 	}
 
-	err := service.Update(&article)
+	article.Actor = actor(g)
+	err := service.WithContext(g.Request.Context()).Update(&article)
 	if err != nil {
-		g.JSON(http.StatusInternalServerError, ErrorResponse{
-			Code:    http.StatusInternalServerError,
-			Message: err.Error(),
-		})
+		writeError(g, err)
 		return
 	}
 
@@ -156,7 +179,99 @@ func (service *ArticleService) DeleteArticle(g *gin.Context) {
 		return
 	}
 
-	err := service.Delete(&article)
+	err := service.WithContext(g.Request.Context()).Delete(&article)
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	g.Status(http.StatusNoContent)
+}
+
+// SetArticleWarehouseStock example
+// @Tags articles
+// @Summary Set the stock of an article in a warehouse
+// @Description Set the stock of an article in a warehouse, the article's total stock is adjusted by the difference
+// @ID set-article-warehouse-stock
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Article ID"
+// @Param stock body WarehouseStockRequestBody true "Warehouse stock"
+// @Success 200 {object} WarehouseStock
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /articles/{id}/stock [put]
+func (service *ArticleService) SetArticleWarehouseStock(g *gin.Context) {
+	var stock WarehouseStock
+	var body WarehouseStockRequestBody
+
+	if err := g.ShouldBindUri(&stock); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the params",
+		})
+		return
+	}
+
+	if err := g.ShouldBindJSON(&body); err != nil || body.WarehouseID == 0 {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the body",
+		})
+		return
+	}
+
+	stock.WarehouseID = body.WarehouseID
+	stock.Quantity = body.Quantity
+	err := service.WithContext(g.Request.Context()).SetWarehouseStock(&stock, StockChange{
+		Reason:    body.Reason,
+		ReceiptID: body.ReceiptID,
+		Actor:     actor(g),
+	})
+	if err != nil {
+		g.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	g.JSON(http.StatusOK, stock)
+}
+
+// ListArticleMovements example
+// @Tags articles
+// @Summary Get the stock movements of an article
+// @Description Get the stock movements of an article in the order they happened, optionally within [from, to)
+// @ID list-article-movements
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Article ID"
+// @Param from query string false "RFC3339 date the movements start from"
+// @Param to query string false "RFC3339 date the movements end before"
+// @Success 200 {array} StockMovement
+// @Failure 400 {object} ErrorResponse
+// @Router /articles/{id}/movements [get]
+func (service *ArticleService) ListArticleMovements(g *gin.Context) {
+	var article Article
+	var query MovementQuery
+
+	if err := g.ShouldBindUri(&article); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the params",
+		})
+		return
+	}
+
+	if err := g.ShouldBindQuery(&query); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the query",
+		})
+		return
+	}
+
+	movements, err := service.GetMovements(article.ID, query.From, query.To)
 	if err != nil {
 		g.JSON(http.StatusInternalServerError, ErrorResponse{
 			Code:    http.StatusInternalServerError,
@@ -164,5 +279,16 @@ func (service *ArticleService) DeleteArticle(g *gin.Context) {
 		})
 		return
 	}
-	g.Status(http.StatusNoContent)
+	g.JSON(http.StatusOK, movements)
 }
+
+// actor returns who makes the request from the X-Actor header
+func actor(g *gin.Context) string {
+	if actor := g.GetHeader("X-Actor"); actor != "" {
+		return actor
+	}
+	return "api"
+}
+
+// writeError writes the response matching the given service error
+var writeError = apierror.Writer()
@@ -1,13 +1,60 @@
 package article
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/unicod3/horreum/pkg/dbclient"
 	"github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"github.com/unicod3/horreum/pkg/outbox"
+	"github.com/unicod3/horreum/pkg/streamer"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
+// articleEvent is the payload of the published article events
+type articleEvent struct {
+	EventName string
+	Data      struct {
+		Before *Article `json:"before"`
+		After  *Article `json:"after"`
+	}
+}
+
+// mockEvent expects a single event to be written to the outbox on the
+// articles topic and returns the event it is written with
+func mockEvent(dataTable *mocks.DataTable) *articleEvent {
+	var event articleEvent
+	dataTable.On("CreateRelated", outbox.TableName, mock.MatchedBy(func(m *outbox.Message) bool {
+		return m.Topic == "articles"
+	})).Run(func(args mock.Arguments) {
+		json.Unmarshal([]byte(args.Get(1).(*outbox.Message).Payload), &event)
+	}).Return(nil).Once()
+	return &event
+}
+
+// stockEvent is the payload of the ArticleStockChanged events
+type stockEvent struct {
+	EventName string
+	Data      StockLevel
+}
+
+// mockStockLevel expects a stock level to be written to the outbox
+// and returns the event it is written with
+func mockStockLevel(dataTable *mocks.DataTable) *stockEvent {
+	var event stockEvent
+	dataTable.On("CreateRelated", outbox.TableName, mock.MatchedBy(func(m *outbox.Message) bool {
+		return m.Topic == StockTopic
+	})).Run(func(args mock.Arguments) {
+		json.Unmarshal([]byte(args.Get(1).(*outbox.Message).Payload), &event)
+	}).Return(nil).Once()
+	return &event
+}
+
 func TestArticle_CalculateAvailableInventory(t *testing.T) {
 	assert := assert.New(t)
 
@@ -34,6 +81,28 @@ func TestArticle_CalculateAvailableInventory(t *testing.T) {
 		article.CalculateAvailableInventory()
 		assert.Equal(int64(2), article.AvailableInventory)
 	})
+
+	t.Run("Test can subtract the reserved stock", func(t *testing.T) {
+		article := &Article{
+			Stock:    10,
+			Reserved: 4,
+			AmountOf: 3,
+		}
+		article.CalculateAvailableInventory()
+		assert.Equal(int64(2), article.AvailableInventory)
+	})
+
+	t.Run("Test can use the warehouse stock", func(t *testing.T) {
+		article := &Article{
+			Stock:             10,
+			AmountOf:          2,
+			WarehouseID:       1,
+			WarehouseStock:    7,
+			WarehouseReserved: 3,
+		}
+		article.CalculateAvailableInventory()
+		assert.Equal(int64(2), article.AvailableInventory)
+	})
 }
 
 func TestArticleServiceImplementsArticleRepositoryInterface(t *testing.T) {
@@ -62,6 +131,53 @@ func TestArticleService_GetAll(t *testing.T) {
 	assert.Equal(articles, w)
 }
 
+func TestArticleService_GetPage(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	articleService := &ArticleService{
+		DataTable: &dataTable,
+	}
+
+	query, err := dbclient.ParsePageQuery(url.Values{"warehouse_id": {"2"}, "stock_lt": {"5"}}, ArticleFields)
+	assert.Nil(err)
+	articles := []Article{{ID: 1, Name: "test", Stock: 4}}
+	dataTable.On("FindPage", query, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(1).(*[]Article) = articles
+	}).Return("", nil).Once()
+
+	w, next, err := articleService.GetPage(query)
+	assert.Nil(err)
+	assert.Empty(next)
+	assert.Equal(articles, w)
+
+	_, err = dbclient.ParsePageQuery(url.Values{"sort": {"warehouse_id"}}, ArticleFields)
+	assert.ErrorIs(err, dbclient.ErrInvalidPageQuery)
+}
+
+func TestArticleService_PopulateReserved(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	articleService := &ArticleService{
+		DataTable: &dataTable,
+	}
+
+	dataTable.On("FindRelated", "warehouse_stock", dbclient.Condition{"article_id IN": []uint64{1, 2}}, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(2).(*[]WarehouseStock) = []WarehouseStock{
+			{WarehouseID: 1, ArticleID: 1, Quantity: 10, Reserved: 2},
+			{WarehouseID: 2, ArticleID: 1, Quantity: 5, Reserved: 3},
+		}
+	}).Return(nil).Once()
+
+	articles := []Article{{ID: 1, Stock: 15}, {ID: 2, Stock: 4}}
+	assert.Nil(articleService.PopulateReserved(articles))
+	assert.Equal(int64(5), articles[0].Reserved)
+	assert.Equal(int64(0), articles[1].Reserved)
+	assert.Nil(articleService.PopulateReserved(nil))
+	dataTable.AssertExpectations(t)
+}
+
 func TestArticleService_GetById(t *testing.T) {
 	assert := assert.New(t)
 
@@ -81,7 +197,7 @@ func TestArticleService_GetById(t *testing.T) {
 	assert.Equal(article, w)
 }
 
-func TestArticleService_Create(t *testing.T) {
+func TestArticleService_GetByExternalIDForUpdate(t *testing.T) {
 	assert := assert.New(t)
 
 	dataTable := mocks.DataTable{}
@@ -89,15 +205,107 @@ func TestArticleService_Create(t *testing.T) {
 		DataTable: &dataTable,
 	}
 
-	article := Article{ID: 1, Name: "test"}
+	dataTable.On("FindForUpdate", dbclient.Condition{"external_id": "1"}, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(1).(*[]Article) = []Article{{ID: 10, ExternalID: "1"}}
+	}).Return(nil).Once()
+	dataTable.On("FindForUpdate", dbclient.Condition{"external_id": "2"}, mock.Anything).Return(nil).Once()
+
+	a, err := articleService.GetByExternalIDForUpdate("1")
+	assert.Nil(err)
+	assert.Equal(uint64(10), a.ID)
+	_, err = articleService.GetByExternalIDForUpdate("2")
+	assert.ErrorIs(err, dbclient.ErrNoMoreRows)
+	dataTable.AssertExpectations(t)
+}
 
+func TestArticleService_GetWarehouseStock(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	articleService := &ArticleService{
+		DataTable: &dataTable,
+	}
+
+	dataTable.On("FindRelated", "warehouse_stock", dbclient.Condition{"article_id": uint64(1), "warehouse_id": uint64(2)}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*args.Get(2).(*[]WarehouseStock) = []WarehouseStock{{ID: 5, ArticleID: 1, WarehouseID: 2, Quantity: 7}}
+		}).Return(nil).Once()
+	dataTable.On("FindRelated", "warehouse_stock", dbclient.Condition{"article_id": uint64(1), "warehouse_id": uint64(3)}, mock.Anything).
+		Return(nil).Once()
+
+	ws, err := articleService.GetWarehouseStock(1, 2)
+	assert.Nil(err)
+	assert.Equal(int64(7), ws.Quantity)
+	ws, err = articleService.GetWarehouseStock(1, 3)
+	assert.Nil(err)
+	assert.Equal(&WarehouseStock{ArticleID: 1, WarehouseID: 3}, ws, "an article without stock in the warehouse has none")
+	dataTable.AssertExpectations(t)
+}
+
+func TestArticleService_Create(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	articleService := &ArticleService{
+		DataTable:   &dataTable,
+		StreamTopic: "articles",
+	}
+	event := mockEvent(&dataTable)
+
+	article := Article{ID: 1, Name: "test", Stock: 5, Actor: "tester"}
+
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
 	var w Article
 	dataTable.On("InsertReturning", &article).Run(func(args mock.Arguments) {
 		w = article
 	}).Return(nil).Once()
+	dataTable.On("CreateRelated", "stock_movements", mock.MatchedBy(func(m *StockMovement) bool {
+		return m.ArticleID == 1 && m.WarehouseID == nil && m.Delta == 5 &&
+			m.Reason == ReasonInitial && m.Actor == "tester"
+	})).Return(nil).Once()
+	level := mockStockLevel(&dataTable)
 	err := articleService.Create(&article)
 	assert.Nil(err)
 	assert.Equal(article, w)
+	dataTable.AssertExpectations(t)
+	assert.Equal(ArticleCreated, event.EventName)
+	assert.Nil(event.Data.Before)
+	assert.Equal("test", event.Data.After.Name)
+	assert.Equal(ArticleStockChanged, level.EventName)
+	assert.Equal(StockLevel{ArticleID: 1, Quantity: 5, QuantityDelta: 5, Reason: ReasonInitial}, level.Data)
+}
+
+func TestArticleService_WithContext(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	articleService := &ArticleService{
+		DataTable:   &dataTable,
+		StreamTopic: "articles",
+	}
+
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
+	dataTable.On("InsertReturning", mock.Anything).Return(nil).Once()
+	dataTable.On("CreateRelated", "stock_movements", mock.Anything).Return(nil).Once()
+	var topics []string
+	dataTable.On("CreateRelated", outbox.TableName, mock.Anything).Run(func(args mock.Arguments) {
+		stored := args.Get(1).(*outbox.Message)
+		assert.Contains(stored.Metadata, `"correlation_id":"request-1"`, "the %s event must carry the id of the request", stored.Topic)
+		topics = append(topics, stored.Topic)
+	}).Return(nil).Twice()
+
+	ctx := streamer.WithCorrelationID(context.Background(), "request-1")
+	err := articleService.WithContext(ctx).Create(&Article{ID: 1, Name: "test", Stock: 5})
+	assert.Nil(err)
+	dataTable.AssertExpectations(t)
+	assert.Equal([]string{StockTopic, "articles"}, topics)
+	assert.Empty(articleService.correlationID, "the service itself must stay unscoped")
 }
 
 func TestArticleService_Update(t *testing.T) {
@@ -105,18 +313,64 @@ func TestArticleService_Update(t *testing.T) {
 
 	dataTable := mocks.DataTable{}
 	articleService := &ArticleService{
-		DataTable: &dataTable,
+		DataTable:   &dataTable,
+		StreamTopic: "articles",
 	}
+	event := mockEvent(&dataTable)
 
-	article := Article{ID: 1, Name: "test"}
+	article := Article{ID: 1, Name: "test", Stock: 2}
 
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
+	dataTable.On("FindForUpdate", dbclient.Condition{"id": article.ID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*[]Article)) = []Article{{ID: 1, Name: "test", Stock: 5}}
+	}).Return(nil).Once()
 	var w Article
 	dataTable.On("UpdateReturning", &article).Run(func(args mock.Arguments) {
 		w = article
 	}).Return(nil).Once()
+	dataTable.On("CreateRelated", "stock_movements", mock.MatchedBy(func(m *StockMovement) bool {
+		return m.ArticleID == 1 && m.Delta == -3 && m.Reason == ReasonAdjustment && m.Actor == ActorSystem
+	})).Return(nil).Once()
+	level := mockStockLevel(&dataTable)
 	err := articleService.Update(&article)
 	assert.Nil(err)
 	assert.Equal(article, w)
+	dataTable.AssertExpectations(t)
+	assert.Equal(ArticleUpdated, event.EventName)
+	assert.Equal(int64(5), event.Data.Before.Stock)
+	assert.Equal(int64(2), event.Data.After.Stock)
+	assert.Equal(StockLevel{ArticleID: 1, Quantity: 2, QuantityDelta: -3, Reason: ReasonAdjustment}, level.Data)
+}
+
+func TestArticleService_UpdateKeepingStock(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	articleService := &ArticleService{
+		DataTable:   &dataTable,
+		StreamTopic: "articles",
+	}
+	mockEvent(&dataTable)
+
+	article := Article{ID: 1, Name: "renamed", Stock: 5}
+
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
+	dataTable.On("FindForUpdate", dbclient.Condition{"id": article.ID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*[]Article)) = []Article{{ID: 1, Name: "test", Stock: 5}}
+	}).Return(nil).Once()
+	dataTable.On("UpdateReturning", &article).Return(nil).Once()
+	err := articleService.Update(&article)
+	assert.Nil(err)
+	dataTable.AssertNotCalled(t, "CreateRelated", "stock_movements", mock.Anything)
+	dataTable.AssertNotCalled(t, "CreateRelated", outbox.TableName, mock.MatchedBy(func(m *outbox.Message) bool {
+		return m.Topic == StockTopic
+	}))
 }
 
 func TestArticleService_Delete(t *testing.T) {
@@ -124,11 +378,175 @@ func TestArticleService_Delete(t *testing.T) {
 
 	dataTable := mocks.DataTable{}
 	articleService := &ArticleService{
-		DataTable: &dataTable,
+		DataTable:   &dataTable,
+		StreamTopic: "articles",
 	}
+	event := mockEvent(&dataTable)
 
-	article := Article{ID: 1, Name: "test"}
+	article := Article{ID: 1}
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
+	dataTable.On("FindForUpdate", dbclient.Condition{"id": article.ID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*[]Article)) = []Article{{ID: 1, Name: "test"}}
+	}).Return(nil).Once()
 	dataTable.On("Delete", dbclient.Condition{"id": article.ID}).Return(nil).Once()
 	err := articleService.Delete(&article)
 	assert.Nil(err)
+	assert.Equal(ArticleDeleted, event.EventName)
+	assert.Equal("test", event.Data.Before.Name)
+	assert.Nil(event.Data.After)
+}
+
+func TestArticle_MapsOnlyTheColumnsOfTheTable(t *testing.T) {
+	columns := map[string]bool{"id": true, "created_at": true, "updated_at": true, "name": true, "external_id": true, "stock": true}
+	typ := reflect.TypeOf(Article{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("db"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		assert.True(t, columns[name], "%s isn't a column of the articles table", name)
+	}
+}
+
+func TestArticleService_SetWarehouseStock(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	articleService := &ArticleService{
+		DataTable: &dataTable,
+	}
+
+	article := Article{ID: 1, Name: "test", Stock: 10}
+	stock := WarehouseStock{ArticleID: 1, WarehouseID: 2, Quantity: 7}
+	created := time.Date(2022, 2, 9, 10, 0, 0, 0, time.UTC)
+	cond := dbclient.Condition{"article_id": stock.ArticleID, "warehouse_id": stock.WarehouseID}
+
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
+	dataTable.On("FindForUpdate", dbclient.Condition{"id": article.ID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*[]Article)) = []Article{article}
+	}).Return(nil).Once()
+	dataTable.On("FindRelated", "warehouse_stock", cond, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(2).(*[]WarehouseStock)) = []WarehouseStock{{ID: 5, ArticleID: 1, WarehouseID: 2, CreatedAt: created, Quantity: 3, Reserved: 2}}
+	}).Return(nil).Once()
+	stockTable := mocks.DataTable{}
+	dataTable.On("Related", "warehouse_stock").Return(&stockTable).Once()
+	stockTable.On("UpdateReturning", mock.MatchedBy(func(ws *WarehouseStock) bool {
+		return ws.ID == 5 && ws.CreatedAt.Equal(created) && ws.Quantity == 7 && ws.Reserved == 2
+	})).Return(nil).Once()
+
+	dataTable.On("Increment", dbclient.Condition{"id": article.ID}, map[string]int64{"stock": 4}).Return(nil).Once()
+
+	receiptID := uint64(9)
+	dataTable.On("CreateRelated", "stock_movements", mock.MatchedBy(func(m *StockMovement) bool {
+		return m.ArticleID == 1 && *m.WarehouseID == 2 && m.Delta == 4 &&
+			m.Reason == ReasonReceipt && *m.ReceiptID == receiptID && m.Actor == "tester"
+	})).Return(nil).Once()
+	level := mockStockLevel(&dataTable)
+
+	err := articleService.SetWarehouseStock(&stock, StockChange{Reason: ReasonReceipt, ReceiptID: &receiptID, Actor: "tester"})
+	assert.Nil(err)
+	assert.Equal(int64(2), stock.Reserved)
+	dataTable.AssertExpectations(t)
+	assert.Equal(StockLevel{
+		ArticleID:     1,
+		WarehouseID:   2,
+		Quantity:      7,
+		Reserved:      2,
+		QuantityDelta: 4,
+		Reason:        ReasonReceipt,
+	}, level.Data)
+}
+
+func TestArticleService_AdjustWarehouseStock(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	articleService := &ArticleService{
+		DataTable: &dataTable,
+	}
+
+	article := Article{ID: 1, Name: "test", Stock: 10}
+	cond := dbclient.Condition{"article_id": uint64(1), "warehouse_id": uint64(2)}
+
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
+	dataTable.On("FindForUpdate", dbclient.Condition{"id": article.ID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*[]Article)) = []Article{article}
+	}).Return(nil).Once()
+	dataTable.On("FindRelated", "warehouse_stock", cond, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(2).(*[]WarehouseStock)) = []WarehouseStock{{ID: 5, ArticleID: 1, WarehouseID: 2, Quantity: 6, Reserved: 4}}
+	}).Return(nil).Once()
+	stockTable := mocks.DataTable{}
+	dataTable.On("Related", "warehouse_stock").Return(&stockTable).Once()
+	stockTable.On("UpdateReturning", mock.MatchedBy(func(ws *WarehouseStock) bool {
+		return ws.ID == 5 && ws.Quantity == 3 && ws.Reserved == 1
+	})).Return(nil).Once()
+
+	dataTable.On("Increment", dbclient.Condition{"id": article.ID}, map[string]int64{"stock": -3}).Return(nil).Once()
+
+	orderID := uint64(5)
+	dataTable.On("CreateRelated", "stock_movements", mock.MatchedBy(func(m *StockMovement) bool {
+		return m.ArticleID == 1 && *m.WarehouseID == 2 && m.Delta == -3 &&
+			m.Reason == ReasonShipment && *m.OrderID == orderID && m.Actor == ActorSystem
+	})).Return(nil).Once()
+	level := mockStockLevel(&dataTable)
+
+	err := articleService.AdjustWarehouseStock(1, 2, -3, -3, StockChange{Reason: ReasonShipment, OrderID: &orderID})
+	assert.Nil(err)
+	dataTable.AssertExpectations(t)
+	assert.Equal(StockLevel{
+		ArticleID:     1,
+		WarehouseID:   2,
+		Quantity:      3,
+		Reserved:      1,
+		QuantityDelta: -3,
+		ReservedDelta: -3,
+		Reason:        ReasonShipment,
+	}, level.Data)
+}
+
+func TestArticleService_GetMovements(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	articleService := &ArticleService{
+		DataTable: &dataTable,
+	}
+
+	from := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Test can filter by date range", func(t *testing.T) {
+		cond := dbclient.Condition{"article_id": uint64(1), "created_at >=": from, "created_at <": to}
+		dataTable.On("FindRelated", "stock_movements", cond, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]StockMovement)) = []StockMovement{
+				{ID: 3, ArticleID: 1, Delta: -2},
+				{ID: 1, ArticleID: 1, Delta: 10},
+			}
+		}).Return(nil).Once()
+
+		movements, err := articleService.GetMovements(1, from, to)
+		assert.Nil(err)
+		assert.Equal([]StockMovement{
+			{ID: 1, ArticleID: 1, Delta: 10},
+			{ID: 3, ArticleID: 1, Delta: -2},
+		}, movements)
+	})
+
+	t.Run("Test can leave the range open", func(t *testing.T) {
+		cond := dbclient.Condition{"article_id": uint64(1)}
+		dataTable.On("FindRelated", "stock_movements", cond, mock.Anything).Return(nil).Once()
+
+		_, err := articleService.GetMovements(1, time.Time{}, time.Time{})
+		assert.Nil(err)
+		dataTable.AssertExpectations(t)
+	})
 }
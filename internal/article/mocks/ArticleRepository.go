@@ -5,6 +5,8 @@ package mocks
 import (
 	mock "github.com/stretchr/testify/mock"
 	article "github.com/unicod3/horreum/internal/article"
+
+	time "time"
 )
 
 // ArticleRepository is an autogenerated mock type for the ArticleRepository type
@@ -12,6 +14,34 @@ type ArticleRepository struct {
 	mock.Mock
 }
 
+// AdjustStock provides a mock function with given fields: articleID, delta, change
+func (_m *ArticleRepository) AdjustStock(articleID uint64, delta int64, change article.StockChange) error {
+	ret := _m.Called(articleID, delta, change)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uint64, int64, article.StockChange) error); ok {
+		r0 = rf(articleID, delta, change)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AdjustWarehouseStock provides a mock function with given fields: articleID, warehouseID, quantityDelta, reservedDelta, change
+func (_m *ArticleRepository) AdjustWarehouseStock(articleID uint64, warehouseID uint64, quantityDelta int64, reservedDelta int64, change article.StockChange) error {
+	ret := _m.Called(articleID, warehouseID, quantityDelta, reservedDelta, change)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uint64, uint64, int64, int64, article.StockChange) error); ok {
+		r0 = rf(articleID, warehouseID, quantityDelta, reservedDelta, change)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Create provides a mock function with given fields: _a0
 func (_m *ArticleRepository) Create(_a0 *article.Article) error {
 	ret := _m.Called(_a0)
@@ -86,6 +116,43 @@ func (_m *ArticleRepository) GetById(_a0 uint64) (*article.Article, error) {
 	return r0, r1
 }
 
+// GetMovements provides a mock function with given fields: articleID, from, to
+func (_m *ArticleRepository) GetMovements(articleID uint64, from time.Time, to time.Time) ([]article.StockMovement, error) {
+	ret := _m.Called(articleID, from, to)
+
+	var r0 []article.StockMovement
+	if rf, ok := ret.Get(0).(func(uint64, time.Time, time.Time) []article.StockMovement); ok {
+		r0 = rf(articleID, from, to)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]article.StockMovement)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(uint64, time.Time, time.Time) error); ok {
+		r1 = rf(articleID, from, to)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetWarehouseStock provides a mock function with given fields: _a0, _a1
+func (_m *ArticleRepository) SetWarehouseStock(_a0 *article.WarehouseStock, _a1 article.StockChange) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*article.WarehouseStock, article.StockChange) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Update provides a mock function with given fields: _a0
 func (_m *ArticleRepository) Update(_a0 *article.Article) error {
 	ret := _m.Called(_a0)
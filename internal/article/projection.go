@@ -0,0 +1,183 @@
+package article
+
+import (
+	"errors"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotProjected is returned by the Projection methods which don't change the stock
+var ErrNotProjected = errors.New("projection only keeps the stock changes")
+
+// StockKey identifies the stock of an article in a warehouse, WarehouseID
+// is zero for the stock which isn't kept in any warehouse
+type StockKey struct {
+	ArticleID   uint64 `json:"article_id"`
+	WarehouseID uint64 `json:"warehouse_id"`
+}
+
+// StockDiff is the difference between the current and the projected stock
+type StockDiff struct {
+	StockKey
+	Current   int64 `json:"current"`
+	Projected int64 `json:"projected"`
+}
+
+// Delta returns the change which turns the current stock into the projected one
+func (d StockDiff) Delta() int64 {
+	return d.Projected - d.Current
+}
+
+// Projection is an ArticleRepository which keeps the stock changes made
+// through it in memory, so the stock can be recomputed from the history
+// without touching the database. Only the methods adjusting the stock
+// are supported, the reserved stock is left out.
+type Projection struct {
+	mu    sync.Mutex
+	stock map[StockKey]int64
+}
+
+// NewProjection returns an empty Projection
+func NewProjection() *Projection {
+	return &Projection{stock: make(map[StockKey]int64)}
+}
+
+// ProjectStock returns the Projection the order events made from the given
+// time on are to be replayed on, it holds all the movements made before from
+// and the ones made after it which don't refer to an order
+func (service *ArticleService) ProjectStock(from time.Time) (*Projection, error) {
+	from = from.UTC()
+	var before, after []StockMovement
+	err := service.DataTable.FindRelated("stock_movements", dbclient.Condition{"created_at <": from}, &before)
+	if err != nil {
+		return nil, err
+	}
+	err = service.DataTable.FindRelated("stock_movements", dbclient.Condition{"created_at >=": from, "order_id IS": nil}, &after)
+	if err != nil {
+		return nil, err
+	}
+
+	projection := NewProjection()
+	projection.ApplyMovements(before)
+	projection.ApplyMovements(after)
+	return projection, nil
+}
+
+// GetAllWarehouseStock returns the stock records of all the articles in all the warehouses
+func (service *ArticleService) GetAllWarehouseStock() ([]WarehouseStock, error) {
+	var stock []WarehouseStock
+	if err := service.DataTable.FindRelated("warehouse_stock", dbclient.Condition{}, &stock); err != nil {
+		return nil, err
+	}
+	return stock, nil
+}
+
+// ApplyMovements adds the deltas of the movements to the projected stock
+func (p *Projection) ApplyMovements(movements []StockMovement) {
+	for _, m := range movements {
+		var warehouseID uint64
+		if m.WarehouseID != nil {
+			warehouseID = *m.WarehouseID
+		}
+		p.add(m.ArticleID, warehouseID, m.Delta)
+	}
+}
+
+// AdjustStock adds delta to the projected stock of the article outside of the warehouses
+func (p *Projection) AdjustStock(articleID uint64, delta int64, change StockChange) error {
+	p.add(articleID, 0, delta)
+	return nil
+}
+
+// AdjustWarehouseStock adds quantityDelta to the projected stock of the article in the warehouse
+func (p *Projection) AdjustWarehouseStock(articleID, warehouseID uint64, quantityDelta, reservedDelta int64, change StockChange) error {
+	p.add(articleID, warehouseID, quantityDelta)
+	return nil
+}
+
+// add adds delta to the projected stock of the article in the warehouse
+func (p *Projection) add(articleID, warehouseID uint64, delta int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stock[StockKey{ArticleID: articleID, WarehouseID: warehouseID}] += delta
+}
+
+// GetAll isn't supported, the projection doesn't keep the articles
+func (p *Projection) GetAll() ([]Article, error) {
+	return nil, ErrNotProjected
+}
+
+// GetById isn't supported, the projection doesn't keep the articles
+func (p *Projection) GetById(uint64) (*Article, error) {
+	return nil, ErrNotProjected
+}
+
+// Create isn't supported, the articles are only changed in the database
+func (p *Projection) Create(*Article) error {
+	return ErrNotProjected
+}
+
+// Update isn't supported, the articles are only changed in the database
+func (p *Projection) Update(*Article) error {
+	return ErrNotProjected
+}
+
+// Delete isn't supported, the articles are only changed in the database
+func (p *Projection) Delete(*Article) error {
+	return ErrNotProjected
+}
+
+// SetWarehouseStock isn't supported, the replayed events only adjust the stock
+func (p *Projection) SetWarehouseStock(*WarehouseStock, StockChange) error {
+	return ErrNotProjected
+}
+
+// GetMovements isn't supported, the projection doesn't record the movements
+func (p *Projection) GetMovements(articleID uint64, from, to time.Time) ([]StockMovement, error) {
+	return nil, ErrNotProjected
+}
+
+// Diff compares the projected stock with the current stock of the articles
+// and their warehouses, the part of an article's stock which isn't kept in any
+// warehouse is compared under the warehouse zero. The projected stock of the
+// articles which don't exist anymore is left out.
+func (p *Projection) Diff(articles []Article, warehouseStock []WarehouseStock) []StockDiff {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	exists := make(map[uint64]bool)
+	current := make(map[StockKey]int64)
+	for _, a := range articles {
+		exists[a.ID] = true
+		current[StockKey{ArticleID: a.ID}] += a.Stock
+	}
+	for _, ws := range warehouseStock {
+		current[StockKey{ArticleID: ws.ArticleID, WarehouseID: ws.WarehouseID}] += ws.Quantity
+		current[StockKey{ArticleID: ws.ArticleID}] -= ws.Quantity
+	}
+
+	keys := make(map[StockKey]bool)
+	for key := range current {
+		keys[key] = true
+	}
+	for key := range p.stock {
+		keys[key] = true
+	}
+
+	var diffs []StockDiff
+	for key := range keys {
+		if !exists[key.ArticleID] || current[key] == p.stock[key] {
+			continue
+		}
+		diffs = append(diffs, StockDiff{StockKey: key, Current: current[key], Projected: p.stock[key]})
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].ArticleID != diffs[j].ArticleID {
+			return diffs[i].ArticleID < diffs[j].ArticleID
+		}
+		return diffs[i].WarehouseID < diffs[j].WarehouseID
+	})
+	return diffs
+}
@@ -0,0 +1,76 @@
+package article
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"testing"
+	"time"
+)
+
+func TestArticleService_ProjectStock(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	articleService := &ArticleService{
+		DataTable: &dataTable,
+	}
+	from := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+	warehouseID := uint64(2)
+
+	dataTable.On("FindRelated", "stock_movements", dbclient.Condition{"created_at <": from}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(2).(*[]StockMovement)) = []StockMovement{
+			{ArticleID: 1, Delta: 10},
+			{ArticleID: 1, WarehouseID: &warehouseID, Delta: 5},
+		}
+	}).Return(nil).Once()
+	dataTable.On("FindRelated", "stock_movements", dbclient.Condition{"created_at >=": from, "order_id IS": nil}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(2).(*[]StockMovement)) = []StockMovement{
+			{ArticleID: 1, WarehouseID: &warehouseID, Delta: 3},
+		}
+	}).Return(nil).Once()
+
+	projection, err := articleService.ProjectStock(from)
+	assert.Nil(err)
+	assert.Equal(map[StockKey]int64{
+		{ArticleID: 1}:                 10,
+		{ArticleID: 1, WarehouseID: 2}: 8,
+	}, projection.stock)
+	dataTable.AssertExpectations(t)
+}
+
+func TestProjection_Diff(t *testing.T) {
+	projection := NewProjection()
+	change := StockChange{Reason: ReasonShipment}
+
+	t.Run("Test can only keep the stock changes", func(t *testing.T) {
+		assert := assert.New(t)
+
+		assert.Nil(projection.AdjustStock(1, 12, change))
+		assert.Nil(projection.AdjustWarehouseStock(1, 2, 6, -1, change))
+		assert.Nil(projection.AdjustWarehouseStock(3, 2, 4, 0, change))
+		assert.Nil(projection.AdjustStock(9, 1, change))
+		assert.ErrorIs(projection.Update(&Article{ID: 1}), ErrNotProjected)
+	})
+
+	t.Run("Test can compare with the current stock", func(t *testing.T) {
+		assert := assert.New(t)
+
+		// Article 1 has 10 outside of the warehouses and 8 in warehouse 2,
+		// article 3 matches the projection and article 9 doesn't exist
+		articles := []Article{{ID: 1, Stock: 18}, {ID: 3, Stock: 4}}
+		warehouseStock := []WarehouseStock{
+			{ArticleID: 1, WarehouseID: 2, Quantity: 8},
+			{ArticleID: 3, WarehouseID: 2, Quantity: 4},
+		}
+
+		diffs := projection.Diff(articles, warehouseStock)
+		assert.Equal([]StockDiff{
+			{StockKey: StockKey{ArticleID: 1}, Current: 10, Projected: 12},
+			{StockKey: StockKey{ArticleID: 1, WarehouseID: 2}, Current: 8, Projected: 6},
+		}, diffs)
+		assert.Equal(int64(2), diffs[0].Delta())
+		assert.Equal(int64(-2), diffs[1].Delta())
+	})
+}
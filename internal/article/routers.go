@@ -13,5 +13,7 @@ func (service *ArticleService) RegisterHTTPRoutes(routerGroup *gin.RouterGroup)
 		articles.POST("/", service.CreateArticle)
 		articles.PUT("/:id", service.UpdateArticle)
 		articles.DELETE("/:id", service.DeleteArticle)
+		articles.PUT("/:id/stock", service.SetArticleWarehouseStock)
+		articles.GET("/:id/movements", service.ListArticleMovements)
 	}
 }
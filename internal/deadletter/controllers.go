@@ -0,0 +1,67 @@
+package deadletter
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/unicod3/horreum/pkg/apierror"
+	"net/http"
+)
+
+// ListDeadLetters example
+// @Tags admin
+// @Summary List the dead letters
+// @Description List the messages their handlers kept failing on
+// @ID get-dead-letters
+// @Accept  json
+// @Produce  json
+// @Success 200 {array} DeadLetter
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/dead-letters/ [get]
+func (service *DeadLetterService) ListDeadLetters(g *gin.Context) {
+	deadLetters, err := service.GetAll()
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	g.JSON(http.StatusOK, deadLetters)
+}
+
+// ReplayDeadLetter example
+// @Tags admin
+// @Summary Replay a dead letter
+// @Description Publish the dead letter to its original topic again to reprocess it
+// @ID replay-dead-letter
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Dead Letter ID"
+// @Success 200 {object} DeadLetter
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /admin/dead-letters/{id}/replay [post]
+func (service *DeadLetterService) ReplayDeadLetter(g *gin.Context) {
+	var deadLetter DeadLetter
+
+	if err := g.ShouldBindUri(&deadLetter); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the params",
+		})
+		return
+	}
+
+	d, err := service.Replay(deadLetter.ID)
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	g.JSON(http.StatusOK, d)
+}
+
+// writeError writes the response matching the given service error
+var writeError = apierror.Writer(
+	apierror.Match(http.StatusConflict, func(err error) bool {
+		var replayedErr *ReplayedError
+		return errors.As(err, &replayedErr)
+	}),
+)
@@ -0,0 +1,132 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/outbox"
+	"time"
+)
+
+// DeadLetterRepository serves as a contract over DeadLetterService
+type DeadLetterRepository interface {
+	GetAll() ([]DeadLetter, error)
+	Store(msg *message.Message) error
+	Replay(id uint64) (*DeadLetter, error)
+}
+
+// DeadLetter represents a record from dead_letters table
+type DeadLetter struct {
+	ID         uint64     `json:"id" uri:"id" db:"id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at,omitempty" db:"created_at,omitempty"`
+	Topic      string     `json:"topic" db:"topic"`
+	Handler    string     `json:"handler" db:"handler"`
+	Reason     string     `json:"reason" db:"reason"`
+	UUID       string     `json:"uuid" db:"uuid"`
+	Payload    string     `json:"payload" db:"payload"`
+	Metadata   string     `json:"metadata" db:"metadata"`
+	ReplayedAt *time.Time `json:"replayed_at,omitempty" db:"replayed_at"`
+}
+
+// ErrorResponse contains information about error
+type ErrorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// ReplayedError is returned when a dead letter is replayed more than once
+type ReplayedError struct {
+	DeadLetterID uint64
+	ReplayedAt   time.Time
+}
+
+func (e *ReplayedError) Error() string {
+	return fmt.Sprintf("dead letter %d is already replayed at %s", e.DeadLetterID, e.ReplayedAt.Format(time.RFC3339))
+}
+
+// poisonedKeys are the metadata keys the dead letter topics add to the messages
+var poisonedKeys = []string{
+	middleware.ReasonForPoisonedKey,
+	middleware.PoisonedTopicKey,
+	middleware.PoisonedHandlerKey,
+	middleware.PoisonedSubscriberKey,
+}
+
+// DeadLetterService holds information about the datatable
+// and implements DeadLetterRepository
+type DeadLetterService struct {
+	DataTable dbclient.DataTable
+}
+
+// GetAll returns all the records
+func (service *DeadLetterService) GetAll() ([]DeadLetter, error) {
+	var deadLetters []DeadLetter
+	if err := service.DataTable.FindAll(&deadLetters); err != nil {
+		return nil, err
+	}
+	return deadLetters, nil
+}
+
+// Store persists the given message of a dead letter topic
+// together with the failure reason and its original topic
+func (service *DeadLetterService) Store(msg *message.Message) error {
+	metadata := make(message.Metadata)
+	for key, value := range msg.Metadata {
+		metadata[key] = value
+	}
+	for _, key := range poisonedKeys {
+		delete(metadata, key)
+	}
+	byteMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	return service.DataTable.InsertReturning(&DeadLetter{
+		Topic:    msg.Metadata.Get(middleware.PoisonedTopicKey),
+		Handler:  msg.Metadata.Get(middleware.PoisonedHandlerKey),
+		Reason:   msg.Metadata.Get(middleware.ReasonForPoisonedKey),
+		UUID:     msg.UUID,
+		Payload:  string(msg.Payload),
+		Metadata: string(byteMetadata),
+	})
+}
+
+// Replay publishes the dead letter with given pk id to its original topic
+// through the outbox, so it is reprocessed by the handler it failed on.
+// A dead letter can be replayed once, a replayed message which fails
+// again ends up as a new dead letter.
+func (service *DeadLetterService) Replay(id uint64) (*DeadLetter, error) {
+	var d *DeadLetter
+	err := service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		var deadLetters []DeadLetter
+		if err := tx.FindForUpdate(dbclient.Condition{"id": id}, &deadLetters); err != nil {
+			return err
+		}
+		if len(deadLetters) == 0 {
+			return dbclient.ErrNoMoreRows
+		}
+		d = &deadLetters[0]
+		if d.ReplayedAt != nil {
+			return &ReplayedError{DeadLetterID: id, ReplayedAt: *d.ReplayedAt}
+		}
+
+		msg := message.NewMessage(d.UUID, []byte(d.Payload))
+		if err := json.Unmarshal([]byte(d.Metadata), &msg.Metadata); err != nil {
+			return err
+		}
+		if err := outbox.Store(tx, d.Topic, msg); err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		d.ReplayedAt = &now
+		return tx.UpdateReturning(d)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,120 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"github.com/unicod3/horreum/pkg/outbox"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterServiceImplementsDeadLetterRepositoryInterface(t *testing.T) {
+	assert := assert.New(t)
+	assert.Implements((*DeadLetterRepository)(nil), new(DeadLetterService))
+}
+
+func TestDeadLetterService_Store(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	deadLetterService := &DeadLetterService{DataTable: &dataTable}
+
+	msg := message.NewMessage("uuid", []byte(`{"EventName":"OrderShipped"}`))
+	msg.Metadata.Set("correlation_id", "correlation")
+	msg.Metadata.Set(middleware.ReasonForPoisonedKey, "insufficient stock")
+	msg.Metadata.Set(middleware.PoisonedTopicKey, "orders")
+	msg.Metadata.Set(middleware.PoisonedHandlerKey, "orders_handler")
+
+	var stored *DeadLetter
+	dataTable.On("InsertReturning", mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(*DeadLetter)
+	}).Return(nil).Once()
+
+	assert.Nil(deadLetterService.Store(msg))
+	assert.Equal("orders", stored.Topic)
+	assert.Equal("orders_handler", stored.Handler)
+	assert.Equal("insufficient stock", stored.Reason)
+	assert.Equal("uuid", stored.UUID)
+	assert.Equal(string(msg.Payload), stored.Payload)
+	assert.JSONEq(`{"correlation_id":"correlation"}`, stored.Metadata)
+}
+
+func TestDeadLetterService_Replay(t *testing.T) {
+	// mockDeadLetter mocks the dead letter with given pk id
+	mockDeadLetter := func(dataTable *mocks.DataTable, deadLetter DeadLetter) {
+		dataTable.On("WithTx", mock.Anything).
+			Return(func(fn func(dbclient.DataTable) error) error {
+				return fn(dataTable)
+			}).Once()
+		dataTable.On("FindForUpdate", dbclient.Condition{"id": deadLetter.ID}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]DeadLetter)) = []DeadLetter{deadLetter}
+		}).Return(nil).Once()
+	}
+
+	t.Run("Test can replay to original topic", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		deadLetterService := &DeadLetterService{DataTable: &dataTable}
+		mockDeadLetter(&dataTable, DeadLetter{
+			ID:       1,
+			Topic:    "orders",
+			UUID:     "uuid",
+			Payload:  `{"EventName":"OrderShipped"}`,
+			Metadata: `{"correlation_id":"correlation"}`,
+		})
+
+		var stored *outbox.Message
+		dataTable.On("CreateRelated", outbox.TableName, mock.Anything).Run(func(args mock.Arguments) {
+			stored = args.Get(1).(*outbox.Message)
+		}).Return(nil).Once()
+		dataTable.On("UpdateReturning", mock.Anything).Return(nil).Once()
+
+		d, err := deadLetterService.Replay(1)
+		assert.Nil(err)
+		assert.NotNil(d.ReplayedAt)
+		assert.Equal("orders", stored.Topic)
+		assert.Equal("uuid", stored.UUID)
+		assert.Equal(`{"EventName":"OrderShipped"}`, stored.Payload)
+		var metadata message.Metadata
+		assert.Nil(json.Unmarshal([]byte(stored.Metadata), &metadata))
+		assert.Equal("correlation", metadata.Get("correlation_id"))
+	})
+
+	t.Run("Test can reject replayed dead letter", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		deadLetterService := &DeadLetterService{DataTable: &dataTable}
+		replayedAt := time.Now().UTC()
+		mockDeadLetter(&dataTable, DeadLetter{ID: 1, Topic: "orders", ReplayedAt: &replayedAt})
+
+		d, err := deadLetterService.Replay(1)
+		assert.Nil(d)
+		var replayedErr *ReplayedError
+		assert.True(errors.As(err, &replayedErr))
+		dataTable.AssertNotCalled(t, "CreateRelated", outbox.TableName, mock.Anything)
+	})
+
+	t.Run("Test can return not found", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		deadLetterService := &DeadLetterService{DataTable: &dataTable}
+		dataTable.On("WithTx", mock.Anything).
+			Return(func(fn func(dbclient.DataTable) error) error {
+				return fn(&dataTable)
+			}).Once()
+		dataTable.On("FindForUpdate", dbclient.Condition{"id": uint64(2)}, mock.Anything).Return(nil).Once()
+
+		d, err := deadLetterService.Replay(2)
+		assert.Nil(d)
+		assert.Equal(dbclient.ErrNoMoreRows, err)
+	})
+}
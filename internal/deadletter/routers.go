@@ -0,0 +1,14 @@
+package deadletter
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterHTTPRoutes registers the package's routes to the gin router
+func (service *DeadLetterService) RegisterHTTPRoutes(routerGroup *gin.RouterGroup) {
+	deadLetters := routerGroup.Group("admin/dead-letters")
+	{
+		deadLetters.GET("/", service.ListDeadLetters)
+		deadLetters.POST("/:id/replay", service.ReplayDeadLetter)
+	}
+}
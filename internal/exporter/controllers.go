@@ -0,0 +1,59 @@
+package exporter
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/unicod3/horreum/pkg/apierror"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"net/http"
+)
+
+// ExportResource example
+// @Tags exports
+// @Summary Export the articles, the products or the orders
+// @Description Download all the records of the listing as a CSV or XLSX file, the listing filters such as name~, status or created_after select the records. The articles come with their reserved and available inventory, the products with their sellable inventory and the orders with their total cost
+// @ID export-resource
+// @Produce  text/csv
+// @Produce  application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param resource path string true "Listing to export" Enums(articles, products, orders)
+// @Param format query string false "Format of the file, csv by default" Enums(csv, xlsx)
+// @Param sort query string false "Comma separated fields to sort by, descending when prefixed with -"
+// @Success 200 {file} file
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /exports/{resource} [get]
+func (service *ExportService) ExportResource(g *gin.Context) {
+	format, err := FormatOf(g.Query("format"))
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	values := g.Request.URL.Query()
+	values.Del("format")
+
+	resource := g.Param("resource")
+	started := false
+	err = service.Export(resource, values, func() (RowWriter, error) {
+		started = true
+		g.Header("Content-Type", format.ContentType())
+		g.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, resource, format))
+		g.Status(http.StatusOK)
+		return NewRowWriter(g.Writer, format, resource)
+	})
+	if err == nil {
+		return
+	}
+	if !started {
+		writeError(g, err)
+		return
+	}
+	// The file is on its way already, it is left incomplete
+	fmt.Println("Error: couldn't complete the export: ", err.Error())
+}
+
+// writeError writes the response matching the given service error
+var writeError = apierror.Writer(
+	apierror.Is(http.StatusNotFound, ErrUnknownResource),
+	apierror.Is(http.StatusBadRequest, ErrUnknownFormat, dbclient.ErrInvalidPageQuery),
+)
@@ -0,0 +1,220 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/internal/order"
+	"github.com/unicod3/horreum/internal/product"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"net/url"
+	"strings"
+)
+
+// ErrUnknownResource is returned for the listings which can't be exported
+var ErrUnknownResource = errors.New("unknown export resource")
+
+// ErrorResponse contains information about error
+type ErrorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// ArticleReader serves a contract to read the pages of the articles
+type ArticleReader interface {
+	GetPage(query dbclient.PageQuery) ([]article.Article, string, error)
+	PopulateReserved(articles []article.Article) error
+}
+
+// ProductReader serves a contract to read the pages of the products
+type ProductReader interface {
+	GetPage(query dbclient.PageQuery) (product.Products, string, error)
+}
+
+// OrderReader serves a contract to read the pages of the orders
+type OrderReader interface {
+	GetPage(query dbclient.PageQuery) ([]order.Order, string, error)
+}
+
+// ExportService exports the listings of the articles, the products and
+// the orders as spreadsheets
+type ExportService struct {
+	Articles ArticleReader
+	Products ProductReader
+	Orders   OrderReader
+}
+
+// table is an exported listing, rows writes the rows of the records of
+// the page of the query and returns the cursor of the next page
+type table struct {
+	fields dbclient.Fields
+	header []string
+	rows   func(query dbclient.PageQuery, w RowWriter) (string, error)
+}
+
+// table returns the table of the resource
+func (service *ExportService) table(resource string) (table, error) {
+	switch resource {
+	case "articles":
+		return table{
+			fields: article.ArticleFields,
+			header: []string{"id", "external_id", "name", "stock", "reserved", "available_inventory", "created_at", "updated_at"},
+			rows:   service.articleRows,
+		}, nil
+	case "products":
+		return table{
+			fields: product.ProductFields,
+			header: []string{"id", "external_id", "name", "price", "sellable_inventory", "articles", "components", "created_at", "updated_at"},
+			rows:   service.productRows,
+		}, nil
+	case "orders":
+		return table{
+			fields: order.OrderFields,
+			header: []string{"id", "warehouse_id", "customer", "status", "allow_backorder", "lines", "quantity", "total", "created_at", "updated_at"},
+			rows:   service.orderRows,
+		}, nil
+	}
+	return table{}, fmt.Errorf("%w: %q", ErrUnknownResource, resource)
+}
+
+// Export writes the records of the resource matching the filters and the
+// sort of the query params to the writer open returns, a header row first.
+// The records are read a page at a time and each page is flushed once it is
+// written, so the export never holds more than a page in memory. The writer
+// is opened once the first page is read, an export failing before is told
+// by the error alone while one failing after leaves the file incomplete.
+func (service *ExportService) Export(resource string, values url.Values, open func() (RowWriter, error)) error {
+	t, err := service.table(resource)
+	if err != nil {
+		return err
+	}
+	// The exports hold all the matching records, read in the largest pages
+	values = copyValues(values)
+	values.Del("limit")
+	query, err := dbclient.ParsePageQuery(values, t.fields)
+	if err != nil {
+		return err
+	}
+	query.Limit = dbclient.MaxPageLimit
+
+	var w RowWriter
+	for {
+		var page bufferedRows
+		next, err := t.rows(query, &page)
+		if err != nil {
+			return err
+		}
+		if w == nil {
+			if w, err = open(); err != nil {
+				return err
+			}
+			if err := w.Write(stringValues(t.header)...); err != nil {
+				return err
+			}
+		}
+		for _, row := range page {
+			if err := w.Write(row...); err != nil {
+				return err
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if next == "" {
+			return w.Close()
+		}
+		if query, err = query.After(next); err != nil {
+			return err
+		}
+	}
+}
+
+func (service *ExportService) articleRows(query dbclient.PageQuery, w RowWriter) (string, error) {
+	articles, next, err := service.Articles.GetPage(query)
+	if err != nil {
+		return "", err
+	}
+	if err := service.Articles.PopulateReserved(articles); err != nil {
+		return "", err
+	}
+	for _, a := range articles {
+		// The available inventory of an article on its own is its unreserved stock
+		err := w.Write(a.ID, a.ExternalID, a.Name, a.Stock, a.Reserved, a.Stock-a.Reserved, a.CreatedAt, a.UpdatedAt)
+		if err != nil {
+			return "", err
+		}
+	}
+	return next, nil
+}
+
+func (service *ExportService) productRows(query dbclient.PageQuery, w RowWriter) (string, error) {
+	products, next, err := service.Products.GetPage(query)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range products {
+		// The articles are listed as the article id and the amount of it, "1:4;2:8"
+		var articles []string
+		for _, a := range p.Articles {
+			articles = append(articles, fmt.Sprintf("%d:%d", a.ID, a.AmountOf))
+		}
+		// The components are listed alike, as the product id and the amount of it
+		var components []string
+		for _, c := range p.Components {
+			components = append(components, fmt.Sprintf("%d:%d", c.ID, c.AmountOf))
+		}
+		err := w.Write(p.ID, p.ExternalID, p.Name, p.Price, p.SellableInventory,
+			strings.Join(articles, ";"), strings.Join(components, ";"), p.CreatedAt, p.UpdatedAt)
+		if err != nil {
+			return "", err
+		}
+	}
+	return next, nil
+}
+
+func (service *ExportService) orderRows(query dbclient.PageQuery, w RowWriter) (string, error) {
+	orders, next, err := service.Orders.GetPage(query)
+	if err != nil {
+		return "", err
+	}
+	for _, o := range orders {
+		err := w.Write(o.ID, o.WarehouseID, o.Customer, string(o.Status), o.AllowBackorder,
+			len(o.Lines), o.Quantity(), o.Total(), o.CreatedAt, o.UpdatedAt)
+		if err != nil {
+			return "", err
+		}
+	}
+	return next, nil
+}
+
+// bufferedRows is a RowWriter holding the rows of a page
+type bufferedRows [][]interface{}
+
+func (rows *bufferedRows) Write(values ...interface{}) error {
+	*rows = append(*rows, values)
+	return nil
+}
+
+func (rows *bufferedRows) Flush() error {
+	return nil
+}
+
+func (rows *bufferedRows) Close() error {
+	return nil
+}
+
+func copyValues(values url.Values) url.Values {
+	c := make(url.Values, len(values))
+	for key, v := range values {
+		c[key] = v
+	}
+	return c
+}
+
+func stringValues(s []string) []interface{} {
+	values := make([]interface{}, len(s))
+	for i, v := range s {
+		values[i] = v
+	}
+	return values
+}
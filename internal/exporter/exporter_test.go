@@ -0,0 +1,143 @@
+package exporter
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/internal/exporter/mocks"
+	"github.com/unicod3/horreum/internal/order"
+	"github.com/unicod3/horreum/internal/product"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// openCSV returns an open func writing CSV to buf
+func openCSV(buf *bytes.Buffer) func() (RowWriter, error) {
+	return func() (RowWriter, error) {
+		return NewRowWriter(buf, FormatCSV, "export")
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	assert := assert.New(t)
+
+	format, err := FormatOf("")
+	assert.Nil(err)
+	assert.Equal(FormatCSV, format)
+	format, err = FormatOf("XLSX")
+	assert.Nil(err)
+	assert.Equal(FormatXLSX, format)
+	_, err = FormatOf("pdf")
+	assert.ErrorIs(err, ErrUnknownFormat)
+}
+
+func TestCSVWriter_Write(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	rows, err := NewRowWriter(&buf, FormatCSV, "export")
+	assert.Nil(err)
+	assert.Nil(rows.Write("=SUM(A1:A2)", "+1", "-1", "@cmd", "\t=1", "\r=1", "leg", "", int64(-3)))
+	assert.Nil(rows.Close())
+	assert.Equal("'=SUM(A1:A2),'+1,'-1,'@cmd,'\t=1,\"'\r=1\",leg,,-3\n", buf.String(),
+		"the formulas must be escaped and the numbers left alone")
+}
+
+func TestExportService_ExportArticles(t *testing.T) {
+	assert := assert.New(t)
+
+	articles := &mocks.ArticleReader{}
+	service := &ExportService{Articles: articles}
+
+	// The cursor of the second page points after the first article
+	query, err := dbclient.ParsePageQuery(url.Values{"name~": {"leg"}, "limit": {"1"}}, article.ArticleFields)
+	assert.Nil(err)
+	first := []article.Article{{ID: 1}, {ID: 2}}
+	next, err := query.Next(&first)
+	assert.Nil(err)
+
+	created := time.Date(2022, 2, 26, 9, 30, 0, 0, time.UTC)
+	articles.On("GetPage", mock.MatchedBy(func(q dbclient.PageQuery) bool {
+		return q.Limit == dbclient.MaxPageLimit && len(q.Filters) == 1 && q.Condition() != nil &&
+			len(q.Condition().Expressions()) == 1
+	})).Return([]article.Article{{ID: 1, ExternalID: "A1", Name: "leg", Stock: 12, CreatedAt: created}}, next, nil).Once()
+	articles.On("GetPage", mock.MatchedBy(func(q dbclient.PageQuery) bool {
+		return len(q.Condition().Expressions()) == 2
+	})).Return([]article.Article{{ID: 2, Name: "leg, short", Stock: 3}}, "", nil).Once()
+	articles.On("PopulateReserved", mock.Anything).Run(func(args mock.Arguments) {
+		for i := range args.Get(0).([]article.Article) {
+			args.Get(0).([]article.Article)[i].Reserved = 2
+		}
+	}).Return(nil).Twice()
+
+	var buf bytes.Buffer
+	err = service.Export("articles", url.Values{"name~": {"leg"}, "limit": {"5"}}, openCSV(&buf))
+	assert.Nil(err)
+	assert.Equal("id,external_id,name,stock,reserved,available_inventory,created_at,updated_at\n"+
+		"1,A1,leg,12,2,10,2022-02-26T09:30:00Z,\n"+
+		"2,,\"leg, short\",3,2,1,,\n", buf.String())
+	articles.AssertExpectations(t)
+}
+
+func TestExportService_ExportProducts(t *testing.T) {
+	assert := assert.New(t)
+
+	products := &mocks.ProductReader{}
+	service := &ExportService{Products: products}
+
+	products.On("GetPage", mock.Anything).Return(product.Products{{
+		ID: 5, Name: "Dining Chair", Price: 1500, SellableInventory: 3,
+		Articles:   []article.Article{{ID: 1, AmountOf: 4}, {ID: 2, AmountOf: 8}},
+		Components: []product.Product{{ID: 7, AmountOf: 2}},
+	}}, "", nil).Once()
+
+	var buf bytes.Buffer
+	err := service.Export("products", url.Values{"sort": {"-price"}}, openCSV(&buf))
+	assert.Nil(err)
+	assert.Equal("id,external_id,name,price,sellable_inventory,articles,components,created_at,updated_at\n"+
+		"5,,Dining Chair,1500,3,1:4;2:8,7:2,,\n", buf.String())
+}
+
+func TestExportService_ExportOrders(t *testing.T) {
+	assert := assert.New(t)
+
+	orders := &mocks.OrderReader{}
+	service := &ExportService{Orders: orders}
+
+	orders.On("GetPage", mock.Anything).Return([]order.Order{{
+		ID: 7, WarehouseID: 1, Customer: "ikea", Status: order.StatusShipped,
+		Lines: []order.OrderLine{{ProductID: 1, Quantity: 2, UnitCost: 150}, {ProductID: 2, Quantity: 1, UnitCost: 1000}},
+	}}, "", nil).Once()
+
+	var buf bytes.Buffer
+	err := service.Export("orders", url.Values{"status": {"shipped"}}, openCSV(&buf))
+	assert.Nil(err)
+	assert.Equal("id,warehouse_id,customer,status,allow_backorder,lines,quantity,total,created_at,updated_at\n"+
+		"7,1,ikea,shipped,false,2,3,1300,,\n", buf.String())
+}
+
+func TestExportService_ExportFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	orders := &mocks.OrderReader{}
+	service := &ExportService{Orders: orders}
+	opened := false
+	open := func() (RowWriter, error) {
+		opened = true
+		return &bufferedRows{}, nil
+	}
+
+	err := service.Export("customers", url.Values{}, open)
+	assert.ErrorIs(err, ErrUnknownResource)
+	err = service.Export("orders", url.Values{"total": {"5"}}, open)
+	assert.ErrorIs(err, dbclient.ErrInvalidPageQuery)
+
+	orders.On("GetPage", mock.Anything).Return(nil, "", errors.New("connection lost")).Once()
+	err = service.Export("orders", url.Values{}, open)
+	assert.EqualError(err, "connection lost")
+	assert.False(opened)
+}
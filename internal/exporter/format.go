@@ -0,0 +1,137 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/unicod3/horreum/pkg/xlsx"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Format is the format of an exported file
+type Format string
+
+const (
+	FormatCSV  Format = "csv"
+	FormatXLSX Format = "xlsx"
+)
+
+// ErrUnknownFormat is returned for the formats which are neither CSV nor XLSX
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// FormatOf returns the Format of its name, CSV when the name is empty
+func FormatOf(s string) (Format, error) {
+	switch Format(strings.ToLower(s)) {
+	case "", FormatCSV:
+		return FormatCSV, nil
+	case FormatXLSX:
+		return FormatXLSX, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownFormat, s)
+}
+
+// ContentType returns the media type of the files of the format
+func (f Format) ContentType() string {
+	if f == FormatXLSX {
+		return xlsx.ContentType
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// RowWriter writes the rows of an exported file, Close has to be
+// called after the last row to complete the file
+type RowWriter interface {
+	Write(values ...interface{}) error
+	Flush() error
+	Close() error
+}
+
+// NewRowWriter returns a RowWriter writing a file of the format to w, when
+// w is an http.Flusher the flushed rows are sent to the client right away.
+// The name is the name of the sheet of an XLSX file.
+func NewRowWriter(w io.Writer, format Format, name string) (RowWriter, error) {
+	var rows RowWriter
+	switch format {
+	case FormatCSV:
+		rows = &csvWriter{csv.NewWriter(w)}
+	case FormatXLSX:
+		sheet, err := xlsx.NewWriter(w, name)
+		if err != nil {
+			return nil, err
+		}
+		rows = sheet
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		return &flushWriter{RowWriter: rows, flusher: flusher}, nil
+	}
+	return rows, nil
+}
+
+// csvWriter writes the rows as CSV lines, the times are written in RFC 3339.
+// The texts a spreadsheet would take for a formula are prefixed with a quote,
+// so the files can be opened safely whatever the records hold.
+type csvWriter struct {
+	*csv.Writer
+}
+
+func (w *csvWriter) Write(values ...interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case time.Time:
+			if !v.IsZero() {
+				record[i] = v.UTC().Format(time.RFC3339)
+			}
+		case string:
+			record[i] = escapeFormula(v)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return w.Writer.Write(record)
+}
+
+// escapeFormula prefixes the text with a quote when it starts with a
+// character a spreadsheet starts a formula with or reads a cell through
+func escapeFormula(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+func (w *csvWriter) Flush() error {
+	w.Writer.Flush()
+	return w.Writer.Error()
+}
+
+func (w *csvWriter) Close() error {
+	return w.Flush()
+}
+
+// flushWriter sends the flushed rows on to the client
+type flushWriter struct {
+	RowWriter
+	flusher http.Flusher
+}
+
+func (w *flushWriter) Flush() error {
+	if err := w.RowWriter.Flush(); err != nil {
+		return err
+	}
+	w.flusher.Flush()
+	return nil
+}
+
+func (w *flushWriter) Close() error {
+	if err := w.RowWriter.Close(); err != nil {
+		return err
+	}
+	w.flusher.Flush()
+	return nil
+}
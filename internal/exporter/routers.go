@@ -0,0 +1,13 @@
+package exporter
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterHTTPRoutes registers the package's routes to the gin router
+func (service *ExportService) RegisterHTTPRoutes(routerGroup *gin.RouterGroup) {
+	exports := routerGroup.Group("exports")
+	{
+		exports.GET("/:resource", service.ExportResource)
+	}
+}
@@ -0,0 +1,83 @@
+package importer
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/unicod3/horreum/pkg/apierror"
+	"io"
+	"net/http"
+)
+
+// ImportInventory example
+// @Tags imports
+// @Summary Import the articles of an inventory file
+// @Description Create the articles of a JSON or CSV inventory file and update the existing ones by their art_id in a single transaction, the stock of the file is set as their stock in the warehouse
+// @ID import-inventory
+// @Accept  json
+// @Accept  text/csv
+// @Produce  json
+// @Param warehouse_id query int true "Warehouse the stock of the file is in"
+// @Param file body string true "Inventory file with the inventory array of the art_id, name and stock fields, or CSV with these columns"
+// @Success 200 {object} Report
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /imports/inventory [post]
+func (service *ImportService) ImportInventory(g *gin.Context) {
+	var query InventoryQuery
+	if err := g.ShouldBindQuery(&query); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the query",
+		})
+		return
+	}
+
+	service.serveImport(g, func(r io.Reader, format Format) (*Report, error) {
+		return service.WithContext(g.Request.Context()).Inventory(r, format, query.WarehouseID, actor(g))
+	})
+}
+
+// ImportProducts example
+// @Tags imports
+// @Summary Import the products of a products file
+// @Description Create the products of a JSON or CSV products file and update the existing ones by their prod_id, or their name, in a single transaction
+// @ID import-products
+// @Accept  json
+// @Accept  text/csv
+// @Produce  json
+// @Param file body string true "Products file with the products array of the prod_id, name, price and contain_articles fields, or CSV with the prod_id, name, price, art_id and amount_of columns"
+// @Success 200 {object} Report
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /imports/products [post]
+func (service *ImportService) ImportProducts(g *gin.Context) {
+	service.serveImport(g, service.WithContext(g.Request.Context()).Products)
+}
+
+// serveImport imports the request body in the format of its content type
+func (service *ImportService) serveImport(g *gin.Context, importFile func(io.Reader, Format) (*Report, error)) {
+	format, err := FormatOf(g.ContentType())
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+
+	report, err := importFile(g.Request.Body, format)
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	g.JSON(http.StatusOK, report)
+}
+
+// actor returns who makes the request from the X-Actor header
+func actor(g *gin.Context) string {
+	if actor := g.GetHeader("X-Actor"); actor != "" {
+		return actor
+	}
+	return "api"
+}
+
+// writeError writes the response matching the given service error
+var writeError = apierror.Writer(
+	apierror.Is(http.StatusBadRequest, ErrInvalidFile, ErrUnknownFormat, ErrInvalidWarehouse),
+)
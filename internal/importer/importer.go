@@ -0,0 +1,270 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/internal/product"
+	"github.com/unicod3/horreum/internal/warehouse"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/streamer"
+	"io"
+)
+
+const (
+	StatusCreated string = "created"
+	StatusUpdated        = "updated"
+	StatusFailed         = "failed"
+)
+
+// Row is the outcome of importing a record of a file, Row is the position
+// of the record in a JSON file and its line in a CSV file
+type Row struct {
+	Row        int    `json:"row"`
+	ExternalID string `json:"external_id"`
+	Status     string `json:"status"`
+	ID         uint64 `json:"id,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Report tells what an import did to each record of the file
+type Report struct {
+	Created int   `json:"created"`
+	Updated int   `json:"updated"`
+	Failed  int   `json:"failed"`
+	Rows    []Row `json:"rows"`
+}
+
+func (r *Report) add(row Row) {
+	switch row.Status {
+	case StatusCreated:
+		r.Created++
+	case StatusUpdated:
+		r.Updated++
+	case StatusFailed:
+		r.Failed++
+	}
+	r.Rows = append(r.Rows, row)
+}
+
+// ErrInvalidWarehouse is returned when an inventory is imported
+// without a warehouse or into one which doesn't exist
+var ErrInvalidWarehouse = errors.New("invalid warehouse")
+
+// InventoryQuery represents the query parameters accepted while importing an inventory
+type InventoryQuery struct {
+	WarehouseID uint64 `form:"warehouse_id"`
+}
+
+// ErrorResponse contains information about error
+type ErrorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// ImportService imports the articles and the products from files,
+// the records are upserted by the ids they have in the files
+type ImportService struct {
+	DataStorage  dbclient.DataStorage
+	ArticleTopic string
+	ProductTopic string
+	// correlationID is carried by the events of the imported records
+	correlationID string
+}
+
+// WithContext returns a copy of the service whose events carry
+// the correlation id of ctx, when ctx carries one
+func (service *ImportService) WithContext(ctx context.Context) *ImportService {
+	scoped := *service
+	scoped.correlationID = streamer.CorrelationIDFrom(ctx)
+	return &scoped
+}
+
+// Inventory creates the articles of the inventory file which don't exist
+// yet and updates the name of the others, the stock of the file is the stock
+// of the articles in the warehouse with given pk id. The stock is changed
+// through the warehouse stock with the import reason, so the articles' total
+// stock and their movements follow it. The articles are written in a single
+// transaction along with their events, so either all the rows which can be
+// read are imported or none of them is.
+func (service *ImportService) Inventory(r io.Reader, format Format, warehouseID uint64, actor string) (*Report, error) {
+	if warehouseID == 0 {
+		return nil, fmt.Errorf("%w: a warehouse_id is required", ErrInvalidWarehouse)
+	}
+	rows, err := parseInventory(r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	var report *Report
+	err = service.DataStorage.WithTx(func(tx dbclient.DataStorage) error {
+		err := tx.NewDataCollection("warehouses").FindOne(dbclient.Condition{"id": warehouseID}, &warehouse.Warehouse{})
+		if errors.Is(err, dbclient.ErrNoMoreRows) {
+			return fmt.Errorf("%w: warehouse %d doesn't exist", ErrInvalidWarehouse, warehouseID)
+		}
+		if err != nil {
+			return err
+		}
+
+		report = &Report{}
+		articles := service.articleService(tx)
+		for _, row := range rows {
+			result := Row{Row: row.row, ExternalID: row.artID}
+			if row.err != nil {
+				result.Status, result.Error = StatusFailed, row.err.Error()
+				report.add(result)
+				continue
+			}
+
+			id, status, err := importArticle(articles, row, warehouseID, actor)
+			if err != nil {
+				return fmt.Errorf("row %d: %w", row.row, err)
+			}
+			result.ID, result.Status = id, status
+			report.add(result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Products creates the products of the products file which don't exist
+// yet and updates the others, the articles of the products are matched by
+// their art_id. The products are written in a single transaction along with
+// their events, the ones containing an article which doesn't exist fail.
+func (service *ImportService) Products(r io.Reader, format Format) (*Report, error) {
+	rows, err := parseProducts(r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	var report *Report
+	err = service.DataStorage.WithTx(func(tx dbclient.DataStorage) error {
+		report = &Report{}
+		articles := service.articleService(tx)
+		products := service.productService(tx)
+		for _, row := range rows {
+			result := Row{Row: row.row, ExternalID: row.externalID()}
+			if row.err != nil {
+				result.Status, result.Error = StatusFailed, row.err.Error()
+				report.add(result)
+				continue
+			}
+
+			p := &product.Product{ExternalID: row.externalID(), Name: row.name}
+			if row.price != nil {
+				p.Price = *row.price
+			}
+			missing, err := resolveArticles(articles, row.articles, p)
+			if err != nil {
+				return fmt.Errorf("row %d: %w", row.row, err)
+			}
+			if missing != "" {
+				result.Status, result.Error = StatusFailed, fmt.Sprintf("article %s doesn't exist", missing)
+				report.add(result)
+				continue
+			}
+
+			current, err := products.GetByExternalID(p.ExternalID)
+			switch {
+			case errors.Is(err, dbclient.ErrNoMoreRows):
+				p, err = products.Create(p)
+				result.Status = StatusCreated
+			case err == nil:
+				p.ID = current.ID
+				if row.price == nil {
+					p.Price = current.Price
+				}
+				p, err = products.Update(p)
+				result.Status = StatusUpdated
+			}
+			if err != nil {
+				return fmt.Errorf("row %d: %w", row.row, err)
+			}
+			result.ID = p.ID
+			report.add(result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// importArticle creates or renames the article of the row and sets its stock
+// in the warehouse to the row's stock, it returns the article's pk id along
+// with the status of the row. The article stays locked until the end of the
+// import, so the warehouse stock doesn't change between reading and adjusting it.
+func importArticle(articles *article.ArticleService, row inventoryRow, warehouseID uint64, actor string) (uint64, string, error) {
+	status := StatusUpdated
+	a, err := articles.GetByExternalIDForUpdate(row.artID)
+	switch {
+	case errors.Is(err, dbclient.ErrNoMoreRows):
+		a = &article.Article{ExternalID: row.artID, Name: row.name, Actor: actor}
+		if err := articles.Create(a); err != nil {
+			return 0, "", err
+		}
+		status = StatusCreated
+	case err != nil:
+		return 0, "", err
+	case a.Name != row.name:
+		a.Name, a.Actor = row.name, actor
+		if err := articles.Update(a); err != nil {
+			return 0, "", err
+		}
+	}
+
+	current, err := articles.GetWarehouseStock(a.ID, warehouseID)
+	if err != nil {
+		return 0, "", err
+	}
+	if delta := row.stock - current.Quantity; delta != 0 {
+		change := article.StockChange{Reason: article.ReasonImport, Actor: actor}
+		if err := articles.AdjustWarehouseStock(a.ID, warehouseID, delta, 0, change); err != nil {
+			return 0, "", err
+		}
+	}
+	return a.ID, status, nil
+}
+
+// resolveArticles sets the articles of the product to the ones with the
+// external ids of the row, the external id of the first article which
+// doesn't exist is returned
+func resolveArticles(articles *article.ArticleService, rows []productArticleRow, p *product.Product) (string, error) {
+	for _, row := range rows {
+		a, err := articles.GetByExternalID(row.artID)
+		if errors.Is(err, dbclient.ErrNoMoreRows) {
+			return row.artID, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		p.Articles = append(p.Articles, article.Article{ID: a.ID, AmountOf: row.amountOf})
+	}
+	return "", nil
+}
+
+// articleService returns an ArticleService working in the transaction,
+// its events are stored in the outbox of the transaction
+func (service *ImportService) articleService(tx dbclient.DataStorage) *article.ArticleService {
+	articles := &article.ArticleService{
+		DataTable:   tx.NewDataCollection("articles"),
+		StreamTopic: service.ArticleTopic,
+	}
+	return articles.WithCorrelationID(service.correlationID)
+}
+
+// productService returns a ProductService working in the transaction,
+// its events are stored in the outbox of the transaction
+func (service *ImportService) productService(tx dbclient.DataStorage) *product.ProductService {
+	products := &product.ProductService{
+		DataTable:   tx.NewDataCollection("products"),
+		StreamTopic: service.ProductTopic,
+	}
+	return products.WithCorrelationID(service.correlationID)
+}
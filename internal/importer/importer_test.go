@@ -0,0 +1,278 @@
+package importer
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/internal/product"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"github.com/unicod3/horreum/pkg/outbox"
+	"strings"
+	"testing"
+)
+
+// mockStorage returns a DataStorage running the imports in a transaction
+// whose tables are the given mocks, the topics of the messages stored in
+// the outbox are written to events
+func mockStorage(tables map[string]*mocks.DataTable, events *[]string) *mocks.DataStorage {
+	storage := &mocks.DataStorage{}
+	storage.On("WithTx", mock.Anything).Return(func(fn func(dbclient.DataStorage) error) error {
+		return fn(storage)
+	}).Once()
+	for name, table := range tables {
+		table := table
+		storage.On("NewDataCollection", name).Return(table)
+		table.On("WithTx", mock.Anything).Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(table)
+		})
+		table.On("CreateRelated", outbox.TableName, mock.Anything).Run(func(args mock.Arguments) {
+			*events = append(*events, args.Get(1).(*outbox.Message).Topic)
+		}).Return(nil)
+	}
+	return storage
+}
+
+func TestFormatOf(t *testing.T) {
+	assert := assert.New(t)
+
+	format, err := FormatOf("application/json; charset=utf-8")
+	assert.Nil(err)
+	assert.Equal(FormatJSON, format)
+	format, err = FormatOf("inventory.CSV")
+	assert.Nil(err)
+	assert.Equal(FormatCSV, format)
+	_, err = FormatOf("application/xml")
+	assert.ErrorIs(err, ErrUnknownFormat)
+}
+
+func TestParseInventory(t *testing.T) {
+	assert := assert.New(t)
+
+	rows, err := parseInventory(strings.NewReader(`{"inventory": [
+		{"art_id": "1", "name": "leg", "stock": "12"},
+		{"art_id": 2, "name": "screw", "stock": 17},
+		{"art_id": "3", "name": "seat", "stock": "-1"},
+		{"name": "table top", "stock": "1"}
+	]}`), FormatJSON)
+	assert.Nil(err)
+	assert.Len(rows, 4)
+	assert.Equal(inventoryRow{row: 1, artID: "1", name: "leg", stock: 12}, rows[0])
+	assert.Equal(inventoryRow{row: 2, artID: "2", name: "screw", stock: 17}, rows[1])
+	assert.EqualError(rows[2].err, "stock can't be less than 0")
+	assert.EqualError(rows[3].err, "art_id is missing")
+
+	rows, err = parseInventory(strings.NewReader("art_id,name,stock\n1,leg,12\n2,screw,many\n"), FormatCSV)
+	assert.Nil(err)
+	assert.Len(rows, 2)
+	assert.Equal(inventoryRow{row: 2, artID: "1", name: "leg", stock: 12}, rows[0])
+	assert.Equal(3, rows[1].row)
+	assert.EqualError(rows[1].err, `stock "many" isn't a whole number`)
+
+	_, err = parseInventory(strings.NewReader("art_id,name\n1,leg\n"), FormatCSV)
+	assert.ErrorIs(err, ErrInvalidFile)
+	_, err = parseInventory(strings.NewReader(`{"inventory": {}}`), FormatJSON)
+	assert.ErrorIs(err, ErrInvalidFile)
+}
+
+func TestParseProducts(t *testing.T) {
+	assert := assert.New(t)
+
+	rows, err := parseProducts(strings.NewReader(`{"products": [
+		{"name": "Dining Chair", "contain_articles": [
+			{"art_id": "1", "amount_of": "4"},
+			{"art_id": "2", "amount_of": "8"}
+		]},
+		{"prod_id": "T1", "name": "Dining Table", "price": 7500, "contain_articles": [
+			{"art_id": "1", "amount_of": "0"}
+		]}
+	]}`), FormatJSON)
+	assert.Nil(err)
+	assert.Len(rows, 2)
+	assert.Equal(productRow{row: 1, name: "Dining Chair", articles: []productArticleRow{
+		{artID: "1", amountOf: 4},
+		{artID: "2", amountOf: 8},
+	}}, rows[0])
+	assert.Equal("Dining Chair", rows[0].externalID())
+	assert.Equal("T1", rows[1].externalID())
+	assert.EqualError(rows[1].err, "article 1: amount_of can't be less than 1")
+
+	rows, err = parseProducts(strings.NewReader(
+		"prod_id,name,price,art_id,amount_of\n"+
+			"C1,Dining Chair,1500,1,4\n"+
+			"T1,Dining Table,,1,4\n"+
+			"C1,Dining Chair,1500,2,8\n"), FormatCSV)
+	assert.Nil(err)
+	assert.Len(rows, 2)
+	price := int64(1500)
+	assert.Equal(productRow{row: 2, prodID: "C1", name: "Dining Chair", price: &price, articles: []productArticleRow{
+		{artID: "1", amountOf: 4},
+		{artID: "2", amountOf: 8},
+	}}, rows[0])
+	assert.Equal(productRow{row: 3, prodID: "T1", name: "Dining Table", articles: []productArticleRow{
+		{artID: "1", amountOf: 4},
+	}}, rows[1])
+}
+
+func TestImportService_Inventory(t *testing.T) {
+	assert := assert.New(t)
+
+	articles := &mocks.DataTable{}
+	warehouses := &mocks.DataTable{}
+	stockTable := &mocks.DataTable{}
+	var events []string
+	storage := mockStorage(map[string]*mocks.DataTable{"articles": articles, "warehouses": warehouses}, &events)
+	service := &ImportService{DataStorage: storage, ArticleTopic: "articles"}
+
+	warehouses.On("FindOne", dbclient.Condition{"id": uint64(1)}, mock.Anything).Return(nil).Once()
+
+	// The new article gets its stock in the warehouse after it is created
+	articles.On("FindForUpdate", dbclient.Condition{"external_id": "1"}, mock.Anything).Return(nil).Once()
+	articles.On("InsertReturning", mock.MatchedBy(func(a *article.Article) bool {
+		return a.ExternalID == "1" && a.Name == "leg" && a.Stock == 0
+	})).Run(func(args mock.Arguments) {
+		args.Get(0).(*article.Article).ID = 10
+	}).Return(nil).Once()
+	articles.On("FindForUpdate", dbclient.Condition{"id": uint64(10)}, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(1).(*[]article.Article) = []article.Article{{ID: 10, ExternalID: "1", Name: "leg"}}
+	}).Return(nil).Once()
+	articles.On("FindRelated", "warehouse_stock", dbclient.Condition{"article_id": uint64(10), "warehouse_id": uint64(1)}, mock.Anything).
+		Return(nil).Twice()
+	articles.On("CreateRelated", "warehouse_stock", mock.MatchedBy(func(ws *article.WarehouseStock) bool {
+		return ws.ArticleID == 10 && ws.WarehouseID == 1 && ws.Quantity == 12
+	})).Return(nil).Once()
+	articles.On("Increment", dbclient.Condition{"id": uint64(10)}, map[string]int64{"stock": 12}).Return(nil).Once()
+
+	// The existing article is renamed and its stock in the warehouse is set
+	// to the one of the file, the stock of the other warehouses is left alone
+	articles.On("FindForUpdate", dbclient.Condition{"external_id": "2"}, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(1).(*[]article.Article) = []article.Article{{ID: 20, ExternalID: "2", Name: "bolt", Stock: 5}}
+	}).Return(nil).Once()
+	articles.On("FindForUpdate", dbclient.Condition{"id": uint64(20)}, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(1).(*[]article.Article) = []article.Article{{ID: 20, ExternalID: "2", Name: "bolt", Stock: 5}}
+	}).Return(nil).Twice()
+	articles.On("UpdateReturning", mock.MatchedBy(func(a *article.Article) bool {
+		return a.ID == 20 && a.Name == "screw" && a.Stock == 5
+	})).Return(nil).Once()
+	articles.On("FindRelated", "warehouse_stock", dbclient.Condition{"article_id": uint64(20), "warehouse_id": uint64(1)}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*args.Get(2).(*[]article.WarehouseStock) = []article.WarehouseStock{{ID: 7, ArticleID: 20, WarehouseID: 1, Quantity: 3, Reserved: 1}}
+		}).Return(nil).Twice()
+	articles.On("Related", "warehouse_stock").Return(stockTable).Once()
+	stockTable.On("UpdateReturning", mock.MatchedBy(func(ws *article.WarehouseStock) bool {
+		return ws.ID == 7 && ws.Quantity == 17 && ws.Reserved == 1
+	})).Return(nil).Once()
+	articles.On("Increment", dbclient.Condition{"id": uint64(20)}, map[string]int64{"stock": 14}).Return(nil).Once()
+
+	var movements []article.StockMovement
+	articles.On("CreateRelated", "stock_movements", mock.Anything).Run(func(args mock.Arguments) {
+		movements = append(movements, *args.Get(1).(*article.StockMovement))
+	}).Return(nil).Twice()
+
+	report, err := service.Inventory(strings.NewReader(
+		"art_id,name,stock\n1,leg,12\n2,screw,17\n3,seat,\n"), FormatCSV, 1, "tester")
+	assert.Nil(err)
+	assert.Equal(&Report{Created: 1, Updated: 1, Failed: 1, Rows: []Row{
+		{Row: 2, ExternalID: "1", Status: StatusCreated, ID: 10},
+		{Row: 3, ExternalID: "2", Status: StatusUpdated, ID: 20},
+		{Row: 4, ExternalID: "3", Status: StatusFailed, Error: "stock is missing"},
+	}}, report)
+	assert.Equal([]string{"articles", article.StockTopic, "articles", article.StockTopic}, events)
+	assert.Len(movements, 2)
+	for i, delta := range []int64{12, 14} {
+		assert.Equal(delta, movements[i].Delta)
+		if assert.NotNil(movements[i].WarehouseID) {
+			assert.Equal(uint64(1), *movements[i].WarehouseID)
+		}
+		assert.Equal(article.ReasonImport, movements[i].Reason)
+		assert.Equal("tester", movements[i].Actor)
+	}
+	articles.AssertExpectations(t)
+	stockTable.AssertExpectations(t)
+}
+
+func TestImportService_InventoryRejectsWarehouse(t *testing.T) {
+	assert := assert.New(t)
+
+	t.Run("Test requires a warehouse", func(t *testing.T) {
+		service := &ImportService{DataStorage: &mocks.DataStorage{}}
+		_, err := service.Inventory(strings.NewReader(`{"inventory": []}`), FormatJSON, 0, "tester")
+		assert.ErrorIs(err, ErrInvalidWarehouse)
+	})
+
+	t.Run("Test rejects an unknown warehouse", func(t *testing.T) {
+		warehouses := &mocks.DataTable{}
+		var events []string
+		storage := mockStorage(map[string]*mocks.DataTable{"warehouses": warehouses}, &events)
+		service := &ImportService{DataStorage: storage}
+		warehouses.On("FindOne", dbclient.Condition{"id": uint64(9)}, mock.Anything).Return(dbclient.ErrNoMoreRows).Once()
+
+		report, err := service.Inventory(strings.NewReader(`{"inventory": [{"art_id": "1", "name": "leg", "stock": "12"}]}`), FormatJSON, 9, "tester")
+		assert.ErrorIs(err, ErrInvalidWarehouse)
+		assert.Contains(err.Error(), "warehouse 9 doesn't exist")
+		assert.Nil(report)
+		assert.Empty(events)
+	})
+}
+
+func TestImportService_InventoryRollsBack(t *testing.T) {
+	assert := assert.New(t)
+
+	articles := &mocks.DataTable{}
+	warehouses := &mocks.DataTable{}
+	var events []string
+	storage := mockStorage(map[string]*mocks.DataTable{"articles": articles, "warehouses": warehouses}, &events)
+	service := &ImportService{DataStorage: storage, ArticleTopic: "articles"}
+
+	warehouses.On("FindOne", dbclient.Condition{"id": uint64(1)}, mock.Anything).Return(nil).Once()
+	articles.On("FindForUpdate", dbclient.Condition{"external_id": "1"}, mock.Anything).Return(errors.New("connection lost")).Once()
+
+	report, err := service.Inventory(strings.NewReader(`{"inventory": [{"art_id": "1", "name": "leg", "stock": "12"}]}`), FormatJSON, 1, "tester")
+	assert.EqualError(err, "row 1: connection lost")
+	assert.Nil(report)
+}
+
+func TestImportService_Products(t *testing.T) {
+	assert := assert.New(t)
+
+	articles := &mocks.DataTable{}
+	products := &mocks.DataTable{}
+	var events []string
+	storage := mockStorage(map[string]*mocks.DataTable{"articles": articles, "products": products}, &events)
+	service := &ImportService{DataStorage: storage, ArticleTopic: "articles", ProductTopic: "products"}
+
+	articles.On("FindOne", dbclient.Condition{"external_id": "1"}, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(1).(*article.Article) = article.Article{ID: 10, ExternalID: "1"}
+	}).Return(nil)
+	articles.On("FindOne", dbclient.Condition{"external_id": "9"}, mock.Anything).Return(dbclient.ErrNoMoreRows)
+	products.On("FindOne", dbclient.Condition{"external_id": "Dining Chair"}, mock.Anything).Return(dbclient.ErrNoMoreRows).Once()
+	products.On("InsertReturning", mock.MatchedBy(func(p *product.Product) bool {
+		return p.ExternalID == "Dining Chair" && p.Name == "Dining Chair" && len(p.Articles) == 1
+	})).Run(func(args mock.Arguments) {
+		args.Get(0).(*product.Product).ID = 5
+	}).Return(nil).Once()
+	products.On("DeleteRelated", "product_articles", dbclient.Condition{"product_id": uint64(5)}).Return(nil).Once()
+	products.On("CreateRelated", "product_articles", &product.ProductArticleRelation{
+		ProductID: 5, ArticleID: 10, AmountOf: 4,
+	}).Return(nil).Once()
+	products.On("FindOne", dbclient.Condition{"id": uint64(5)}, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(1).(*product.Product) = product.Product{ID: 5, ExternalID: "Dining Chair", Name: "Dining Chair"}
+	}).Return(nil).Once()
+	products.On("LoadMany2Many", mock.Anything, "product_articles pa", "articles a", "a.id = pa.article_id",
+		dbclient.Condition{"pa.product_id": uint64(5)}, mock.Anything).Return(nil).Once()
+	products.On("FindRelated", "product_components", dbclient.Condition{"product_id": uint64(5)}, mock.Anything).
+		Return(nil).Once()
+
+	report, err := service.Products(strings.NewReader(`{"products": [
+		{"name": "Dining Chair", "contain_articles": [{"art_id": "1", "amount_of": "4"}]},
+		{"name": "Dining Table", "contain_articles": [{"art_id": "9", "amount_of": "1"}]}
+	]}`), FormatJSON)
+	assert.Nil(err)
+	assert.Equal(&Report{Created: 1, Failed: 1, Rows: []Row{
+		{Row: 1, ExternalID: "Dining Chair", Status: StatusCreated, ID: 5},
+		{Row: 2, ExternalID: "Dining Table", Status: StatusFailed, Error: "article 9 doesn't exist"},
+	}}, report)
+	assert.Equal([]string{"products"}, events)
+	products.AssertExpectations(t)
+}
@@ -0,0 +1,293 @@
+package importer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Format is the format of an imported file
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatCSV  Format = "csv"
+)
+
+// ErrInvalidFile is returned when an imported file can't be read at all,
+// the rows which can't be imported are reported one by one instead
+var ErrInvalidFile = errors.New("invalid import file")
+
+// ErrUnknownFormat is returned for the files which are neither JSON nor CSV
+var ErrUnknownFormat = errors.New("unknown import format")
+
+// FormatOf returns the Format of a content type or a file name
+func FormatOf(s string) (Format, error) {
+	s = strings.ToLower(s)
+	switch {
+	case strings.HasSuffix(s, ".json"), strings.HasPrefix(s, "application/json"):
+		return FormatJSON, nil
+	case strings.HasSuffix(s, ".csv"), strings.HasPrefix(s, "text/csv"):
+		return FormatCSV, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownFormat, s)
+}
+
+// value is a field of an imported file, the inventory files give
+// the ids and the quantities both as strings and as numbers
+type value string
+
+func (v *value) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = value(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("%s is neither a string nor a number", data)
+	}
+	*v = value(n)
+	return nil
+}
+
+// inventoryRow is an article of an inventory file
+type inventoryRow struct {
+	row   int
+	artID string
+	name  string
+	stock int64
+	err   error
+}
+
+// productRow is a product of a products file
+type productRow struct {
+	row      int
+	prodID   string
+	name     string
+	price    *int64
+	articles []productArticleRow
+	err      error
+}
+
+// productArticleRow is an article a product of a products file contains
+type productArticleRow struct {
+	artID    string
+	amountOf int64
+}
+
+// externalID returns the id the product is matched by, its name
+// when the file doesn't give it an id
+func (r productRow) externalID() string {
+	if r.prodID != "" {
+		return r.prodID
+	}
+	return r.name
+}
+
+// parseInventory reads the articles of an inventory file, in JSON the
+// articles are given as {"inventory": [{"art_id", "name", "stock"}]}
+// and in CSV as the rows of the art_id, name and stock columns
+func parseInventory(r io.Reader, format Format) ([]inventoryRow, error) {
+	var records []map[string]value
+	switch format {
+	case FormatJSON:
+		var file struct {
+			Inventory []map[string]value `json:"inventory"`
+		}
+		if err := json.NewDecoder(r).Decode(&file); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+		}
+		records = file.Inventory
+	case FormatCSV:
+		var err error
+		if records, err = readCSV(r, "art_id", "name", "stock"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+
+	rows := make([]inventoryRow, 0, len(records))
+	for i, record := range records {
+		row := inventoryRow{
+			row:   rowNumber(format, i),
+			artID: strings.TrimSpace(string(record["art_id"])),
+			name:  strings.TrimSpace(string(record["name"])),
+		}
+		row.stock, row.err = parseQuantity("stock", record["stock"], 0)
+		switch {
+		case row.err != nil:
+		case row.artID == "":
+			row.err = errors.New("art_id is missing")
+		case row.name == "":
+			row.err = errors.New("name is missing")
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// productRecord is a product as it is given in a products file
+type productRecord struct {
+	ProdID          value              `json:"prod_id"`
+	Name            value              `json:"name"`
+	Price           *value             `json:"price"`
+	ContainArticles []map[string]value `json:"contain_articles"`
+}
+
+// parseProducts reads the products of a products file, in JSON the products
+// are given as {"products": [{"prod_id", "name", "price", "contain_articles":
+// [{"art_id", "amount_of"}]}]} and in CSV as the rows of the prod_id, name,
+// price, art_id and amount_of columns, one row for each article of a product.
+// The prod_id and the price are optional, the products without a prod_id are
+// matched by their names.
+func parseProducts(r io.Reader, format Format) ([]productRow, error) {
+	var records []productRecord
+	var numbers []int
+	switch format {
+	case FormatJSON:
+		var file struct {
+			Products []productRecord `json:"products"`
+		}
+		if err := json.NewDecoder(r).Decode(&file); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+		}
+		records = file.Products
+		for i := range records {
+			numbers = append(numbers, rowNumber(format, i))
+		}
+	case FormatCSV:
+		lines, err := readCSV(r, "name", "art_id", "amount_of")
+		if err != nil {
+			return nil, err
+		}
+		// The lines of a product are merged into the first one of them
+		index := make(map[string]int)
+		for i, line := range lines {
+			key := string(line["prod_id"])
+			if key == "" {
+				key = string(line["name"])
+			}
+			article := map[string]value{"art_id": line["art_id"], "amount_of": line["amount_of"]}
+			if j, ok := index[key]; ok {
+				records[j].ContainArticles = append(records[j].ContainArticles, article)
+				continue
+			}
+			record := productRecord{ProdID: line["prod_id"], Name: line["name"], ContainArticles: []map[string]value{article}}
+			if price := line["price"]; price != "" {
+				record.Price = &price
+			}
+			index[key] = len(records)
+			records = append(records, record)
+			numbers = append(numbers, rowNumber(format, i))
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+
+	rows := make([]productRow, 0, len(records))
+	for i, record := range records {
+		row := productRow{
+			row:    numbers[i],
+			prodID: strings.TrimSpace(string(record.ProdID)),
+			name:   strings.TrimSpace(string(record.Name)),
+		}
+		row.err = row.parse(record)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parse reads the price and the articles of the record into the row
+func (r *productRow) parse(record productRecord) error {
+	if r.name == "" {
+		return errors.New("name is missing")
+	}
+	if record.Price != nil {
+		price, err := parseQuantity("price", *record.Price, 0)
+		if err != nil {
+			return err
+		}
+		r.price = &price
+	}
+	for _, a := range record.ContainArticles {
+		article := productArticleRow{artID: strings.TrimSpace(string(a["art_id"]))}
+		if article.artID == "" {
+			return errors.New("art_id of an article is missing")
+		}
+		var err error
+		if article.amountOf, err = parseQuantity("amount_of", a["amount_of"], 1); err != nil {
+			return fmt.Errorf("article %s: %w", article.artID, err)
+		}
+		r.articles = append(r.articles, article)
+	}
+	return nil
+}
+
+// parseQuantity parses a quantity which can't be less than min
+func parseQuantity(name string, v value, min int64) (int64, error) {
+	s := strings.TrimSpace(string(v))
+	if s == "" {
+		return 0, fmt.Errorf("%s is missing", name)
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s %q isn't a whole number", name, s)
+	}
+	if n < min {
+		return 0, fmt.Errorf("%s can't be less than %d", name, min)
+	}
+	return n, nil
+}
+
+// readCSV reads the lines of a CSV file with a header as maps of the
+// columns to their values, the given columns are required
+func readCSV(r io.Reader, required ...string) ([]map[string]value, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	columns := make(map[string]bool)
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+		columns[header[i]] = true
+	}
+	for _, column := range required {
+		if !columns[column] {
+			return nil, fmt.Errorf("%w: %s column is missing", ErrInvalidFile, column)
+		}
+	}
+
+	var lines []map[string]value
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+		}
+		line := make(map[string]value, len(header))
+		for i, column := range header {
+			line[column] = value(record[i])
+		}
+		lines = append(lines, line)
+	}
+}
+
+// rowNumber returns the number a record is reported with, it is the
+// position of the record in a JSON file and its line in a CSV file
+func rowNumber(format Format, i int) int {
+	if format == FormatCSV {
+		// The first line is the header
+		return i + 2
+	}
+	return i + 1
+}
@@ -0,0 +1,14 @@
+package importer
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterHTTPRoutes registers the package's routes to the gin router
+func (service *ImportService) RegisterHTTPRoutes(routerGroup *gin.RouterGroup) {
+	imports := routerGroup.Group("imports")
+	{
+		imports.POST("/inventory", service.ImportInventory)
+		imports.POST("/products", service.ImportProducts)
+	}
+}
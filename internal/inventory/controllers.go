@@ -0,0 +1,91 @@
+package inventory
+
+import (
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// keepAliveInterval is the interval a comment is sent
+// in to keep the idle streams open
+const keepAliveInterval = 15 * time.Second
+
+// StreamInventory example
+// @Tags stream
+// @Summary Stream the inventory changes
+// @Description Stream the article stock and product sellable inventory changes as Server-Sent Events,
+// @Description the article_stock events hold the stock level of an article and the product_inventory
+// @Description events hold the sellable inventory of a product. Streams resume after the Last-Event-ID
+// @Description with the latest 1000 events the instance keeps in memory, they are lost when it restarts.
+// @ID stream-inventory
+// @Produce  text/event-stream
+// @Param product_id query int false "Product ID"
+// @Param article_id query int false "Article ID"
+// @Param warehouse_id query int false "Warehouse ID"
+// @Param Last-Event-ID header int false "ID of the last received event"
+// @Success 200 {object} ProductInventory
+// @Failure 400 {object} ErrorResponse
+// @Router /stream/inventory [get]
+func (service *InventoryService) StreamInventory(g *gin.Context) {
+	var filter Filter
+	if err := g.ShouldBindQuery(&filter); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the query",
+		})
+		return
+	}
+
+	var lastID uint64
+	if header := g.GetHeader("Last-Event-ID"); header != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(header, 10, 64); err != nil {
+			g.JSON(http.StatusBadRequest, ErrorResponse{
+				Code:    http.StatusBadRequest,
+				Message: "Couldn't resolve the Last-Event-ID",
+			})
+			return
+		}
+	}
+
+	missed, changes, cancel := service.Broker.Subscribe(filter, lastID)
+	defer cancel()
+
+	g.Header("Cache-Control", "no-cache")
+	g.Header("Connection", "keep-alive")
+	g.Status(http.StatusOK)
+	for _, change := range missed {
+		g.Render(-1, event(change))
+	}
+	g.Writer.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	g.Stream(func(w io.Writer) bool {
+		select {
+		case <-g.Request.Context().Done():
+			return false
+		case change, ok := <-changes:
+			if !ok {
+				return false
+			}
+			g.Render(-1, event(change))
+			return true
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		}
+	})
+}
+
+// event returns the Server-Sent Event of the change
+func event(c Change) sse.Event {
+	return sse.Event{
+		Id:    strconv.FormatUint(c.ID, 10),
+		Event: c.Event,
+		Data:  c.Data,
+	}
+}
@@ -0,0 +1,298 @@
+package inventory
+
+import (
+	"errors"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/internal/product"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"sync"
+	"time"
+)
+
+const (
+	ArticleStockEvent     string = "article_stock"
+	ProductInventoryEvent        = "product_inventory"
+)
+
+// DefaultHistorySize is the number of the latest changes
+// kept for the streams to resume from
+const DefaultHistorySize = 1000
+
+// subscriberBuffer is the number of changes a subscriber can fall behind
+const subscriberBuffer = 64
+
+// ProductReader serves a contract to read the sellable inventory of the products
+type ProductReader interface {
+	GetById(id uint64) (*product.Product, error)
+	GetByIdForWarehouse(id, warehouseID uint64) (*product.Product, error)
+	GetIdsByArticle(articleID uint64) ([]uint64, error)
+}
+
+// ProductInventory is the data of the product_inventory changes,
+// WarehouseID is zero for the sellable inventory over all warehouses
+type ProductInventory struct {
+	ProductID         uint64 `json:"product_id"`
+	WarehouseID       uint64 `json:"warehouse_id,omitempty"`
+	SellableInventory int64  `json:"sellable_inventory"`
+}
+
+// Change is an inventory change pushed to the streams, Data holds an
+// *article.StockLevel for the article_stock changes and a ProductInventory
+// for the product_inventory changes. The ids of the related articles and
+// products are kept to filter the changes by.
+type Change struct {
+	ID          uint64
+	Event       string
+	Data        interface{}
+	WarehouseID uint64
+	ArticleIDs  []uint64
+	ProductIDs  []uint64
+}
+
+// Filter represents the query parameters the changes are filtered by, an
+// article filter matches the products the article is a part of as well and
+// a product filter matches its articles. Unset fields match every change.
+type Filter struct {
+	ProductID   uint64 `form:"product_id"`
+	ArticleID   uint64 `form:"article_id"`
+	WarehouseID uint64 `form:"warehouse_id"`
+}
+
+// ErrorResponse contains information about error
+type ErrorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Matches reports whether the change passes the filter
+func (f Filter) Matches(c *Change) bool {
+	if f.WarehouseID != 0 && c.WarehouseID != f.WarehouseID {
+		return false
+	}
+	if f.ArticleID != 0 && !contains(c.ArticleIDs, f.ArticleID) {
+		return false
+	}
+	if f.ProductID != 0 && !contains(c.ProductIDs, f.ProductID) {
+		return false
+	}
+	return true
+}
+
+func contains(ids []uint64, id uint64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// subscription holds the filter and the channel of a subscriber
+type subscription struct {
+	filter  Filter
+	changes chan Change
+}
+
+// Broker fans the changes out to its subscribers and keeps the latest ones
+// so the subscribers can resume after reconnecting. The changes are only kept
+// in memory, the subscribers can't resume with the changes published before
+// the instance restarted.
+type Broker struct {
+	mu          sync.Mutex
+	size        int
+	lastID      uint64
+	history     []Change
+	subscribers map[*subscription]struct{}
+	closed      bool
+}
+
+// NewBroker returns a Broker keeping the given number of latest changes
+func NewBroker(size int) *Broker {
+	return &Broker{
+		size:        size,
+		subscribers: make(map[*subscription]struct{}),
+	}
+}
+
+// Publish assigns the changes their ids and pushes them to the matching
+// subscribers. The ids are based on the time, so they keep growing over the
+// restarts. A subscriber which can't keep up is dropped by closing its channel,
+// it can resume with the id of the last change it got.
+func (b *Broker) Publish(changes ...Change) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, c := range changes {
+		c.ID = uint64(time.Now().UnixNano())
+		if c.ID <= b.lastID {
+			c.ID = b.lastID + 1
+		}
+		b.lastID = c.ID
+
+		b.history = append(b.history, c)
+		if len(b.history) > b.size {
+			b.history = append([]Change(nil), b.history[len(b.history)-b.size:]...)
+		}
+
+		for s := range b.subscribers {
+			if !s.filter.Matches(&c) {
+				continue
+			}
+			select {
+			case s.changes <- c:
+			default:
+				b.unsubscribe(s)
+			}
+		}
+	}
+}
+
+// Subscribe subscribes to the changes matching the filter, when lastID is
+// set the kept changes after it are returned to be sent before the ones
+// coming from the channel. The channel is closed when the subscriber is
+// dropped or the returned cancel function is called.
+func (b *Broker) Subscribe(filter Filter, lastID uint64) ([]Change, <-chan Change, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var missed []Change
+	if lastID != 0 {
+		for i := range b.history {
+			if b.history[i].ID > lastID && filter.Matches(&b.history[i]) {
+				missed = append(missed, b.history[i])
+			}
+		}
+	}
+
+	s := &subscription{filter: filter, changes: make(chan Change, subscriberBuffer)}
+	if b.closed {
+		close(s.changes)
+		return missed, s.changes, func() {}
+	}
+	b.subscribers[s] = struct{}{}
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.unsubscribe(s)
+	}
+	return missed, s.changes, cancel
+}
+
+// Close drops all the subscribers so their streams end, the
+// subscribers coming after it get a closed channel
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subscribers {
+		b.unsubscribe(s)
+	}
+}
+
+// unsubscribe removes the subscriber and closes its channel once,
+// it needs to be called with the lock held
+func (b *Broker) unsubscribe(s *subscription) {
+	if _, ok := b.subscribers[s]; !ok {
+		return
+	}
+	delete(b.subscribers, s)
+	close(s.changes)
+}
+
+// inventoryKey identifies the sellable inventory of a product in a warehouse
+type inventoryKey struct {
+	productID   uint64
+	warehouseID uint64
+}
+
+// InventoryService turns the stock events into the changes of the
+// article stock and the product sellable inventory and publishes them
+// to the Broker
+type InventoryService struct {
+	Products ProductReader
+	Broker   *Broker
+
+	mu       sync.Mutex
+	sellable map[inventoryKey]int64
+}
+
+// HandleStockLevel publishes the stock level of the article along with the
+// sellable inventory of the products it is a part of in the level's warehouse,
+// the products whose sellable inventory didn't change are left out
+func (service *InventoryService) HandleStockLevel(level *article.StockLevel) error {
+	productIDs, err := service.Products.GetIdsByArticle(level.ArticleID)
+	if err != nil {
+		return err
+	}
+
+	changes := []Change{{
+		Event:       ArticleStockEvent,
+		Data:        level,
+		WarehouseID: level.WarehouseID,
+		ArticleIDs:  []uint64{level.ArticleID},
+		ProductIDs:  productIDs,
+	}}
+	for _, productID := range productIDs {
+		var p *product.Product
+		if level.WarehouseID != 0 {
+			p, err = service.Products.GetByIdForWarehouse(productID, level.WarehouseID)
+		} else {
+			p, err = service.Products.GetById(productID)
+		}
+		// The product may be deleted in the meantime
+		if errors.Is(err, dbclient.ErrNoMoreRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if change, ok := service.productChange(p, level.WarehouseID); ok {
+			changes = append(changes, change)
+		}
+	}
+
+	service.Broker.Publish(changes...)
+	return nil
+}
+
+// HandleProduct publishes the sellable inventory of the created or updated
+// product over all warehouses, when it is changed
+func (service *InventoryService) HandleProduct(p *product.Product) {
+	if change, ok := service.productChange(p, 0); ok {
+		service.Broker.Publish(change)
+	}
+}
+
+// productChange returns the product_inventory change of the product in the
+// warehouse, false is returned when the sellable inventory is the same as
+// the last one published
+func (service *InventoryService) productChange(p *product.Product, warehouseID uint64) (Change, bool) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if service.sellable == nil {
+		service.sellable = make(map[inventoryKey]int64)
+	}
+	key := inventoryKey{productID: p.ID, warehouseID: warehouseID}
+	if last, ok := service.sellable[key]; ok && last == p.SellableInventory {
+		return Change{}, false
+	}
+	service.sellable[key] = p.SellableInventory
+
+	var articleIDs []uint64
+	for _, a := range p.BillOfMaterials() {
+		articleIDs = append(articleIDs, a.ID)
+	}
+	return Change{
+		Event: ProductInventoryEvent,
+		Data: ProductInventory{
+			ProductID:         p.ID,
+			WarehouseID:       warehouseID,
+			SellableInventory: p.SellableInventory,
+		},
+		WarehouseID: warehouseID,
+		ArticleIDs:  articleIDs,
+		ProductIDs:  []uint64{p.ID},
+	}, true
+}
@@ -0,0 +1,122 @@
+package inventory
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/internal/inventory/mocks"
+	"github.com/unicod3/horreum/internal/product"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"testing"
+)
+
+func TestFilter_Matches(t *testing.T) {
+	assert := assert.New(t)
+
+	change := &Change{WarehouseID: 2, ArticleIDs: []uint64{1, 3}, ProductIDs: []uint64{5}}
+	assert.True(Filter{}.Matches(change))
+	assert.True(Filter{WarehouseID: 2, ArticleID: 3, ProductID: 5}.Matches(change))
+	assert.False(Filter{WarehouseID: 1}.Matches(change))
+	assert.False(Filter{ArticleID: 2}.Matches(change))
+	assert.False(Filter{ProductID: 1}.Matches(change))
+}
+
+func TestBroker_Subscribe(t *testing.T) {
+	t.Run("Test can push matching changes", func(t *testing.T) {
+		assert := assert.New(t)
+
+		broker := NewBroker(DefaultHistorySize)
+		_, changes, cancel := broker.Subscribe(Filter{WarehouseID: 2}, 0)
+		defer cancel()
+
+		broker.Publish(
+			Change{Event: ArticleStockEvent, WarehouseID: 1},
+			Change{Event: ArticleStockEvent, WarehouseID: 2},
+		)
+		change := <-changes
+		assert.Equal(uint64(2), change.WarehouseID)
+		assert.NotZero(change.ID)
+		assert.Len(changes, 0)
+	})
+
+	t.Run("Test can resume after the last event id", func(t *testing.T) {
+		assert := assert.New(t)
+
+		broker := NewBroker(2)
+		broker.Publish(Change{WarehouseID: 1}, Change{WarehouseID: 2}, Change{WarehouseID: 3}, Change{WarehouseID: 4})
+		assert.Len(broker.history, 2, "only the latest changes must be kept")
+
+		missed, _, cancel := broker.Subscribe(Filter{}, broker.history[0].ID)
+		defer cancel()
+		assert.Len(missed, 1)
+		assert.Equal(uint64(4), missed[0].WarehouseID)
+
+		missed, _, cancel = broker.Subscribe(Filter{}, 1)
+		defer cancel()
+		assert.Len(missed, 2, "an older id must get all the kept changes")
+		assert.Less(missed[0].ID, missed[1].ID)
+	})
+
+	t.Run("Test can drop slow subscribers", func(t *testing.T) {
+		assert := assert.New(t)
+
+		broker := NewBroker(DefaultHistorySize)
+		_, changes, cancel := broker.Subscribe(Filter{}, 0)
+		for i := 0; i <= subscriberBuffer; i++ {
+			broker.Publish(Change{})
+		}
+		for range changes {
+		}
+		assert.Len(broker.subscribers, 0)
+		cancel()
+	})
+
+	t.Run("Test can end the streams on close", func(t *testing.T) {
+		assert := assert.New(t)
+
+		broker := NewBroker(DefaultHistorySize)
+		_, changes, cancel := broker.Subscribe(Filter{}, 0)
+		defer cancel()
+		broker.Close()
+		_, ok := <-changes
+		assert.False(ok)
+
+		_, changes, cancel = broker.Subscribe(Filter{}, 0)
+		defer cancel()
+		_, ok = <-changes
+		assert.False(ok, "a subscriber after close must get a closed channel")
+	})
+}
+
+func TestInventoryService_HandleStockLevel(t *testing.T) {
+	assert := assert.New(t)
+
+	products := &mocks.ProductReader{}
+	service := &InventoryService{Products: products, Broker: NewBroker(DefaultHistorySize)}
+	_, changes, cancel := service.Broker.Subscribe(Filter{}, 0)
+	defer cancel()
+
+	level := &article.StockLevel{ArticleID: 1, WarehouseID: 2, Quantity: 8, Reserved: 2, ReservedDelta: 2}
+	products.On("GetIdsByArticle", uint64(1)).Return([]uint64{5, 6}, nil).Twice()
+	products.On("GetByIdForWarehouse", uint64(5), uint64(2)).Return(&product.Product{
+		ID:                5,
+		SellableInventory: 3,
+		Articles:          []article.Article{{ID: 1}, {ID: 4}},
+	}, nil).Twice()
+	products.On("GetByIdForWarehouse", uint64(6), uint64(2)).Return(nil, dbclient.ErrNoMoreRows).Twice()
+
+	assert.Nil(service.HandleStockLevel(level))
+	stock := <-changes
+	assert.Equal(ArticleStockEvent, stock.Event)
+	assert.Equal(level, stock.Data)
+	assert.Equal([]uint64{5, 6}, stock.ProductIDs)
+	inventory := <-changes
+	assert.Equal(ProductInventoryEvent, inventory.Event)
+	assert.Equal(ProductInventory{ProductID: 5, WarehouseID: 2, SellableInventory: 3}, inventory.Data)
+	assert.Equal([]uint64{1, 4}, inventory.ArticleIDs)
+
+	// The unchanged sellable inventory isn't pushed again
+	assert.Nil(service.HandleStockLevel(level))
+	assert.Equal(ArticleStockEvent, (<-changes).Event)
+	assert.Len(changes, 0)
+	products.AssertExpectations(t)
+}
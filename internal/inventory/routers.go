@@ -0,0 +1,13 @@
+package inventory
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterHTTPRoutes registers the package's routes to the gin router
+func (service *InventoryService) RegisterHTTPRoutes(routerGroup *gin.RouterGroup) {
+	stream := routerGroup.Group("stream")
+	{
+		stream.GET("/inventory", service.StreamInventory)
+	}
+}
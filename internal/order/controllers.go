@@ -1,24 +1,50 @@
 package order
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/unicod3/horreum/internal/product"
+	"github.com/unicod3/horreum/pkg/apierror"
+	"github.com/unicod3/horreum/pkg/dbclient"
 	"net/http"
 )
 
 // ListOrders example
 // @Tags orders
-// @Summary Get all orders
-// @Description Get all orders
+// @Summary Get a page of the orders
+// @Description Get a page of the orders, the Link and X-Next-Cursor headers point to the next page
 // @ID list-orders
 // @Accept  json
 // @Produce  json
+// @Param limit query int false "Number of orders in the page, 50 by default and 500 at most"
+// @Param cursor query string false "Cursor of the page, the X-Next-Cursor of the previous page"
+// @Param sort query string false "Comma separated fields to sort by, descending when prefixed with -"
+// @Param warehouse_id query int false "Orders of the warehouse"
+// @Param status query string false "Orders in the status, repeated values match any of them"
+// @Param customer~ query string false "Customer contains, case insensitively"
+// @Param created_after query string false "RFC3339 date the orders are created after, created_before is alike"
 // @Success 200 {array} Order
+// @Header 200 {string} Link "Link to the next page"
+// @Header 200 {string} X-Next-Cursor "Cursor of the next page"
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Router /orders/ [get]
 func (service *OrderService) ListOrders(g *gin.Context) {
-	orders, err := service.GetAll()
+	query, err := dbclient.ParsePageQuery(g.Request.URL.Query(), OrderFields)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	orders, next, err := service.GetPage(query)
 	if err != nil {
+		writeError(g, err)
 		return
 	}
+	dbclient.WritePageHeaders(g.Writer.Header(), g.Request.URL, next)
 	g.JSON(http.StatusOK, orders)
 }
 
@@ -67,9 +93,9 @@ func (service *OrderService) GetOrder(g *gin.Context) {
 // @Failure 400 {object} ErrorResponse
 // @Router /orders/ [post]
 func (service *OrderService) CreateOrder(g *gin.Context) {
-	var order Order
+	var body RequestBody
 
-	if err := g.ShouldBindJSON(&order); err != nil {
+	if err := g.ShouldBindJSON(&body); err != nil {
 		g.JSON(http.StatusBadRequest, ErrorResponse{
 			Code:    http.StatusBadRequest,
 			Message: "Couldn't resolve the body",
@@ -77,7 +103,8 @@ func (service *OrderService) CreateOrder(g *gin.Context) {
 		return
 	}
 
-	err := service.Create(&order)
+	order := body.Order()
+	err := service.WithContext(g.Request.Context()).Create(order)
 	if err != nil {
 		g.JSON(http.StatusInternalServerError, ErrorResponse{
 			Code:    http.StatusInternalServerError,
@@ -92,7 +119,7 @@ func (service *OrderService) CreateOrder(g *gin.Context) {
 // UpdateOrder example
 // @Tags orders
 // @Summary Update a order with given data
-// @Description Update a order with given data
+// @Description Update a order with given data, the increased quantities of a confirmed or picking order are reserved in its warehouse
 // @ID update-order
 // @Accept  json
 // @Produce  json
@@ -101,11 +128,12 @@ func (service *OrderService) CreateOrder(g *gin.Context) {
 // @Success 200 {object} Order
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} StockErrorResponse
 // @Router /orders/{id} [put]
 func (service *OrderService) UpdateOrder(g *gin.Context) {
-	var order Order
+	var uri Order
 
-	if err := g.ShouldBindUri(&order); err != nil {
+	if err := g.ShouldBindUri(&uri); err != nil {
 		g.JSON(http.StatusBadRequest, ErrorResponse{
 			Code:    http.StatusBadRequest,
 			Message: "Couldn't resolve the params",
@@ -113,7 +141,8 @@ func (service *OrderService) UpdateOrder(g *gin.Context) {
 		return
 	}
 
-	if err := g.ShouldBindJSON(&order); err != nil {
+	var body RequestBody
+	if err := g.ShouldBindJSON(&body); err != nil {
 		g.JSON(http.StatusBadRequest, ErrorResponse{
 			Code:    http.StatusBadRequest,
 			Message: "Couldn't resolve the body",
@@ -121,12 +150,11 @@ func (service *OrderService) UpdateOrder(g *gin.Context) {
 		return
 	}
 
-	err := service.Update(&order)
+	order := body.Order()
+	order.ID = uri.ID
+	err := service.WithContext(g.Request.Context()).Update(order)
 	if err != nil {
-		g.JSON(http.StatusInternalServerError, ErrorResponse{
-			Code:    http.StatusInternalServerError,
-			Message: err.Error(),
-		})
+		writeError(g, err)
 		return
 	}
 
@@ -144,6 +172,7 @@ func (service *OrderService) UpdateOrder(g *gin.Context) {
 // @Success 204 string string "NoContent"
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
 // @Router /orders/{id} [delete]
 func (service *OrderService) DeleteOrder(g *gin.Context) {
 	var order Order
@@ -156,13 +185,141 @@ func (service *OrderService) DeleteOrder(g *gin.Context) {
 		return
 	}
 
-	err := service.Delete(&order)
+	err := service.WithContext(g.Request.Context()).Delete(&order)
 	if err != nil {
-		g.JSON(http.StatusInternalServerError, ErrorResponse{
-			Code:    http.StatusInternalServerError,
-			Message: err.Error(),
-		})
+		writeError(g, err)
 		return
 	}
 	g.Status(http.StatusNoContent)
 }
+
+// ConfirmOrder example
+// @Tags orders
+// @Summary Confirm a draft order and reserve its stock
+// @Description Confirm a draft order, the stock of its lines is reserved in the order's warehouse
+// @ID confirm-order
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Order ID"
+// @Success 200 {object} Order
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} StockErrorResponse
+// @Router /orders/{id}/confirm [post]
+func (service *OrderService) ConfirmOrder(g *gin.Context) {
+	service.transitionOrder(g, "confirm")
+}
+
+// PickOrder example
+// @Tags orders
+// @Summary Start picking a confirmed order
+// @Description Start picking a confirmed order
+// @ID pick-order
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Order ID"
+// @Success 200 {object} Order
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /orders/{id}/pick [post]
+func (service *OrderService) PickOrder(g *gin.Context) {
+	service.transitionOrder(g, "pick")
+}
+
+// ShipOrder example
+// @Tags orders
+// @Summary Ship an order
+// @Description Ship a confirmed or picking order, its reserved stock is consumed
+// @ID ship-order
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Order ID"
+// @Success 200 {object} Order
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /orders/{id}/ship [post]
+func (service *OrderService) ShipOrder(g *gin.Context) {
+	service.transitionOrder(g, "ship")
+}
+
+// DeliverOrder example
+// @Tags orders
+// @Summary Deliver a shipped order
+// @Description Deliver a shipped order
+// @ID deliver-order
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Order ID"
+// @Success 200 {object} Order
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /orders/{id}/deliver [post]
+func (service *OrderService) DeliverOrder(g *gin.Context) {
+	service.transitionOrder(g, "deliver")
+}
+
+// CancelOrder example
+// @Tags orders
+// @Summary Cancel an order
+// @Description Cancel an order which is not shipped yet, its reserved stock is released
+// @ID cancel-order
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Order ID"
+// @Success 200 {object} Order
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /orders/{id}/cancel [post]
+func (service *OrderService) CancelOrder(g *gin.Context) {
+	service.transitionOrder(g, "cancel")
+}
+
+// ReturnOrder example
+// @Tags orders
+// @Summary Return a shipped or delivered order
+// @Description Return a shipped or delivered order, its stock is increased back
+// @ID return-order
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Order ID"
+// @Success 200 {object} Order
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /orders/{id}/return [post]
+func (service *OrderService) ReturnOrder(g *gin.Context) {
+	service.transitionOrder(g, "return")
+}
+
+// transitionOrder applies the given action to the order in the uri
+func (service *OrderService) transitionOrder(g *gin.Context, action string) {
+	var order Order
+
+	if err := g.ShouldBindUri(&order); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the params",
+		})
+		return
+	}
+
+	o, err := service.WithContext(g.Request.Context()).Transition(order.ID, action)
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	g.JSON(http.StatusOK, o)
+}
+
+// writeError writes the response matching the given service error
+var writeError = apierror.Writer(
+	apierror.Match(http.StatusConflict, func(err error) bool {
+		var transitionErr *TransitionError
+		return errors.As(err, &transitionErr)
+	}),
+	product.InsufficientStock,
+)
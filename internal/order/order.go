@@ -1,17 +1,40 @@
 package order
 
 import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/unicod3/horreum/internal/product"
 	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/outbox"
 	"github.com/unicod3/horreum/pkg/streamer"
 	"time"
 )
 
 const (
-	OrderCreated string = "OrderCreated"
-	OrderUpdated        = "OrderUpdated"
-	OrderDeleted        = "OrderDeleted"
+	OrderCreated   string = "OrderCreated"
+	OrderUpdated          = "OrderUpdated"
+	OrderDeleted          = "OrderDeleted"
+	OrderConfirmed        = "OrderConfirmed"
+	OrderPicking          = "OrderPicking"
+	OrderShipped          = "OrderShipped"
+	OrderDelivered        = "OrderDelivered"
+	OrderCancelled        = "OrderCancelled"
+	OrderReturned         = "OrderReturned"
 )
 
+// RegisterEvents registers the schemas of the package's events to the registry
+func RegisterEvents(registry *streamer.Registry) {
+	for _, event := range []string{
+		OrderCreated, OrderUpdated, OrderDeleted,
+		OrderConfirmed, OrderPicking, OrderShipped,
+		OrderDelivered, OrderCancelled, OrderReturned,
+	} {
+		registry.Register(event, 1, Order{})
+	}
+}
+
 // OrderRepository serves as a contract over OrderService
 type OrderRepository interface {
 	GetAll() ([]Order, error)
@@ -19,16 +42,30 @@ type OrderRepository interface {
 	Create(o *Order) error
 	Update(o *Order) error
 	Delete(o *Order) error
+	Transition(id uint64, action string) (*Order, error)
+}
+
+// Inventory serves a contract to reserve the stock an order needs
+type Inventory interface {
+	BillOfMaterials(tx dbclient.DataTable, productIDs []uint64) ([]product.ProductArticleRelation, error)
+	ReserveArticles(tx dbclient.DataTable, warehouseID uint64, bom []product.ProductArticleRelation, quantities map[uint64]int64, allowBackorder bool) error
 }
 
 // Order represents a record from orders table
 type Order struct {
-	ID          uint64      `json:"id" uri:"id" db:"id,omitempty"`
-	WarehouseID uint64      `json:"warehouse_id" db:"warehouse_id,inline"`
-	CreatedAt   time.Time   `json:"created_at,omitempty" db:"created_at,omitempty"`
-	UpdatedAt   time.Time   `json:"updated_at,omitempty" db:"updated_at,omitempty"`
-	Customer    string      `json:"customer" db:"customer"`
-	Lines       []OrderLine `json:"lines" db:"-"`
+	ID             uint64      `json:"id" uri:"id" db:"id,omitempty"`
+	WarehouseID    uint64      `json:"warehouse_id" db:"warehouse_id,inline"`
+	CreatedAt      time.Time   `json:"created_at,omitempty" db:"created_at,omitempty"`
+	UpdatedAt      time.Time   `json:"updated_at,omitempty" db:"updated_at,omitempty"`
+	Customer       string      `json:"customer" db:"customer"`
+	AllowBackorder bool        `json:"allow_backorder" db:"allow_backorder"`
+	Status         Status      `json:"status" db:"status"`
+	PreviousStatus Status      `json:"previous_status,omitempty" db:"-"`
+	Lines          []OrderLine `json:"lines" db:"-"`
+	PreviousLines  []OrderLine `json:"previous_lines,omitempty" db:"-"`
+	// PreviousWarehouseID is set by the updates moving a reservation
+	// to another warehouse, PreviousLines are reserved in it
+	PreviousWarehouseID uint64 `json:"previous_warehouse_id,omitempty" db:"-"`
 }
 
 // OrderLine represents a record from order_lines table
@@ -40,6 +77,43 @@ type OrderLine struct {
 	UpdatedAt time.Time `json:"updated_at,omitempty" db:"updated_at,omitempty"`
 	Quantity  uint64    `json:"quantity" db:"quantity"`
 	UnitCost  uint64    `json:"unit_cost" db:"unit_cost"`
+	// Articles are the articles one of the product takes, kept when the
+	// stock of the line is reserved so it is released, shipped and
+	// returned in the same amounts even when the product changes later.
+	// The lines a reservation is converted into keep the articles of the
+	// reservation, they hold its stock while the order is a draft.
+	Articles LineArticles `json:"articles,omitempty" db:"articles"`
+}
+
+// LineArticle is the amount of an article one of the product of a line takes
+type LineArticle struct {
+	ArticleID uint64 `json:"article_id"`
+	AmountOf  int64  `json:"amount_of"`
+}
+
+// LineArticles are stored as a jsonb column of the order_lines table
+type LineArticles []LineArticle
+
+// Value implements driver.Valuer
+func (articles LineArticles) Value() (driver.Value, error) {
+	if articles == nil {
+		return nil, nil
+	}
+	return json.Marshal(articles)
+}
+
+// Scan implements sql.Scanner
+func (articles *LineArticles) Scan(src interface{}) error {
+	switch data := src.(type) {
+	case nil:
+		*articles = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, articles)
+	case string:
+		return json.Unmarshal([]byte(data), articles)
+	}
+	return fmt.Errorf("can't scan %T into LineArticles", src)
 }
 
 // ErrorResponse contains information about error
@@ -48,17 +122,129 @@ type ErrorResponse struct {
 	Message string `json:"message"`
 }
 
+// StockErrorResponse contains information about the products
+// that can't be served from the warehouse stock
+type StockErrorResponse struct {
+	Code      int                     `json:"code"`
+	Message   string                  `json:"message"`
+	Shortages []product.StockShortage `json:"shortages"`
+}
+
 // RequestBody represents the data type that needs to be sent over request
 type RequestBody struct {
-	Customer    string `json:"customer"`
-	WarehouseID uint64 `json:"warehouse_id"`
-	Lines       []struct {
+	Customer       string `json:"customer"`
+	WarehouseID    uint64 `json:"warehouse_id"`
+	AllowBackorder bool   `json:"allow_backorder"`
+	Lines          []struct {
 		ProductID uint64 `json:"product_id"`
 		Quantity  uint64 `json:"quantity"`
 		UnitCost  uint64 `json:"unit_cost"`
 	} `json:"lines"`
 }
 
+// Order returns the order the request body describes, the fields the
+// service keeps track of itself can't be set through the request
+func (body *RequestBody) Order() *Order {
+	o := &Order{
+		Customer:       body.Customer,
+		WarehouseID:    body.WarehouseID,
+		AllowBackorder: body.AllowBackorder,
+	}
+	for _, line := range body.Lines {
+		o.Lines = append(o.Lines, OrderLine{
+			ProductID: line.ProductID,
+			Quantity:  line.Quantity,
+			UnitCost:  line.UnitCost,
+		})
+	}
+	return o
+}
+
+// productIDs returns the ids of the products of the lines in the order they are met
+func (o *Order) productIDs() []uint64 {
+	var productIDs []uint64
+	seen := make(map[uint64]bool)
+	for _, line := range o.Lines {
+		if !seen[line.ProductID] {
+			seen[line.ProductID] = true
+			productIDs = append(productIDs, line.ProductID)
+		}
+	}
+	return productIDs
+}
+
+// productQuantities sums up the ordered quantities by product id
+func (o *Order) productQuantities() map[uint64]int64 {
+	quantities := make(map[uint64]int64)
+	for _, line := range o.Lines {
+		quantities[line.ProductID] += int64(line.Quantity)
+	}
+	return quantities
+}
+
+// Quantity returns the quantity of the products ordered by the lines
+func (o *Order) Quantity() uint64 {
+	var quantity uint64
+	for _, line := range o.Lines {
+		quantity += line.Quantity
+	}
+	return quantity
+}
+
+// Total returns the cost of the order, the sum of the quantity
+// times the unit cost of its lines
+func (o *Order) Total() uint64 {
+	var total uint64
+	for _, line := range o.Lines {
+		total += line.Quantity * line.UnitCost
+	}
+	return total
+}
+
+// ReservedLines returns the lines of an order in the given status which hold
+// reserved stock. Every line of an order holding a reservation does, the
+// lines of a draft order only when they are converted from a reservation
+// and keep the articles its stock is held with.
+func ReservedLines(status Status, lines []OrderLine) []OrderLine {
+	if status.HoldsReservation() {
+		return lines
+	}
+	if status != StatusDraft {
+		return nil
+	}
+	var reserved []OrderLine
+	for _, line := range lines {
+		if len(line.Articles) > 0 {
+			reserved = append(reserved, line)
+		}
+	}
+	return reserved
+}
+
+// ReservedDeltas returns the change of the quantities holding reserved stock
+// by product id between PreviousLines and Lines, see ReservedLines
+func (o *Order) ReservedDeltas() map[uint64]int64 {
+	return (&Order{
+		Lines:         ReservedLines(o.Status, o.Lines),
+		PreviousLines: ReservedLines(o.Status, o.PreviousLines),
+	}).LineDeltas()
+}
+
+// LineDeltas returns the change of the ordered quantities by product id
+// between PreviousLines and Lines, products whose quantity didn't change are left out
+func (o *Order) LineDeltas() map[uint64]int64 {
+	deltas := (&Order{Lines: o.Lines}).productQuantities()
+	for productID, quantity := range (&Order{Lines: o.PreviousLines}).productQuantities() {
+		deltas[productID] -= quantity
+	}
+	for productID, delta := range deltas {
+		if delta == 0 {
+			delete(deltas, productID)
+		}
+	}
+	return deltas
+}
+
 func (o *Order) populateLines(dataTable dbclient.DataTable) error {
 	return dataTable.FindRelated("order_lines", dbclient.Condition{"order_id": o.ID}, &o.Lines)
 }
@@ -74,6 +260,81 @@ func (o *Order) createLines(dataTable dbclient.DataTable) error {
 	return nil
 }
 
+// keepLineArticles sets the articles of the lines to the ones
+// the previous lines of the same products were reserved with
+func (o *Order) keepLineArticles() {
+	reserved := make(map[uint64]LineArticles)
+	for _, line := range o.PreviousLines {
+		reserved[line.ProductID] = line.Articles
+	}
+	for i, line := range o.Lines {
+		o.Lines[i].Articles = reserved[line.ProductID]
+	}
+}
+
+// reserve reserves the stock of the given product quantities in the order's
+// warehouse, quantities are keyed by product id. The lines keeping articles
+// are reserved with them, the others with the current bills of materials of
+// their products which are kept on the lines.
+func (o *Order) reserve(tx dbclient.DataTable, inventory Inventory, quantities map[uint64]int64) error {
+	if len(quantities) == 0 {
+		return nil
+	}
+	kept := make(map[uint64]LineArticles)
+	for _, line := range o.Lines {
+		if len(line.Articles) > 0 {
+			kept[line.ProductID] = line.Articles
+		}
+	}
+	var missing []uint64
+	for _, productID := range o.productIDs() {
+		if _, ok := quantities[productID]; ok && kept[productID] == nil {
+			missing = append(missing, productID)
+		}
+	}
+	var current []product.ProductArticleRelation
+	if len(missing) > 0 {
+		var err error
+		current, err = inventory.BillOfMaterials(tx, missing)
+		if err != nil {
+			return err
+		}
+	}
+
+	var bom []product.ProductArticleRelation
+	for _, productID := range o.productIDs() {
+		if _, ok := quantities[productID]; !ok {
+			continue
+		}
+		for _, art := range kept[productID] {
+			bom = append(bom, product.ProductArticleRelation{ProductID: productID, ArticleID: art.ArticleID, AmountOf: art.AmountOf})
+		}
+		for _, relation := range current {
+			if relation.ProductID == productID {
+				bom = append(bom, relation)
+			}
+		}
+	}
+	err := inventory.ReserveArticles(tx, o.WarehouseID, bom, quantities, o.AllowBackorder)
+	if err != nil {
+		return err
+	}
+
+	for i, line := range o.Lines {
+		if _, ok := quantities[line.ProductID]; !ok || len(line.Articles) > 0 {
+			continue
+		}
+		articles := kept[line.ProductID]
+		for _, relation := range current {
+			if relation.ProductID == line.ProductID {
+				articles = append(articles, LineArticle{ArticleID: relation.ArticleID, AmountOf: relation.AmountOf})
+			}
+		}
+		o.Lines[i].Articles = articles
+	}
+	return nil
+}
+
 func (o *Order) deleteLines(dataTable dbclient.DataTable) error {
 	return dataTable.DeleteRelated("order_lines", dbclient.Condition{"order_id": o.ID})
 }
@@ -82,8 +343,23 @@ func (o *Order) deleteLines(dataTable dbclient.DataTable) error {
 // and implements OrderService
 type OrderService struct {
 	DataTable     dbclient.DataTable
+	Inventory     Inventory
 	StreamChannel streamer.Channel
 	StreamTopic   string
+	// correlationID is carried by the events of the service
+	correlationID string
+}
+
+// WithContext returns a copy of the service whose events, and the stock
+// changes of its Inventory, carry the correlation id of ctx, when ctx
+// carries one
+func (service *OrderService) WithContext(ctx context.Context) *OrderService {
+	scoped := *service
+	scoped.correlationID = streamer.CorrelationIDFrom(ctx)
+	if products, ok := scoped.Inventory.(*product.ProductService); ok {
+		scoped.Inventory = products.WithContext(ctx)
+	}
+	return &scoped
 }
 
 // GetAll returns all the records
@@ -101,6 +377,47 @@ func (service *OrderService) GetAll() ([]Order, error) {
 	return orders, nil
 }
 
+// OrderFields are the fields the orders can be filtered and sorted by
+var OrderFields = dbclient.Fields{
+	"warehouse_id": {Type: dbclient.IntField},
+	"customer":     {Type: dbclient.StringField},
+	"status":       {Type: dbclient.StringField},
+	"created_at":   {Type: dbclient.TimeField},
+	"updated_at":   {Type: dbclient.TimeField},
+}
+
+// GetPage returns the records of the page and the cursor of the next one,
+// the lines of the orders are loaded with a single query
+func (service *OrderService) GetPage(query dbclient.PageQuery) ([]Order, string, error) {
+	var orders []Order
+	next, err := service.DataTable.FindPage(query, &orders)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(orders) == 0 {
+		return orders, next, nil
+	}
+
+	var ids []uint64
+	for _, o := range orders {
+		ids = append(ids, o.ID)
+	}
+	var lines []OrderLine
+	err = service.DataTable.FindRelated("order_lines", dbclient.Condition{"order_id IN": ids}, &lines)
+	if err != nil {
+		return nil, "", err
+	}
+	index := make(map[uint64]int, len(orders))
+	for i, o := range orders {
+		index[o.ID] = i
+	}
+	for _, line := range lines {
+		i := index[line.OrderID]
+		orders[i].Lines = append(orders[i].Lines, line)
+	}
+	return orders, next, nil
+}
+
 // GetById returns single record for given pk id
 func (service *OrderService) GetById(id uint64) (*Order, error) {
 	var order Order
@@ -111,71 +428,190 @@ func (service *OrderService) GetById(id uint64) (*Order, error) {
 	return &order, nil
 }
 
-// Create creates a new record on the datastore with given struct
+// Create creates a new draft record on the datastore with given struct,
+// the order, its lines and the event are written in a single transaction
 func (service *OrderService) Create(o *Order) error {
-	if err := service.DataTable.InsertReturning(o); err != nil {
-		return err
+	o.Status = StatusDraft
+	o.PreviousStatus = ""
+	o.PreviousLines = nil
+	o.PreviousWarehouseID = 0
+	for i := range o.Lines {
+		o.Lines[i].Articles = nil
 	}
-	err := o.createLines(service.DataTable)
-	if err != nil {
-		return err
-	}
-
-	// Publish an event on the channel
-	err = service.PublishEvent(OrderCreated, o)
-	if err != nil {
-		return err
-	}
-	return nil
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		if err := tx.InsertReturning(o); err != nil {
+			return err
+		}
+		if err := o.createLines(tx); err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, OrderCreated, o)
+	})
 }
 
-// Update updates given record on the datastore by finding it with its pk
+// Update updates given record on the datastore by finding it with its pk,
+// the order, its lines and the event are written in a single transaction.
+// Only draft orders and the orders holding a reservation can be updated.
+// The increased quantities of the lines holding reserved stock, see
+// ReservedLines, are reserved in the same transaction, so the update fails
+// with an *product.InsufficientStockError when the stock doesn't suffice,
+// and they are reserved again when the order moves to another warehouse.
+// The decreased quantities and the reservation in the previous warehouse
+// are released after the update.
 func (service *OrderService) Update(o *Order) error {
 	o.UpdatedAt = time.Now().UTC()
-	if err := service.DataTable.UpdateReturning(o); err != nil {
-		return err
-	}
-	err := o.deleteLines(service.DataTable)
-	if err != nil {
-		return err
-	}
+	o.PreviousStatus = ""
+	o.PreviousLines = nil
+	o.PreviousWarehouseID = 0
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		current, err := findForUpdate(tx, o.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status != StatusDraft && !current.Status.HoldsReservation() {
+			return &TransitionError{OrderID: o.ID, Action: "update", Status: current.Status}
+		}
+		if err := current.populateLines(tx); err != nil {
+			return err
+		}
+		o.Status = current.Status
+		o.PreviousLines = current.Lines
+		o.keepLineArticles()
 
-	err = o.createLines(service.DataTable)
-	if err != nil {
-		return err
-	}
+		quantities := make(map[uint64]int64)
+		if o.WarehouseID != current.WarehouseID && len(ReservedLines(o.Status, o.PreviousLines)) > 0 {
+			o.PreviousWarehouseID = current.WarehouseID
+			quantities = (&Order{Lines: ReservedLines(o.Status, o.Lines)}).productQuantities()
+		} else {
+			for productID, delta := range o.ReservedDeltas() {
+				if delta > 0 {
+					quantities[productID] = delta
+				}
+			}
+		}
+		if err := o.reserve(tx, service.Inventory, quantities); err != nil {
+			return err
+		}
 
-	// Publish an event on the channel
-	err = service.PublishEvent(OrderUpdated, o)
-	if err != nil {
-		return err
-	}
-	return nil
+		if err := tx.UpdateReturning(o); err != nil {
+			return err
+		}
+		if err := o.deleteLines(tx); err != nil {
+			return err
+		}
+		if err := o.createLines(tx); err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, OrderUpdated, o)
+	})
 }
 
-// Delete deletes the given struct from database by finding it with its pk
+// Delete deletes the given struct from database by finding it with its pk,
+// orders holding reserved stock need to be cancelled first. The stock held
+// by the lines of a draft order converted from reservations is released
+// after the delete.
 func (service *OrderService) Delete(o *Order) error {
-	if err := service.DataTable.Delete(dbclient.Condition{"id": o.ID}); err != nil {
-		return err
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		current, err := findForUpdate(tx, o.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status.HoldsReservation() {
+			return &TransitionError{OrderID: o.ID, Action: "delete", Status: current.Status}
+		}
+		if err := current.populateLines(tx); err != nil {
+			return err
+		}
+		*o = *current
+		if err := tx.Delete(dbclient.Condition{"id": o.ID}); err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, OrderDeleted, o)
+	})
+}
+
+// Transition moves the order with given pk id to the next status of the
+// given action and publishes the event of the transition. Stock is reserved
+// in the same transaction when the order is confirmed, so confirming fails
+// with an *product.InsufficientStockError when the stock doesn't suffice.
+// The lines keep the articles their stock is reserved with.
+func (service *OrderService) Transition(id uint64, action string) (*Order, error) {
+	transition, ok := GetTransition(action)
+	if !ok {
+		return nil, fmt.Errorf("unknown order action %q", action)
 	}
 
-	// Publish an event on the channel
-	err := service.PublishEvent(OrderDeleted, o)
+	var o *Order
+	err := service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		var err error
+		o, err = findForUpdate(tx, id)
+		if err != nil {
+			return err
+		}
+		if !transition.Allows(o.Status) {
+			return &TransitionError{OrderID: id, Action: action, Status: o.Status}
+		}
+		if err := o.populateLines(tx); err != nil {
+			return err
+		}
+
+		if transition.To == StatusConfirmed {
+			// The lines converted from reservations hold their stock already
+			quantities := make(map[uint64]int64)
+			for _, line := range o.Lines {
+				if len(line.Articles) == 0 {
+					quantities[line.ProductID] += int64(line.Quantity)
+				}
+			}
+			if err := o.reserve(tx, service.Inventory, quantities); err != nil {
+				return err
+			}
+			for i := range o.Lines {
+				if err := tx.Related("order_lines").UpdateReturning(&o.Lines[i]); err != nil {
+					return err
+				}
+			}
+		}
+
+		o.PreviousStatus = o.Status
+		o.Status = transition.To
+		o.UpdatedAt = time.Now().UTC()
+		if err := tx.UpdateReturning(o); err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, transition.Event, o)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return o, nil
+}
 
-	return nil
+// findForUpdate returns the order with given pk id and
+// locks it until the end of the transaction
+func findForUpdate(tx dbclient.DataTable, id uint64) (*Order, error) {
+	var orders []Order
+	if err := tx.FindForUpdate(dbclient.Condition{"id": id}, &orders); err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, dbclient.ErrNoMoreRows
+	}
+	return &orders[0], nil
 }
 
-func (service *OrderService) PublishEvent(event string, order *Order) error {
+// PublishEvent writes the event to the outbox within the transaction of tx,
+// the outbox.Relay publishes it on the StreamChannel once it is committed
+func (service *OrderService) PublishEvent(tx dbclient.DataTable, event string, order *Order) error {
 	msg, err := streamer.NewMessage(&streamer.Message{
-		EventName: event,
-		Data:      order,
+		EventName:     event,
+		AggregateType: "order",
+		AggregateID:   order.ID,
+		CorrelationID: service.correlationID,
+		Data:          order,
 	})
 	if err != nil {
 		return err
 	}
-	streamer.PublishMessage(service.StreamChannel, service.StreamTopic, msg)
-	return nil
+	return outbox.Store(tx, service.StreamTopic, msg)
 }
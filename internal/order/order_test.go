@@ -1,14 +1,31 @@
 package order
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	orderMocks "github.com/unicod3/horreum/internal/order/mocks"
+	"github.com/unicod3/horreum/internal/product"
 	"github.com/unicod3/horreum/pkg/dbclient"
 	"github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"github.com/unicod3/horreum/pkg/outbox"
 	"github.com/unicod3/horreum/pkg/streamer"
 	"testing"
 )
 
+// mockOutbox expects an event to be written to the outbox
+// and returns the message the event is written with
+func mockOutbox(dataTable *mocks.DataTable) *streamer.Message {
+	var message streamer.Message
+	dataTable.On("CreateRelated", outbox.TableName, mock.Anything).Run(func(args mock.Arguments) {
+		m := args.Get(1).(*outbox.Message)
+		json.Unmarshal([]byte(m.Payload), &message)
+	}).Return(nil).Once()
+	return &message
+}
+
 func TestOrderServiceImplementsOrderRepositoryInterface(t *testing.T) {
 	assert := assert.New(t)
 	assert.Implements((*OrderRepository)(nil), new(OrderService))
@@ -36,6 +53,36 @@ func TestOrderService_GetAll(t *testing.T) {
 	assert.Equal(orders, w)
 }
 
+func TestOrderService_GetPage(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	orderService := &OrderService{
+		DataTable: &dataTable,
+	}
+
+	query := dbclient.PageQuery{Fields: OrderFields, Limit: 2}
+	dataTable.On("FindPage", query, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(1).(*[]Order) = []Order{{ID: 2}, {ID: 1}}
+	}).Return("next", nil).Once()
+	dataTable.On("FindRelated", "order_lines", dbclient.Condition{"order_id IN": []uint64{2, 1}}, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(2).(*[]OrderLine) = []OrderLine{
+			{ID: 1, OrderID: 1, ProductID: 5},
+			{ID: 2, OrderID: 2, ProductID: 6},
+			{ID: 3, OrderID: 1, ProductID: 7},
+		}
+	}).Return(nil).Once()
+
+	orders, next, err := orderService.GetPage(query)
+	assert.Nil(err)
+	assert.Equal("next", next)
+	assert.Equal([]Order{
+		{ID: 2, Lines: []OrderLine{{ID: 2, OrderID: 2, ProductID: 6}}},
+		{ID: 1, Lines: []OrderLine{{ID: 1, OrderID: 1, ProductID: 5}, {ID: 3, OrderID: 1, ProductID: 7}}},
+	}, orders)
+	dataTable.AssertExpectations(t)
+}
+
 func TestOrderService_GetById(t *testing.T) {
 	assert := assert.New(t)
 
@@ -65,21 +112,90 @@ func TestOrderService_Create(t *testing.T) {
 
 	dataTable := mocks.DataTable{}
 	orderService := &OrderService{
-		DataTable:     &dataTable,
-		StreamTopic:   "orders",
-		StreamChannel: streamer.NewChannel(),
+		DataTable:   &dataTable,
+		StreamTopic: "orders",
 	}
 
-	order := Order{ID: 1, Customer: "test"}
+	order := Order{ID: 1, Customer: "test", Status: StatusShipped, Lines: []OrderLine{
+		{ProductID: 1, Quantity: 2, Articles: LineArticles{{ArticleID: 7, AmountOf: 4}}},
+	}}
 
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
 	var w Order
 	dataTable.On("InsertReturning", &order).Run(func(args mock.Arguments) {
 		w = order
 	}).Return(nil).Once()
+	dataTable.On("CreateRelated", "order_lines", mock.MatchedBy(func(line *OrderLine) bool {
+		return line.Articles == nil
+	})).Return(nil).Once()
+	message := mockOutbox(&dataTable)
 
 	err := orderService.Create(&order)
 	assert.Nil(err)
 	assert.Equal(order, w)
+	assert.Equal(StatusDraft, w.Status)
+	assert.Equal(OrderCreated, message.EventName)
+}
+
+func TestOrderService_WithContext(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	orderService := &OrderService{
+		DataTable:   &dataTable,
+		StreamTopic: "orders",
+	}
+
+	var stored *outbox.Message
+	dataTable.On("CreateRelated", outbox.TableName, mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(1).(*outbox.Message)
+	}).Return(nil).Once()
+
+	ctx := streamer.WithCorrelationID(context.Background(), "request-1")
+	err := orderService.WithContext(ctx).PublishEvent(&dataTable, OrderCreated, &Order{ID: 1})
+	assert.Nil(err)
+	assert.Contains(stored.Metadata, `"correlation_id":"request-1"`)
+	assert.Empty(orderService.correlationID, "the service itself must stay unscoped")
+}
+
+func TestOrderService_CreateRollsBackOnLineFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	tx := mocks.DataTable{}
+	orderService := &OrderService{
+		DataTable:   &dataTable,
+		StreamTopic: "orders",
+	}
+
+	order := Order{Customer: "test", Lines: []OrderLine{{ProductID: 1, Quantity: 2}, {ProductID: 2, Quantity: 1}}}
+	lineErr := errors.New("insert failed")
+
+	var txErr error
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			txErr = fn(&tx)
+			return txErr
+		}).Once()
+	tx.On("InsertReturning", &order).Run(func(args mock.Arguments) {
+		order.ID = 1
+	}).Return(nil).Once()
+	tx.On("CreateRelated", "order_lines", mock.MatchedBy(func(line *OrderLine) bool {
+		return line.ProductID == 1
+	})).Return(lineErr).Once()
+
+	err := orderService.Create(&order)
+	assert.Equal(lineErr, err)
+	assert.Equal(lineErr, txErr, "the transaction must be rolled back")
+	tx.AssertExpectations(t)
+	// Nothing runs after the failing line, neither in the transaction nor outside of it
+	tx.AssertNumberOfCalls(t, "CreateRelated", 1)
+	tx.AssertNotCalled(t, "CreateRelated", outbox.TableName, mock.Anything)
+	dataTable.AssertNumberOfCalls(t, "WithTx", 1)
+	assert.Len(dataTable.Calls, 1)
 }
 
 func TestOrderService_Update(t *testing.T) {
@@ -87,23 +203,291 @@ func TestOrderService_Update(t *testing.T) {
 
 	dataTable := mocks.DataTable{}
 	orderService := &OrderService{
-		DataTable:     &dataTable,
-		StreamTopic:   "orders",
-		StreamChannel: streamer.NewChannel(),
+		DataTable:   &dataTable,
+		StreamTopic: "orders",
 	}
 
 	order := Order{ID: 1, Customer: "test"}
 
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
+	dataTable.On("FindForUpdate", dbclient.Condition{"id": order.ID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*[]Order)) = []Order{{ID: 1, Status: StatusDraft}}
+	}).Return(nil).Once()
+	dataTable.On("FindRelated", "order_lines", dbclient.Condition{"order_id": order.ID}, mock.Anything).Return(nil).Once()
 	var w Order
 	dataTable.On("UpdateReturning", &order).Run(func(args mock.Arguments) {
 		w = order
 	}).Return(nil).Once()
 
 	dataTable.On("DeleteRelated", "order_lines", dbclient.Condition{"order_id": order.ID}).Return(nil).Once()
+	message := mockOutbox(&dataTable)
 
 	err := orderService.Update(&order)
 	assert.Nil(err)
 	assert.Equal(order, w)
+	assert.Equal(StatusDraft, w.Status)
+	assert.Equal(OrderUpdated, message.EventName)
+}
+
+func TestOrderService_UpdateReservedOrder(t *testing.T) {
+	previousLines := []OrderLine{
+		{ID: 1, OrderID: 1, ProductID: 1, Quantity: 2, Articles: LineArticles{{ArticleID: 7, AmountOf: 4}}},
+		{ID: 2, OrderID: 1, ProductID: 2, Quantity: 3, Articles: LineArticles{{ArticleID: 8, AmountOf: 1}}},
+	}
+
+	// mockReservedOrder mocks a confirmed order of warehouse 3 with previousLines
+	mockReservedOrder := func(dataTable *mocks.DataTable) {
+		dataTable.On("WithTx", mock.Anything).
+			Return(func(fn func(dbclient.DataTable) error) error {
+				return fn(dataTable)
+			}).Once()
+		dataTable.On("FindForUpdate", dbclient.Condition{"id": uint64(1)}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]Order)) = []Order{{ID: 1, WarehouseID: 3, Status: StatusConfirmed}}
+		}).Return(nil).Once()
+		dataTable.On("FindRelated", "order_lines", dbclient.Condition{"order_id": uint64(1)}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]OrderLine)) = previousLines
+		}).Return(nil).Once()
+	}
+
+	t.Run("Test can decrease quantities", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		orderService := &OrderService{
+			DataTable:   &dataTable,
+			StreamTopic: "orders",
+		}
+		mockReservedOrder(&dataTable)
+
+		order := Order{ID: 1, WarehouseID: 3, Lines: []OrderLine{{ProductID: 1, Quantity: 1}},
+			PreviousWarehouseID: 9, PreviousStatus: StatusPicking}
+		dataTable.On("UpdateReturning", &order).Return(nil).Once()
+		dataTable.On("DeleteRelated", "order_lines", dbclient.Condition{"order_id": order.ID}).Return(nil).Once()
+		dataTable.On("CreateRelated", "order_lines", mock.Anything).Return(nil).Once()
+		mockOutbox(&dataTable)
+
+		err := orderService.Update(&order)
+		assert.Nil(err)
+		assert.Equal(StatusConfirmed, order.Status)
+		assert.Equal(previousLines, order.PreviousLines)
+		assert.Zero(order.PreviousWarehouseID, "the stock must only be released in the warehouse the order is moved from")
+		assert.Empty(order.PreviousStatus)
+		assert.Equal(previousLines[0].Articles, order.Lines[0].Articles, "the line keeps the articles it was reserved with")
+		dataTable.AssertExpectations(t)
+	})
+
+	t.Run("Test can reserve increased quantities", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		inventory := orderMocks.Inventory{}
+		orderService := &OrderService{
+			DataTable:   &dataTable,
+			Inventory:   &inventory,
+			StreamTopic: "orders",
+		}
+		mockReservedOrder(&dataTable)
+
+		order := Order{ID: 1, WarehouseID: 3, Lines: []OrderLine{
+			{ProductID: 1, Quantity: 4},
+			{ProductID: 3, Quantity: 1},
+		}}
+		bom := []product.ProductArticleRelation{{ProductID: 3, ArticleID: 9, AmountOf: 2}}
+		inventory.On("BillOfMaterials", &dataTable, []uint64{3}).Return(bom, nil).Once()
+		inventory.On("ReserveArticles", &dataTable, uint64(3), []product.ProductArticleRelation{
+			{ProductID: 1, ArticleID: 7, AmountOf: 4},
+			{ProductID: 3, ArticleID: 9, AmountOf: 2},
+		}, map[uint64]int64{1: 2, 3: 1}, false).Return(nil).Once()
+		dataTable.On("UpdateReturning", &order).Return(nil).Once()
+		dataTable.On("DeleteRelated", "order_lines", dbclient.Condition{"order_id": order.ID}).Return(nil).Once()
+		dataTable.On("CreateRelated", "order_lines", mock.Anything).Return(nil).Twice()
+		mockOutbox(&dataTable)
+
+		err := orderService.Update(&order)
+		assert.Nil(err)
+		assert.Equal(LineArticles{{ArticleID: 7, AmountOf: 4}}, order.Lines[0].Articles)
+		assert.Equal(LineArticles{{ArticleID: 9, AmountOf: 2}}, order.Lines[1].Articles)
+		assert.Zero(order.PreviousWarehouseID)
+		inventory.AssertExpectations(t)
+		dataTable.AssertExpectations(t)
+	})
+
+	t.Run("Test can reject increased quantities the stock doesn't serve", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		inventory := orderMocks.Inventory{}
+		orderService := &OrderService{
+			DataTable: &dataTable,
+			Inventory: &inventory,
+		}
+		mockReservedOrder(&dataTable)
+
+		order := Order{ID: 1, WarehouseID: 3, Lines: []OrderLine{
+			{ProductID: 1, Quantity: 4},
+			{ProductID: 2, Quantity: 3},
+		}}
+		stockErr := &product.InsufficientStockError{WarehouseID: 3, Shortages: []product.StockShortage{
+			{ProductID: 1, Requested: 2, SellableInventory: 1, LimitingArticleID: 7},
+		}}
+		inventory.On("ReserveArticles", &dataTable, uint64(3), []product.ProductArticleRelation{
+			{ProductID: 1, ArticleID: 7, AmountOf: 4},
+		}, map[uint64]int64{1: 2}, false).Return(stockErr).Once()
+
+		err := orderService.Update(&order)
+		assert.Equal(stockErr, err)
+		inventory.AssertNotCalled(t, "BillOfMaterials", mock.Anything, mock.Anything)
+		dataTable.AssertNotCalled(t, "UpdateReturning", mock.Anything)
+	})
+
+	t.Run("Test can move the reservation to another warehouse", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		inventory := orderMocks.Inventory{}
+		orderService := &OrderService{
+			DataTable:   &dataTable,
+			Inventory:   &inventory,
+			StreamTopic: "orders",
+		}
+		mockReservedOrder(&dataTable)
+
+		order := Order{ID: 1, WarehouseID: 4, Lines: []OrderLine{
+			{ProductID: 1, Quantity: 2},
+			{ProductID: 2, Quantity: 3},
+		}}
+		inventory.On("ReserveArticles", &dataTable, uint64(4), []product.ProductArticleRelation{
+			{ProductID: 1, ArticleID: 7, AmountOf: 4},
+			{ProductID: 2, ArticleID: 8, AmountOf: 1},
+		}, map[uint64]int64{1: 2, 2: 3}, false).Return(nil).Once()
+		dataTable.On("UpdateReturning", &order).Return(nil).Once()
+		dataTable.On("DeleteRelated", "order_lines", dbclient.Condition{"order_id": order.ID}).Return(nil).Once()
+		dataTable.On("CreateRelated", "order_lines", mock.Anything).Return(nil).Twice()
+		message := mockOutbox(&dataTable)
+
+		err := orderService.Update(&order)
+		assert.Nil(err)
+		assert.Equal(uint64(3), order.PreviousWarehouseID)
+		assert.Equal(previousLines, order.PreviousLines)
+		assert.Equal(OrderUpdated, message.EventName)
+		inventory.AssertExpectations(t)
+	})
+}
+
+func TestOrderService_UpdateRejectsShippedOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	orderService := &OrderService{
+		DataTable: &dataTable,
+	}
+
+	order := Order{ID: 1, Customer: "test"}
+
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
+	dataTable.On("FindForUpdate", dbclient.Condition{"id": order.ID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*[]Order)) = []Order{{ID: 1, Status: StatusShipped}}
+	}).Return(nil).Once()
+
+	err := orderService.Update(&order)
+	assert.Equal(&TransitionError{OrderID: 1, Action: "update", Status: StatusShipped}, err)
+	dataTable.AssertNotCalled(t, "UpdateReturning", mock.Anything)
+}
+
+func TestRequestBody_Order(t *testing.T) {
+	var body RequestBody
+	err := json.Unmarshal([]byte(`{"customer":"test","warehouse_id":2,"status":"shipped",
+		"previous_warehouse_id":3,"previous_status":"confirmed","previous_lines":[{"product_id":1,"quantity":1}],
+		"lines":[{"id":4,"product_id":1,"quantity":2,"unit_cost":5,"articles":[{"article_id":1,"amount_of":9}]}]}`), &body)
+	assert.Nil(t, err)
+	assert.Equal(t, &Order{
+		Customer:    "test",
+		WarehouseID: 2,
+		Lines:       []OrderLine{{ProductID: 1, Quantity: 2, UnitCost: 5}},
+	}, body.Order(), "only the fields of the request body are taken")
+}
+
+func TestReservedLines(t *testing.T) {
+	assert := assert.New(t)
+
+	lines := []OrderLine{
+		{ProductID: 1, Quantity: 2, Articles: LineArticles{{ArticleID: 7, AmountOf: 4}}},
+		{ProductID: 2, Quantity: 1},
+	}
+	assert.Equal(lines, ReservedLines(StatusConfirmed, lines))
+	assert.Equal(lines[:1], ReservedLines(StatusDraft, lines))
+	assert.Empty(ReservedLines(StatusCancelled, lines))
+}
+
+func TestOrder_LineDeltas(t *testing.T) {
+	previous := []OrderLine{
+		{ProductID: 1, Quantity: 2},
+		{ProductID: 2, Quantity: 3},
+		{ProductID: 3, Quantity: 4},
+	}
+
+	cases := []struct {
+		name   string
+		lines  []OrderLine
+		deltas map[uint64]int64
+	}{
+		{
+			name:   "unchanged",
+			lines:  previous,
+			deltas: map[uint64]int64{},
+		},
+		{
+			name: "added",
+			lines: append([]OrderLine{
+				{ProductID: 4, Quantity: 1},
+			}, previous...),
+			deltas: map[uint64]int64{4: 1},
+		},
+		{
+			name: "removed",
+			lines: []OrderLine{
+				{ProductID: 1, Quantity: 2},
+				{ProductID: 3, Quantity: 4},
+			},
+			deltas: map[uint64]int64{2: -3},
+		},
+		{
+			name: "changed quantity",
+			lines: []OrderLine{
+				{ProductID: 1, Quantity: 5},
+				{ProductID: 2, Quantity: 1},
+				{ProductID: 3, Quantity: 4},
+			},
+			deltas: map[uint64]int64{1: 3, 2: -2},
+		},
+		{
+			name: "split into lines",
+			lines: []OrderLine{
+				{ProductID: 1, Quantity: 1},
+				{ProductID: 1, Quantity: 1},
+				{ProductID: 2, Quantity: 3},
+				{ProductID: 3, Quantity: 4},
+			},
+			deltas: map[uint64]int64{},
+		},
+		{
+			name:   "emptied",
+			lines:  nil,
+			deltas: map[uint64]int64{1: -2, 2: -3, 3: -4},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			o := Order{Lines: c.lines, PreviousLines: previous}
+			assert.Equal(t, c.deltas, o.LineDeltas())
+		})
+	}
 }
 
 func TestOrderService_Delete(t *testing.T) {
@@ -111,13 +495,229 @@ func TestOrderService_Delete(t *testing.T) {
 
 	dataTable := mocks.DataTable{}
 	orderService := &OrderService{
-		DataTable:     &dataTable,
-		StreamTopic:   "orders",
-		StreamChannel: streamer.NewChannel(),
+		DataTable:   &dataTable,
+		StreamTopic: "orders",
 	}
 
 	order := Order{ID: 1, Customer: "test"}
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
+	dataTable.On("FindForUpdate", dbclient.Condition{"id": order.ID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*[]Order)) = []Order{{ID: 1, Customer: "test", Status: StatusCancelled}}
+	}).Return(nil).Once()
+	dataTable.On("FindRelated", "order_lines", dbclient.Condition{"order_id": order.ID}, mock.Anything).Return(nil).Once()
 	dataTable.On("Delete", dbclient.Condition{"id": order.ID}).Return(nil).Once()
+	message := mockOutbox(&dataTable)
+
+	err := orderService.Delete(&order)
+	assert.Nil(err)
+	assert.Equal(StatusCancelled, order.Status)
+	assert.Equal(OrderDeleted, message.EventName)
+}
+
+func TestOrderService_DeleteRejectsReservedOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	orderService := &OrderService{
+		DataTable: &dataTable,
+	}
+
+	order := Order{ID: 1}
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
+	dataTable.On("FindForUpdate", dbclient.Condition{"id": order.ID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*[]Order)) = []Order{{ID: 1, Status: StatusPicking}}
+	}).Return(nil).Once()
+
 	err := orderService.Delete(&order)
+	assert.Equal(&TransitionError{OrderID: 1, Action: "delete", Status: StatusPicking}, err)
+	dataTable.AssertNotCalled(t, "Delete", mock.Anything)
+}
+
+func TestOrderService_Transition(t *testing.T) {
+	lines := []OrderLine{
+		{ProductID: 1, Quantity: 2},
+		{ProductID: 1, Quantity: 3},
+	}
+
+	t.Run("Test can confirm and reserve stock", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		inventory := orderMocks.Inventory{}
+		orderService := &OrderService{
+			DataTable:   &dataTable,
+			Inventory:   &inventory,
+			StreamTopic: "orders",
+		}
+
+		dataTable.On("WithTx", mock.Anything).
+			Return(func(fn func(dbclient.DataTable) error) error {
+				return fn(&dataTable)
+			}).Once()
+		dataTable.On("FindForUpdate", dbclient.Condition{"id": uint64(1)}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]Order)) = []Order{{ID: 1, WarehouseID: 3, Status: StatusDraft}}
+		}).Return(nil).Once()
+		dataTable.On("FindRelated", "order_lines", dbclient.Condition{"order_id": uint64(1)}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]OrderLine)) = append([]OrderLine(nil), lines...)
+		}).Return(nil).Once()
+		bom := []product.ProductArticleRelation{
+			{ProductID: 1, ArticleID: 7, AmountOf: 4},
+			{ProductID: 1, ArticleID: 8, AmountOf: 1},
+		}
+		inventory.On("BillOfMaterials", &dataTable, []uint64{1}).Return(bom, nil).Once()
+		inventory.On("ReserveArticles", &dataTable, uint64(3), bom, map[uint64]int64{1: 5}, false).Return(nil).Once()
+		linesTable := mocks.DataTable{}
+		dataTable.On("Related", "order_lines").Return(&linesTable)
+		linesTable.On("UpdateReturning", mock.Anything).Return(nil).Twice()
+		dataTable.On("UpdateReturning", mock.Anything).Return(nil).Once()
+		message := mockOutbox(&dataTable)
+
+		o, err := orderService.Transition(1, "confirm")
+		assert.Nil(err)
+		assert.Equal(StatusConfirmed, o.Status)
+		assert.Equal(StatusDraft, o.PreviousStatus)
+		assert.Equal(OrderConfirmed, message.EventName)
+		articles := LineArticles{{ArticleID: 7, AmountOf: 4}, {ArticleID: 8, AmountOf: 1}}
+		for _, line := range o.Lines {
+			assert.Equal(articles, line.Articles)
+		}
+		inventory.AssertExpectations(t)
+		linesTable.AssertExpectations(t)
+	})
+
+	t.Run("Test can confirm without reserving the converted lines again", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		inventory := orderMocks.Inventory{}
+		orderService := &OrderService{
+			DataTable:   &dataTable,
+			Inventory:   &inventory,
+			StreamTopic: "orders",
+		}
+
+		dataTable.On("WithTx", mock.Anything).
+			Return(func(fn func(dbclient.DataTable) error) error {
+				return fn(&dataTable)
+			}).Once()
+		dataTable.On("FindForUpdate", dbclient.Condition{"id": uint64(1)}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]Order)) = []Order{{ID: 1, WarehouseID: 3, Status: StatusDraft}}
+		}).Return(nil).Once()
+		dataTable.On("FindRelated", "order_lines", dbclient.Condition{"order_id": uint64(1)}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]OrderLine)) = []OrderLine{
+				{ProductID: 1, Quantity: 2, Articles: LineArticles{{ArticleID: 7, AmountOf: 4}}},
+				{ProductID: 2, Quantity: 1},
+			}
+		}).Return(nil).Once()
+		bom := []product.ProductArticleRelation{{ProductID: 2, ArticleID: 8, AmountOf: 1}}
+		inventory.On("BillOfMaterials", &dataTable, []uint64{2}).Return(bom, nil).Once()
+		inventory.On("ReserveArticles", &dataTable, uint64(3), bom, map[uint64]int64{2: 1}, false).Return(nil).Once()
+		linesTable := mocks.DataTable{}
+		dataTable.On("Related", "order_lines").Return(&linesTable)
+		linesTable.On("UpdateReturning", mock.Anything).Return(nil).Twice()
+		dataTable.On("UpdateReturning", mock.Anything).Return(nil).Once()
+		mockOutbox(&dataTable)
+
+		o, err := orderService.Transition(1, "confirm")
+		assert.Nil(err)
+		assert.Equal(LineArticles{{ArticleID: 7, AmountOf: 4}}, o.Lines[0].Articles)
+		assert.Equal(LineArticles{{ArticleID: 8, AmountOf: 1}}, o.Lines[1].Articles)
+		inventory.AssertExpectations(t)
+	})
+
+	t.Run("Test can reject insufficient stock", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		inventory := orderMocks.Inventory{}
+		orderService := &OrderService{
+			DataTable: &dataTable,
+			Inventory: &inventory,
+		}
+		stockErr := &product.InsufficientStockError{WarehouseID: 3, Shortages: []product.StockShortage{
+			{ProductID: 1, Requested: 5, SellableInventory: 4, LimitingArticleID: 7},
+		}}
+
+		dataTable.On("WithTx", mock.Anything).
+			Return(func(fn func(dbclient.DataTable) error) error {
+				return fn(&dataTable)
+			}).Once()
+		dataTable.On("FindForUpdate", dbclient.Condition{"id": uint64(1)}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]Order)) = []Order{{ID: 1, WarehouseID: 3, Status: StatusDraft}}
+		}).Return(nil).Once()
+		dataTable.On("FindRelated", "order_lines", dbclient.Condition{"order_id": uint64(1)}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]OrderLine)) = append([]OrderLine(nil), lines...)
+		}).Return(nil).Once()
+		bom := []product.ProductArticleRelation{{ProductID: 1, ArticleID: 7, AmountOf: 4}}
+		inventory.On("BillOfMaterials", &dataTable, []uint64{1}).Return(bom, nil).Once()
+		inventory.On("ReserveArticles", &dataTable, uint64(3), bom, map[uint64]int64{1: 5}, false).Return(stockErr).Once()
+
+		o, err := orderService.Transition(1, "confirm")
+		assert.Nil(o)
+		assert.Equal(stockErr, err)
+		dataTable.AssertNotCalled(t, "UpdateReturning", mock.Anything)
+	})
+
+	t.Run("Test can reject invalid transition", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		orderService := &OrderService{
+			DataTable: &dataTable,
+		}
+
+		dataTable.On("WithTx", mock.Anything).
+			Return(func(fn func(dbclient.DataTable) error) error {
+				return fn(&dataTable)
+			}).Once()
+		dataTable.On("FindForUpdate", dbclient.Condition{"id": uint64(1)}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]Order)) = []Order{{ID: 1, Status: StatusDraft}}
+		}).Return(nil).Once()
+
+		o, err := orderService.Transition(1, "ship")
+		assert.Nil(o)
+		assert.Equal(&TransitionError{OrderID: 1, Action: "ship", Status: StatusDraft}, err)
+	})
+
+	t.Run("Test can reject unknown action", func(t *testing.T) {
+		o, err := (&OrderService{}).Transition(1, "teleport")
+		assert.Nil(t, o)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestOrder_Total(t *testing.T) {
+	assert := assert.New(t)
+
+	o := &Order{Lines: []OrderLine{
+		{ProductID: 1, Quantity: 2, UnitCost: 150},
+		{ProductID: 2, Quantity: 3, UnitCost: 1000},
+	}}
+	assert.Equal(uint64(5), o.Quantity())
+	assert.Equal(uint64(3300), o.Total())
+	assert.Equal(uint64(0), (&Order{}).Total())
+}
+
+func TestLineArticles_ValueAndScan(t *testing.T) {
+	assert := assert.New(t)
+
+	articles := LineArticles{{ArticleID: 7, AmountOf: 4}, {ArticleID: 8, AmountOf: 1}}
+	value, err := articles.Value()
+	assert.Nil(err)
+
+	var scanned LineArticles
+	assert.Nil(scanned.Scan(value))
+	assert.Equal(articles, scanned)
+
+	value, err = LineArticles(nil).Value()
 	assert.Nil(err)
+	assert.Nil(value)
+	assert.Nil(scanned.Scan(nil))
+	assert.Nil(scanned)
 }
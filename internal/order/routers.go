@@ -13,5 +13,11 @@ func (service *OrderService) RegisterHTTPRoutes(routerGroup *gin.RouterGroup) {
 		orders.POST("/", service.CreateOrder)
 		orders.PUT("/:id", service.UpdateOrder)
 		orders.DELETE("/:id", service.DeleteOrder)
+		orders.POST("/:id/confirm", service.ConfirmOrder)
+		orders.POST("/:id/pick", service.PickOrder)
+		orders.POST("/:id/ship", service.ShipOrder)
+		orders.POST("/:id/deliver", service.DeliverOrder)
+		orders.POST("/:id/cancel", service.CancelOrder)
+		orders.POST("/:id/return", service.ReturnOrder)
 	}
 }
@@ -0,0 +1,99 @@
+package order
+
+import (
+	"fmt"
+)
+
+// Status represents the state of an order in its lifecycle
+type Status string
+
+const (
+	StatusDraft     Status = "draft"
+	StatusConfirmed Status = "confirmed"
+	StatusPicking   Status = "picking"
+	StatusShipped   Status = "shipped"
+	StatusDelivered Status = "delivered"
+	StatusCancelled Status = "cancelled"
+	StatusReturned  Status = "returned"
+)
+
+// Transition describes a change of the order status
+// and the event published when it happens
+type Transition struct {
+	Action string
+	From   []Status
+	To     Status
+	Event  string
+}
+
+var transitions = map[string]Transition{
+	"confirm": {
+		Action: "confirm",
+		From:   []Status{StatusDraft},
+		To:     StatusConfirmed,
+		Event:  OrderConfirmed,
+	},
+	"pick": {
+		Action: "pick",
+		From:   []Status{StatusConfirmed},
+		To:     StatusPicking,
+		Event:  OrderPicking,
+	},
+	"ship": {
+		Action: "ship",
+		From:   []Status{StatusConfirmed, StatusPicking},
+		To:     StatusShipped,
+		Event:  OrderShipped,
+	},
+	"deliver": {
+		Action: "deliver",
+		From:   []Status{StatusShipped},
+		To:     StatusDelivered,
+		Event:  OrderDelivered,
+	},
+	"cancel": {
+		Action: "cancel",
+		From:   []Status{StatusDraft, StatusConfirmed, StatusPicking},
+		To:     StatusCancelled,
+		Event:  OrderCancelled,
+	},
+	"return": {
+		Action: "return",
+		From:   []Status{StatusShipped, StatusDelivered},
+		To:     StatusReturned,
+		Event:  OrderReturned,
+	},
+}
+
+// GetTransition returns the Transition registered for the given action
+func GetTransition(action string) (Transition, bool) {
+	t, ok := transitions[action]
+	return t, ok
+}
+
+// Allows reports whether the transition can start from the given status
+func (t Transition) Allows(status Status) bool {
+	for _, from := range t.From {
+		if from == status {
+			return true
+		}
+	}
+	return false
+}
+
+// HoldsReservation reports whether an order in the given status
+// has stock reserved for its lines
+func (s Status) HoldsReservation() bool {
+	return s == StatusConfirmed || s == StatusPicking
+}
+
+// TransitionError is returned when an order can't change its status
+type TransitionError struct {
+	OrderID uint64
+	Action  string
+	Status  Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("order %d can't %s while it is %s", e.OrderID, e.Action, e.Status)
+}
@@ -0,0 +1,45 @@
+package order
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTransition_Allows(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		action string
+		from   Status
+		allows bool
+	}{
+		{"confirm", StatusDraft, true},
+		{"confirm", StatusConfirmed, false},
+		{"pick", StatusConfirmed, true},
+		{"ship", StatusConfirmed, true},
+		{"ship", StatusPicking, true},
+		{"ship", StatusDraft, false},
+		{"deliver", StatusShipped, true},
+		{"deliver", StatusPicking, false},
+		{"cancel", StatusDraft, true},
+		{"cancel", StatusPicking, true},
+		{"cancel", StatusShipped, false},
+		{"return", StatusDelivered, true},
+		{"return", StatusCancelled, false},
+	}
+	for _, c := range cases {
+		transition, ok := GetTransition(c.action)
+		assert.True(ok, c.action)
+		assert.Equal(c.allows, transition.Allows(c.from), "%s from %s", c.action, c.from)
+	}
+}
+
+func TestStatus_HoldsReservation(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(StatusConfirmed.HoldsReservation())
+	assert.True(StatusPicking.HoldsReservation())
+	assert.False(StatusDraft.HoldsReservation())
+	assert.False(StatusShipped.HoldsReservation())
+	assert.False(StatusCancelled.HoldsReservation())
+}
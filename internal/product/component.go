@@ -0,0 +1,311 @@
+package product
+
+import (
+	"errors"
+	"fmt"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"strings"
+)
+
+// ErrComponentCycle is returned when a product would contain
+// itself through its components
+var ErrComponentCycle = errors.New("product components form a cycle")
+
+// ErrInvalidComponent is returned for the components with an amount less
+// than 1 and for the components which don't exist
+var ErrInvalidComponent = errors.New("invalid product component")
+
+// ErrComponentInUse is returned when a product still used as
+// a component of other products is deleted
+var ErrComponentInUse = errors.New("product is used as a component")
+
+// componentsLockKey is the advisory lock serializing the writes of the
+// components, so no two of them can close a cycle checkCycle doesn't see
+const componentsLockKey int64 = 0x70726f64636f6d70
+
+// ProductComponentRelation represents a record from product_components
+// table, the product contains AmountOf of the component product
+type ProductComponentRelation struct {
+	ProductID   uint64 `db:"product_id"`
+	ComponentID uint64 `db:"component_id"`
+	AmountOf    int64  `db:"amount_of"`
+}
+
+// BillOfMaterials returns the articles needed to build one of the product
+// through the whole tree of its components, the articles of a component
+// are multiplied by the amount of it the product contains. An article met
+// on several levels is listed once with the sum of its amounts and its
+// available inventory calculated for that sum. Products without components
+// need their own articles only.
+func (p *Product) BillOfMaterials() []article.Article {
+	if len(p.Components) == 0 {
+		return p.Articles
+	}
+	var articles []article.Article
+	index := make(map[uint64]int)
+	p.walkArticles(1, func(art article.Article, amountOf int64) {
+		if i, ok := index[art.ID]; ok {
+			articles[i].AmountOf += amountOf
+			return
+		}
+		art.AmountOf = amountOf
+		index[art.ID] = len(articles)
+		articles = append(articles, art)
+	})
+	for i := range articles {
+		articles[i].CalculateAvailableInventory()
+	}
+	return articles
+}
+
+// walkArticles calls fn with the articles of the tree and the amount of
+// them needed to build quantity of the product
+func (p *Product) walkArticles(quantity int64, fn func(art article.Article, amountOf int64)) {
+	for _, art := range p.Articles {
+		fn(art, art.AmountOf*quantity)
+	}
+	for _, component := range p.Components {
+		component.walkArticles(component.AmountOf*quantity, fn)
+	}
+}
+
+// populateComponents loads the components of the product along with their
+// articles and their own components, path holds the ids of the products
+// the product is a part of and a component met on it again is a cycle
+func (service *ProductService) populateComponents(product *Product, warehouseID uint64, path []uint64) error {
+	var relations []ProductComponentRelation
+	err := service.DataTable.FindRelated("product_components", dbclient.Condition{"product_id": product.ID}, &relations)
+	if err != nil {
+		return err
+	}
+
+	var components []Product
+	for _, relation := range relations {
+		for _, id := range path {
+			if id == relation.ComponentID {
+				return fmt.Errorf("%w: product %d contains itself", ErrComponentCycle, id)
+			}
+		}
+		var component Product
+		if err := service.DataTable.FindOne(dbclient.Condition{"id": relation.ComponentID}, &component); err != nil {
+			return err
+		}
+		component.AmountOf = relation.AmountOf
+		component.WarehouseID = warehouseID
+		if err := service.loadArticles(&component, warehouseID); err != nil {
+			return err
+		}
+		// The path is copied so the siblings don't share its backing array
+		componentPath := append(path[:len(path):len(path)], component.ID)
+		if err := service.populateComponents(&component, warehouseID, componentPath); err != nil {
+			return err
+		}
+		component.CalculateSellableInventory()
+		components = append(components, component)
+	}
+	product.Components = components
+	return nil
+}
+
+// lockComponentWrites serializes the transaction of tx with the other ones
+// writing components when the product gives its components, it has to be
+// called before any product is locked so the writers can't deadlock
+func lockComponentWrites(tx dbclient.DataTable, p *Product) error {
+	if p.Components == nil {
+		return nil
+	}
+	return tx.AdvisoryLock(componentsLockKey)
+}
+
+// syncComponents replaces the components of the product in the datastore,
+// they are left as they are when the product doesn't give any. A product
+// can't contain itself, neither directly nor through its components. The
+// components are locked until the end of the transaction, so they can't be
+// deleted while they are added.
+func syncComponents(dataTable dbclient.DataTable, p *Product) error {
+	if p.Components == nil {
+		return nil
+	}
+	var componentIDs []uint64
+	for _, component := range p.Components {
+		if component.AmountOf < 1 {
+			return fmt.Errorf("%w: amount_of of component %d can't be less than 1", ErrInvalidComponent, component.ID)
+		}
+		componentIDs = append(componentIDs, component.ID)
+	}
+	if err := lockComponents(dataTable, componentIDs); err != nil {
+		return err
+	}
+	if err := checkCycle(dataTable, p.ID, componentIDs); err != nil {
+		return err
+	}
+
+	err := dataTable.DeleteRelated("product_components", dbclient.Condition{"product_id": p.ID})
+	if err != nil {
+		return err
+	}
+	for _, component := range p.Components {
+		err = dataTable.CreateRelated("product_components", &ProductComponentRelation{
+			ProductID:   p.ID,
+			ComponentID: component.ID,
+			AmountOf:    component.AmountOf,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockComponents locks the component products with given pk ids until the
+// end of the transaction, ErrInvalidComponent is returned when one of them
+// doesn't exist
+func lockComponents(dataTable dbclient.DataTable, componentIDs []uint64) error {
+	if len(componentIDs) == 0 {
+		return nil
+	}
+	var locked []Product
+	if err := dataTable.FindForUpdate(dbclient.Condition{"id IN": componentIDs}, &locked); err != nil {
+		return err
+	}
+	found := make(map[uint64]bool)
+	for _, component := range locked {
+		found[component.ID] = true
+	}
+	for _, id := range componentIDs {
+		if !found[id] {
+			return fmt.Errorf("%w: component %d doesn't exist", ErrInvalidComponent, id)
+		}
+	}
+	return nil
+}
+
+// checkUnused returns ErrComponentInUse when the product with given pk id
+// is a component of another product
+func checkUnused(dataTable dbclient.DataTable, productID uint64) error {
+	var relations []ProductComponentRelation
+	err := dataTable.FindRelated("product_components", dbclient.Condition{"component_id": productID}, &relations)
+	if err != nil {
+		return err
+	}
+	if len(relations) == 0 {
+		return nil
+	}
+	var products []string
+	for _, relation := range relations {
+		products = append(products, fmt.Sprintf("%d", relation.ProductID))
+	}
+	return fmt.Errorf("%w: product %d is a component of products %s",
+		ErrComponentInUse, productID, strings.Join(products, ", "))
+}
+
+// checkCycle returns ErrComponentCycle when the product is one of the
+// components or a part of them, the components are walked a level at a time
+func checkCycle(dataTable dbclient.DataTable, productID uint64, componentIDs []uint64) error {
+	seen := make(map[uint64]bool)
+	for level := componentIDs; len(level) > 0; {
+		for _, id := range level {
+			if id == productID {
+				return fmt.Errorf("%w: product %d contains itself", ErrComponentCycle, productID)
+			}
+			seen[id] = true
+		}
+		var relations []ProductComponentRelation
+		err := dataTable.FindRelated("product_components", dbclient.Condition{"product_id IN": level}, &relations)
+		if err != nil {
+			return err
+		}
+		level = nil
+		for _, relation := range relations {
+			if !seen[relation.ComponentID] {
+				seen[relation.ComponentID] = true
+				level = append(level, relation.ComponentID)
+			}
+		}
+	}
+	return nil
+}
+
+// componentNeed is a product met while expanding the components of a
+// top level product, quantity of it is needed for one of the top product
+type componentNeed struct {
+	top       uint64
+	productID uint64
+	quantity  int64
+	path      []uint64
+}
+
+// billOfMaterials returns the articles needed to build one of each of the
+// products as relations of the products to the articles, the components of
+// the products are expanded into their articles all the way down a level at
+// a time. The relations are in the order the articles are met.
+func billOfMaterials(tx dbclient.DataTable, productIDs []uint64) ([]ProductArticleRelation, error) {
+	var bom []ProductArticleRelation
+	type key struct{ productID, articleID uint64 }
+	index := make(map[key]int)
+
+	var level []componentNeed
+	for _, productID := range productIDs {
+		level = append(level, componentNeed{top: productID, productID: productID, quantity: 1, path: []uint64{productID}})
+	}
+	for len(level) > 0 {
+		var ids []uint64
+		added := make(map[uint64]bool)
+		for _, need := range level {
+			if !added[need.productID] {
+				added[need.productID] = true
+				ids = append(ids, need.productID)
+			}
+		}
+
+		var relations []ProductArticleRelation
+		err := tx.FindRelated("product_articles", dbclient.Condition{"product_id IN": ids}, &relations)
+		if err != nil {
+			return nil, err
+		}
+		var components []ProductComponentRelation
+		err = tx.FindRelated("product_components", dbclient.Condition{"product_id IN": ids}, &components)
+		if err != nil {
+			return nil, err
+		}
+
+		var next []componentNeed
+		for _, need := range level {
+			for _, relation := range relations {
+				if relation.ProductID != need.productID {
+					continue
+				}
+				k := key{need.top, relation.ArticleID}
+				if i, ok := index[k]; ok {
+					bom[i].AmountOf += relation.AmountOf * need.quantity
+					continue
+				}
+				index[k] = len(bom)
+				bom = append(bom, ProductArticleRelation{
+					ProductID: need.top,
+					ArticleID: relation.ArticleID,
+					AmountOf:  relation.AmountOf * need.quantity,
+				})
+			}
+			for _, component := range components {
+				if component.ProductID != need.productID {
+					continue
+				}
+				for _, id := range need.path {
+					if id == component.ComponentID {
+						return nil, fmt.Errorf("%w: product %d contains itself", ErrComponentCycle, id)
+					}
+				}
+				next = append(next, componentNeed{
+					top:       need.top,
+					productID: component.ComponentID,
+					quantity:  component.AmountOf * need.quantity,
+					path:      append(need.path[:len(need.path):len(need.path)], component.ComponentID),
+				})
+			}
+		}
+		level = next
+	}
+	return bom, nil
+}
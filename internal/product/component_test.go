@@ -0,0 +1,216 @@
+package product
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"testing"
+)
+
+// mockComponent mocks reading the product with given pk id along with its
+// articles and its component relations
+func mockComponent(dataTable *mocks.DataTable, productID uint64, articles []article.Article, components []ProductComponentRelation) {
+	dataTable.On("FindOne", dbclient.Condition{"id": productID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*Product)) = Product{ID: productID}
+	}).Return(nil).Once()
+	dataTable.On("LoadMany2Many", articleColumns,
+		"product_articles pa",
+		"articles a",
+		"a.id = pa.article_id",
+		dbclient.Condition{"pa.product_id": productID},
+		mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(5).(*[]ProductArticle)) = productArticles(articles...)
+	}).Return(nil).Once()
+	dataTable.On("FindRelated", "product_components", dbclient.Condition{"product_id": productID}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]ProductComponentRelation)) = components
+		}).Return(nil).Once()
+}
+
+func TestProduct_BillOfMaterials(t *testing.T) {
+	assert := assert.New(t)
+
+	product := &Product{
+		Articles: []article.Article{{ID: 10, Stock: 20, AmountOf: 1}},
+		Components: []Product{
+			{
+				ID:       2,
+				AmountOf: 2,
+				Articles: []article.Article{{ID: 10, Stock: 20, AmountOf: 3}, {ID: 11, Stock: 3, AmountOf: 1}},
+			},
+		},
+	}
+
+	articles := product.BillOfMaterials()
+	assert.Len(articles, 2)
+	assert.Equal(uint64(10), articles[0].ID)
+	assert.Equal(int64(7), articles[0].AmountOf)
+	assert.Equal(int64(2), articles[0].AvailableInventory)
+	assert.Equal(uint64(11), articles[1].ID)
+	assert.Equal(int64(2), articles[1].AmountOf)
+	assert.Equal(int64(1), articles[1].AvailableInventory)
+	assert.Equal(int64(3), product.Components[0].Articles[0].AmountOf, "the components are left as they are")
+
+	product.CalculateSellableInventory()
+	assert.Equal(int64(1), product.SellableInventory)
+}
+
+func TestProductService_GetByIdExpandsComponents(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := &mocks.DataTable{}
+	productService := &ProductService{DataTable: dataTable}
+
+	mockComponent(dataTable, 1, []article.Article{{ID: 10, Stock: 20, AmountOf: 1}},
+		[]ProductComponentRelation{{ProductID: 1, ComponentID: 2, AmountOf: 2}})
+	mockComponent(dataTable, 2, []article.Article{{ID: 10, Stock: 20, AmountOf: 3}, {ID: 11, Stock: 3, AmountOf: 1}}, nil)
+
+	p, err := productService.GetById(1)
+	assert.Nil(err)
+	assert.Len(p.Components, 1)
+	assert.Equal(uint64(2), p.Components[0].ID)
+	assert.Equal(int64(2), p.Components[0].AmountOf)
+	assert.Equal(int64(3), p.Components[0].SellableInventory)
+	assert.Equal(int64(1), p.SellableInventory)
+	dataTable.AssertExpectations(t)
+}
+
+func TestProductService_GetByIdRejectsCycle(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := &mocks.DataTable{}
+	productService := &ProductService{DataTable: dataTable}
+
+	mockComponent(dataTable, 1, nil, []ProductComponentRelation{{ProductID: 1, ComponentID: 2, AmountOf: 2}})
+	mockComponent(dataTable, 2, nil, []ProductComponentRelation{{ProductID: 2, ComponentID: 1, AmountOf: 1}})
+
+	_, err := productService.GetById(1)
+	assert.ErrorIs(err, ErrComponentCycle)
+}
+
+// mockComponents expects the components with given pk ids to be locked
+func mockComponents(dataTable *mocks.DataTable, ids ...uint64) {
+	dataTable.On("FindForUpdate", dbclient.Condition{"id IN": ids}, mock.Anything).Run(func(args mock.Arguments) {
+		for _, id := range ids {
+			*(args.Get(1).(*[]Product)) = append(*(args.Get(1).(*[]Product)), Product{ID: id})
+		}
+	}).Return(nil).Once()
+}
+
+func TestSyncComponents(t *testing.T) {
+	assert := assert.New(t)
+
+	t.Run("Test leaves the components when none is given", func(t *testing.T) {
+		dataTable := &mocks.DataTable{}
+		assert.Nil(syncComponents(dataTable, &Product{ID: 1}))
+		dataTable.AssertExpectations(t)
+	})
+
+	t.Run("Test rejects an amount less than 1", func(t *testing.T) {
+		dataTable := &mocks.DataTable{}
+		err := syncComponents(dataTable, &Product{ID: 1, Components: []Product{{ID: 2}}})
+		assert.ErrorIs(err, ErrInvalidComponent)
+	})
+
+	t.Run("Test rejects the product itself", func(t *testing.T) {
+		dataTable := &mocks.DataTable{}
+		mockComponents(dataTable, 1)
+		err := syncComponents(dataTable, &Product{ID: 1, Components: []Product{{ID: 1, AmountOf: 1}}})
+		assert.ErrorIs(err, ErrComponentCycle)
+	})
+
+	t.Run("Test rejects a missing component", func(t *testing.T) {
+		dataTable := &mocks.DataTable{}
+		dataTable.On("FindForUpdate", dbclient.Condition{"id IN": []uint64{2, 3}}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]Product)) = []Product{{ID: 2}}
+		}).Return(nil).Once()
+		err := syncComponents(dataTable, &Product{ID: 1, Components: []Product{{ID: 2, AmountOf: 1}, {ID: 3, AmountOf: 1}}})
+		assert.ErrorIs(err, ErrInvalidComponent)
+		assert.Contains(err.Error(), "component 3 doesn't exist")
+		dataTable.AssertNotCalled(t, "DeleteRelated", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Test rejects a cycle through the components", func(t *testing.T) {
+		dataTable := &mocks.DataTable{}
+		mockComponents(dataTable, 2)
+		dataTable.On("FindRelated", "product_components", dbclient.Condition{"product_id IN": []uint64{2}}, mock.Anything).
+			Run(func(args mock.Arguments) {
+				*(args.Get(2).(*[]ProductComponentRelation)) = []ProductComponentRelation{{ProductID: 2, ComponentID: 3, AmountOf: 1}}
+			}).Return(nil).Once()
+		dataTable.On("FindRelated", "product_components", dbclient.Condition{"product_id IN": []uint64{3}}, mock.Anything).
+			Run(func(args mock.Arguments) {
+				*(args.Get(2).(*[]ProductComponentRelation)) = []ProductComponentRelation{{ProductID: 3, ComponentID: 1, AmountOf: 4}}
+			}).Return(nil).Once()
+
+		err := syncComponents(dataTable, &Product{ID: 1, Components: []Product{{ID: 2, AmountOf: 1}}})
+		assert.ErrorIs(err, ErrComponentCycle)
+		dataTable.AssertNotCalled(t, "DeleteRelated", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Test replaces the components", func(t *testing.T) {
+		dataTable := &mocks.DataTable{}
+		mockComponents(dataTable, 2)
+		dataTable.On("FindRelated", "product_components", dbclient.Condition{"product_id IN": []uint64{2}}, mock.Anything).
+			Return(nil).Once()
+		dataTable.On("DeleteRelated", "product_components", dbclient.Condition{"product_id": uint64(1)}).
+			Return(nil).Once()
+		dataTable.On("CreateRelated", "product_components", &ProductComponentRelation{
+			ProductID: 1, ComponentID: 2, AmountOf: 3,
+		}).Return(nil).Once()
+
+		err := syncComponents(dataTable, &Product{ID: 1, Components: []Product{{ID: 2, AmountOf: 3}}})
+		assert.Nil(err)
+		dataTable.AssertExpectations(t)
+	})
+}
+
+func TestBillOfMaterials(t *testing.T) {
+	assert := assert.New(t)
+
+	tx := &mocks.DataTable{}
+	tx.On("FindRelated", "product_articles", dbclient.Condition{"product_id IN": []uint64{1, 4}}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]ProductArticleRelation)) = []ProductArticleRelation{
+				{ProductID: 1, ArticleID: 10, AmountOf: 1},
+				{ProductID: 4, ArticleID: 11, AmountOf: 5},
+			}
+		}).Return(nil).Once()
+	tx.On("FindRelated", "product_components", dbclient.Condition{"product_id IN": []uint64{1, 4}}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]ProductComponentRelation)) = []ProductComponentRelation{
+				{ProductID: 1, ComponentID: 2, AmountOf: 2},
+			}
+		}).Return(nil).Once()
+	tx.On("FindRelated", "product_articles", dbclient.Condition{"product_id IN": []uint64{2}}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]ProductArticleRelation)) = []ProductArticleRelation{
+				{ProductID: 2, ArticleID: 10, AmountOf: 3},
+				{ProductID: 2, ArticleID: 11, AmountOf: 1},
+			}
+		}).Return(nil).Once()
+	tx.On("FindRelated", "product_components", dbclient.Condition{"product_id IN": []uint64{2}}, mock.Anything).
+		Return(nil).Once()
+
+	bom, err := billOfMaterials(tx, []uint64{1, 4})
+	assert.Nil(err)
+	assert.Equal([]ProductArticleRelation{
+		{ProductID: 1, ArticleID: 10, AmountOf: 7},
+		{ProductID: 4, ArticleID: 11, AmountOf: 5},
+		{ProductID: 1, ArticleID: 11, AmountOf: 2},
+	}, bom)
+	tx.AssertExpectations(t)
+}
+
+func TestLockComponentWrites(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := &mocks.DataTable{}
+	assert.Nil(lockComponentWrites(dataTable, &Product{ID: 1}))
+	dataTable.AssertNotCalled(t, "AdvisoryLock", mock.Anything)
+
+	dataTable.On("AdvisoryLock", componentsLockKey).Return(nil).Once()
+	assert.Nil(lockComponentWrites(dataTable, &Product{ID: 1, Components: []Product{}}))
+	dataTable.AssertExpectations(t)
+}
@@ -0,0 +1,152 @@
+//go:build integration
+// +build integration
+
+package product
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/outbox"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClient returns the client of the migrated Postgres database given by
+// the TEST_DATABASE_* environment variables, the integration tests can't
+// run without it
+func testClient(t *testing.T) *dbclient.Client {
+	host := os.Getenv("TEST_DATABASE_HOST")
+	if host == "" {
+		t.Fatal("TEST_DATABASE_HOST is not set")
+	}
+	return dbclient.NewPostgresClient(
+		host,
+		os.Getenv("TEST_DATABASE_USER"),
+		os.Getenv("TEST_DATABASE_NAME"),
+		os.Getenv("TEST_DATABASE_PASS")).(*dbclient.Client)
+}
+
+func TestProduct_DecreaseStockByConcurrently(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testClient(t)
+	defer client.Close()
+	sess := *client.Session
+
+	var lastMessage uint64
+	row, err := sess.SQL().QueryRow("SELECT COALESCE(MAX(id), 0) FROM " + outbox.TableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := row.Scan(&lastMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	articles := client.NewDataCollection("articles")
+	art := article.Article{Name: t.Name(), Stock: 1000, UpdatedAt: time.Now().UTC().Add(-time.Hour)}
+	if err := articles.InsertReturning(&art); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sess.SQL().Exec("DELETE FROM stock_movements WHERE article_id = ?", art.ID)
+		sess.SQL().Exec("DELETE FROM "+outbox.TableName+" WHERE id > ?", lastMessage)
+		sess.SQL().Exec("DELETE FROM articles WHERE id = ?", art.ID)
+	})
+	articleService := &article.ArticleService{DataTable: articles}
+
+	// Every order works on the same stale snapshot of the article
+	snapshot := art
+	snapshot.AmountOf = 2
+
+	const orders = 300
+	var wg sync.WaitGroup
+	for i := 0; i < orders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			product := &Product{Articles: []article.Article{snapshot}}
+			assert.Nil(product.DecreaseStockBy(articleService, 1, article.StockChange{}))
+		}()
+	}
+	wg.Wait()
+
+	var current article.Article
+	assert.Nil(articles.FindOne(dbclient.Condition{"id": art.ID}, &current))
+	assert.Equal(int64(1000-orders*2), current.Stock)
+	assert.True(current.UpdatedAt.After(art.UpdatedAt), "the decreases set updated_at")
+
+	count, err := sess.Collection("stock_movements").Find(dbclient.Condition{"article_id": art.ID}).Count()
+	assert.Nil(err)
+	assert.Equal(uint64(orders), count)
+}
+
+func TestProductService_ComponentWritesConcurrently(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testClient(t)
+	defer client.Close()
+	sess := *client.Session
+
+	var lastMessage uint64
+	row, err := sess.SQL().QueryRow("SELECT COALESCE(MAX(id), 0) FROM " + outbox.TableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := row.Scan(&lastMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	productService := &ProductService{DataTable: client.NewDataCollection("products"), StreamTopic: "products"}
+	first, err := productService.Create(&Product{Name: t.Name() + " first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := productService.Create(&Product{Name: t.Name() + " second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sess.SQL().Exec("DELETE FROM product_components WHERE product_id IN (?, ?)", first.ID, second.ID)
+		sess.SQL().Exec("DELETE FROM products WHERE id IN (?, ?)", first.ID, second.ID)
+		sess.SQL().Exec("DELETE FROM "+outbox.TableName+" WHERE id > ?", lastMessage)
+	})
+
+	// Each product takes the other one as a component at the same time,
+	// only one of them can succeed or they would contain each other
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, pair := range [][2]*Product{{first, second}, {second, first}} {
+		i, pair := i, pair
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := *pair[0]
+			p.Articles = nil
+			p.Components = []Product{{ID: pair[1].ID, AmountOf: 1}}
+			_, errs[i] = productService.Update(&p)
+		}()
+	}
+	wg.Wait()
+	if errs[0] == nil {
+		assert.ErrorIs(errs[1], ErrComponentCycle)
+	} else {
+		assert.ErrorIs(errs[0], ErrComponentCycle)
+		assert.Nil(errs[1])
+	}
+
+	// The product used as a component can't be deleted
+	component := second
+	if errs[0] != nil {
+		component = first
+	}
+	assert.ErrorIs(productService.Delete(&Product{ID: component.ID}), ErrComponentInUse)
+
+	missing := *first
+	missing.Articles = nil
+	missing.Components = []Product{{ID: 1 << 62, AmountOf: 1}}
+	_, err = productService.Update(&missing)
+	assert.ErrorIs(err, ErrInvalidComponent)
+}
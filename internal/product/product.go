@@ -1,17 +1,52 @@
 package product
 
 import (
+	"context"
 	"github.com/unicod3/horreum/internal/article"
 	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/outbox"
 	"github.com/unicod3/horreum/pkg/streamer"
 	"sort"
 	"time"
 )
 
+const (
+	ProductCreated string = "ProductCreated"
+	ProductUpdated        = "ProductUpdated"
+	ProductDeleted        = "ProductDeleted"
+)
+
+// Snapshot is the data of the product events, Before is nil
+// for the created products and After is nil for the deleted ones
+type Snapshot struct {
+	Before *Product `json:"before"`
+	After  *Product `json:"after"`
+}
+
+// ProductID returns the pk id of the product the snapshot belongs to
+func (s Snapshot) ProductID() uint64 {
+	if s.After != nil {
+		return s.After.ID
+	}
+	if s.Before != nil {
+		return s.Before.ID
+	}
+	return 0
+}
+
+// RegisterEvents registers the schemas of the package's events to the registry
+func RegisterEvents(registry *streamer.Registry) {
+	for _, event := range []string{ProductCreated, ProductUpdated, ProductDeleted} {
+		registry.Register(event, 1, Snapshot{})
+	}
+}
+
 // ProductRepository serves as a contract over ArticleService
 type ProductRepository interface {
 	GetAll() (Products, error)
 	GetById(uint64) (*Product, error)
+	GetByIdForWarehouse(id, warehouseID uint64) (*Product, error)
+	GetIdsByArticle(articleID uint64) ([]uint64, error)
 	Create(*Product) (*Product, error)
 	Update(*Product) (*Product, error)
 	Delete(*Product) error
@@ -23,17 +58,27 @@ type Product struct {
 	CreatedAt         time.Time         `json:"created_at,omitempty" db:"created_at,omitempty"`
 	UpdatedAt         time.Time         `json:"updated_at,omitempty" db:"updated_at,omitempty"`
 	Name              string            `json:"name" db:"name"`
+	ExternalID        string            `json:"external_id,omitempty" db:"external_id,omitempty"`
 	Price             int64             `json:"price" db:"price"`
 	SellableInventory int64             `json:"sellable_inventory,omitempty" db:"-"`
+	WarehouseID       uint64            `json:"warehouse_id,omitempty" db:"-"`
+	AmountOf          int64             `json:"amount_of,omitempty" db:"-"`
 	Articles          []article.Article `json:"articles" db:"-"`
+	Components        []Product         `json:"components,omitempty" db:"-"`
 }
 
+// CalculateSellableInventory calculates how many of the product can be built
+// from the unreserved stock of the articles of its whole bill of materials,
+// the sellable inventory of its components is calculated along the way
 func (p *Product) CalculateSellableInventory() {
-	if len(p.Articles) == 0 {
+	for i := range p.Components {
+		p.Components[i].CalculateSellableInventory()
+	}
+	articles := p.BillOfMaterials()
+	if len(articles) == 0 {
 		p.SellableInventory = 0
 		return
 	}
-	articles := p.Articles
 	sort.Slice(articles, func(i, j int) bool {
 		return articles[i].AvailableInventory < articles[j].AvailableInventory
 	})
@@ -41,37 +86,50 @@ func (p *Product) CalculateSellableInventory() {
 	p.SellableInventory = minInventoryOfArticles
 }
 
-func (p *Product) IncreaseStockBy(articleService article.ArticleRepository, quantity int64) error {
-	if quantity == 0 {
-		return nil
-	}
+// IncreaseStockBy increases the stock of the product's articles by
+// the amount needed to build the given quantity of the product
+func (p *Product) IncreaseStockBy(articleService article.ArticleRepository, quantity int64, change article.StockChange) error {
+	return p.adjustStockBy(articleService, quantity, 0, change)
+}
 
-	for _, art := range p.Articles {
-		decreaseAmount := art.AmountOf * quantity
-		newStock := art.Stock + decreaseAmount
-		err := articleService.Update(&article.Article{
-			ID:    art.ID,
-			Stock: newStock,
-		})
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+// DecreaseStockBy decreases the stock of the product's articles by
+// the amount needed to build the given quantity of the product
+func (p *Product) DecreaseStockBy(articleService article.ArticleRepository, quantity int64, change article.StockChange) error {
+	return p.adjustStockBy(articleService, -quantity, 0, change)
 }
 
-func (p *Product) DecreaseStockBy(articleService article.ArticleRepository, quantity int64) error {
-	if quantity == 0 {
-		return nil
-	}
+// ConsumeStockBy decreases both the stock and the reserved stock of the
+// product's articles by the amount needed to build the given quantity
+func (p *Product) ConsumeStockBy(articleService article.ArticleRepository, quantity int64, change article.StockChange) error {
+	return p.adjustStockBy(articleService, -quantity, -quantity, change)
+}
 
-	for _, art := range p.Articles {
-		decreaseAmount := art.AmountOf * quantity
-		newStock := art.Stock - decreaseAmount
-		err := articleService.Update(&article.Article{
-			ID:    art.ID,
-			Stock: newStock,
-		})
+// ReleaseStockBy decreases the reserved stock of the product's articles
+// by the amount needed to build the given quantity of the product
+func (p *Product) ReleaseStockBy(articleService article.ArticleRepository, quantity int64, change article.StockChange) error {
+	return p.adjustStockBy(articleService, 0, -quantity, change)
+}
+
+// adjustStockBy applies the deltas, given in product quantity, to the stock
+// and the reserved stock of the articles of the product's bill of materials,
+// the change describes the movements of the stock. Articles loaded for a
+// warehouse are only adjusted in that warehouse, stock can only be reserved
+// in a warehouse.
+func (p *Product) adjustStockBy(articleService article.ArticleRepository, quantityDelta, reservedDelta int64, change article.StockChange) error {
+	for _, art := range p.BillOfMaterials() {
+		var err error
+		switch {
+		case art.WarehouseID != 0:
+			err = articleService.AdjustWarehouseStock(
+				art.ID,
+				art.WarehouseID,
+				art.AmountOf*quantityDelta,
+				art.AmountOf*reservedDelta,
+				change,
+			)
+		case quantityDelta != 0:
+			err = articleService.AdjustStock(art.ID, art.AmountOf*quantityDelta, change)
+		}
 		if err != nil {
 			return err
 		}
@@ -85,9 +143,22 @@ type ProductArticleRelation struct {
 	AmountOf  int64  `db:"amount_of"`
 }
 
+// ProductArticle represents an article of a product as it is read along
+// with the amount the product needs and the stock reserved over all the
+// warehouses, they aren't columns of the articles table
 type ProductArticle struct {
 	ProductID       uint64 `db:"product_id"`
 	article.Article `db:",inline"`
+	AmountOf        int64 `json:"-" db:"amount_of"`
+	Reserved        int64 `json:"-" db:"reserved"`
+}
+
+// toArticle returns the article with its amount and its reserved stock
+func (pa ProductArticle) toArticle() article.Article {
+	a := pa.Article
+	a.AmountOf = pa.AmountOf
+	a.Reserved = pa.Reserved
+	return a
 }
 
 // Products holds multiple Product
@@ -123,12 +194,25 @@ func (productMap ProductMap) Products() Products {
 	return products
 }
 
+// ProductQuery represents the query parameters accepted while reading products
+type ProductQuery struct {
+	WarehouseID uint64 `form:"warehouse_id"`
+}
+
 // ErrorResponse contains information about error
 type ErrorResponse struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 }
 
+// StockErrorResponse contains information about the products
+// that can't be served from the warehouse stock
+type StockErrorResponse struct {
+	Code      int             `json:"code"`
+	Message   string          `json:"message"`
+	Shortages []StockShortage `json:"shortages"`
+}
+
 // ProductRequestBody represents the data type that needs to be sent over request
 type ProductRequestBody struct {
 	Name     string `json:"name"`
@@ -138,6 +222,10 @@ type ProductRequestBody struct {
 		ProductID uint64 `json:"-"`
 		AmountOf  int64  `json:"amount_of"`
 	} `json:"articles"`
+	Components []struct {
+		ID       uint64 `json:"id"`
+		AmountOf int64  `json:"amount_of"`
+	} `json:"components,omitempty"`
 }
 
 // ProductService holds information about the datatable
@@ -146,6 +234,30 @@ type ProductService struct {
 	DataTable     dbclient.DataTable
 	StreamChannel streamer.Channel
 	StreamTopic   string
+	// correlationID is carried by the events of the service and
+	// by the stock changes of the articles it makes
+	correlationID string
+}
+
+// WithContext returns a copy of the service whose events carry
+// the correlation id of ctx, when ctx carries one
+func (service *ProductService) WithContext(ctx context.Context) *ProductService {
+	return service.WithCorrelationID(streamer.CorrelationIDFrom(ctx))
+}
+
+// WithCorrelationID returns a copy of the service whose events carry the
+// given correlation id, the events get a new one when it is empty
+func (service *ProductService) WithCorrelationID(id string) *ProductService {
+	scoped := *service
+	scoped.correlationID = id
+	return &scoped
+}
+
+// articles returns the ArticleService changing the stock of the
+// articles within tx, its events carry the correlation id of the service
+func (service *ProductService) articles(tx dbclient.DataTable) *article.ArticleService {
+	articles := &article.ArticleService{DataTable: tx.Related("articles")}
+	return articles.WithCorrelationID(service.correlationID)
 }
 
 // GetAll returns all the records
@@ -167,13 +279,43 @@ func (service *ProductService) GetAll() (Products, error) {
 	return products, nil
 }
 
+// ProductFields are the fields the products can be filtered and sorted by
+var ProductFields = dbclient.Fields{
+	"name":       {Type: dbclient.StringField},
+	"price":      {Type: dbclient.IntField},
+	"created_at": {Type: dbclient.TimeField},
+	"updated_at": {Type: dbclient.TimeField},
+}
+
+// GetPage returns the records of the page and the cursor of the next one,
+// only the articles of the products in the page are loaded
+func (service *ProductService) GetPage(query dbclient.PageQuery) (Products, string, error) {
+	var products Products
+	next, err := service.DataTable.FindPage(query, &products)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(products) == 0 {
+		return products, next, nil
+	}
+	products, err = service.populateArticles(products)
+	if err != nil {
+		return nil, "", err
+	}
+	products, err = service.populateSellableInventory(products)
+	if err != nil {
+		return nil, "", err
+	}
+	return products, next, nil
+}
+
 // GetById returns single record for given pk id
 func (service *ProductService) GetById(id uint64) (*Product, error) {
 	var product Product
 	if err := service.DataTable.FindOne(dbclient.Condition{"id": id}, &product); err != nil {
 		return nil, err
 	}
-	err := service.populateArticle(&product)
+	err := service.populateArticle(&product, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -181,45 +323,221 @@ func (service *ProductService) GetById(id uint64) (*Product, error) {
 	return &product, nil
 }
 
-// Create creates a new record on the datastore with given struct
-func (service *ProductService) Create(p *Product) (*Product, error) {
-	if err := service.DataTable.InsertReturning(p); err != nil {
+// GetByIdForWarehouse returns single record for given pk id with its
+// articles and sellable inventory calculated for the given warehouse
+func (service *ProductService) GetByIdForWarehouse(id, warehouseID uint64) (*Product, error) {
+	var product Product
+	if err := service.DataTable.FindOne(dbclient.Condition{"id": id}, &product); err != nil {
+		return nil, err
+	}
+	product.WarehouseID = warehouseID
+	err := service.populateArticle(&product, warehouseID)
+	if err != nil {
 		return nil, err
 	}
-	err := service.syncArticles(p)
+	(&product).CalculateSellableInventory()
+	return &product, nil
+}
+
+// GetIdsByArticle returns the sorted pk ids of the products the article
+// with given pk id is a part of, either directly or through a component
+func (service *ProductService) GetIdsByArticle(articleID uint64) ([]uint64, error) {
+	var relations []ProductArticleRelation
+	err := service.DataTable.FindRelated("product_articles", dbclient.Condition{"article_id": articleID}, &relations)
 	if err != nil {
 		return nil, err
 	}
-	p, _ = service.GetById(p.ID)
-	return p, nil
+	var level []uint64
+	for _, relation := range relations {
+		level = append(level, relation.ProductID)
+	}
+
+	// The products containing the ones found are found a level at a time
+	var ids []uint64
+	seen := make(map[uint64]bool)
+	for len(level) > 0 {
+		var found []uint64
+		for _, id := range level {
+			if !seen[id] {
+				seen[id] = true
+				found = append(found, id)
+			}
+		}
+		if len(found) == 0 {
+			break
+		}
+		ids = append(ids, found...)
+
+		var components []ProductComponentRelation
+		err := service.DataTable.FindRelated("product_components", dbclient.Condition{"component_id IN": found}, &components)
+		if err != nil {
+			return nil, err
+		}
+		level = nil
+		for _, component := range components {
+			level = append(level, component.ProductID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
 }
 
-// Update updates given record on the datastore by finding it with its pk
+// GetByExternalID returns the record imported with given external id
+// along with its articles
+func (service *ProductService) GetByExternalID(externalID string) (*Product, error) {
+	var product Product
+	if err := service.DataTable.FindOne(dbclient.Condition{"external_id": externalID}, &product); err != nil {
+		return nil, err
+	}
+	if err := service.populateArticle(&product, 0); err != nil {
+		return nil, err
+	}
+	product.CalculateSellableInventory()
+	return &product, nil
+}
+
+// Create creates a new record on the datastore with given struct, the product,
+// its articles, its components and its event are written in a single transaction
+func (service *ProductService) Create(p *Product) (*Product, error) {
+	var created *Product
+	err := service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		if err := lockComponentWrites(tx, p); err != nil {
+			return err
+		}
+		if err := tx.InsertReturning(p); err != nil {
+			return err
+		}
+		if err := syncArticles(tx, p); err != nil {
+			return err
+		}
+		if err := syncComponents(tx, p); err != nil {
+			return err
+		}
+		var err error
+		if created, err = service.inTx(tx).GetById(p.ID); err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, ProductCreated, nil, created)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// Update updates given record on the datastore by finding it with its pk, the
+// product, its articles, its components and its event are written in a single
+// transaction in which the product is locked
 func (service *ProductService) Update(p *Product) (*Product, error) {
 	p.UpdatedAt = time.Now().UTC()
-	if err := service.DataTable.UpdateReturning(p); err != nil {
+	var updated *Product
+	err := service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		if err := lockComponentWrites(tx, p); err != nil {
+			return err
+		}
+		before, err := service.lockProduct(tx, p.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.UpdateReturning(p); err != nil {
+			return err
+		}
+		if err := syncArticles(tx, p); err != nil {
+			return err
+		}
+		if err := syncComponents(tx, p); err != nil {
+			return err
+		}
+		if updated, err = service.inTx(tx).GetById(p.ID); err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, ProductUpdated, before, updated)
+	})
+	if err != nil {
 		return nil, err
 	}
-	err := service.syncArticles(p)
+	return updated, nil
+}
+
+// Delete deletes the given struct from database by finding it with its pk,
+// its event is stored in the same transaction. A product used as a component
+// of other products can't be deleted, ErrComponentInUse is returned for it.
+func (service *ProductService) Delete(p *Product) error {
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		before, err := service.lockProduct(tx, p.ID)
+		if err != nil {
+			return err
+		}
+		// The lock keeps the product from being added as a component meanwhile
+		if err := checkUnused(tx, p.ID); err != nil {
+			return err
+		}
+		if err := tx.Delete(dbclient.Condition{"id": p.ID}); err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, ProductDeleted, before, nil)
+	})
+}
+
+// PublishEvent writes the event with the snapshots of the product before and
+// after the change to the outbox within the transaction of tx, the
+// outbox.Relay publishes it on the StreamChannel once it is committed
+func (service *ProductService) PublishEvent(tx dbclient.DataTable, event string, before, after *Product) error {
+	snapshot := Snapshot{Before: before, After: after}
+	msg, err := streamer.NewMessage(&streamer.Message{
+		EventName:     event,
+		AggregateType: "product",
+		AggregateID:   snapshot.ProductID(),
+		CorrelationID: service.correlationID,
+		Data:          snapshot,
+	})
 	if err != nil {
+		return err
+	}
+	return outbox.Store(tx, service.StreamTopic, msg)
+}
+
+// inTx returns a copy of the service working in the transaction of tx
+func (service *ProductService) inTx(tx dbclient.DataTable) *ProductService {
+	scoped := *service
+	scoped.DataTable = tx
+	return &scoped
+}
+
+// lockProduct locks the product with given pk id until the end of the
+// transaction of tx and returns it with its articles and components
+func (service *ProductService) lockProduct(tx dbclient.DataTable, id uint64) (*Product, error) {
+	var products []Product
+	if err := tx.FindForUpdate(dbclient.Condition{"id": id}, &products); err != nil {
 		return nil, err
 	}
-	p, _ = service.GetById(p.ID)
-	return p, nil
+	if len(products) == 0 {
+		return nil, dbclient.ErrNoMoreRows
+	}
+	return service.inTx(tx).GetById(id)
 }
 
-// Delete deletes the given struct from database by finding it with its pk
-func (service *ProductService) Delete(p *Product) error {
-	if err := service.DataTable.Delete(dbclient.Condition{"id": p.ID}); err != nil {
+// articleColumns selects the articles of a product along with the amount the
+// product needs and the stock reserved for orders over all warehouses
+const articleColumns = "a.*, pa.amount_of as amount_of, COALESCE((" +
+	"SELECT SUM(ws.reserved) FROM warehouse_stock ws WHERE ws.article_id = a.id" +
+	"), 0)::bigint as reserved"
+
+// populateArticle loads the articles of the product and expands its
+// components recursively, each of them with its own articles and components
+func (service *ProductService) populateArticle(product *Product, warehouseID uint64) error {
+	if err := service.loadArticles(product, warehouseID); err != nil {
 		return err
 	}
-	return nil
+	return service.populateComponents(product, warehouseID, []uint64{product.ID})
 }
 
-func (service *ProductService) populateArticle(product *Product) error {
-	var productArticles []article.Article
+// loadArticles loads the articles the product contains directly,
+// with their stock in the warehouse unless warehouseID is zero
+func (service *ProductService) loadArticles(product *Product, warehouseID uint64) error {
+	var productArticles []ProductArticle
 	err := service.DataTable.LoadMany2Many(
-		"a.*, pa.amount_of as amount_of",
+		articleColumns,
 		"product_articles pa",
 		"articles a",
 		"a.id = pa.article_id",
@@ -229,19 +547,56 @@ func (service *ProductService) populateArticle(product *Product) error {
 	if err != nil {
 		return err
 	}
-	for i, article := range productArticles {
-		(&article).CalculateAvailableInventory()
-		productArticles[i] = article
+	var articles []article.Article
+	for _, productArticle := range productArticles {
+		articles = append(articles, productArticle.toArticle())
+	}
+	if warehouseID != 0 {
+		if err := service.loadWarehouseStock(articles, warehouseID); err != nil {
+			return err
+		}
+	}
+	for i := range articles {
+		articles[i].CalculateAvailableInventory()
 	}
 
-	product.Articles = productArticles
+	product.Articles = articles
+	return nil
+}
+
+// loadWarehouseStock reads the stock of the articles in the warehouse
+func (service *ProductService) loadWarehouseStock(articles []article.Article, warehouseID uint64) error {
+	if len(articles) == 0 {
+		return nil
+	}
+	var ids []uint64
+	for _, art := range articles {
+		ids = append(ids, art.ID)
+	}
+	var stock []article.WarehouseStock
+	err := service.DataTable.FindRelated("warehouse_stock", dbclient.Condition{
+		"warehouse_id":  warehouseID,
+		"article_id IN": ids,
+	}, &stock)
+	if err != nil {
+		return err
+	}
+	byArticle := make(map[uint64]article.WarehouseStock, len(stock))
+	for _, ws := range stock {
+		byArticle[ws.ArticleID] = ws
+	}
+	for i := range articles {
+		articles[i].WarehouseID = warehouseID
+		articles[i].WarehouseStock = byArticle[articles[i].ID].Quantity
+		articles[i].WarehouseReserved = byArticle[articles[i].ID].Reserved
+	}
 	return nil
 }
 
 func (service *ProductService) populateArticles(products Products) (Products, error) {
 	var productArticles []ProductArticle
 	err := service.DataTable.LoadMany2Many(
-		"pa.product_id as product_id, a.*, pa.amount_of as amount_of",
+		"pa.product_id as product_id, "+articleColumns,
 		"product_articles pa",
 		"articles a",
 		"a.id = pa.article_id",
@@ -255,29 +610,48 @@ func (service *ProductService) populateArticles(products Products) (Products, er
 	for _, productArticle := range productArticles {
 		product := productMap[productArticle.ProductID]
 
-		article := article.Article{
-			ID:        productArticle.ID,
-			CreatedAt: productArticle.CreatedAt,
-			UpdatedAt: productArticle.UpdatedAt,
-			Name:      productArticle.Name,
-			Stock:     productArticle.Stock,
-			AmountOf:  productArticle.AmountOf,
-		}
+		article := productArticle.toArticle()
 		article.CalculateAvailableInventory()
 		product.Articles = append(product.Articles, article)
 		productMap[productArticle.ProductID] = product
 	}
 
-	return productMap.Products(), nil
+	// The products keep their order, it is the order of the page they are in
+	for i, product := range products {
+		products[i] = productMap[product.ID]
+	}
+
+	if len(products) == 0 {
+		return products, nil
+	}
+	// Only the products containing other products are expanded further
+	var components []ProductComponentRelation
+	err = service.DataTable.FindRelated("product_components", dbclient.Condition{"product_id IN": products.IDList()}, &components)
+	if err != nil {
+		return nil, err
+	}
+	kits := make(map[uint64]bool)
+	for _, component := range components {
+		kits[component.ProductID] = true
+	}
+	for i := range products {
+		if !kits[products[i].ID] {
+			continue
+		}
+		if err := service.populateComponents(&products[i], 0, []uint64{products[i].ID}); err != nil {
+			return nil, err
+		}
+	}
+	return products, nil
 }
 
-func (service *ProductService) syncArticles(p *Product) error {
-	err := service.DataTable.DeleteRelated("product_articles", dbclient.Condition{"product_id": p.ID})
+func syncArticles(dataTable dbclient.DataTable, p *Product) error {
+	err := dataTable.DeleteRelated("product_articles", dbclient.Condition{"product_id": p.ID})
 	if err != nil {
 		return err
 	}
 	for _, article := range p.Articles {
-		err = service.DataTable.CreateRelated("product_articles", &ProductArticleRelation{
+		err = dataTable.CreateRelated("product_articles", &ProductArticleRelation{
 			ProductID: p.ID,
 			ArticleID: article.ID,
 			AmountOf:  article.AmountOf,
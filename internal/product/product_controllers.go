@@ -1,28 +1,48 @@
 package product
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/unicod3/horreum/pkg/apierror"
+	"github.com/unicod3/horreum/pkg/dbclient"
 	"net/http"
 )
 
 // ListProducts example
 // @Tags products
-// @Summary Get all products
-// @Description Get all products
+// @Summary Get a page of the products
+// @Description Get a page of the products, the Link and X-Next-Cursor headers point to the next page
 // @ID list-products
 // @Accept  json
 // @Produce  json
+// @Param limit query int false "Number of products in the page, 50 by default and 500 at most"
+// @Param cursor query string false "Cursor of the page, the X-Next-Cursor of the previous page"
+// @Param sort query string false "Comma separated fields to sort by, descending when prefixed with -"
+// @Param name~ query string false "Name contains, case insensitively"
+// @Param price_lt query int false "Price is less than, _lte, _gt and _gte are alike"
+// @Param created_after query string false "RFC3339 date the products are created after, created_before is alike"
 // @Success 200 {array} ProductArticle
+// @Header 200 {string} Link "Link to the next page"
+// @Header 200 {string} X-Next-Cursor "Cursor of the next page"
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Router /products/ [get]
 func (service *ProductService) ListProducts(g *gin.Context) {
-	products, err := service.GetAll()
+	query, err := dbclient.ParsePageQuery(g.Request.URL.Query(), ProductFields)
 	if err != nil {
-		g.JSON(http.StatusInternalServerError, ErrorResponse{
-			Code:    http.StatusInternalServerError,
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
 			Message: err.Error(),
 		})
 		return
 	}
+
+	products, next, err := service.GetPage(query)
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	dbclient.WritePageHeaders(g.Writer.Header(), g.Request.URL, next)
 	g.JSON(http.StatusOK, products)
 }
 
@@ -34,12 +54,14 @@ func (service *ProductService) ListProducts(g *gin.Context) {
 // @Accept  json
 // @Produce  json
 // @Param id path int true "Product ID"
+// @Param warehouse_id query int false "Calculate sellable inventory for the given warehouse"
 // @Success 200 {object} Product
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
 // @Router /products/{id} [get]
 func (service *ProductService) GetProduct(g *gin.Context) {
 	var product Product
+	var query ProductQuery
 
 	if err := g.ShouldBindUri(&product); err != nil {
 		g.JSON(http.StatusBadRequest, ErrorResponse{
@@ -49,12 +71,27 @@ func (service *ProductService) GetProduct(g *gin.Context) {
 		return
 	}
 
-	w, err := service.GetById(product.ID)
+	if err := g.ShouldBindQuery(&query); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the query",
+		})
+		return
+	}
+
+	var w *Product
+	var err error
+	if query.WarehouseID != 0 {
+		w, err = service.GetByIdForWarehouse(product.ID, query.WarehouseID)
+	} else {
+		w, err = service.GetById(product.ID)
+	}
 	if err != nil {
 		g.JSON(http.StatusNotFound, ErrorResponse{
 			Code:    http.StatusNotFound,
 			Message: err.Error(),
 		})
+		return
 	}
 	g.JSON(http.StatusOK, w)
 }
@@ -81,12 +118,9 @@ func (service *ProductService) CreateProduct(g *gin.Context) {
 		return
 	}
 
-	p, err := service.Create(&product)
+	p, err := service.WithContext(g.Request.Context()).Create(&product)
 	if err != nil {
-		g.JSON(http.StatusInternalServerError, ErrorResponse{
-			Code:    http.StatusInternalServerError,
-			Message: err.Error(),
-		})
+		writeError(g, err)
 		return
 	}
 
@@ -125,12 +159,9 @@ func (service *ProductService) UpdateProduct(g *gin.Context) {
 		return
 	}
 
-	p, err := service.Update(&product)
+	p, err := service.WithContext(g.Request.Context()).Update(&product)
 	if err != nil {
-		g.JSON(http.StatusInternalServerError, ErrorResponse{
-			Code:    http.StatusInternalServerError,
-			Message: err.Error(),
-		})
+		writeError(g, err)
 		return
 	}
 
@@ -140,7 +171,7 @@ func (service *ProductService) UpdateProduct(g *gin.Context) {
 // DeleteProduct example
 // @Tags products
 // @Summary Delete a product by id
-// @Description Delete a product by id
+// @Description Delete a product by id, a product used as a component of other products can't be deleted
 // @ID delete-product
 // @Accept  json
 // @Produce  json
@@ -148,6 +179,7 @@ func (service *ProductService) UpdateProduct(g *gin.Context) {
 // @Success 204 string string "NoContent"
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
 // @Router /products/{id} [delete]
 func (service *ProductService) DeleteProduct(g *gin.Context) {
 	var product Product
@@ -160,13 +192,112 @@ func (service *ProductService) DeleteProduct(g *gin.Context) {
 		return
 	}
 
-	err := service.Delete(&product)
+	err := service.WithContext(g.Request.Context()).Delete(&product)
 	if err != nil {
-		g.JSON(http.StatusInternalServerError, ErrorResponse{
-			Code:    http.StatusInternalServerError,
-			Message: err.Error(),
-		})
+		writeError(g, err)
 		return
 	}
 	g.Status(http.StatusNoContent)
 }
+
+// SellProduct example
+// @Tags products
+// @Summary Sell a product from a warehouse without an order
+// @Description Decrease the warehouse stock of the product's articles by the sold quantity and record it as a sale, the product is returned with its articles and components and its sellable inventory in the warehouse after the sale. A product without articles can't be sold
+// @ID sell-product
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Product ID"
+// @Param sale body SaleRequestBody true "Sale"
+// @Success 200 {object} Product
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} StockErrorResponse
+// @Router /products/{id}/sell [post]
+func (service *ProductService) SellProduct(g *gin.Context) {
+	var product Product
+	var body SaleRequestBody
+
+	if err := g.ShouldBindUri(&product); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the params",
+		})
+		return
+	}
+
+	if err := g.ShouldBindJSON(&body); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the body",
+		})
+		return
+	}
+
+	sold, err := service.WithContext(g.Request.Context()).Sell(body.WarehouseID, []SaleLine{{ProductID: product.ID, Quantity: body.Quantity}}, actor(g))
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	g.JSON(http.StatusOK, sold[0])
+}
+
+// SellBasket example
+// @Tags products
+// @Summary Sell a basket of products from a warehouse without an order
+// @Description Sell the products of the basket in a single transaction, either all of them are sold or none when the warehouse stock can't serve one of them
+// @ID sell-basket
+// @Accept  json
+// @Produce  json
+// @Param basket body BasketRequestBody true "Basket"
+// @Success 200 {array} Product
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} StockErrorResponse
+// @Router /products/sell [post]
+func (service *ProductService) SellBasket(g *gin.Context) {
+	var body BasketRequestBody
+
+	if err := g.ShouldBindJSON(&body); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the body",
+		})
+		return
+	}
+
+	sold, err := service.WithContext(g.Request.Context()).Sell(body.WarehouseID, body.Lines, actor(g))
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	g.JSON(http.StatusOK, sold)
+}
+
+// actor returns who makes the request from the X-Actor header
+func actor(g *gin.Context) string {
+	if actor := g.GetHeader("X-Actor"); actor != "" {
+		return actor
+	}
+	return "api"
+}
+
+// writeError writes the response matching the given service error
+var writeError = apierror.Writer(
+	apierror.Is(http.StatusBadRequest, ErrInvalidSale, ErrComponentCycle, ErrInvalidComponent),
+	apierror.Is(http.StatusConflict, ErrComponentInUse),
+	InsufficientStock,
+)
+
+// InsufficientStock answers the InsufficientStockError with its shortages
+func InsufficientStock(err error) (int, interface{}, bool) {
+	var stockErr *InsufficientStockError
+	if !errors.As(err, &stockErr) {
+		return 0, nil, false
+	}
+	return http.StatusConflict, StockErrorResponse{
+		Code:      http.StatusConflict,
+		Message:   stockErr.Error(),
+		Shortages: stockErr.Shortages,
+	}, true
+}
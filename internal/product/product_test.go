@@ -1,16 +1,77 @@
 package product
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/unicod3/horreum/internal/article"
 	articleMock "github.com/unicod3/horreum/internal/article/mocks"
 	"github.com/unicod3/horreum/pkg/dbclient"
 	"github.com/unicod3/horreum/pkg/dbclient/mocks"
-	"github.com/upper/db/v4"
+	"github.com/unicod3/horreum/pkg/outbox"
+	"github.com/unicod3/horreum/pkg/streamer"
 	"testing"
 )
 
+// productEvent is the payload of the published product events
+type productEvent struct {
+	EventName string
+	Data      struct {
+		Before *Product `json:"before"`
+		After  *Product `json:"after"`
+	}
+}
+
+// mockEvent expects a single event to be written to the outbox on the
+// products topic and returns the event it is written with
+func mockEvent(dataTable *mocks.DataTable) *productEvent {
+	var event productEvent
+	dataTable.On("CreateRelated", outbox.TableName, mock.MatchedBy(func(m *outbox.Message) bool {
+		return m.Topic == "products"
+	})).Run(func(args mock.Arguments) {
+		json.Unmarshal([]byte(args.Get(1).(*outbox.Message).Payload), &event)
+	}).Return(nil).Once()
+	return &event
+}
+
+// mockLockProduct mocks locking the product with given pk id
+// and reading it without articles and components
+func mockLockProduct(dataTable *mocks.DataTable, product Product) {
+	dataTable.On("FindForUpdate", dbclient.Condition{"id": product.ID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*[]Product)) = []Product{product}
+	}).Return(nil).Once()
+	mockGetById(dataTable, product)
+}
+
+// productArticles returns the articles as they are read for a product
+func productArticles(articles ...article.Article) []ProductArticle {
+	var read []ProductArticle
+	for _, art := range articles {
+		read = append(read, ProductArticle{Article: art, AmountOf: art.AmountOf, Reserved: art.Reserved})
+	}
+	return read
+}
+
+// mockGetById mocks reading the product with given pk id without articles
+// and components
+func mockGetById(dataTable *mocks.DataTable, product Product) {
+	dataTable.On("FindOne", dbclient.Condition{"id": product.ID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*Product)) = product
+	}).Return(nil).Once()
+	dataTable.On("LoadMany2Many",
+		articleColumns,
+		"product_articles pa",
+		"articles a",
+		"a.id = pa.article_id",
+		dbclient.Condition{"pa.product_id": product.ID},
+		mock.Anything).
+		Return(nil).Once()
+	dataTable.On("FindRelated", "product_components", dbclient.Condition{"product_id": product.ID}, mock.Anything).
+		Return(nil).Once()
+}
+
 func TestProduct_CalculateSellableInventory(t *testing.T) {
 	assert := assert.New(t)
 
@@ -67,33 +128,72 @@ func TestProduct_DecreaseStockBy(t *testing.T) {
 		}
 
 		orderQuantity := int64(2)
-		newArt1 := article.Article{
-			ID:    uint64(1),
-			Stock: art1.Stock - (art1.AmountOf * orderQuantity),
-		}
-
-		newArt2 := article.Article{
-			ID:    uint64(2),
-			Stock: art2.Stock - (art2.AmountOf * orderQuantity),
-		}
-
-		newArt3 := article.Article{
-			ID:    uint64(3),
-			Stock: art3.Stock - (art3.AmountOf * orderQuantity),
-		}
 		articleService := &articleMock.ArticleRepository{}
-		articleService.On("Update", &newArt1).Return(nil)
-		articleService.On("Update", &newArt2).Return(nil)
-		articleService.On("Update", &newArt3).Return(nil)
+		articleService.On("AdjustStock", art1.ID, -art1.AmountOf*orderQuantity, article.StockChange{}).Return(nil).Once()
+		articleService.On("AdjustStock", art2.ID, -art2.AmountOf*orderQuantity, article.StockChange{}).Return(nil).Once()
+		articleService.On("AdjustStock", art3.ID, -art3.AmountOf*orderQuantity, article.StockChange{}).Return(nil).Once()
 
-		err := product.DecreaseStockBy(articleService, orderQuantity)
-		if err != nil {
-			return
-		}
+		err := product.DecreaseStockBy(articleService, orderQuantity, article.StockChange{})
+		assert.Nil(err)
+		articleService.AssertExpectations(t)
 		assert.Equal(int64(0), product.SellableInventory)
 	})
 }
 
+func TestProduct_DecreaseStockByInWarehouse(t *testing.T) {
+	product := &Product{
+		WarehouseID: 2,
+		Articles: []article.Article{
+			{ID: 1, Stock: 10, AmountOf: 3, WarehouseID: 2, WarehouseStock: 6},
+		},
+	}
+
+	articleService := &articleMock.ArticleRepository{}
+	articleService.On("AdjustWarehouseStock", uint64(1), uint64(2), int64(-6), int64(0), article.StockChange{}).Return(nil).Once()
+
+	err := product.DecreaseStockBy(articleService, 2, article.StockChange{})
+	assert.Nil(t, err)
+	articleService.AssertExpectations(t)
+	articleService.AssertNotCalled(t, "AdjustStock", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProduct_ConsumeStockBy(t *testing.T) {
+	product := &Product{
+		WarehouseID: 2,
+		Articles: []article.Article{
+			{ID: 1, AmountOf: 3, WarehouseID: 2},
+			{ID: 2, AmountOf: 1, WarehouseID: 2},
+		},
+	}
+
+	orderID := uint64(5)
+	change := article.StockChange{Reason: article.ReasonShipment, OrderID: &orderID, Actor: article.ActorSystem}
+
+	articleService := &articleMock.ArticleRepository{}
+	articleService.On("AdjustWarehouseStock", uint64(1), uint64(2), int64(-6), int64(-6), change).Return(nil).Once()
+	articleService.On("AdjustWarehouseStock", uint64(2), uint64(2), int64(-2), int64(-2), change).Return(nil).Once()
+
+	err := product.ConsumeStockBy(articleService, 2, change)
+	assert.Nil(t, err)
+	articleService.AssertExpectations(t)
+}
+
+func TestProduct_ReleaseStockBy(t *testing.T) {
+	product := &Product{
+		WarehouseID: 2,
+		Articles: []article.Article{
+			{ID: 1, AmountOf: 3, WarehouseID: 2},
+		},
+	}
+
+	articleService := &articleMock.ArticleRepository{}
+	articleService.On("AdjustWarehouseStock", uint64(1), uint64(2), int64(0), int64(-6), article.StockChange{}).Return(nil).Once()
+
+	err := product.ReleaseStockBy(articleService, 2, article.StockChange{})
+	assert.Nil(t, err)
+	articleService.AssertExpectations(t)
+}
+
 func TestProduct_IncreaseStockBy(t *testing.T) {
 	assert := assert.New(t)
 
@@ -121,29 +221,14 @@ func TestProduct_IncreaseStockBy(t *testing.T) {
 		}
 
 		orderQuantity := int64(2)
-		newArt1 := article.Article{
-			ID:    uint64(1),
-			Stock: art1.Stock + (art1.AmountOf * orderQuantity),
-		}
-
-		newArt2 := article.Article{
-			ID:    uint64(2),
-			Stock: art2.Stock + (art2.AmountOf * orderQuantity),
-		}
-
-		newArt3 := article.Article{
-			ID:    uint64(3),
-			Stock: art3.Stock + (art3.AmountOf * orderQuantity),
-		}
 		articleService := &articleMock.ArticleRepository{}
-		articleService.On("Update", &newArt1).Return(nil)
-		articleService.On("Update", &newArt2).Return(nil)
-		articleService.On("Update", &newArt3).Return(nil)
+		articleService.On("AdjustStock", art1.ID, art1.AmountOf*orderQuantity, article.StockChange{}).Return(nil).Once()
+		articleService.On("AdjustStock", art2.ID, art2.AmountOf*orderQuantity, article.StockChange{}).Return(nil).Once()
+		articleService.On("AdjustStock", art3.ID, art3.AmountOf*orderQuantity, article.StockChange{}).Return(nil).Once()
 
-		err := product.IncreaseStockBy(articleService, orderQuantity)
-		if err != nil {
-			return
-		}
+		err := product.IncreaseStockBy(articleService, orderQuantity, article.StockChange{})
+		assert.Nil(err)
+		articleService.AssertExpectations(t)
 		assert.Equal(int64(0), product.SellableInventory)
 	})
 }
@@ -170,7 +255,7 @@ func TestProductService_GetAll(t *testing.T) {
 		w = products
 	}).Return(nil).Once()
 	var productArticles []ProductArticle
-	dataTable.On("LoadMany2Many", "pa.product_id as product_id, a.*, pa.amount_of as amount_of",
+	dataTable.On("LoadMany2Many", "pa.product_id as product_id, "+articleColumns,
 		"product_articles pa",
 		"articles a",
 		"a.id = pa.article_id",
@@ -180,6 +265,38 @@ func TestProductService_GetAll(t *testing.T) {
 	assert.Equal(products, w)
 }
 
+func TestProductService_GetPageKeepsTheOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	productService := &ProductService{
+		DataTable: &dataTable,
+	}
+
+	query := dbclient.PageQuery{Fields: ProductFields, Sort: []dbclient.SortField{{Field: "name", Desc: true}}}
+	dataTable.On("FindPage", query, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(1).(*Products) = Products{{ID: 3, Name: "c"}, {ID: 1, Name: "b"}, {ID: 2, Name: "a"}}
+	}).Return("", nil).Once()
+	dataTable.On("LoadMany2Many", "pa.product_id as product_id, "+articleColumns,
+		"product_articles pa",
+		"articles a",
+		"a.id = pa.article_id",
+		dbclient.Condition{"pa.product_id IN ": []uint64{3, 1, 2}}, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(5).(*[]ProductArticle) = []ProductArticle{
+			{ProductID: 1, Article: article.Article{ID: 10, Stock: 4}, AmountOf: 2},
+		}
+	}).Return(nil).Once()
+	dataTable.On("FindRelated", "product_components", dbclient.Condition{"product_id IN": []uint64{3, 1, 2}}, mock.Anything).
+		Return(nil).Once()
+
+	products, next, err := productService.GetPage(query)
+	assert.Nil(err)
+	assert.Empty(next)
+	assert.Equal([]uint64{3, 1, 2}, products.IDList())
+	assert.Equal(int64(2), products[1].SellableInventory)
+	dataTable.AssertExpectations(t)
+}
+
 func TestProductService_GetById(t *testing.T) {
 	assert := assert.New(t)
 
@@ -194,19 +311,66 @@ func TestProductService_GetById(t *testing.T) {
 	dataTable.On("FindOne", dbclient.Condition{"id": product.ID}, &w).Run(func(args mock.Arguments) {
 		w = product
 	}).Return(nil).Once()
-	var productArticles []article.Article
-	dataTable.On("LoadMany2Many", "a.*, pa.amount_of as amount_of",
+	var productArticles []ProductArticle
+	dataTable.On("LoadMany2Many", articleColumns,
 		"product_articles pa",
 		"articles a",
 		"a.id = pa.article_id",
 		dbclient.Condition{"pa.product_id": w.ID},
 		&productArticles).Return(nil).Once()
+	dataTable.On("FindRelated", "product_components", dbclient.Condition{"product_id": w.ID}, mock.Anything).
+		Return(nil).Once()
 	_, err := productService.GetById(product.ID)
 	assert.Nil(err)
 	assert.Equal(product, w)
 }
 
-func TestProductService_Create(t *testing.T) {
+func TestProductService_GetByIdForWarehouse(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	productService := &ProductService{
+		DataTable: &dataTable,
+	}
+
+	product := Product{ID: 1, Name: "test", Price: 1025}
+
+	dataTable.On("FindOne", dbclient.Condition{"id": product.ID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*Product)) = product
+	}).Return(nil).Once()
+	dataTable.On("LoadMany2Many", articleColumns,
+		"product_articles pa",
+		"articles a",
+		"a.id = pa.article_id",
+		dbclient.Condition{"pa.product_id": product.ID},
+		mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(5).(*[]ProductArticle)) = productArticles(
+			article.Article{ID: 1, Stock: 100, AmountOf: 2},
+			article.Article{ID: 2, Stock: 100, AmountOf: 1},
+		)
+	}).Return(nil).Once()
+	// The warehouse id is bound to the query of the stock rather than formatted into it
+	dataTable.On("FindRelated", "warehouse_stock", dbclient.Condition{
+		"warehouse_id":  uint64(3),
+		"article_id IN": []uint64{1, 2},
+	}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(2).(*[]article.WarehouseStock)) = []article.WarehouseStock{
+			{ArticleID: 1, WarehouseID: 3, Quantity: 9},
+			{ArticleID: 2, WarehouseID: 3, Quantity: 7, Reserved: 2},
+		}
+	}).Return(nil).Once()
+	dataTable.On("FindRelated", "product_components", dbclient.Condition{"product_id": product.ID}, mock.Anything).
+		Return(nil).Once()
+
+	p, err := productService.GetByIdForWarehouse(product.ID, 3)
+	assert.Nil(err)
+	assert.Equal(uint64(3), p.WarehouseID)
+	assert.Equal(int64(4), p.SellableInventory)
+	assert.Equal(int64(2), p.Articles[1].WarehouseReserved)
+	dataTable.AssertExpectations(t)
+}
+
+func TestProductService_GetIdsByArticle(t *testing.T) {
 	assert := assert.New(t)
 
 	dataTable := mocks.DataTable{}
@@ -214,12 +378,47 @@ func TestProductService_Create(t *testing.T) {
 		DataTable: &dataTable,
 	}
 
+	dataTable.On("FindRelated", "product_articles", dbclient.Condition{"article_id": uint64(2)}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]ProductArticleRelation)) = []ProductArticleRelation{
+				{ProductID: 5, ArticleID: 2, AmountOf: 1},
+				{ProductID: 3, ArticleID: 2, AmountOf: 4},
+			}
+		}).Return(nil).Once()
+	dataTable.On("FindRelated", "product_components", dbclient.Condition{"component_id IN": []uint64{5, 3}}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]ProductComponentRelation)) = []ProductComponentRelation{
+				{ProductID: 7, ComponentID: 3, AmountOf: 2},
+			}
+		}).Return(nil).Once()
+	dataTable.On("FindRelated", "product_components", dbclient.Condition{"component_id IN": []uint64{7}}, mock.Anything).
+		Return(nil).Once()
+
+	ids, err := productService.GetIdsByArticle(2)
+	assert.Nil(err)
+	assert.Equal([]uint64{3, 5, 7}, ids)
+}
+
+func TestProductService_Create(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	productService := &ProductService{
+		DataTable:   &dataTable,
+		StreamTopic: "products",
+	}
+	event := mockEvent(&dataTable)
+
 	productID := uint64(1)
 	product := Product{
 		Name:  "test",
 		Price: 1000,
 	}
 
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
 	dataTable.On("InsertReturning", &product).
 		Return(func(data interface{}) error {
 			(&product).ID = productID
@@ -227,68 +426,122 @@ func TestProductService_Create(t *testing.T) {
 		}).Once()
 	dataTable.On("DeleteRelated", "product_articles", dbclient.Condition{"product_id": productID}).
 		Return(nil).Once()
+	mockGetById(&dataTable, Product{ID: productID, Name: "test", Price: 1000})
 
-	result := Product{}
-	dataTable.On("FindOne", dbclient.Condition{"id": productID}, &result).
-		Return(func(cond db.Cond, dataAddress interface{}) error {
-			(&product).ID = productID
-			(&product).Name = product.Name
-			(&product).Price = product.Price
-			return nil
-		}).Once()
-	dataTable.On("LoadMany2Many",
-		"a.*, pa.amount_of as amount_of",
-		"product_articles pa",
-		"articles a",
-		"a.id = pa.article_id",
-		dbclient.Condition{"pa.product_id": product.ID},
-		new([]article.Article)).
-		Return(nil).Once()
 	p, err := productService.Create(&product)
 	assert.Nil(err)
-	assert.Equal(result, *p)
+	assert.Equal(Product{ID: productID, Name: "test", Price: 1000}, *p)
+	assert.Equal(ProductCreated, event.EventName)
+	assert.Nil(event.Data.Before)
+	assert.Equal("test", event.Data.After.Name)
 }
 
-func TestProductService_Update(t *testing.T) {
+func TestProductService_WithContext(t *testing.T) {
 	assert := assert.New(t)
 
 	dataTable := mocks.DataTable{}
 	productService := &ProductService{
-		DataTable: &dataTable,
+		DataTable:   &dataTable,
+		StreamTopic: "products",
+	}
+
+	var stored *outbox.Message
+	dataTable.On("CreateRelated", outbox.TableName, mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(1).(*outbox.Message)
+	}).Return(nil).Once()
+
+	ctx := streamer.WithCorrelationID(context.Background(), "request-1")
+	err := productService.WithContext(ctx).PublishEvent(&dataTable, ProductCreated, nil, &Product{ID: 1})
+	assert.Nil(err)
+	assert.Contains(stored.Metadata, `"correlation_id":"request-1"`)
+	assert.Empty(productService.correlationID, "the service itself must stay unscoped")
+}
+
+func TestProductService_CreateRollsBackOnArticleFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	tx := mocks.DataTable{}
+	productService := &ProductService{
+		DataTable:   &dataTable,
+		StreamTopic: "products",
 	}
+
 	productID := uint64(1)
 	product := Product{
-		Name:  "test",
-		Price: 10,
+		Name:       "test",
+		Price:      1000,
+		Articles:   []article.Article{{ID: 1, AmountOf: 2}, {ID: 2, AmountOf: 1}, {ID: 3, AmountOf: 1}},
+		Components: []Product{{ID: 4, AmountOf: 1}},
 	}
+	articleErr := errors.New("insert failed")
 
-	dataTable.On("UpdateReturning", &product).Return(nil).
+	var txErr error
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			txErr = fn(&tx)
+			return txErr
+		}).Once()
+	tx.On("AdvisoryLock", componentsLockKey).Return(nil).Once()
+	tx.On("InsertReturning", &product).
 		Return(func(data interface{}) error {
 			(&product).ID = productID
 			return nil
 		}).Once()
-	dataTable.On("DeleteRelated", "product_articles", dbclient.Condition{"product_id": productID}).
+	tx.On("DeleteRelated", "product_articles", dbclient.Condition{"product_id": productID}).
 		Return(nil).Once()
+	tx.On("CreateRelated", "product_articles", &ProductArticleRelation{
+		ProductID: productID, ArticleID: 1, AmountOf: 2,
+	}).Return(nil).Once()
+	tx.On("CreateRelated", "product_articles", &ProductArticleRelation{
+		ProductID: productID, ArticleID: 2, AmountOf: 1,
+	}).Return(articleErr).Once()
 
-	result := Product{}
-	dataTable.On("FindOne", dbclient.Condition{"id": productID}, &result).
-		Return(func(cond db.Cond, dataAddress interface{}) error {
-			(&product).ID = productID
-			(&product).Name = product.Name
-			(&product).Price = product.Price
-			return nil
+	p, err := productService.Create(&product)
+	assert.Nil(p)
+	assert.Equal(articleErr, err)
+	assert.Equal(articleErr, txErr, "the transaction must be rolled back")
+	tx.AssertExpectations(t)
+	// Nothing runs after the failing article, neither in the transaction nor outside of it
+	tx.AssertNumberOfCalls(t, "CreateRelated", 2)
+	tx.AssertNotCalled(t, "FindRelated", "product_components", mock.Anything, mock.Anything)
+	tx.AssertNotCalled(t, "DeleteRelated", "product_components", mock.Anything)
+	assert.Len(dataTable.Calls, 1)
+	tx.AssertNotCalled(t, "CreateRelated", outbox.TableName, mock.Anything)
+}
+
+func TestProductService_Update(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	productService := &ProductService{
+		DataTable:   &dataTable,
+		StreamTopic: "products",
+	}
+	event := mockEvent(&dataTable)
+
+	product := Product{
+		ID:    1,
+		Name:  "test",
+		Price: 10,
+	}
+
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
 		}).Once()
-	dataTable.On("LoadMany2Many",
-		"a.*, pa.amount_of as amount_of",
-		"product_articles pa",
-		"articles a",
-		"a.id = pa.article_id",
-		dbclient.Condition{"pa.product_id": product.ID},
-		new([]article.Article)).
+	mockLockProduct(&dataTable, Product{ID: 1, Name: "old", Price: 5})
+	dataTable.On("UpdateReturning", &product).Return(nil).Once()
+	dataTable.On("DeleteRelated", "product_articles", dbclient.Condition{"product_id": product.ID}).
 		Return(nil).Once()
+	mockGetById(&dataTable, Product{ID: 1, Name: "test", Price: 10})
+
 	p, err := productService.Update(&product)
 	assert.Nil(err)
-	assert.Equal(result, *p)
+	assert.Equal(Product{ID: 1, Name: "test", Price: 10}, *p)
+	assert.Equal(ProductUpdated, event.EventName)
+	assert.Equal("old", event.Data.Before.Name)
+	assert.Equal(int64(10), event.Data.After.Price)
 }
 
 func TestProductService_Delete(t *testing.T) {
@@ -296,11 +549,52 @@ func TestProductService_Delete(t *testing.T) {
 
 	dataTable := mocks.DataTable{}
 	productService := &ProductService{
-		DataTable: &dataTable,
+		DataTable:   &dataTable,
+		StreamTopic: "products",
 	}
+	event := mockEvent(&dataTable)
 
-	product := Product{ID: 1, Name: "test"}
+	product := Product{ID: 1}
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
+	mockLockProduct(&dataTable, Product{ID: 1, Name: "test"})
+	dataTable.On("FindRelated", "product_components", dbclient.Condition{"component_id": product.ID}, mock.Anything).
+		Return(nil).Once()
 	dataTable.On("Delete", dbclient.Condition{"id": product.ID}).Return(nil).Once()
 	err := productService.Delete(&product)
 	assert.Nil(err)
+	assert.Equal(ProductDeleted, event.EventName)
+	assert.Equal("test", event.Data.Before.Name)
+	assert.Nil(event.Data.After)
+}
+
+func TestProductService_DeleteRejectsComponentInUse(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	productService := &ProductService{
+		DataTable:   &dataTable,
+		StreamTopic: "products",
+	}
+
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
+	mockLockProduct(&dataTable, Product{ID: 2, Name: "leg"})
+	dataTable.On("FindRelated", "product_components", dbclient.Condition{"component_id": uint64(2)}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]ProductComponentRelation)) = []ProductComponentRelation{
+				{ProductID: 1, ComponentID: 2, AmountOf: 4},
+				{ProductID: 5, ComponentID: 2, AmountOf: 1},
+			}
+		}).Return(nil).Once()
+
+	err := productService.Delete(&Product{ID: 2})
+	assert.ErrorIs(err, ErrComponentInUse)
+	assert.Contains(err.Error(), "products 1, 5")
+	dataTable.AssertNotCalled(t, "Delete", mock.Anything)
+	dataTable.AssertNotCalled(t, "CreateRelated", outbox.TableName, mock.Anything)
 }
@@ -0,0 +1,237 @@
+package product
+
+import (
+	"fmt"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"sort"
+	"strings"
+)
+
+// StockShortage describes a product which can't be served in the requested quantity
+type StockShortage struct {
+	ProductID           uint64 `json:"product_id"`
+	Requested           int64  `json:"requested"`
+	SellableInventory   int64  `json:"sellable_inventory"`
+	LimitingArticleID   uint64 `json:"limiting_article_id,omitempty"`
+	LimitingArticleName string `json:"limiting_article_name,omitempty"`
+}
+
+// InsufficientStockError is returned when the stock of a warehouse
+// can't serve the requested product quantities
+type InsufficientStockError struct {
+	WarehouseID uint64
+	Shortages   []StockShortage
+}
+
+func (e *InsufficientStockError) Error() string {
+	var products []string
+	for _, shortage := range e.Shortages {
+		products = append(products, fmt.Sprintf("%d", shortage.ProductID))
+	}
+	return fmt.Sprintf("insufficient stock in warehouse %d for products: %s",
+		e.WarehouseID, strings.Join(products, ", "))
+}
+
+// ReserveStock reserves the warehouse stock of the articles needed to build
+// the given product quantities with their current bills of materials,
+// quantities are keyed by product id. See ReserveArticles.
+func (service *ProductService) ReserveStock(tx dbclient.DataTable, warehouseID uint64, quantities map[uint64]int64, allowBackorder bool) error {
+	bom, err := billOfMaterials(tx, sortedProductIDs(quantities))
+	if err != nil {
+		return err
+	}
+	return service.ReserveArticles(tx, warehouseID, bom, quantities, allowBackorder)
+}
+
+// BillOfMaterials returns the articles needed to build one of each of the
+// products with given pk ids, the reservations keep them so the stock they
+// reserved is released or consumed in the same amounts later on
+func (service *ProductService) BillOfMaterials(tx dbclient.DataTable, productIDs []uint64) ([]ProductArticleRelation, error) {
+	return billOfMaterials(tx, productIDs)
+}
+
+// ReserveArticles reserves the warehouse stock of the articles of the bill of
+// materials bom needed to build the given product quantities, quantities are
+// keyed by product id. The articles are locked until the end of the
+// transaction tx belongs to, so the unreserved stock can't change between
+// the check and the reservation. Unless allowBackorder is set an
+// *InsufficientStockError is returned and nothing is reserved when
+// a product can't be served.
+func (service *ProductService) ReserveArticles(tx dbclient.DataTable, warehouseID uint64, bom []ProductArticleRelation, quantities map[uint64]int64, allowBackorder bool) error {
+	articleIDs, needed, shortages, err := lockStock(tx, warehouseID, bom, quantities)
+	if err != nil {
+		return err
+	}
+	if len(shortages) > 0 && !allowBackorder {
+		return &InsufficientStockError{
+			WarehouseID: warehouseID,
+			Shortages:   shortages,
+		}
+	}
+
+	articleService := service.articles(tx)
+	for _, articleID := range articleIDs {
+		err = articleService.AdjustWarehouseStock(articleID, warehouseID, 0, needed[articleID], article.StockChange{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockStock locks the articles of the bill of materials bom needed to build
+// the given product quantities and their stock in the warehouse until the end
+// of the transaction tx belongs to, it returns the articles along with the
+// quantity needed per article and the shortages of the products the
+// unreserved stock can't serve
+func lockStock(tx dbclient.DataTable, warehouseID uint64, bom []ProductArticleRelation, quantities map[uint64]int64) ([]uint64, map[uint64]int64, []StockShortage, error) {
+	articleIDs, needed := articleQuantities(bom, quantities)
+	articles, err := lockArticles(tx, warehouseID, articleIDs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return articleIDs, needed, stockShortages(warehouseID, bom, quantities, needed, articles), nil
+}
+
+// lockArticles locks the articles with given pk ids and their stock in the
+// warehouse until the end of the transaction tx belongs to, it returns the
+// articles keyed by id with their stock in the warehouse
+func lockArticles(tx dbclient.DataTable, warehouseID uint64, articleIDs []uint64) (map[uint64]article.Article, error) {
+	articles := make(map[uint64]article.Article)
+	if len(articleIDs) == 0 {
+		return articles, nil
+	}
+	var locked []article.Article
+	err := tx.Related("articles").FindForUpdate(dbclient.Condition{"id IN": articleIDs}, &locked)
+	if err != nil {
+		return nil, err
+	}
+	var stock []article.WarehouseStock
+	err = tx.Related("warehouse_stock").FindForUpdate(dbclient.Condition{
+		"warehouse_id":  warehouseID,
+		"article_id IN": articleIDs,
+	}, &stock)
+	if err != nil {
+		return nil, err
+	}
+	for _, art := range locked {
+		art.WarehouseID = warehouseID
+		articles[art.ID] = art
+	}
+	for _, ws := range stock {
+		art := articles[ws.ArticleID]
+		art.WarehouseStock = ws.Quantity
+		art.WarehouseReserved = ws.Reserved
+		articles[ws.ArticleID] = art
+	}
+	return articles, nil
+}
+
+// stockShortages returns the shortages of the products the unreserved stock
+// of the articles can't serve in the given quantities, needed is the quantity
+// needed per article to build all of them
+func stockShortages(warehouseID uint64, bom []ProductArticleRelation, quantities, needed map[uint64]int64, articles map[uint64]article.Article) []StockShortage {
+	var shortages []StockShortage
+	for _, productID := range sortedProductIDs(quantities) {
+		var short *article.Article
+		for _, relation := range bom {
+			art := articles[relation.ArticleID]
+			if relation.ProductID == productID && needed[art.ID] > art.WarehouseStock-art.WarehouseReserved {
+				short = &art
+				break
+			}
+		}
+		product := buildProduct(productID, warehouseID, bom, articles)
+
+		if product.SellableInventory >= quantities[productID] && short == nil {
+			continue
+		}
+		shortage := StockShortage{
+			ProductID:         productID,
+			Requested:         quantities[productID],
+			SellableInventory: product.SellableInventory,
+		}
+		if product.SellableInventory < quantities[productID] && len(product.Articles) > 0 {
+			short = &product.Articles[0]
+		}
+		if short != nil {
+			shortage.LimitingArticleID = short.ID
+			shortage.LimitingArticleName = short.Name
+		}
+		shortages = append(shortages, shortage)
+	}
+	return shortages
+}
+
+// buildProduct returns the product with given pk id in the warehouse with the
+// articles of its bill of materials bom, taken from articles, and the
+// sellable inventory they give
+func buildProduct(productID, warehouseID uint64, bom []ProductArticleRelation, articles map[uint64]article.Article) Product {
+	product := Product{ID: productID, WarehouseID: warehouseID}
+	for _, relation := range bom {
+		if relation.ProductID != productID {
+			continue
+		}
+		art := articles[relation.ArticleID]
+		art.AmountOf = relation.AmountOf
+		art.CalculateAvailableInventory()
+		product.Articles = append(product.Articles, art)
+	}
+	product.CalculateSellableInventory()
+	return product
+}
+
+// ReleaseStock releases the warehouse stock reserved for the given product
+// quantities by ReserveStock, quantities are keyed by product id.
+func (service *ProductService) ReleaseStock(tx dbclient.DataTable, warehouseID uint64, quantities map[uint64]int64) error {
+	bom, err := billOfMaterials(tx, sortedProductIDs(quantities))
+	if err != nil {
+		return err
+	}
+	return service.ReleaseArticles(tx, warehouseID, bom, quantities)
+}
+
+// ReleaseArticles releases the warehouse stock of the articles of the bill of
+// materials bom reserved for the given product quantities by ReserveArticles,
+// quantities are keyed by product id.
+func (service *ProductService) ReleaseArticles(tx dbclient.DataTable, warehouseID uint64, bom []ProductArticleRelation, quantities map[uint64]int64) error {
+	articleIDs, needed := articleQuantities(bom, quantities)
+
+	articleService := service.articles(tx)
+	for _, articleID := range articleIDs {
+		err := articleService.AdjustWarehouseStock(articleID, warehouseID, 0, -needed[articleID], article.StockChange{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedProductIDs returns the product ids quantities are keyed by in order
+func sortedProductIDs(quantities map[uint64]int64) []uint64 {
+	var productIDs []uint64
+	for productID := range quantities {
+		productIDs = append(productIDs, productID)
+	}
+	sort.Slice(productIDs, func(i, j int) bool { return productIDs[i] < productIDs[j] })
+	return productIDs
+}
+
+// articleQuantities sums up the quantity needed per article to build the
+// given product quantities from the bill of materials bom, it returns the
+// article ids in the order they are met and the quantity needed per article
+func articleQuantities(bom []ProductArticleRelation, quantities map[uint64]int64) ([]uint64, map[uint64]int64) {
+	var articleIDs []uint64
+	needed := make(map[uint64]int64)
+	for _, relation := range bom {
+		if quantities[relation.ProductID] == 0 {
+			continue
+		}
+		if _, ok := needed[relation.ArticleID]; !ok {
+			articleIDs = append(articleIDs, relation.ArticleID)
+		}
+		needed[relation.ArticleID] += relation.AmountOf * quantities[relation.ProductID]
+	}
+	return articleIDs, needed
+}
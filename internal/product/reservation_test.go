@@ -0,0 +1,142 @@
+package product
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"github.com/unicod3/horreum/pkg/outbox"
+	"testing"
+)
+
+// reservationMocks prepares a transaction where product 1 needs 2 of article 1
+// and 1 of article 2, and product 2 needs 1 of article 2. Warehouse 3 keeps
+// 10 unreserved of article 1 and 4 of article 2.
+func reservationMocks() (*mocks.DataTable, *mocks.DataTable, *mocks.DataTable) {
+	tx := &mocks.DataTable{}
+	articles := &mocks.DataTable{}
+	stock := &mocks.DataTable{}
+
+	tx.On("FindRelated", "product_articles", dbclient.Condition{"product_id IN": []uint64{1, 2}}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]ProductArticleRelation)) = []ProductArticleRelation{
+				{ProductID: 1, ArticleID: 1, AmountOf: 2},
+				{ProductID: 1, ArticleID: 2, AmountOf: 1},
+				{ProductID: 2, ArticleID: 2, AmountOf: 1},
+			}
+		}).Return(nil).Once()
+	tx.On("FindRelated", "product_components", dbclient.Condition{"product_id IN": []uint64{1, 2}}, mock.Anything).
+		Return(nil).Once()
+	tx.On("Related", "articles").Return(articles)
+	tx.On("Related", "warehouse_stock").Return(stock)
+
+	articles.On("FindForUpdate", dbclient.Condition{"id IN": []uint64{1, 2}}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]article.Article)) = []article.Article{
+				{ID: 1, Name: "leg", Stock: 20},
+				{ID: 2, Name: "screw", Stock: 8},
+			}
+		}).Return(nil).Once()
+	stock.On("FindForUpdate", dbclient.Condition{"warehouse_id": uint64(3), "article_id IN": []uint64{1, 2}}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]article.WarehouseStock)) = []article.WarehouseStock{
+				{ArticleID: 1, WarehouseID: 3, Quantity: 12, Reserved: 2},
+				{ArticleID: 2, WarehouseID: 3, Quantity: 4},
+			}
+		}).Return(nil).Once()
+
+	return tx, articles, stock
+}
+
+// expectReserved expects the reserved stock of the article in warehouse 3 to be
+// increased by reserved while its quantity stays the same
+func expectReserved(articles *mocks.DataTable, art article.Article, reserved int64) {
+	cond := dbclient.Condition{"article_id": art.ID, "warehouse_id": uint64(3)}
+	articles.On("FindForUpdate", dbclient.Condition{"id": art.ID}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]article.Article)) = []article.Article{art}
+		}).Return(nil).Once()
+	articles.On("FindRelated", "warehouse_stock", cond, mock.Anything).Return(nil).Once()
+	articles.On("CreateRelated", "warehouse_stock", mock.MatchedBy(func(ws *article.WarehouseStock) bool {
+		return ws.ArticleID == art.ID && ws.WarehouseID == 3 && ws.Quantity == 0 && ws.Reserved == reserved
+	})).Return(nil).Once()
+	articles.On("CreateRelated", outbox.TableName, mock.MatchedBy(func(m *outbox.Message) bool {
+		return m.Topic == article.StockTopic
+	})).Return(nil).Once()
+}
+
+func TestProductService_ReserveStock(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, articles, _ := reservationMocks()
+	articles.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(articles)
+		})
+	expectReserved(articles, article.Article{ID: 1, Stock: 20}, 4)
+	expectReserved(articles, article.Article{ID: 2, Stock: 8}, 3)
+
+	err := (&ProductService{}).ReserveStock(tx, 3, map[uint64]int64{1: 2, 2: 1}, false)
+	assert.Nil(err)
+	articles.AssertExpectations(t)
+}
+
+func TestProductService_ReserveStockRejectsShortage(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, articles, _ := reservationMocks()
+
+	err := (&ProductService{}).ReserveStock(tx, 3, map[uint64]int64{1: 3, 2: 2}, false)
+	assert.Equal(&InsufficientStockError{
+		WarehouseID: 3,
+		Shortages: []StockShortage{
+			{ProductID: 1, Requested: 3, SellableInventory: 4, LimitingArticleID: 2, LimitingArticleName: "screw"},
+			{ProductID: 2, Requested: 2, SellableInventory: 4, LimitingArticleID: 2, LimitingArticleName: "screw"},
+		},
+	}, err)
+	articles.AssertNotCalled(t, "WithTx", mock.Anything)
+}
+
+func TestProductService_ReserveStockAllowsBackorder(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, articles, _ := reservationMocks()
+	articles.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(articles)
+		})
+	expectReserved(articles, article.Article{ID: 1, Stock: 20}, 10)
+	expectReserved(articles, article.Article{ID: 2, Stock: 8}, 6)
+
+	err := (&ProductService{}).ReserveStock(tx, 3, map[uint64]int64{1: 5, 2: 1}, true)
+	assert.Nil(err)
+	articles.AssertExpectations(t)
+}
+
+func TestProductService_ReleaseStock(t *testing.T) {
+	assert := assert.New(t)
+
+	tx := &mocks.DataTable{}
+	articles := &mocks.DataTable{}
+	tx.On("FindRelated", "product_articles", dbclient.Condition{"product_id IN": []uint64{1}}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]ProductArticleRelation)) = []ProductArticleRelation{
+				{ProductID: 1, ArticleID: 1, AmountOf: 2},
+				{ProductID: 1, ArticleID: 2, AmountOf: 1},
+			}
+		}).Return(nil).Once()
+	tx.On("FindRelated", "product_components", dbclient.Condition{"product_id IN": []uint64{1}}, mock.Anything).
+		Return(nil).Once()
+	tx.On("Related", "articles").Return(articles)
+	articles.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(articles)
+		})
+	expectReserved(articles, article.Article{ID: 1, Stock: 20}, -4)
+	expectReserved(articles, article.Article{ID: 2, Stock: 8}, -2)
+
+	err := (&ProductService{}).ReleaseStock(tx, 3, map[uint64]int64{1: 2})
+	assert.Nil(err)
+	articles.AssertExpectations(t)
+}
@@ -13,5 +13,7 @@ func (service *ProductService) RegisterHTTPRoutes(routerGroup *gin.RouterGroup)
 		products.POST("/", service.CreateProduct)
 		products.PUT("/:id", service.UpdateProduct)
 		products.DELETE("/:id", service.DeleteProduct)
+		products.POST("/sell", service.SellBasket)
+		products.POST("/:id/sell", service.SellProduct)
 	}
 }
@@ -0,0 +1,150 @@
+package product
+
+import (
+	"errors"
+	"fmt"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/pkg/dbclient"
+)
+
+// ErrInvalidSale is returned for the sales without a warehouse or a
+// product, with a quantity less than 1 or of a product without articles
+var ErrInvalidSale = errors.New("invalid sale")
+
+// SaleLine is a quantity of a product sold
+type SaleLine struct {
+	ProductID uint64 `json:"product_id"`
+	Quantity  int64  `json:"quantity"`
+}
+
+// SaleRequestBody represents the sale of a product sent over request
+type SaleRequestBody struct {
+	WarehouseID uint64 `json:"warehouse_id"`
+	Quantity    int64  `json:"quantity"`
+}
+
+// BasketRequestBody represents the sale of a basket of products sent over request
+type BasketRequestBody struct {
+	WarehouseID uint64     `json:"warehouse_id"`
+	Lines       []SaleLine `json:"lines"`
+}
+
+// Sell sells the quantities of the products of the lines from the warehouse
+// without an order. The stock of the articles in the warehouse is decreased
+// in a single transaction and recorded as movements with the sale reason.
+// The articles are locked before the unreserved stock is checked, so unless
+// it serves every line an *InsufficientStockError is returned and nothing
+// is sold. The products are returned in the order of the lines, a product
+// sold on several lines once, as GetByIdForWarehouse returns them with their
+// sellable inventory after the sale.
+func (service *ProductService) Sell(warehouseID uint64, lines []SaleLine, actor string) (Products, error) {
+	if warehouseID == 0 {
+		return nil, fmt.Errorf("%w: warehouse_id is missing", ErrInvalidSale)
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%w: no product is sold", ErrInvalidSale)
+	}
+	var productIDs []uint64
+	quantities := make(map[uint64]int64)
+	for _, line := range lines {
+		if line.ProductID == 0 {
+			return nil, fmt.Errorf("%w: product_id is missing", ErrInvalidSale)
+		}
+		if line.Quantity < 1 {
+			return nil, fmt.Errorf("%w: quantity of product %d can't be less than 1", ErrInvalidSale, line.ProductID)
+		}
+		if _, ok := quantities[line.ProductID]; !ok {
+			productIDs = append(productIDs, line.ProductID)
+		}
+		quantities[line.ProductID] += line.Quantity
+	}
+
+	var sold Products
+	err := service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		var products Products
+		if err := tx.FindRelated("products", dbclient.Condition{"id IN": productIDs}, &products); err != nil {
+			return err
+		}
+		if len(products) < len(productIDs) {
+			return dbclient.ErrNoMoreRows
+		}
+
+		bom, err := billOfMaterials(tx, productIDs)
+		if err != nil {
+			return err
+		}
+		for _, productID := range productIDs {
+			if !containsProduct(bom, productID) {
+				return fmt.Errorf("%w: product %d has no articles", ErrInvalidSale, productID)
+			}
+		}
+
+		articleIDs, needed := articleQuantities(bom, quantities)
+		locked, err := lockArticles(tx, warehouseID, articleIDs)
+		if err != nil {
+			return err
+		}
+		if shortages := stockShortages(warehouseID, bom, quantities, needed, locked); len(shortages) > 0 {
+			return &InsufficientStockError{
+				WarehouseID: warehouseID,
+				Shortages:   shortages,
+			}
+		}
+
+		// The articles of the products are loaded for the warehouse as
+		// GetByIdForWarehouse loads them once their stock is locked, so
+		// the stock they are loaded with is the one the sale decreases
+		byID := make(map[uint64]Product)
+		for _, p := range products {
+			byID[p.ID] = p
+		}
+		articles := service.articles(tx)
+		change := article.StockChange{Reason: article.ReasonSale, Actor: actor}
+		for _, productID := range productIDs {
+			p := byID[productID]
+			p.WarehouseID = warehouseID
+			if err := service.inTx(tx).populateArticle(&p, warehouseID); err != nil {
+				return err
+			}
+			if err := p.DecreaseStockBy(articles, quantities[productID], change); err != nil {
+				return err
+			}
+			sold = append(sold, p)
+		}
+		for i := range sold {
+			sold[i].decreaseArticleStock(needed)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sold, nil
+}
+
+// decreaseArticleStock decreases the stock of the articles of the product
+// and its components by the quantities sold of them, keyed by article id,
+// and calculates the sellable inventory they leave
+func (p *Product) decreaseArticleStock(sold map[uint64]int64) {
+	for i := range p.Articles {
+		art := &p.Articles[i]
+		art.Stock -= sold[art.ID]
+		art.WarehouseStock -= sold[art.ID]
+		art.CalculateAvailableInventory()
+	}
+	for i := range p.Components {
+		p.Components[i].decreaseArticleStock(sold)
+	}
+	p.CalculateSellableInventory()
+}
+
+// containsProduct reports whether the bill of materials bom
+// holds an article of the product with given pk id
+func containsProduct(bom []ProductArticleRelation, productID uint64) bool {
+	for _, relation := range bom {
+		if relation.ProductID == productID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package product
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/internal/article"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"github.com/unicod3/horreum/pkg/outbox"
+	"testing"
+)
+
+// expectProducts expects the sold products 1 and 2 to be read
+func expectProducts(tx *mocks.DataTable) {
+	tx.On("FindRelated", "products", dbclient.Condition{"id IN": []uint64{1, 2}}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(2).(*Products)) = Products{{ID: 2, Name: "bolt"}, {ID: 1, Name: "chair"}}
+		}).Return(nil).Once()
+}
+
+// expectLoaded expects the articles of the product to be loaded with
+// their stock in warehouse 3, the product has no components
+func expectLoaded(tx *mocks.DataTable, productID uint64, articles []article.Article, stock []article.WarehouseStock) {
+	tx.On("LoadMany2Many", articleColumns,
+		"product_articles pa",
+		"articles a",
+		"a.id = pa.article_id",
+		dbclient.Condition{"pa.product_id": productID},
+		mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(5).(*[]ProductArticle)) = productArticles(articles...)
+	}).Return(nil).Once()
+	var ids []uint64
+	for _, art := range articles {
+		ids = append(ids, art.ID)
+	}
+	tx.On("FindRelated", "warehouse_stock", dbclient.Condition{"warehouse_id": uint64(3), "article_id IN": ids}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]article.WarehouseStock)) = stock
+		}).Return(nil).Once()
+	tx.On("FindRelated", "product_components", dbclient.Condition{"product_id": productID}, mock.Anything).
+		Return(nil).Once()
+}
+
+// expectSold expects the quantity of the article in warehouse 3 to be
+// decreased by sold and the sale to be recorded
+func expectSold(articles *mocks.DataTable, articleID uint64, sold int64) {
+	cond := dbclient.Condition{"article_id": articleID, "warehouse_id": uint64(3)}
+	articles.On("FindForUpdate", dbclient.Condition{"id": articleID}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]article.Article)) = []article.Article{{ID: articleID}}
+		}).Return(nil).Once()
+	articles.On("FindRelated", "warehouse_stock", cond, mock.Anything).Return(nil).Once()
+	articles.On("CreateRelated", "warehouse_stock", mock.MatchedBy(func(ws *article.WarehouseStock) bool {
+		return ws.ArticleID == articleID && ws.Quantity == -sold && ws.Reserved == 0
+	})).Return(nil).Once()
+	articles.On("Increment", dbclient.Condition{"id": articleID}, map[string]int64{"stock": -sold}).Return(nil).Once()
+	articles.On("CreateRelated", "stock_movements", mock.MatchedBy(func(m *article.StockMovement) bool {
+		return m.ArticleID == articleID && m.Delta == -sold && m.Reason == article.ReasonSale && m.Actor == "till 4"
+	})).Return(nil).Once()
+	articles.On("CreateRelated", outbox.TableName, mock.MatchedBy(func(m *outbox.Message) bool {
+		return m.Topic == article.StockTopic
+	})).Return(nil).Once()
+}
+
+func TestProductService_Sell(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, articles, _ := reservationMocks()
+	tx.On("WithTx", mock.Anything).Return(func(fn func(dbclient.DataTable) error) error {
+		return fn(tx)
+	}).Once()
+	articles.On("WithTx", mock.Anything).Return(func(fn func(dbclient.DataTable) error) error {
+		return fn(articles)
+	})
+	expectProducts(tx)
+	leg := article.Article{ID: 1, Name: "leg", Stock: 20, AmountOf: 2}
+	screw := article.Article{ID: 2, Name: "screw", Stock: 8, AmountOf: 1}
+	expectLoaded(tx, 1, []article.Article{leg, screw}, []article.WarehouseStock{
+		{ArticleID: 1, WarehouseID: 3, Quantity: 12, Reserved: 2},
+		{ArticleID: 2, WarehouseID: 3, Quantity: 4},
+	})
+	expectLoaded(tx, 2, []article.Article{screw}, []article.WarehouseStock{
+		{ArticleID: 2, WarehouseID: 3, Quantity: 4},
+	})
+	// The stock is decreased product by product through DecreaseStockBy
+	expectSold(articles, 1, 4)
+	expectSold(articles, 2, 2)
+	expectSold(articles, 2, 1)
+
+	service := &ProductService{DataTable: tx}
+	sold, err := service.Sell(3, []SaleLine{
+		{ProductID: 1, Quantity: 1},
+		{ProductID: 2, Quantity: 1},
+		{ProductID: 1, Quantity: 1},
+	}, "till 4")
+	assert.Nil(err)
+	assert.Len(sold, 2)
+	assert.Equal(uint64(1), sold[0].ID)
+	assert.Equal("chair", sold[0].Name)
+	assert.Equal(uint64(3), sold[0].WarehouseID)
+	assert.Len(sold[0].Articles, 2)
+	left := make(map[string]article.Article)
+	for _, art := range sold[0].Articles {
+		left[art.Name] = art
+	}
+	assert.Equal(int64(8), left["leg"].WarehouseStock)
+	assert.Equal(int64(16), left["leg"].Stock)
+	assert.Equal(int64(3), left["leg"].AvailableInventory)
+	assert.Equal(int64(1), left["screw"].WarehouseStock, "the screws of both products are sold")
+	assert.Equal(int64(1), sold[0].SellableInventory, "the screws left limit the chairs")
+	assert.Equal(uint64(2), sold[1].ID)
+	assert.Equal("bolt", sold[1].Name)
+	assert.Equal(int64(1), sold[1].SellableInventory)
+	tx.AssertExpectations(t)
+	articles.AssertExpectations(t)
+}
+
+func TestProduct_DecreaseArticleStock(t *testing.T) {
+	assert := assert.New(t)
+
+	p := &Product{
+		WarehouseID: 3,
+		Articles:    []article.Article{{ID: 10, WarehouseID: 3, Stock: 30, WarehouseStock: 20, AmountOf: 1}},
+		Components: []Product{{
+			ID:       2,
+			AmountOf: 2,
+			Articles: []article.Article{{ID: 11, WarehouseID: 3, Stock: 9, WarehouseStock: 7, AmountOf: 1}},
+		}},
+	}
+	p.decreaseArticleStock(map[uint64]int64{10: 5, 11: 4})
+	assert.Equal(int64(15), p.Articles[0].WarehouseStock)
+	assert.Equal(int64(25), p.Articles[0].Stock)
+	assert.Equal(int64(3), p.Components[0].Articles[0].WarehouseStock, "the components keep their own articles")
+	assert.Equal(int64(3), p.Components[0].SellableInventory)
+	assert.Equal(int64(1), p.SellableInventory)
+}
+
+func TestProductService_SellRejectsShortage(t *testing.T) {
+	assert := assert.New(t)
+
+	tx, articles, _ := reservationMocks()
+	tx.On("WithTx", mock.Anything).Return(func(fn func(dbclient.DataTable) error) error {
+		return fn(tx)
+	}).Once()
+	expectProducts(tx)
+
+	service := &ProductService{DataTable: tx}
+	_, err := service.Sell(3, []SaleLine{{ProductID: 1, Quantity: 3}, {ProductID: 2, Quantity: 2}}, "till 4")
+	var stockErr *InsufficientStockError
+	assert.True(errors.As(err, &stockErr))
+	assert.Len(stockErr.Shortages, 2)
+	articles.AssertNotCalled(t, "WithTx", mock.Anything)
+}
+
+func TestProductService_SellRejectsInvalidSale(t *testing.T) {
+	assert := assert.New(t)
+
+	service := &ProductService{DataTable: &mocks.DataTable{}}
+	_, err := service.Sell(0, []SaleLine{{ProductID: 1, Quantity: 1}}, "api")
+	assert.ErrorIs(err, ErrInvalidSale)
+	_, err = service.Sell(3, nil, "api")
+	assert.ErrorIs(err, ErrInvalidSale)
+	_, err = service.Sell(3, []SaleLine{{ProductID: 1, Quantity: 0}}, "api")
+	assert.ErrorIs(err, ErrInvalidSale)
+	_, err = service.Sell(3, []SaleLine{{ProductID: 0, Quantity: 1}}, "api")
+	assert.ErrorIs(err, ErrInvalidSale)
+}
+
+func TestProductService_SellRejectsProductWithoutArticles(t *testing.T) {
+	assert := assert.New(t)
+
+	tx := &mocks.DataTable{}
+	tx.On("WithTx", mock.Anything).Return(func(fn func(dbclient.DataTable) error) error {
+		return fn(tx)
+	}).Once()
+	tx.On("FindRelated", "products", dbclient.Condition{"id IN": []uint64{1}}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(2).(*Products)) = Products{{ID: 1}}
+		}).Return(nil).Once()
+	tx.On("FindRelated", "product_articles", dbclient.Condition{"product_id IN": []uint64{1}}, mock.Anything).
+		Return(nil).Once()
+	tx.On("FindRelated", "product_components", dbclient.Condition{"product_id IN": []uint64{1}}, mock.Anything).
+		Return(nil).Once()
+
+	service := &ProductService{DataTable: tx}
+	_, err := service.Sell(3, []SaleLine{{ProductID: 1, Quantity: 1}}, "api")
+	assert.ErrorIs(err, ErrInvalidSale)
+	tx.AssertNotCalled(t, "Related", "articles")
+}
+
+func TestProductService_SellRejectsMissingProduct(t *testing.T) {
+	assert := assert.New(t)
+
+	tx := &mocks.DataTable{}
+	tx.On("WithTx", mock.Anything).Return(func(fn func(dbclient.DataTable) error) error {
+		return fn(tx)
+	}).Once()
+	tx.On("FindRelated", "products", dbclient.Condition{"id IN": []uint64{1, 2}}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(2).(*Products)) = Products{{ID: 1}}
+		}).Return(nil).Once()
+
+	service := &ProductService{DataTable: tx}
+	_, err := service.Sell(3, []SaleLine{{ProductID: 1, Quantity: 1}, {ProductID: 2, Quantity: 1}}, "api")
+	assert.ErrorIs(err, dbclient.ErrNoMoreRows)
+}
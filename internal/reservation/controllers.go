@@ -0,0 +1,166 @@
+package reservation
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/unicod3/horreum/internal/order"
+	"github.com/unicod3/horreum/internal/product"
+	"github.com/unicod3/horreum/pkg/apierror"
+	"net/http"
+	"time"
+)
+
+// GetReservation example
+// @Tags reservations
+// @Summary Get single reservation by id
+// @Description Get single reservation by id
+// @ID get-reservation
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Reservation ID"
+// @Success 200 {object} Reservation
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /reservations/{id} [get]
+func (service *ReservationService) GetReservation(g *gin.Context) {
+	var reservation Reservation
+
+	if err := g.ShouldBindUri(&reservation); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the params",
+		})
+		return
+	}
+
+	r, err := service.GetById(reservation.ID)
+	if err != nil {
+		g.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    http.StatusNotFound,
+			Message: err.Error(),
+		})
+		return
+	}
+	g.JSON(http.StatusOK, r)
+}
+
+// CreateReservation example
+// @Tags reservations
+// @Summary Hold the stock of a product for a while
+// @Description Hold the stock of a product in a warehouse for ttl seconds without decreasing it
+// @ID create-reservation
+// @Accept  json
+// @Produce  json
+// @Param reservation body RequestBody true "Reservation"
+// @Success 201 {object} Reservation
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} StockErrorResponse
+// @Router /reservations/ [post]
+func (service *ReservationService) CreateReservation(g *gin.Context) {
+	var body RequestBody
+	if err := g.ShouldBindJSON(&body); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the body",
+		})
+		return
+	}
+
+	reservation := Reservation{
+		ProductID:   body.ProductID,
+		WarehouseID: body.WarehouseID,
+		Quantity:    body.Quantity,
+	}
+	err := service.WithContext(g.Request.Context()).Create(&reservation, time.Duration(body.TTL)*time.Second)
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+
+	g.JSON(http.StatusCreated, reservation)
+}
+
+// ReleaseReservation example
+// @Tags reservations
+// @Summary Release an active reservation
+// @Description Release an active reservation, the stock it holds is available again
+// @ID release-reservation
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Reservation ID"
+// @Success 200 {object} Reservation
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /reservations/{id} [delete]
+func (service *ReservationService) ReleaseReservation(g *gin.Context) {
+	var reservation Reservation
+
+	if err := g.ShouldBindUri(&reservation); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the params",
+		})
+		return
+	}
+
+	r, err := service.WithContext(g.Request.Context()).Release(reservation.ID)
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	g.JSON(http.StatusOK, r)
+}
+
+// ConvertReservation example
+// @Tags reservations
+// @Summary Convert an active reservation into an order line
+// @Description Add the reserved product to a draft order of the same warehouse at its price, the held stock moves to the order
+// @ID convert-reservation
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Reservation ID"
+// @Param order body ConvertRequestBody true "Order"
+// @Success 200 {object} Reservation
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /reservations/{id}/convert [post]
+func (service *ReservationService) ConvertReservation(g *gin.Context) {
+	var reservation Reservation
+	var body ConvertRequestBody
+
+	if err := g.ShouldBindUri(&reservation); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the params",
+		})
+		return
+	}
+	if err := g.ShouldBindJSON(&body); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the body",
+		})
+		return
+	}
+
+	r, err := service.WithContext(g.Request.Context()).Convert(reservation.ID, body.OrderID)
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	g.JSON(http.StatusOK, r)
+}
+
+// writeError writes the response matching the given service error
+var writeError = apierror.Writer(
+	apierror.Is(http.StatusBadRequest, ErrInvalidQuantity),
+	apierror.Is(http.StatusConflict, ErrWarehouseMismatch),
+	apierror.Match(http.StatusConflict, func(err error) bool {
+		var statusErr *StatusError
+		var transitionErr *order.TransitionError
+		return errors.As(err, &statusErr) || errors.As(err, &transitionErr)
+	}),
+	product.InsufficientStock,
+)
@@ -0,0 +1,402 @@
+package reservation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/unicod3/horreum/internal/order"
+	"github.com/unicod3/horreum/internal/product"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/outbox"
+	"github.com/unicod3/horreum/pkg/streamer"
+	"time"
+)
+
+const (
+	ReservationCreated   string = "ReservationCreated"
+	ReservationReleased         = "ReservationReleased"
+	ReservationConverted        = "ReservationConverted"
+	ReservationExpired          = "ReservationExpired"
+)
+
+// RegisterEvents registers the schemas of the package's events to the registry
+func RegisterEvents(registry *streamer.Registry) {
+	for _, event := range []string{
+		ReservationCreated, ReservationReleased,
+		ReservationConverted, ReservationExpired,
+	} {
+		registry.Register(event, 1, Reservation{})
+	}
+}
+
+// DefaultTTL is used when a reservation is created without a ttl
+const DefaultTTL = 15 * time.Minute
+
+// Status represents the state of a reservation
+type Status string
+
+const (
+	StatusActive    Status = "active"
+	StatusReleased  Status = "released"
+	StatusConverted Status = "converted"
+	StatusExpired   Status = "expired"
+)
+
+var (
+	// ErrInvalidQuantity is returned when a reservation is created without a positive quantity
+	ErrInvalidQuantity = errors.New("reservation quantity should be positive")
+	// ErrWarehouseMismatch is returned when a reservation is converted into an
+	// order of another warehouse
+	ErrWarehouseMismatch = errors.New("reservation and order belong to different warehouses")
+)
+
+// StatusError is returned when a reservation is not active anymore
+type StatusError struct {
+	ReservationID uint64
+	Status        Status
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("reservation %d is %s", e.ReservationID, e.Status)
+}
+
+// ReservationRepository serves as a contract over ReservationService
+type ReservationRepository interface {
+	GetById(id uint64) (*Reservation, error)
+	Create(r *Reservation, ttl time.Duration) error
+	Release(id uint64) (*Reservation, error)
+	Convert(id, orderID uint64) (*Reservation, error)
+	ExpireStale(now time.Time) ([]Reservation, error)
+}
+
+// Inventory serves a contract to hold and release the stock of a reservation
+type Inventory interface {
+	BillOfMaterials(tx dbclient.DataTable, productIDs []uint64) ([]product.ProductArticleRelation, error)
+	ReserveArticles(tx dbclient.DataTable, warehouseID uint64, bom []product.ProductArticleRelation, quantities map[uint64]int64, allowBackorder bool) error
+	ReleaseArticles(tx dbclient.DataTable, warehouseID uint64, bom []product.ProductArticleRelation, quantities map[uint64]int64) error
+}
+
+// Reservation represents a record from reservations table
+type Reservation struct {
+	ID          uint64    `json:"id" uri:"id" db:"id,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitempty" db:"created_at,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at,omitempty" db:"updated_at,omitempty"`
+	ProductID   uint64    `json:"product_id" db:"product_id"`
+	WarehouseID uint64    `json:"warehouse_id" db:"warehouse_id"`
+	OrderID     *uint64   `json:"order_id,omitempty" db:"order_id,omitempty"`
+	Quantity    int64     `json:"quantity" db:"quantity"`
+	Status      Status    `json:"status" db:"status"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+	// Articles are the articles one of the product takes, kept when the
+	// stock is held so it is released or moved to an order in the same amounts
+	Articles order.LineArticles `json:"articles,omitempty" db:"articles"`
+}
+
+// ErrorResponse contains information about error
+type ErrorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// StockErrorResponse contains information about the products
+// that can't be served from the warehouse stock
+type StockErrorResponse struct {
+	Code      int                     `json:"code"`
+	Message   string                  `json:"message"`
+	Shortages []product.StockShortage `json:"shortages"`
+}
+
+// RequestBody represents the data type that needs to be sent over request,
+// TTL is given in seconds
+type RequestBody struct {
+	ProductID   uint64 `json:"product_id"`
+	WarehouseID uint64 `json:"warehouse_id"`
+	Quantity    int64  `json:"quantity"`
+	TTL         int64  `json:"ttl"`
+}
+
+// ConvertRequestBody represents the data type that needs to be sent over request
+type ConvertRequestBody struct {
+	OrderID uint64 `json:"order_id"`
+}
+
+// quantities returns the reserved quantity keyed by product id
+func (r *Reservation) quantities() map[uint64]int64 {
+	return map[uint64]int64{r.ProductID: r.Quantity}
+}
+
+// bom returns the bill of materials the stock of the reservation is held
+// with, the reservations held before their articles were kept are held
+// with the current one of the product
+func (service *ReservationService) bom(tx dbclient.DataTable, r *Reservation) ([]product.ProductArticleRelation, error) {
+	if len(r.Articles) == 0 {
+		return service.Inventory.BillOfMaterials(tx, []uint64{r.ProductID})
+	}
+	var bom []product.ProductArticleRelation
+	for _, art := range r.Articles {
+		bom = append(bom, product.ProductArticleRelation{
+			ProductID: r.ProductID,
+			ArticleID: art.ArticleID,
+			AmountOf:  art.AmountOf,
+		})
+	}
+	return bom, nil
+}
+
+// release releases the stock held by the reservation
+func (service *ReservationService) release(tx dbclient.DataTable, r *Reservation) error {
+	bom, err := service.bom(tx, r)
+	if err != nil {
+		return err
+	}
+	return service.Inventory.ReleaseArticles(tx, r.WarehouseID, bom, r.quantities())
+}
+
+// lineArticles returns the articles of the bill of materials bom
+func lineArticles(bom []product.ProductArticleRelation) order.LineArticles {
+	var articles order.LineArticles
+	for _, relation := range bom {
+		articles = append(articles, order.LineArticle{ArticleID: relation.ArticleID, AmountOf: relation.AmountOf})
+	}
+	return articles
+}
+
+// ReservationService holds information about the datatable
+// and implements ReservationRepository
+type ReservationService struct {
+	DataTable     dbclient.DataTable
+	Inventory     Inventory
+	StreamChannel streamer.Channel
+	StreamTopic   string
+	// correlationID is carried by the events of the service
+	correlationID string
+}
+
+// WithContext returns a copy of the service whose events, and the stock
+// changes of its Inventory, carry the correlation id of ctx, when ctx
+// carries one
+func (service *ReservationService) WithContext(ctx context.Context) *ReservationService {
+	scoped := *service
+	scoped.correlationID = streamer.CorrelationIDFrom(ctx)
+	if products, ok := scoped.Inventory.(*product.ProductService); ok {
+		scoped.Inventory = products.WithContext(ctx)
+	}
+	return &scoped
+}
+
+// GetById returns single record for given pk id
+func (service *ReservationService) GetById(id uint64) (*Reservation, error) {
+	var reservation Reservation
+	if err := service.DataTable.FindOne(dbclient.Condition{"id": id}, &reservation); err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// Create holds the stock of the reserved product in the reservation's
+// warehouse until the ttl passes, DefaultTTL is used when ttl is not positive.
+// The stock is held, the reservation and its event are written in a single transaction.
+func (service *ReservationService) Create(r *Reservation, ttl time.Duration) error {
+	if r.Quantity <= 0 {
+		return ErrInvalidQuantity
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	r.Status = StatusActive
+	r.OrderID = nil
+	r.ExpiresAt = time.Now().UTC().Add(ttl)
+
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		bom, err := service.Inventory.BillOfMaterials(tx, []uint64{r.ProductID})
+		if err != nil {
+			return err
+		}
+		err = service.Inventory.ReserveArticles(tx, r.WarehouseID, bom, r.quantities(), false)
+		if err != nil {
+			return err
+		}
+		r.Articles = lineArticles(bom)
+		if err := tx.InsertReturning(r); err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, ReservationCreated, r)
+	})
+}
+
+// Release releases the stock held by the active reservation with given pk id
+func (service *ReservationService) Release(id uint64) (*Reservation, error) {
+	var r *Reservation
+	err := service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		var err error
+		r, err = findActiveForUpdate(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := service.release(tx, r); err != nil {
+			return err
+		}
+		r.Status = StatusReleased
+		r.UpdatedAt = time.Now().UTC()
+		if err := tx.UpdateReturning(r); err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, ReservationReleased, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Convert turns the active reservation with given pk id into a line of the
+// draft order with given orderID at the price of the product. The stock held
+// by the reservation moves to the line in the same transaction, the line
+// keeps the articles of the reservation so it isn't reserved again when
+// the order is confirmed.
+func (service *ReservationService) Convert(id, orderID uint64) (*Reservation, error) {
+	var r *Reservation
+	err := service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		var err error
+		r, err = findActiveForUpdate(tx, id)
+		if err != nil {
+			return err
+		}
+		// The sweeper may not have expired it yet
+		if !r.ExpiresAt.After(time.Now().UTC()) {
+			return &StatusError{ReservationID: id, Status: StatusExpired}
+		}
+
+		var orders []order.Order
+		if err := tx.Related("orders").FindForUpdate(dbclient.Condition{"id": orderID}, &orders); err != nil {
+			return err
+		}
+		if len(orders) == 0 {
+			return dbclient.ErrNoMoreRows
+		}
+		o := orders[0]
+		if o.Status != order.StatusDraft {
+			return &order.TransitionError{OrderID: o.ID, Action: "add lines", Status: o.Status}
+		}
+		if o.WarehouseID != r.WarehouseID {
+			return ErrWarehouseMismatch
+		}
+
+		var p product.Product
+		if err := tx.Related("products").FindOne(dbclient.Condition{"id": r.ProductID}, &p); err != nil {
+			return err
+		}
+		bom, err := service.bom(tx, r)
+		if err != nil {
+			return err
+		}
+		err = tx.CreateRelated("order_lines", &order.OrderLine{
+			OrderID:   o.ID,
+			ProductID: r.ProductID,
+			Quantity:  uint64(r.Quantity),
+			UnitCost:  uint64(p.Price),
+			Articles:  lineArticles(bom),
+		})
+		if err != nil {
+			return err
+		}
+
+		r.Status = StatusConverted
+		r.OrderID = &o.ID
+		r.UpdatedAt = time.Now().UTC()
+		if err := tx.UpdateReturning(r); err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, ReservationConverted, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ExpireStale expires the active reservations whose expiry is before now,
+// releases the stock they hold and publishes a ReservationExpired event for
+// each, the reservations and their events are written in a single transaction
+func (service *ReservationService) ExpireStale(now time.Time) ([]Reservation, error) {
+	var reservations []Reservation
+	err := service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		err := tx.FindForUpdate(dbclient.Condition{
+			"status":       StatusActive,
+			"expires_at <": now,
+		}, &reservations)
+		if err != nil {
+			return err
+		}
+		for i := range reservations {
+			r := &reservations[i]
+			if err := service.release(tx, r); err != nil {
+				return err
+			}
+			r.Status = StatusExpired
+			r.UpdatedAt = now
+			if err := tx.UpdateReturning(r); err != nil {
+				return err
+			}
+			if err := service.PublishEvent(tx, ReservationExpired, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// Sweep expires stale reservations every interval until ctx is done
+func (service *ReservationService) Sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := service.ExpireStale(time.Now().UTC()); err != nil {
+				fmt.Println("Error: couldn't expire reservations: ", err.Error())
+			}
+		}
+	}
+}
+
+// PublishEvent writes the event to the outbox within the transaction of tx,
+// the outbox.Relay publishes it on the StreamChannel once it is committed
+func (service *ReservationService) PublishEvent(tx dbclient.DataTable, event string, reservation *Reservation) error {
+	msg, err := streamer.NewMessage(&streamer.Message{
+		EventName:     event,
+		AggregateType: "reservation",
+		AggregateID:   reservation.ID,
+		CorrelationID: service.correlationID,
+		Data:          reservation,
+	})
+	if err != nil {
+		return err
+	}
+	return outbox.Store(tx, service.StreamTopic, msg)
+}
+
+// findActiveForUpdate returns the reservation with given pk id and locks it
+// until the end of the transaction, a *StatusError is returned when the
+// reservation is not active
+func findActiveForUpdate(tx dbclient.DataTable, id uint64) (*Reservation, error) {
+	var reservations []Reservation
+	if err := tx.FindForUpdate(dbclient.Condition{"id": id}, &reservations); err != nil {
+		return nil, err
+	}
+	if len(reservations) == 0 {
+		return nil, dbclient.ErrNoMoreRows
+	}
+	r := &reservations[0]
+	if r.Status != StatusActive {
+		return nil, &StatusError{ReservationID: id, Status: r.Status}
+	}
+	return r, nil
+}
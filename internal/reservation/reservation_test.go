@@ -0,0 +1,349 @@
+package reservation
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/internal/order"
+	"github.com/unicod3/horreum/internal/product"
+	reservationMocks "github.com/unicod3/horreum/internal/reservation/mocks"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"github.com/unicod3/horreum/pkg/outbox"
+	"github.com/unicod3/horreum/pkg/streamer"
+	"testing"
+	"time"
+)
+
+func TestReservationServiceImplementsReservationRepositoryInterface(t *testing.T) {
+	assert := assert.New(t)
+	assert.Implements((*ReservationRepository)(nil), new(ReservationService))
+}
+
+func TestProductServiceImplementsInventoryInterface(t *testing.T) {
+	assert := assert.New(t)
+	assert.Implements((*Inventory)(nil), new(product.ProductService))
+}
+
+// newReservationService returns a ReservationService whose transactions run on dataTable
+func newReservationService(dataTable *mocks.DataTable, inventory *reservationMocks.Inventory) *ReservationService {
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(dataTable)
+		}).Once()
+	return &ReservationService{
+		DataTable:     dataTable,
+		Inventory:     inventory,
+		StreamTopic:   "reservations",
+		StreamChannel: streamer.NewChannel(),
+	}
+}
+
+// mockOutbox expects an event to be written to the outbox
+// and returns the message the event is written with
+func mockOutbox(dataTable *mocks.DataTable) *streamer.Message {
+	var message streamer.Message
+	dataTable.On("CreateRelated", outbox.TableName, mock.Anything).Run(func(args mock.Arguments) {
+		m := args.Get(1).(*outbox.Message)
+		json.Unmarshal([]byte(m.Payload), &message)
+	}).Return(nil).Once()
+	return &message
+}
+
+// bom is the bill of materials of the product 1 in the tests
+var bom = []product.ProductArticleRelation{{ProductID: 1, ArticleID: 7, AmountOf: 4}}
+
+// expectLocked expects the reservation with given id to be locked and returns it as r
+func expectLocked(dataTable *mocks.DataTable, r Reservation) {
+	dataTable.On("FindForUpdate", dbclient.Condition{"id": r.ID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*[]Reservation)) = []Reservation{r}
+	}).Return(nil).Once()
+}
+
+func TestReservationService_Create(t *testing.T) {
+	t.Run("Test can hold the stock", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		inventory := reservationMocks.Inventory{}
+		reservationService := newReservationService(&dataTable, &inventory)
+
+		r := Reservation{ProductID: 1, WarehouseID: 3, Quantity: 2}
+		inventory.On("BillOfMaterials", &dataTable, []uint64{1}).Return(bom, nil).Once()
+		inventory.On("ReserveArticles", &dataTable, uint64(3), bom, map[uint64]int64{1: 2}, false).Return(nil).Once()
+		dataTable.On("InsertReturning", &r).Return(nil).Once()
+		message := mockOutbox(&dataTable)
+
+		before := time.Now().UTC()
+		err := reservationService.Create(&r, time.Minute)
+		assert.Nil(err)
+		assert.Equal(ReservationCreated, message.EventName)
+		dataTable.AssertExpectations(t)
+		assert.Equal(StatusActive, r.Status)
+		assert.Equal(order.LineArticles{{ArticleID: 7, AmountOf: 4}}, r.Articles)
+		assert.WithinDuration(before.Add(time.Minute), r.ExpiresAt, time.Second)
+		inventory.AssertExpectations(t)
+	})
+
+	t.Run("Test can use the default ttl", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		inventory := reservationMocks.Inventory{}
+		reservationService := newReservationService(&dataTable, &inventory)
+
+		r := Reservation{ProductID: 1, WarehouseID: 3, Quantity: 2}
+		inventory.On("BillOfMaterials", &dataTable, []uint64{1}).Return(bom, nil).Once()
+		inventory.On("ReserveArticles", &dataTable, uint64(3), bom, map[uint64]int64{1: 2}, false).Return(nil).Once()
+		dataTable.On("InsertReturning", &r).Return(nil).Once()
+		mockOutbox(&dataTable)
+
+		before := time.Now().UTC()
+		err := reservationService.Create(&r, 0)
+		assert.Nil(err)
+		assert.WithinDuration(before.Add(DefaultTTL), r.ExpiresAt, time.Second)
+	})
+
+	t.Run("Test can reject insufficient stock", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		inventory := reservationMocks.Inventory{}
+		reservationService := newReservationService(&dataTable, &inventory)
+
+		r := Reservation{ProductID: 1, WarehouseID: 3, Quantity: 5}
+		stockErr := &product.InsufficientStockError{WarehouseID: 3}
+		inventory.On("BillOfMaterials", &dataTable, []uint64{1}).Return(bom, nil).Once()
+		inventory.On("ReserveArticles", &dataTable, uint64(3), bom, map[uint64]int64{1: 5}, false).Return(stockErr).Once()
+
+		err := reservationService.Create(&r, time.Minute)
+		assert.Equal(stockErr, err)
+		dataTable.AssertNotCalled(t, "InsertReturning", mock.Anything)
+		dataTable.AssertNotCalled(t, "CreateRelated", outbox.TableName, mock.Anything)
+	})
+
+	t.Run("Test can reject invalid quantity", func(t *testing.T) {
+		err := (&ReservationService{}).Create(&Reservation{ProductID: 1}, time.Minute)
+		assert.Equal(t, ErrInvalidQuantity, err)
+	})
+}
+
+func TestReservationService_WithContext(t *testing.T) {
+	assert := assert.New(t)
+
+	products := &product.ProductService{}
+	reservationService := &ReservationService{
+		Inventory:   products,
+		StreamTopic: "reservations",
+	}
+	dataTable := mocks.DataTable{}
+	var stored *outbox.Message
+	dataTable.On("CreateRelated", outbox.TableName, mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(1).(*outbox.Message)
+	}).Return(nil).Once()
+
+	ctx := streamer.WithCorrelationID(context.Background(), "request-1")
+	scoped := reservationService.WithContext(ctx)
+	assert.Nil(scoped.PublishEvent(&dataTable, ReservationCreated, &Reservation{ID: 1}))
+	assert.Contains(stored.Metadata, `"correlation_id":"request-1"`)
+	assert.IsType(&product.ProductService{}, scoped.Inventory)
+	assert.NotSame(products, scoped.Inventory, "the stock changes must carry the id of the request as well")
+	assert.Same(products, reservationService.Inventory, "the service itself must stay unscoped")
+}
+
+func TestReservationService_Release(t *testing.T) {
+	t.Run("Test can release the stock", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		inventory := reservationMocks.Inventory{}
+		reservationService := newReservationService(&dataTable, &inventory)
+
+		expectLocked(&dataTable, Reservation{
+			ID: 1, ProductID: 1, WarehouseID: 3, Quantity: 2, Status: StatusActive,
+			Articles: order.LineArticles{{ArticleID: 7, AmountOf: 4}},
+		})
+		inventory.On("ReleaseArticles", &dataTable, uint64(3), bom, map[uint64]int64{1: 2}).Return(nil).Once()
+		dataTable.On("UpdateReturning", mock.Anything).Return(nil).Once()
+		message := mockOutbox(&dataTable)
+
+		r, err := reservationService.Release(1)
+		assert.Nil(err)
+		assert.Equal(StatusReleased, r.Status)
+		assert.Equal(ReservationReleased, message.EventName)
+		inventory.AssertExpectations(t)
+		inventory.AssertNotCalled(t, "BillOfMaterials", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Test can release the stock with the current articles", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		inventory := reservationMocks.Inventory{}
+		reservationService := newReservationService(&dataTable, &inventory)
+
+		expectLocked(&dataTable, Reservation{ID: 1, ProductID: 1, WarehouseID: 3, Quantity: 2, Status: StatusActive})
+		inventory.On("BillOfMaterials", &dataTable, []uint64{1}).Return(bom, nil).Once()
+		inventory.On("ReleaseArticles", &dataTable, uint64(3), bom, map[uint64]int64{1: 2}).Return(nil).Once()
+		dataTable.On("UpdateReturning", mock.Anything).Return(nil).Once()
+		mockOutbox(&dataTable)
+
+		_, err := reservationService.Release(1)
+		assert.Nil(err)
+		inventory.AssertExpectations(t)
+	})
+
+	t.Run("Test can reject inactive reservation", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		inventory := reservationMocks.Inventory{}
+		reservationService := newReservationService(&dataTable, &inventory)
+
+		expectLocked(&dataTable, Reservation{ID: 1, Status: StatusConverted})
+
+		r, err := reservationService.Release(1)
+		assert.Nil(r)
+		assert.Equal(&StatusError{ReservationID: 1, Status: StatusConverted}, err)
+		inventory.AssertNotCalled(t, "ReleaseArticles", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestReservationService_Convert(t *testing.T) {
+	active := Reservation{
+		ID:          1,
+		ProductID:   1,
+		WarehouseID: 3,
+		Quantity:    2,
+		Status:      StatusActive,
+		ExpiresAt:   time.Now().UTC().Add(time.Minute),
+		Articles:    order.LineArticles{{ArticleID: 7, AmountOf: 4}},
+	}
+
+	t.Run("Test can convert into an order line", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		orders := mocks.DataTable{}
+		inventory := reservationMocks.Inventory{}
+		reservationService := newReservationService(&dataTable, &inventory)
+
+		expectLocked(&dataTable, active)
+		dataTable.On("Related", "orders").Return(&orders)
+		orders.On("FindForUpdate", dbclient.Condition{"id": uint64(5)}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]order.Order)) = []order.Order{{ID: 5, WarehouseID: 3, Status: order.StatusDraft}}
+		}).Return(nil).Once()
+		products := mocks.DataTable{}
+		dataTable.On("Related", "products").Return(&products)
+		products.On("FindOne", dbclient.Condition{"id": uint64(1)}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(1).(*product.Product)) = product.Product{ID: 1, Price: 250}
+		}).Return(nil).Once()
+		dataTable.On("CreateRelated", "order_lines", &order.OrderLine{
+			OrderID:   5,
+			ProductID: 1,
+			Quantity:  2,
+			UnitCost:  250,
+			Articles:  order.LineArticles{{ArticleID: 7, AmountOf: 4}},
+		}).Return(nil).Once()
+		dataTable.On("UpdateReturning", mock.Anything).Return(nil).Once()
+		message := mockOutbox(&dataTable)
+
+		r, err := reservationService.Convert(1, 5)
+		assert.Nil(err)
+		assert.Equal(StatusConverted, r.Status)
+		assert.Equal(ReservationConverted, message.EventName)
+		assert.Equal(uint64(5), *r.OrderID)
+		dataTable.AssertExpectations(t)
+		inventory.AssertNotCalled(t, "ReleaseArticles", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Test can reject confirmed order", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		orders := mocks.DataTable{}
+		inventory := reservationMocks.Inventory{}
+		reservationService := newReservationService(&dataTable, &inventory)
+
+		expectLocked(&dataTable, active)
+		dataTable.On("Related", "orders").Return(&orders)
+		orders.On("FindForUpdate", dbclient.Condition{"id": uint64(5)}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]order.Order)) = []order.Order{{ID: 5, WarehouseID: 3, Status: order.StatusConfirmed}}
+		}).Return(nil).Once()
+
+		r, err := reservationService.Convert(1, 5)
+		assert.Nil(r)
+		assert.Equal(&order.TransitionError{OrderID: 5, Action: "add lines", Status: order.StatusConfirmed}, err)
+		inventory.AssertNotCalled(t, "ReleaseArticles", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Test can reject order of another warehouse", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		orders := mocks.DataTable{}
+		inventory := reservationMocks.Inventory{}
+		reservationService := newReservationService(&dataTable, &inventory)
+
+		expectLocked(&dataTable, active)
+		dataTable.On("Related", "orders").Return(&orders)
+		orders.On("FindForUpdate", dbclient.Condition{"id": uint64(5)}, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]order.Order)) = []order.Order{{ID: 5, WarehouseID: 4, Status: order.StatusDraft}}
+		}).Return(nil).Once()
+
+		r, err := reservationService.Convert(1, 5)
+		assert.Nil(r)
+		assert.Equal(ErrWarehouseMismatch, err)
+	})
+
+	t.Run("Test can reject expired reservation", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		inventory := reservationMocks.Inventory{}
+		reservationService := newReservationService(&dataTable, &inventory)
+
+		expired := active
+		expired.ExpiresAt = time.Now().UTC().Add(-time.Minute)
+		expectLocked(&dataTable, expired)
+
+		r, err := reservationService.Convert(1, 5)
+		assert.Nil(r)
+		assert.Equal(&StatusError{ReservationID: 1, Status: StatusExpired}, err)
+	})
+}
+
+func TestReservationService_ExpireStale(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	inventory := reservationMocks.Inventory{}
+	reservationService := newReservationService(&dataTable, &inventory)
+
+	now := time.Now().UTC()
+	dataTable.On("FindForUpdate", dbclient.Condition{"status": StatusActive, "expires_at <": now}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(1).(*[]Reservation)) = []Reservation{
+				{ID: 1, ProductID: 1, WarehouseID: 3, Quantity: 2, Status: StatusActive, Articles: order.LineArticles{{ArticleID: 7, AmountOf: 4}}},
+				{ID: 2, ProductID: 2, WarehouseID: 4, Quantity: 1, Status: StatusActive},
+			}
+		}).Return(nil).Once()
+	secondBom := []product.ProductArticleRelation{{ProductID: 2, ArticleID: 8, AmountOf: 1}}
+	inventory.On("BillOfMaterials", &dataTable, []uint64{2}).Return(secondBom, nil).Once()
+	inventory.On("ReleaseArticles", &dataTable, uint64(3), bom, map[uint64]int64{1: 2}).Return(nil).Once()
+	inventory.On("ReleaseArticles", &dataTable, uint64(4), secondBom, map[uint64]int64{2: 1}).Return(nil).Once()
+	dataTable.On("UpdateReturning", mock.Anything).Return(nil).Twice()
+	first, second := mockOutbox(&dataTable), mockOutbox(&dataTable)
+
+	expired, err := reservationService.ExpireStale(now)
+	assert.Nil(err)
+	assert.Len(expired, 2)
+	for _, r := range expired {
+		assert.Equal(StatusExpired, r.Status)
+	}
+	inventory.AssertExpectations(t)
+	dataTable.AssertExpectations(t)
+	assert.Equal(ReservationExpired, first.EventName)
+	assert.Equal(ReservationExpired, second.EventName)
+}
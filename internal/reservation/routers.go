@@ -0,0 +1,16 @@
+package reservation
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterHTTPRoutes registers the package's routes to the gin router
+func (service *ReservationService) RegisterHTTPRoutes(routerGroup *gin.RouterGroup) {
+	reservations := routerGroup.Group("reservations")
+	{
+		reservations.GET("/:id", service.GetReservation)
+		reservations.POST("/", service.CreateReservation)
+		reservations.DELETE("/:id", service.ReleaseReservation)
+		reservations.POST("/:id/convert", service.ConvertReservation)
+	}
+}
@@ -2,23 +2,45 @@ package warehouse
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/unicod3/horreum/pkg/apierror"
+	"github.com/unicod3/horreum/pkg/dbclient"
 	"net/http"
 )
 
 // ListWarehouses example
 // @Tags warehouses
-// @Summary Get all warehouses
-// @Description Get all warehouses
+// @Summary Get a page of the warehouses
+// @Description Get a page of the warehouses, the Link and X-Next-Cursor headers point to the next page
 // @ID list-warehouse
 // @Accept  json
 // @Produce  json
+// @Param limit query int false "Number of warehouses in the page, 50 by default and 500 at most"
+// @Param cursor query string false "Cursor of the page, the X-Next-Cursor of the previous page"
+// @Param sort query string false "Comma separated fields to sort by, descending when prefixed with -"
+// @Param name~ query string false "Name contains, case insensitively"
+// @Param created_after query string false "RFC3339 date the warehouses are created after, created_before is alike"
 // @Success 200 {array} Warehouse
+// @Header 200 {string} Link "Link to the next page"
+// @Header 200 {string} X-Next-Cursor "Cursor of the next page"
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Router /warehouses/ [get]
 func (service *WarehouseService) ListWarehouses(g *gin.Context) {
-	warehouses, err := service.GetAll()
+	query, err := dbclient.ParsePageQuery(g.Request.URL.Query(), WarehouseFields)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	warehouses, next, err := service.GetPage(query)
 	if err != nil {
+		writeError(g, err)
 		return
 	}
+	dbclient.WritePageHeaders(g.Writer.Header(), g.Request.URL, next)
 	g.JSON(http.StatusOK, warehouses)
 }
 
@@ -76,7 +98,7 @@ func (service *WarehouseService) CreateWarehouse(g *gin.Context) {
 		return
 	}
 
-	err := service.Create(&warehouse)
+	err := service.WithContext(g.Request.Context()).Create(&warehouse)
 	if err != nil {
 		g.JSON(http.StatusInternalServerError, ErrorResponse{
 			Code:    http.StatusInternalServerError,
@@ -120,12 +142,9 @@ func (service *WarehouseService) UpdateWarehouse(g *gin.Context) {
 		return
 	}
 
-	err := service.Update(&warehouse)
+	err := service.WithContext(g.Request.Context()).Update(&warehouse)
 	if err != nil {
-		g.JSON(http.StatusInternalServerError, ErrorResponse{
-			Code:    http.StatusInternalServerError,
-			Message: err.Error(),
-		})
+		writeError(g, err)
 		return
 	}
 
@@ -135,7 +154,7 @@ func (service *WarehouseService) UpdateWarehouse(g *gin.Context) {
 // DeleteWarehouse example
 // @Tags warehouses
 // @Summary Delete a warehouse by id
-// @Description Delete a warehouse by id
+// @Description Delete a warehouse by id, a warehouse still holding stock or reservations can't be deleted
 // @ID delete-warehouse
 // @Accept  json
 // @Produce  json
@@ -143,6 +162,7 @@ func (service *WarehouseService) UpdateWarehouse(g *gin.Context) {
 // @Success 204 string string "NoContent"
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
 // @Router /warehouses/{id} [delete]
 func (service *WarehouseService) DeleteWarehouse(g *gin.Context) {
 	warehouse := Warehouse{}
@@ -155,7 +175,46 @@ func (service *WarehouseService) DeleteWarehouse(g *gin.Context) {
 		return
 	}
 
-	err := service.Delete(&warehouse)
+	err := service.WithContext(g.Request.Context()).Delete(&warehouse)
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	g.Status(http.StatusNoContent)
+}
+
+// GetWarehouseStock example
+// @Tags warehouses
+// @Summary Get the article stock of a warehouse
+// @Description Get the article stock of a warehouse
+// @ID get-warehouse-stock
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Warehouse ID"
+// @Success 200 {array} Stock
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /warehouses/{id}/stock [get]
+func (service *WarehouseService) GetWarehouseStock(g *gin.Context) {
+	warehouse := Warehouse{}
+
+	if err := g.ShouldBindUri(&warehouse); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the params",
+		})
+		return
+	}
+
+	if _, err := service.GetById(warehouse.ID); err != nil {
+		g.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    http.StatusNotFound,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	stock, err := service.GetStock(warehouse.ID)
 	if err != nil {
 		g.JSON(http.StatusInternalServerError, ErrorResponse{
 			Code:    http.StatusInternalServerError,
@@ -163,5 +222,10 @@ func (service *WarehouseService) DeleteWarehouse(g *gin.Context) {
 		})
 		return
 	}
-	g.Status(http.StatusNoContent)
+	g.JSON(http.StatusOK, stock)
 }
+
+// writeError writes the response matching the given service error
+var writeError = apierror.Writer(
+	apierror.Is(http.StatusConflict, ErrWarehouseNotEmpty),
+)
@@ -13,5 +13,6 @@ func (service WarehouseService) RegisterHTTPRoutes(routerGroup *gin.RouterGroup)
 		warehouses.POST("/", service.CreateWarehouse)
 		warehouses.PUT("/:id", service.UpdateWarehouse)
 		warehouses.DELETE("/:id", service.DeleteWarehouse)
+		warehouses.GET("/:id/stock", service.GetWarehouseStock)
 	}
 }
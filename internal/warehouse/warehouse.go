@@ -1,11 +1,51 @@
 package warehouse
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/outbox"
 	"github.com/unicod3/horreum/pkg/streamer"
+	"strings"
 	"time"
 )
 
+// ErrWarehouseNotEmpty is returned when a warehouse still
+// holding stock or reservations is deleted
+var ErrWarehouseNotEmpty = errors.New("warehouse still holds stock")
+
+const (
+	WarehouseCreated string = "WarehouseCreated"
+	WarehouseUpdated        = "WarehouseUpdated"
+	WarehouseDeleted        = "WarehouseDeleted"
+)
+
+// Snapshot is the data of the warehouse events, Before is nil
+// for the created warehouses and After is nil for the deleted ones
+type Snapshot struct {
+	Before *Warehouse `json:"before"`
+	After  *Warehouse `json:"after"`
+}
+
+// WarehouseID returns the pk id of the warehouse the snapshot belongs to
+func (s Snapshot) WarehouseID() uint64 {
+	if s.After != nil {
+		return s.After.ID
+	}
+	if s.Before != nil {
+		return s.Before.ID
+	}
+	return 0
+}
+
+// RegisterEvents registers the schemas of the package's events to the registry
+func RegisterEvents(registry *streamer.Registry) {
+	for _, event := range []string{WarehouseCreated, WarehouseUpdated, WarehouseDeleted} {
+		registry.Register(event, 1, Snapshot{})
+	}
+}
+
 // Warehouse represents a record from warehouses table
 type Warehouse struct {
 	ID        uint64    `json:"id" uri:"id" db:"id,omitempty"`
@@ -14,6 +54,21 @@ type Warehouse struct {
 	Name      string    `json:"name" db:"name"`
 }
 
+// Stock represents the quantity of an article kept in a warehouse
+type Stock struct {
+	ArticleID uint64    `json:"article_id" db:"article_id"`
+	Name      string    `json:"name" db:"name"`
+	Quantity  int64     `json:"quantity" db:"quantity"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// stockRecord represents a record from warehouse_stock table
+type stockRecord struct {
+	ArticleID uint64 `db:"article_id"`
+	Quantity  int64  `db:"quantity"`
+	Reserved  int64  `db:"reserved"`
+}
+
 // ErrorResponse contains information about error
 type ErrorResponse struct {
 	Code    int    `json:"code"`
@@ -32,6 +87,7 @@ type WarehouseRepository interface {
 	Create(w *Warehouse) error
 	Update(w *Warehouse) error
 	Delete(w *Warehouse) error
+	GetStock(id uint64) ([]Stock, error)
 }
 
 // WarehouseService holds information about the datatable
@@ -40,6 +96,16 @@ type WarehouseService struct {
 	DataTable     dbclient.DataTable
 	StreamChannel streamer.Channel
 	StreamTopic   string
+	// correlationID is carried by the events of the service
+	correlationID string
+}
+
+// WithContext returns a copy of the service whose events carry
+// the correlation id of ctx, when ctx carries one
+func (service *WarehouseService) WithContext(ctx context.Context) *WarehouseService {
+	scoped := *service
+	scoped.correlationID = streamer.CorrelationIDFrom(ctx)
+	return &scoped
 }
 
 // GetAll returns all the records
@@ -51,6 +117,23 @@ func (service *WarehouseService) GetAll() ([]Warehouse, error) {
 	return warehouses, nil
 }
 
+// WarehouseFields are the fields the warehouses can be filtered and sorted by
+var WarehouseFields = dbclient.Fields{
+	"name":       {Type: dbclient.StringField},
+	"created_at": {Type: dbclient.TimeField},
+	"updated_at": {Type: dbclient.TimeField},
+}
+
+// GetPage returns the records of the page and the cursor of the next one
+func (service *WarehouseService) GetPage(query dbclient.PageQuery) ([]Warehouse, string, error) {
+	var warehouses []Warehouse
+	next, err := service.DataTable.FindPage(query, &warehouses)
+	if err != nil {
+		return nil, "", err
+	}
+	return warehouses, next, nil
+}
+
 // GetById returns single record for given pk id
 func (service *WarehouseService) GetById(id uint64) (*Warehouse, error) {
 	var warehouse Warehouse
@@ -60,27 +143,117 @@ func (service *WarehouseService) GetById(id uint64) (*Warehouse, error) {
 	return &warehouse, nil
 }
 
-// Create creates a new record on the datastore with given struct
+// Create creates a new record on the datastore with given struct,
+// its event is stored in the same transaction
 func (service *WarehouseService) Create(w *Warehouse) error {
-	if err := service.DataTable.InsertReturning(w); err != nil {
-		return err
-	}
-	return nil
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		if err := tx.InsertReturning(w); err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, WarehouseCreated, nil, w)
+	})
 }
 
-// Update updates given record on the datastore by finding it with its pk
+// Update updates given record on the datastore by finding it with its pk,
+// its event is stored in the same transaction
 func (service *WarehouseService) Update(w *Warehouse) error {
 	w.UpdatedAt = time.Now().UTC()
-	if err := service.DataTable.UpdateReturning(w); err != nil {
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		current, err := findForUpdate(tx, w.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.UpdateReturning(w); err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, WarehouseUpdated, current, w)
+	})
+}
+
+// Delete deletes the given struct from database by finding it with its pk,
+// its event is stored in the same transaction. A warehouse still holding
+// stock or reservations can't be deleted, its stock would disappear
+// without a movement while the totals of the articles still count it.
+func (service *WarehouseService) Delete(w *Warehouse) error {
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		current, err := findForUpdate(tx, w.ID)
+		if err != nil {
+			return err
+		}
+		if err := checkEmpty(tx, w.ID); err != nil {
+			return err
+		}
+		if err := tx.Delete(dbclient.Condition{"id": w.ID}); err != nil {
+			return err
+		}
+		return service.PublishEvent(tx, WarehouseDeleted, current, nil)
+	})
+}
+
+// GetStock returns the article stock kept in the warehouse with given pk id
+func (service *WarehouseService) GetStock(id uint64) ([]Stock, error) {
+	var stock []Stock
+	err := service.DataTable.LoadMany2Many(
+		"ws.article_id as article_id, a.name as name, ws.quantity as quantity, ws.updated_at as updated_at",
+		"warehouse_stock ws",
+		"articles a",
+		"a.id = ws.article_id",
+		dbclient.Condition{"ws.warehouse_id": id},
+		&stock)
+	if err != nil {
+		return nil, err
+	}
+	return stock, nil
+}
+
+// PublishEvent writes the event with the snapshots of the warehouse before and
+// after the change to the outbox within the transaction of tx, the
+// outbox.Relay publishes it on the StreamChannel once it is committed
+func (service *WarehouseService) PublishEvent(tx dbclient.DataTable, event string, before, after *Warehouse) error {
+	snapshot := Snapshot{Before: before, After: after}
+	msg, err := streamer.NewMessage(&streamer.Message{
+		EventName:     event,
+		AggregateType: "warehouse",
+		AggregateID:   snapshot.WarehouseID(),
+		CorrelationID: service.correlationID,
+		Data:          snapshot,
+	})
+	if err != nil {
 		return err
 	}
-	return nil
+	return outbox.Store(tx, service.StreamTopic, msg)
 }
 
-// Delete deletes the given struct from database by finding it with its pk
-func (service *WarehouseService) Delete(w *Warehouse) error {
-	if err := service.DataTable.Delete(dbclient.Condition{"id": w.ID}); err != nil {
+// checkEmpty returns ErrWarehouseNotEmpty when the warehouse with given pk
+// id holds stock or reservations. The stock is locked until the end of the
+// transaction, the stock added to the locked warehouse waits for it as well.
+func checkEmpty(tx dbclient.DataTable, id uint64) error {
+	var stock []stockRecord
+	if err := tx.Related("warehouse_stock").FindForUpdate(dbclient.Condition{"warehouse_id": id}, &stock); err != nil {
 		return err
 	}
-	return nil
+	var articles []string
+	for _, s := range stock {
+		if s.Quantity != 0 || s.Reserved != 0 {
+			articles = append(articles, fmt.Sprintf("%d", s.ArticleID))
+		}
+	}
+	if len(articles) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: warehouse %d holds articles %s",
+		ErrWarehouseNotEmpty, id, strings.Join(articles, ", "))
+}
+
+// findForUpdate returns the warehouse with given pk id and
+// locks it until the end of the transaction
+func findForUpdate(tx dbclient.DataTable, id uint64) (*Warehouse, error) {
+	var warehouses []Warehouse
+	if err := tx.FindForUpdate(dbclient.Condition{"id": id}, &warehouses); err != nil {
+		return nil, err
+	}
+	if len(warehouses) == 0 {
+		return nil, dbclient.ErrNoMoreRows
+	}
+	return &warehouses[0], nil
 }
@@ -1,13 +1,47 @@
 package warehouse
 
 import (
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/unicod3/horreum/pkg/dbclient"
 	"github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"github.com/unicod3/horreum/pkg/outbox"
 	"testing"
 )
 
+// warehouseEvent is the payload of the published warehouse events
+type warehouseEvent struct {
+	EventName string
+	Data      struct {
+		Before *Warehouse `json:"before"`
+		After  *Warehouse `json:"after"`
+	}
+}
+
+// mockEvent expects a single event to be written to the outbox on the
+// warehouses topic and returns the event it is written with
+func mockEvent(dataTable *mocks.DataTable) *warehouseEvent {
+	var event warehouseEvent
+	dataTable.On("CreateRelated", outbox.TableName, mock.MatchedBy(func(m *outbox.Message) bool {
+		return m.Topic == "warehouses"
+	})).Run(func(args mock.Arguments) {
+		json.Unmarshal([]byte(args.Get(1).(*outbox.Message).Payload), &event)
+	}).Return(nil).Once()
+	return &event
+}
+
+// mockWarehouse mocks the locked warehouse of a transaction
+func mockWarehouse(dataTable *mocks.DataTable, warehouse Warehouse) {
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(dataTable)
+		}).Once()
+	dataTable.On("FindForUpdate", dbclient.Condition{"id": warehouse.ID}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*[]Warehouse)) = []Warehouse{warehouse}
+	}).Return(nil).Once()
+}
+
 func TestWarehouseServiceImplementsWarehouseRepositoryInterface(t *testing.T) {
 	assert := assert.New(t)
 	assert.Implements((*WarehouseRepository)(nil), new(WarehouseService))
@@ -34,6 +68,26 @@ func TestWarehouseService_GetAll(t *testing.T) {
 	assert.Equal(warehouses, w)
 }
 
+func TestWarehouseService_GetPage(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	warehouseService := &WarehouseService{
+		DataTable: &dataTable,
+	}
+
+	warehouses := []Warehouse{{ID: 1, Name: "test"}}
+	query := dbclient.PageQuery{Fields: WarehouseFields, Limit: 1}
+	dataTable.On("FindPage", query, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(1).(*[]Warehouse) = warehouses
+	}).Return("next", nil).Once()
+
+	w, next, err := warehouseService.GetPage(query)
+	assert.Nil(err)
+	assert.Equal("next", next)
+	assert.Equal(warehouses, w)
+}
+
 func TestWarehouseService_GetById(t *testing.T) {
 	assert := assert.New(t)
 
@@ -58,11 +112,17 @@ func TestWarehouseService_Create(t *testing.T) {
 
 	dataTable := mocks.DataTable{}
 	warehouseService := &WarehouseService{
-		DataTable: &dataTable,
+		DataTable:   &dataTable,
+		StreamTopic: "warehouses",
 	}
+	event := mockEvent(&dataTable)
 
 	warehouse := Warehouse{ID: 1, Name: "test"}
 
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
 	var w Warehouse
 	dataTable.On("InsertReturning", &warehouse).Run(func(args mock.Arguments) {
 		w = warehouse
@@ -70,6 +130,9 @@ func TestWarehouseService_Create(t *testing.T) {
 	err := warehouseService.Create(&warehouse)
 	assert.Nil(err)
 	assert.Equal(warehouse, w)
+	assert.Equal(WarehouseCreated, event.EventName)
+	assert.Nil(event.Data.Before)
+	assert.Equal("test", event.Data.After.Name)
 }
 
 func TestWarehouseService_Update(t *testing.T) {
@@ -77,10 +140,13 @@ func TestWarehouseService_Update(t *testing.T) {
 
 	dataTable := mocks.DataTable{}
 	warehouseService := &WarehouseService{
-		DataTable: &dataTable,
+		DataTable:   &dataTable,
+		StreamTopic: "warehouses",
 	}
+	event := mockEvent(&dataTable)
 
 	warehouse := Warehouse{ID: 1, Name: "test"}
+	mockWarehouse(&dataTable, Warehouse{ID: 1, Name: "old"})
 
 	var w Warehouse
 	dataTable.On("UpdateReturning", &warehouse).Run(func(args mock.Arguments) {
@@ -89,9 +155,86 @@ func TestWarehouseService_Update(t *testing.T) {
 	err := warehouseService.Update(&warehouse)
 	assert.Nil(err)
 	assert.Equal(warehouse, w)
+	assert.Equal(WarehouseUpdated, event.EventName)
+	assert.Equal("old", event.Data.Before.Name)
+	assert.Equal("test", event.Data.After.Name)
+}
+
+func TestWarehouseService_UpdateMissingWarehouse(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	warehouseService := &WarehouseService{
+		DataTable:   &dataTable,
+		StreamTopic: "warehouses",
+	}
+
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
+	dataTable.On("FindForUpdate", dbclient.Condition{"id": uint64(1)}, mock.Anything).Return(nil).Once()
+
+	err := warehouseService.Update(&Warehouse{ID: 1, Name: "test"})
+	assert.Equal(dbclient.ErrNoMoreRows, err)
+	dataTable.AssertNotCalled(t, "CreateRelated", outbox.TableName, mock.Anything)
+}
+
+// mockStock mocks the locked stock of the warehouse with given pk id
+func mockStock(dataTable *mocks.DataTable, id uint64, stock []stockRecord) {
+	stockTable := mocks.DataTable{}
+	dataTable.On("Related", "warehouse_stock").Return(&stockTable).Once()
+	stockTable.On("FindForUpdate", dbclient.Condition{"warehouse_id": id}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*[]stockRecord)) = stock
+	}).Return(nil).Once()
 }
 
 func TestWarehouseService_Delete(t *testing.T) {
+	t.Run("Test can delete warehouse without stock", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		warehouseService := &WarehouseService{
+			DataTable:   &dataTable,
+			StreamTopic: "warehouses",
+		}
+		event := mockEvent(&dataTable)
+
+		warehouse := Warehouse{ID: 1}
+		mockWarehouse(&dataTable, Warehouse{ID: 1, Name: "test"})
+		mockStock(&dataTable, 1, []stockRecord{{ArticleID: 7}})
+		dataTable.On("Delete", dbclient.Condition{"id": warehouse.ID}).Return(nil).Once()
+		err := warehouseService.Delete(&warehouse)
+		assert.Nil(err)
+		assert.Equal(WarehouseDeleted, event.EventName)
+		assert.Equal("test", event.Data.Before.Name)
+		assert.Nil(event.Data.After)
+	})
+
+	t.Run("Test can reject warehouse holding stock", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		warehouseService := &WarehouseService{
+			DataTable:   &dataTable,
+			StreamTopic: "warehouses",
+		}
+
+		mockWarehouse(&dataTable, Warehouse{ID: 1, Name: "test"})
+		mockStock(&dataTable, 1, []stockRecord{
+			{ArticleID: 7, Quantity: 3},
+			{ArticleID: 8},
+			{ArticleID: 9, Reserved: 2},
+		})
+		err := warehouseService.Delete(&Warehouse{ID: 1})
+		assert.ErrorIs(err, ErrWarehouseNotEmpty)
+		assert.Contains(err.Error(), "articles 7, 9")
+		dataTable.AssertNotCalled(t, "Delete", mock.Anything)
+		dataTable.AssertNotCalled(t, "CreateRelated", outbox.TableName, mock.Anything)
+	})
+}
+
+func TestWarehouseService_GetStock(t *testing.T) {
 	assert := assert.New(t)
 
 	dataTable := mocks.DataTable{}
@@ -99,8 +242,21 @@ func TestWarehouseService_Delete(t *testing.T) {
 		DataTable: &dataTable,
 	}
 
-	warehouse := Warehouse{ID: 1, Name: "test"}
-	dataTable.On("Delete", dbclient.Condition{"id": warehouse.ID}).Return(nil).Once()
-	err := warehouseService.Delete(&warehouse)
+	stock := []Stock{
+		{ArticleID: 1, Name: "leg", Quantity: 12},
+	}
+
+	var w []Stock
+	dataTable.On("LoadMany2Many",
+		"ws.article_id as article_id, a.name as name, ws.quantity as quantity, ws.updated_at as updated_at",
+		"warehouse_stock ws",
+		"articles a",
+		"a.id = ws.article_id",
+		dbclient.Condition{"ws.warehouse_id": uint64(1)},
+		&w).Run(func(args mock.Arguments) {
+		w = stock
+	}).Return(nil).Once()
+	_, err := warehouseService.GetStock(1)
 	assert.Nil(err)
+	assert.Equal(stock, w)
 }
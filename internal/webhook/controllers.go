@@ -0,0 +1,194 @@
+package webhook
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/unicod3/horreum/pkg/apierror"
+	"net/http"
+)
+
+// ListWebhooks example
+// @Tags webhooks
+// @Summary Get all webhook subscriptions
+// @Description Get all webhook subscriptions
+// @ID list-webhooks
+// @Accept  json
+// @Produce  json
+// @Success 200 {array} Subscription
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/ [get]
+func (service *WebhookService) ListWebhooks(g *gin.Context) {
+	subscriptions, err := service.GetAll()
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	g.JSON(http.StatusOK, subscriptions)
+}
+
+// GetWebhook example
+// @Tags webhooks
+// @Summary Get single webhook subscription by id
+// @Description Get single webhook subscription by id
+// @ID get-webhook
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} Subscription
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /webhooks/{id} [get]
+func (service *WebhookService) GetWebhook(g *gin.Context) {
+	var subscription Subscription
+
+	if err := g.ShouldBindUri(&subscription); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the params",
+		})
+		return
+	}
+
+	s, err := service.GetById(subscription.ID)
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	g.JSON(http.StatusOK, s)
+}
+
+// CreateWebhook example
+// @Tags webhooks
+// @Summary Create a webhook subscription with given data
+// @Description Subscribe a url to the given event types, "*" subscribes to every event.
+// @Description The deliveries are signed with the secret in the X-Horreum-Signature header.
+// @ID create-webhook
+// @Accept  json
+// @Produce  json
+// @Param webhook body RequestBody true "Webhook"
+// @Success 201 {object} Subscription
+// @Failure 400 {object} ErrorResponse
+// @Router /webhooks/ [post]
+func (service *WebhookService) CreateWebhook(g *gin.Context) {
+	var body RequestBody
+	if err := g.ShouldBindJSON(&body); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the body",
+		})
+		return
+	}
+
+	subscription := body.Subscription()
+	if err := service.Create(subscription); err != nil {
+		writeError(g, err)
+		return
+	}
+	g.JSON(http.StatusCreated, subscription)
+}
+
+// UpdateWebhook example
+// @Tags webhooks
+// @Summary Update a webhook subscription with given data
+// @Description Update a webhook subscription with given data, the secret is kept when it is left out
+// @ID update-webhook
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Webhook ID"
+// @Param webhook body RequestBody true "Webhook"
+// @Success 200 {object} Subscription
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /webhooks/{id} [put]
+func (service *WebhookService) UpdateWebhook(g *gin.Context) {
+	var uri Subscription
+	if err := g.ShouldBindUri(&uri); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the params",
+		})
+		return
+	}
+
+	var body RequestBody
+	if err := g.ShouldBindJSON(&body); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the body",
+		})
+		return
+	}
+
+	subscription := body.Subscription()
+	subscription.ID = uri.ID
+	if err := service.Update(subscription); err != nil {
+		writeError(g, err)
+		return
+	}
+	g.JSON(http.StatusOK, subscription)
+}
+
+// DeleteWebhook example
+// @Tags webhooks
+// @Summary Delete a webhook subscription by id
+// @Description Delete a webhook subscription and its deliveries by id
+// @ID delete-webhook
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Webhook ID"
+// @Success 204 string string "NoContent"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /webhooks/{id} [delete]
+func (service *WebhookService) DeleteWebhook(g *gin.Context) {
+	var subscription Subscription
+
+	if err := g.ShouldBindUri(&subscription); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the params",
+		})
+		return
+	}
+
+	if err := service.Delete(&subscription); err != nil {
+		writeError(g, err)
+		return
+	}
+	g.Status(http.StatusNoContent)
+}
+
+// ListWebhookDeliveries example
+// @Tags webhooks
+// @Summary Get the deliveries of a webhook subscription
+// @Description Get the deliveries of a webhook subscription with their status and last error, latest first
+// @ID list-webhook-deliveries
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Webhook ID"
+// @Success 200 {array} Delivery
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /webhooks/{id}/deliveries [get]
+func (service *WebhookService) ListWebhookDeliveries(g *gin.Context) {
+	var subscription Subscription
+
+	if err := g.ShouldBindUri(&subscription); err != nil {
+		g.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "Couldn't resolve the params",
+		})
+		return
+	}
+
+	deliveries, err := service.GetDeliveries(subscription.ID)
+	if err != nil {
+		writeError(g, err)
+		return
+	}
+	g.JSON(http.StatusOK, deliveries)
+}
+
+// writeError writes the response matching the given service error
+var writeError = apierror.Writer(
+	apierror.Is(http.StatusBadRequest, ErrInvalidURL, ErrNoEventTypes, ErrNoSecret),
+)
@@ -0,0 +1,214 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Horreum-Signature"
+	EventHeader     = "X-Horreum-Event"
+	DeliveryHeader  = "X-Horreum-Delivery"
+)
+
+const (
+	DefaultMaxAttempts    = 8
+	DefaultInitialBackoff = 10 * time.Second
+	DefaultMaxBackoff     = time.Hour
+	DefaultTimeout        = 10 * time.Second
+	DefaultBatchSize      = 20
+	DefaultClaimTimeout   = 5 * time.Minute
+)
+
+// Sign returns the signature of the payload sent in the SignatureHeader,
+// it is the hex encoded HMAC-SHA256 of the payload keyed by the secret
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether the signature is the signature of the payload
+func Verify(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, payload)), []byte(signature))
+}
+
+// Dispatcher posts the pending deliveries to their subscriptions
+type Dispatcher struct {
+	DataTable      dbclient.DataTable
+	Client         *http.Client
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	BatchSize      int
+	ClaimTimeout   time.Duration
+}
+
+// NewDispatcher returns a Dispatcher of the deliveries in dataTable with the defaults
+func NewDispatcher(dataTable dbclient.DataTable) *Dispatcher {
+	return &Dispatcher{
+		DataTable:      dataTable,
+		Client:         &http.Client{Timeout: DefaultTimeout},
+		MaxAttempts:    DefaultMaxAttempts,
+		InitialBackoff: DefaultInitialBackoff,
+		MaxBackoff:     DefaultMaxBackoff,
+		BatchSize:      DefaultBatchSize,
+		ClaimTimeout:   DefaultClaimTimeout,
+	}
+}
+
+// Backoff returns the delay before the next attempt of a delivery which failed
+// the given number of attempts, the delay doubles with every attempt
+func (d *Dispatcher) Backoff(attempts int) time.Duration {
+	backoff := d.InitialBackoff
+	for i := 1; i < attempts && backoff < d.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.MaxBackoff {
+		return d.MaxBackoff
+	}
+	return backoff
+}
+
+// Drain posts a batch of the pending deliveries whose next attempt is due and
+// returns the number of the succeeded ones. The batch is claimed in a short
+// transaction by moving its next attempt ClaimTimeout ahead, concurrent
+// dispatchers skip the claimed deliveries, so they never post the same delivery
+// twice. The deliveries are posted outside of the transaction and the result of
+// each one is recorded on its own, a failed delivery is attempted again after
+// its Backoff and it is marked as failed once it runs out of attempts.
+func (d *Dispatcher) Drain() (int, error) {
+	deliveries, subscriptions, err := d.claim()
+	if err != nil {
+		return 0, err
+	}
+
+	var delivered int
+	var recordErr error
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		subscription, ok := subscriptions[delivery.SubscriptionID]
+		if !ok {
+			continue
+		}
+
+		code, err := d.post(subscription, delivery)
+		now := time.Now().UTC()
+		delivery.Attempts++
+		delivery.ResponseCode = code
+		if err == nil {
+			delivery.Status = StatusSucceeded
+			delivery.LastError = ""
+			delivery.DeliveredAt = &now
+		} else {
+			delivery.LastError = err.Error()
+			if delivery.Attempts >= d.MaxAttempts {
+				delivery.Status = StatusFailed
+			} else {
+				delivery.NextAttemptAt = now.Add(d.Backoff(delivery.Attempts))
+			}
+		}
+		if err := d.DataTable.UpdateReturning(delivery); err != nil {
+			if recordErr == nil {
+				recordErr = fmt.Errorf("delivery %d: %w", delivery.ID, err)
+			}
+			continue
+		}
+		if delivery.Status == StatusSucceeded {
+			delivered++
+		}
+	}
+	return delivered, recordErr
+}
+
+// claim locks the next batch of the due pending deliveries which aren't claimed
+// by another dispatcher, claims them for ClaimTimeout and returns them with
+// their subscriptions
+func (d *Dispatcher) claim() ([]Delivery, map[uint64]*Subscription, error) {
+	var deliveries []Delivery
+	subscriptionMap := make(map[uint64]*Subscription)
+	err := d.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		now := time.Now().UTC()
+		err := tx.FindForUpdateSkipLocked(dbclient.Condition{
+			"status":             StatusPending,
+			"next_attempt_at <=": now,
+		}, d.BatchSize, &deliveries)
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+
+		var ids []uint64
+		for i := range deliveries {
+			ids = append(ids, deliveries[i].SubscriptionID)
+			deliveries[i].NextAttemptAt = now.Add(d.ClaimTimeout)
+			if err := tx.UpdateReturning(&deliveries[i]); err != nil {
+				return err
+			}
+		}
+		var subscriptions []Subscription
+		if err := tx.FindRelated(SubscriptionsTable, dbclient.Condition{"id IN": ids}, &subscriptions); err != nil {
+			return err
+		}
+		for i := range subscriptions {
+			subscriptionMap[subscriptions[i].ID] = &subscriptions[i]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return deliveries, subscriptionMap, nil
+}
+
+// post posts the delivery's payload to the subscription's url and returns
+// the response status code, any status other than 2xx is an error
+func (d *Dispatcher) post(subscription *Subscription, delivery *Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Horreum-Webhooks")
+	req.Header.Set(SignatureHeader, Sign(subscription.Secret, []byte(delivery.Payload)))
+	req.Header.Set(EventHeader, delivery.EventName)
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(delivery.ID, 10))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// The body is read so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Run drains the deliveries every interval until ctx is done
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := d.Drain(); err != nil {
+				fmt.Println("Error: couldn't dispatch the webhooks: ", err.Error())
+			}
+		}
+	}
+}
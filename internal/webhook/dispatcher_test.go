@@ -0,0 +1,203 @@
+package webhook
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// receiver is a local webhook endpoint which verifies the signature
+// of the deliveries and responds with the given status
+type receiver struct {
+	*httptest.Server
+	secret   string
+	status   int
+	received []string
+}
+
+func newReceiver(t *testing.T, secret string, status int) *receiver {
+	r := &receiver{secret: secret, status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		if !Verify(r.secret, body, req.Header.Get(SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "OrderCreated", req.Header.Get(EventHeader))
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		r.received = append(r.received, string(body))
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// mockDeliveries mocks the due deliveries of the subscription and returns
+// the deliveries the dispatcher updates after it claims them
+func mockDeliveries(t *testing.T, dataTable *mocks.DataTable, subscription Subscription, deliveries ...Delivery) *[]Delivery {
+	var updated []Delivery
+	claimed := 0
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(dataTable)
+		}).Once()
+	dataTable.On("FindForUpdateSkipLocked", mock.MatchedBy(func(cond dbclient.Condition) bool {
+		return cond["status"] == StatusPending
+	}), DefaultBatchSize, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(2).(*[]Delivery)) = deliveries
+	}).Return(nil).Once()
+	dataTable.On("FindRelated", SubscriptionsTable, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(2).(*[]Subscription)) = []Subscription{subscription}
+	}).Return(nil).Once()
+	dataTable.On("UpdateReturning", mock.Anything).Run(func(args mock.Arguments) {
+		delivery := *args.Get(0).(*Delivery)
+		if claimed < len(deliveries) {
+			claimed++
+			if !delivery.NextAttemptAt.After(time.Now().UTC().Add(DefaultClaimTimeout / 2)) {
+				t.Error("the batch must be claimed before it is posted")
+			}
+			return
+		}
+		updated = append(updated, delivery)
+	}).Return(nil)
+	return &updated
+}
+
+func TestSign(t *testing.T) {
+	assert := assert.New(t)
+
+	payload := []byte(`{"EventName":"OrderCreated"}`)
+	signature := Sign("s3cr3t", payload)
+	assert.Regexp("^sha256=[0-9a-f]{64}$", signature)
+	assert.True(Verify("s3cr3t", payload, signature))
+	assert.False(Verify("other", payload, signature))
+	assert.False(Verify("s3cr3t", []byte(`{}`), signature))
+}
+
+func TestDispatcher_Backoff(t *testing.T) {
+	assert := assert.New(t)
+
+	d := &Dispatcher{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	assert.Equal(time.Second, d.Backoff(1))
+	assert.Equal(2*time.Second, d.Backoff(2))
+	assert.Equal(8*time.Second, d.Backoff(4))
+	assert.Equal(10*time.Second, d.Backoff(5))
+	assert.Equal(10*time.Second, d.Backoff(100))
+}
+
+func TestDispatcher_Drain(t *testing.T) {
+	payload := `{"EventName":"OrderCreated","Data":{"id":1}}`
+
+	t.Run("Test can deliver signed payload", func(t *testing.T) {
+		assert := assert.New(t)
+
+		r := newReceiver(t, "s3cr3t", http.StatusOK)
+		dataTable := mocks.DataTable{}
+		subscription := Subscription{ID: 1, URL: r.URL, Secret: "s3cr3t"}
+		updated := mockDeliveries(t, &dataTable, subscription, Delivery{
+			ID: 7, SubscriptionID: 1, EventName: "OrderCreated", Payload: payload, Status: StatusPending,
+		})
+
+		delivered, err := NewDispatcher(&dataTable).Drain()
+		assert.Nil(err)
+		assert.Equal(1, delivered)
+		assert.Equal([]string{payload}, r.received)
+		assert.Equal(StatusSucceeded, (*updated)[0].Status)
+		assert.Equal(1, (*updated)[0].Attempts)
+		assert.Equal(http.StatusOK, (*updated)[0].ResponseCode)
+		assert.NotNil((*updated)[0].DeliveredAt)
+	})
+
+	t.Run("Test can back off failed delivery", func(t *testing.T) {
+		assert := assert.New(t)
+
+		r := newReceiver(t, "s3cr3t", http.StatusServiceUnavailable)
+		dataTable := mocks.DataTable{}
+		subscription := Subscription{ID: 1, URL: r.URL, Secret: "s3cr3t"}
+		updated := mockDeliveries(t, &dataTable, subscription, Delivery{
+			ID: 7, SubscriptionID: 1, EventName: "OrderCreated", Payload: payload, Status: StatusPending, Attempts: 2,
+		})
+
+		dispatcher := NewDispatcher(&dataTable)
+		before := time.Now().UTC()
+		delivered, err := dispatcher.Drain()
+		assert.Nil(err)
+		assert.Equal(0, delivered)
+		d := (*updated)[0]
+		assert.Equal(StatusPending, d.Status)
+		assert.Equal(3, d.Attempts)
+		assert.Equal(http.StatusServiceUnavailable, d.ResponseCode)
+		assert.Equal("unexpected status 503", d.LastError)
+		assert.WithinDuration(before.Add(4*DefaultInitialBackoff), d.NextAttemptAt, time.Second)
+	})
+
+	t.Run("Test can reject wrongly signed delivery", func(t *testing.T) {
+		assert := assert.New(t)
+
+		r := newReceiver(t, "s3cr3t", http.StatusOK)
+		dataTable := mocks.DataTable{}
+		subscription := Subscription{ID: 1, URL: r.URL, Secret: "rotated"}
+		updated := mockDeliveries(t, &dataTable, subscription, Delivery{
+			ID: 7, SubscriptionID: 1, EventName: "OrderCreated", Payload: payload, Status: StatusPending,
+		})
+
+		_, err := NewDispatcher(&dataTable).Drain()
+		assert.Nil(err)
+		assert.Empty(r.received)
+		assert.Equal(http.StatusUnauthorized, (*updated)[0].ResponseCode)
+	})
+
+	t.Run("Test can fail delivery out of attempts", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		subscription := Subscription{ID: 1, URL: "http://127.0.0.1:1/unreachable", Secret: "s3cr3t"}
+		updated := mockDeliveries(t, &dataTable, subscription, Delivery{
+			ID: 7, SubscriptionID: 1, EventName: "OrderCreated", Payload: payload, Status: StatusPending,
+			Attempts: DefaultMaxAttempts - 1,
+		})
+
+		_, err := NewDispatcher(&dataTable).Drain()
+		assert.Nil(err)
+		assert.Equal(StatusFailed, (*updated)[0].Status)
+		assert.Equal(DefaultMaxAttempts, (*updated)[0].Attempts)
+		assert.NotEmpty((*updated)[0].LastError)
+	})
+	t.Run("Test can record each delivery on its own", func(t *testing.T) {
+		assert := assert.New(t)
+
+		r := newReceiver(t, "s3cr3t", http.StatusOK)
+		dataTable := mocks.DataTable{}
+		subscription := Subscription{ID: 1, URL: r.URL, Secret: "s3cr3t"}
+		dataTable.On("WithTx", mock.Anything).
+			Return(func(fn func(dbclient.DataTable) error) error {
+				return fn(&dataTable)
+			}).Once()
+		dataTable.On("FindForUpdateSkipLocked", mock.Anything, DefaultBatchSize, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]Delivery)) = []Delivery{
+				{ID: 7, SubscriptionID: 1, EventName: "OrderCreated", Payload: payload, Status: StatusPending},
+				{ID: 8, SubscriptionID: 1, EventName: "OrderCreated", Payload: payload, Status: StatusPending},
+			}
+		}).Return(nil).Once()
+		dataTable.On("FindRelated", SubscriptionsTable, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]Subscription)) = []Subscription{subscription}
+		}).Return(nil).Once()
+		dataTable.On("UpdateReturning", mock.Anything).Return(nil).Twice()
+		dataTable.On("UpdateReturning", mock.MatchedBy(func(d *Delivery) bool { return d.ID == 7 })).
+			Return(errors.New("connection reset")).Once()
+		dataTable.On("UpdateReturning", mock.Anything).Return(nil).Once()
+
+		delivered, err := NewDispatcher(&dataTable).Drain()
+		assert.NotNil(err)
+		assert.Equal(1, delivered, "a failed record doesn't undo the others")
+		assert.Len(r.received, 2)
+		dataTable.AssertExpectations(t)
+	})
+}
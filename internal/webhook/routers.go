@@ -0,0 +1,18 @@
+package webhook
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterHTTPRoutes registers the package's routes to the gin router
+func (service *WebhookService) RegisterHTTPRoutes(routerGroup *gin.RouterGroup) {
+	webhooks := routerGroup.Group("webhooks")
+	{
+		webhooks.GET("/", service.ListWebhooks)
+		webhooks.GET("/:id", service.GetWebhook)
+		webhooks.POST("/", service.CreateWebhook)
+		webhooks.PUT("/:id", service.UpdateWebhook)
+		webhooks.DELETE("/:id", service.DeleteWebhook)
+		webhooks.GET("/:id/deliveries", service.ListWebhookDeliveries)
+	}
+}
@@ -0,0 +1,249 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"net/url"
+	"sort"
+	"time"
+)
+
+const (
+	SubscriptionsTable = "webhook_subscriptions"
+	DeliveriesTable    = "webhook_deliveries"
+)
+
+// AllEvents subscribes to every event
+const AllEvents = "*"
+
+// DeliveryStatus represents the state of a delivery
+type DeliveryStatus string
+
+const (
+	StatusPending   DeliveryStatus = "pending"
+	StatusSucceeded DeliveryStatus = "succeeded"
+	StatusFailed    DeliveryStatus = "failed"
+)
+
+var (
+	// ErrInvalidURL is returned when a subscription doesn't have an absolute http(s) url
+	ErrInvalidURL = errors.New("webhook url should be an absolute http or https url")
+	// ErrNoEventTypes is returned when a subscription doesn't subscribe to any event
+	ErrNoEventTypes = errors.New("webhook should subscribe to at least one event type")
+	// ErrNoSecret is returned when a subscription is created without a secret
+	ErrNoSecret = errors.New("webhook secret should be given")
+)
+
+// WebhookRepository serves as a contract over WebhookService
+type WebhookRepository interface {
+	GetAll() ([]Subscription, error)
+	GetById(id uint64) (*Subscription, error)
+	Create(s *Subscription) error
+	Update(s *Subscription) error
+	Delete(s *Subscription) error
+	GetDeliveries(id uint64) ([]Delivery, error)
+	Enqueue(msg *message.Message) error
+}
+
+// Subscription represents a record from webhook_subscriptions table,
+// the secret signs the deliveries and is never returned
+type Subscription struct {
+	ID         uint64               `json:"id" uri:"id" db:"id,omitempty"`
+	CreatedAt  time.Time            `json:"created_at,omitempty" db:"created_at,omitempty"`
+	UpdatedAt  time.Time            `json:"updated_at,omitempty" db:"updated_at,omitempty"`
+	URL        string               `json:"url" db:"url"`
+	EventTypes dbclient.StringArray `json:"event_types" db:"event_types" swaggertype:"array,string"`
+	Secret     string               `json:"-" db:"secret"`
+}
+
+// Delivery represents a record from webhook_deliveries table,
+// it is the delivery of an event to a subscription
+type Delivery struct {
+	ID             uint64         `json:"id" db:"id,omitempty"`
+	CreatedAt      time.Time      `json:"created_at,omitempty" db:"created_at,omitempty"`
+	SubscriptionID uint64         `json:"subscription_id" db:"subscription_id"`
+	EventID        string         `json:"event_id" db:"event_id"`
+	EventName      string         `json:"event_name" db:"event_name"`
+	Payload        string         `json:"payload" db:"payload"`
+	Status         DeliveryStatus `json:"status" db:"status"`
+	Attempts       int            `json:"attempts" db:"attempts"`
+	ResponseCode   int            `json:"response_code,omitempty" db:"response_code"`
+	LastError      string         `json:"last_error,omitempty" db:"last_error"`
+	NextAttemptAt  time.Time      `json:"next_attempt_at" db:"next_attempt_at"`
+	DeliveredAt    *time.Time     `json:"delivered_at,omitempty" db:"delivered_at"`
+}
+
+// ErrorResponse contains information about error
+type ErrorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// RequestBody represents the data type that needs to be sent over request,
+// the secret can be left out on update to keep the current one
+type RequestBody struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Secret     string   `json:"secret"`
+}
+
+// Subscription returns the subscription the request body describes
+func (body *RequestBody) Subscription() *Subscription {
+	return &Subscription{
+		URL:        body.URL,
+		EventTypes: body.EventTypes,
+		Secret:     body.Secret,
+	}
+}
+
+// Matches reports whether the subscription subscribes to the event
+func (s *Subscription) Matches(event string) bool {
+	for _, eventType := range s.EventTypes {
+		if eventType == AllEvents || eventType == event {
+			return true
+		}
+	}
+	return false
+}
+
+// validate returns the error of the first invalid field
+func (s *Subscription) validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidURL
+	}
+	if len(s.EventTypes) == 0 {
+		return ErrNoEventTypes
+	}
+	if s.Secret == "" {
+		return ErrNoSecret
+	}
+	return nil
+}
+
+// WebhookService holds information about the datatable
+// and implements WebhookRepository
+type WebhookService struct {
+	DataTable dbclient.DataTable
+}
+
+// GetAll returns all the records
+func (service *WebhookService) GetAll() ([]Subscription, error) {
+	var subscriptions []Subscription
+	if err := service.DataTable.FindAll(&subscriptions); err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// GetById returns single record for given pk id
+func (service *WebhookService) GetById(id uint64) (*Subscription, error) {
+	var subscription Subscription
+	if err := service.DataTable.FindOne(dbclient.Condition{"id": id}, &subscription); err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// Create creates a new record on the datastore with given struct
+func (service *WebhookService) Create(s *Subscription) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	return service.DataTable.InsertReturning(s)
+}
+
+// Update updates given record on the datastore by finding it with its pk,
+// the current secret is kept when s doesn't have one
+func (service *WebhookService) Update(s *Subscription) error {
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		current, err := findForUpdate(tx, s.ID)
+		if err != nil {
+			return err
+		}
+		if s.Secret == "" {
+			s.Secret = current.Secret
+		}
+		if err := s.validate(); err != nil {
+			return err
+		}
+		s.CreatedAt = current.CreatedAt
+		s.UpdatedAt = time.Now().UTC()
+		return tx.UpdateReturning(s)
+	})
+}
+
+// Delete deletes the given struct from database by finding it with its pk,
+// the deliveries of the subscription are deleted along with it
+func (service *WebhookService) Delete(s *Subscription) error {
+	return service.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		if _, err := findForUpdate(tx, s.ID); err != nil {
+			return err
+		}
+		return tx.Delete(dbclient.Condition{"id": s.ID})
+	})
+}
+
+// GetDeliveries returns the deliveries of the subscription with
+// given pk id, the latest delivery comes first
+func (service *WebhookService) GetDeliveries(id uint64) ([]Delivery, error) {
+	if _, err := service.GetById(id); err != nil {
+		return nil, err
+	}
+	var deliveries []Delivery
+	err := service.DataTable.FindRelated(DeliveriesTable, dbclient.Condition{"subscription_id": id}, &deliveries)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+	return deliveries, nil
+}
+
+// Enqueue writes a pending delivery of the event in the message for every
+// subscription subscribing to it, the Dispatcher delivers them
+func (service *WebhookService) Enqueue(msg *message.Message) error {
+	var envelope struct {
+		EventName string
+	}
+	if err := json.Unmarshal(msg.Payload, &envelope); err != nil {
+		return err
+	}
+
+	subscriptions, err := service.GetAll()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, s := range subscriptions {
+		if !s.Matches(envelope.EventName) {
+			continue
+		}
+		err := service.DataTable.CreateRelated(DeliveriesTable, &Delivery{
+			SubscriptionID: s.ID,
+			EventID:        msg.UUID,
+			EventName:      envelope.EventName,
+			Payload:        string(msg.Payload),
+			Status:         StatusPending,
+			NextAttemptAt:  now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findForUpdate returns the subscription with given pk id and
+// locks it until the end of the transaction
+func findForUpdate(tx dbclient.DataTable, id uint64) (*Subscription, error) {
+	var subscriptions []Subscription
+	if err := tx.FindForUpdate(dbclient.Condition{"id": id}, &subscriptions); err != nil {
+		return nil, err
+	}
+	if len(subscriptions) == 0 {
+		return nil, dbclient.ErrNoMoreRows
+	}
+	return &subscriptions[0], nil
+}
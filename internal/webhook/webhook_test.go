@@ -0,0 +1,114 @@
+package webhook
+
+import (
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"github.com/unicod3/horreum/pkg/streamer"
+	"testing"
+)
+
+func TestWebhookServiceImplementsWebhookRepositoryInterface(t *testing.T) {
+	assert := assert.New(t)
+	assert.Implements((*WebhookRepository)(nil), new(WebhookService))
+}
+
+func TestWebhookService_Create(t *testing.T) {
+	t.Run("Test can create valid subscription", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		webhookService := &WebhookService{DataTable: &dataTable}
+		s := &Subscription{URL: "https://erp.example.com/hooks", EventTypes: []string{"OrderCreated"}, Secret: "s3cr3t"}
+		dataTable.On("InsertReturning", s).Return(nil).Once()
+
+		assert.Nil(webhookService.Create(s))
+		dataTable.AssertExpectations(t)
+	})
+
+	t.Run("Test can reject invalid subscriptions", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		webhookService := &WebhookService{DataTable: &dataTable}
+
+		assert.Equal(ErrInvalidURL, webhookService.Create(&Subscription{URL: "erp/hooks", EventTypes: []string{"*"}, Secret: "s"}))
+		assert.Equal(ErrNoEventTypes, webhookService.Create(&Subscription{URL: "http://erp/hooks", Secret: "s"}))
+		assert.Equal(ErrNoSecret, webhookService.Create(&Subscription{URL: "http://erp/hooks", EventTypes: []string{"*"}}))
+		dataTable.AssertNotCalled(t, "InsertReturning", mock.Anything)
+	})
+}
+
+func TestWebhookService_UpdateKeepsSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	webhookService := &WebhookService{DataTable: &dataTable}
+
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		}).Once()
+	dataTable.On("FindForUpdate", dbclient.Condition{"id": uint64(1)}, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(1).(*[]Subscription)) = []Subscription{{ID: 1, URL: "http://erp/hooks", Secret: "s3cr3t"}}
+	}).Return(nil).Once()
+	dataTable.On("UpdateReturning", mock.Anything).Return(nil).Once()
+
+	s := &Subscription{ID: 1, URL: "http://erp/v2/hooks", EventTypes: []string{"*"}}
+	assert.Nil(webhookService.Update(s))
+	assert.Equal("s3cr3t", s.Secret)
+	dataTable.AssertExpectations(t)
+}
+
+func TestWebhookService_Enqueue(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	webhookService := &WebhookService{DataTable: &dataTable}
+
+	dataTable.On("FindAll", mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(0).(*[]Subscription)) = []Subscription{
+			{ID: 1, EventTypes: []string{"OrderCreated"}},
+			{ID: 2, EventTypes: []string{"ArticleStockChanged"}},
+			{ID: 3, EventTypes: []string{AllEvents}},
+		}
+	}).Return(nil).Once()
+	var deliveries []*Delivery
+	dataTable.On("CreateRelated", DeliveriesTable, mock.Anything).Run(func(args mock.Arguments) {
+		deliveries = append(deliveries, args.Get(1).(*Delivery))
+	}).Return(nil)
+
+	msg, err := streamer.NewMessage(&streamer.Message{EventName: "OrderCreated", Data: 1})
+	assert.Nil(err)
+	assert.Nil(webhookService.Enqueue(msg))
+
+	assert.Len(deliveries, 2)
+	for i, subscriptionID := range []uint64{1, 3} {
+		assert.Equal(subscriptionID, deliveries[i].SubscriptionID)
+		assert.Equal(msg.UUID, deliveries[i].EventID)
+		assert.Equal("OrderCreated", deliveries[i].EventName)
+		assert.Equal(string(msg.Payload), deliveries[i].Payload)
+		assert.Equal(StatusPending, deliveries[i].Status)
+	}
+
+	assert.NotNil(webhookService.Enqueue(message.NewMessage("1", []byte("not json"))))
+}
+
+func TestWebhookService_GetDeliveries(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	webhookService := &WebhookService{DataTable: &dataTable}
+
+	dataTable.On("FindOne", dbclient.Condition{"id": uint64(1)}, mock.Anything).Return(nil).Once()
+	dataTable.On("FindRelated", DeliveriesTable, dbclient.Condition{"subscription_id": uint64(1)}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]Delivery)) = []Delivery{{ID: 1}, {ID: 3}, {ID: 2}}
+		}).Return(nil).Once()
+
+	deliveries, err := webhookService.GetDeliveries(1)
+	assert.Nil(err)
+	assert.Equal([]Delivery{{ID: 3}, {ID: 2}, {ID: 1}}, deliveries)
+}
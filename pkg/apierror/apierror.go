@@ -0,0 +1,59 @@
+package apierror
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"net/http"
+)
+
+// Response is the body the errors are answered with
+type Response struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Mapping returns the status and the body an error is answered with,
+// ok is false for the errors it doesn't map
+type Mapping func(err error) (status int, body interface{}, ok bool)
+
+// Is returns a Mapping answering the errors which are any of the targets with status
+func Is(status int, targets ...error) Mapping {
+	return Match(status, func(err error) bool {
+		for _, target := range targets {
+			if errors.Is(err, target) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Match returns a Mapping answering the errors match reports with status
+func Match(status int, match func(err error) bool) Mapping {
+	return func(err error) (int, interface{}, bool) {
+		if !match(err) {
+			return 0, nil, false
+		}
+		return status, Response{Code: status, Message: err.Error()}, true
+	}
+}
+
+// Writer returns a function answering the requests with the errors of a
+// service. An error is answered by the first of the mappings which maps
+// it, the missing records are answered with 404 and any other error with 500.
+func Writer(mappings ...Mapping) func(g *gin.Context, err error) {
+	mappings = append([]Mapping{Is(http.StatusNotFound, dbclient.ErrNoMoreRows)}, mappings...)
+	return func(g *gin.Context, err error) {
+		for _, mapping := range mappings {
+			if status, body, ok := mapping(err); ok {
+				g.JSON(status, body)
+				return
+			}
+		}
+		g.JSON(http.StatusInternalServerError, Response{
+			Code:    http.StatusInternalServerError,
+			Message: err.Error(),
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	errInvalid := errors.New("invalid")
+	errConflict := errors.New("conflict")
+	writeError := Writer(
+		Is(http.StatusBadRequest, errInvalid),
+		Match(http.StatusConflict, func(err error) bool { return errors.Is(err, errConflict) }),
+	)
+
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("order 1: %w", dbclient.ErrNoMoreRows), http.StatusNotFound},
+		{fmt.Errorf("line 2: %w", errInvalid), http.StatusBadRequest},
+		{errConflict, http.StatusConflict},
+		{errors.New("connection lost"), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		g, _ := gin.CreateTestContext(recorder)
+		writeError(g, test.err)
+
+		var response Response
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		assert.Equal(t, test.status, recorder.Code, test.err.Error())
+		assert.Equal(t, Response{Code: test.status, Message: test.err.Error()}, response)
+	}
+}
@@ -1,41 +1,62 @@
 package dbclient
 
 import (
+	"database/sql"
 	"github.com/upper/db/v4"
 	"github.com/upper/db/v4/adapter/postgresql"
 	"log"
+	"sort"
+	"time"
 )
 
 // Client holds database session
 type Client struct {
 	Session *db.Session
+	inTx    bool
 }
 
 // DataStorage serves a contract over Client
 type DataStorage interface {
 	NewDataCollection(tableName string) DataTable
+	SQLDB() *sql.DB
+	WithTx(fn func(tx DataStorage) error) error
+	Close() error
 }
 
 // DataCollection implements DataTable interface
 type DataCollection struct {
 	db.Collection
+	inTx bool
 }
 
 // DataTable serves a contract for DataCollection
 type DataTable interface {
 	db.Collection
+	AdvisoryLock(key int64) error
 	FindAll(dataAddress interface{}) error
 	FindOne(cond Condition, dataAddress interface{}) error
+	FindForUpdate(cond Condition, dataAddress interface{}) error
+	FindForUpdateSkipLocked(cond Condition, limit int, dataAddress interface{}) error
 	FindRelated(tableName string, condition Condition, dataAddress interface{}) error
+	FindPage(query PageQuery, dataAddress interface{}) (string, error)
+	Increment(cond Condition, deltas map[string]int64) error
 	CreateRelated(tableName string, dataAddress interface{}) error
 	Delete(cond Condition) error
 	DeleteRelated(tableName string, condition Condition) error
 	LoadMany2Many(columns, from, join, on string, condition Condition, dataAddress interface{}) error
+	Related(tableName string) DataTable
+	WithTx(fn func(tx DataTable) error) error
 }
 
 // Condition is map to define query conditions
 type Condition = db.Cond
 
+// StringArray is a string slice stored in a text[] column
+type StringArray = postgresql.StringArray
+
+// ErrNoMoreRows is returned when a query doesn't match any record
+var ErrNoMoreRows = db.ErrNoMoreRows
+
 // NewPostgresClient returns a Client struct which holds a postgres session
 func NewPostgresClient(host, user, database, password string) DataStorage {
 	settings := postgresql.ConnectionURL{
@@ -55,8 +76,53 @@ func NewPostgresClient(host, user, database, password string) DataStorage {
 // NewDataCollection returns a DataTable interface
 func (client *Client) NewDataCollection(tableName string) DataTable {
 	return &DataCollection{
-		(*(client.Session)).Collection(tableName),
+		Collection: (*(client.Session)).Collection(tableName),
+		inTx:       client.inTx,
+	}
+}
+
+// SQLDB returns the underlying *sql.DB of the session for the packages
+// that need to talk to the database directly, it is nil in a transaction
+func (client *Client) SQLDB() *sql.DB {
+	sqlDB, _ := (*(client.Session)).Driver().(*sql.DB)
+	return sqlDB
+}
+
+// WithTx runs fn inside a database transaction, every DataTable created
+// from the given DataStorage shares it. The transaction is rolled back
+// if fn returns an error or panics and committed otherwise. Calling WithTx
+// on a DataStorage that is already in a transaction reuses it.
+func (client *Client) WithTx(fn func(tx DataStorage) error) error {
+	if client.inTx {
+		return fn(client)
+	}
+	return (*(client.Session)).Tx(func(sess db.Session) error {
+		defer rollbackOnPanic(sess)
+		return fn(&Client{Session: &sess, inTx: true})
+	})
+}
+
+// rollbackOnPanic rolls the transaction of sess back and panics again when
+// it is deferred by a panicking function. upper/db only rolls back on an
+// error, a panic would leave the transaction with its locks open.
+func rollbackOnPanic(sess db.Session) {
+	if p := recover(); p != nil {
+		if tx, ok := sess.(interface{ Rollback() error }); ok {
+			if err := tx.Rollback(); err != nil {
+				log.Printf("dbclient: couldn't roll back the transaction: %v", err)
+			}
+		}
+		panic(p)
+	}
+}
+
+// Close closes the session and its connections, the DataStorage of
+// a transaction is closed by the end of the transaction instead
+func (client *Client) Close() error {
+	if client.inTx {
+		return nil
 	}
+	return (*(client.Session)).Close()
 }
 
 // FindAll gets all the records for given DataTable
@@ -77,6 +143,79 @@ func (c *DataCollection) FindOne(cond Condition, dataAddress interface{}) error
 	return nil
 }
 
+// FindForUpdate gets the records that matches the given Condition,
+// writes them to given address and locks them until the end of the
+// transaction. Records are locked in pk order to avoid deadlocks.
+func (c *DataCollection) FindForUpdate(cond Condition, dataAddress interface{}) error {
+	return c.Session().SQL().
+		SelectFrom(c.Name()).
+		Where(cond).
+		OrderBy("id").
+		Amend(func(query string) string {
+			return query + " FOR UPDATE"
+		}).
+		All(dataAddress)
+}
+
+// FindForUpdateSkipLocked gets at most limit records that match the
+// given Condition in pk order and locks them until the end of the
+// transaction like FindForUpdate, but skips the records locked by other
+// transactions instead of waiting for them. It lets concurrent workers
+// claim separate batches of a queue table.
+func (c *DataCollection) FindForUpdateSkipLocked(cond Condition, limit int, dataAddress interface{}) error {
+	return c.Session().SQL().
+		SelectFrom(c.Name()).
+		Where(cond).
+		OrderBy("id").
+		Limit(limit).
+		Amend(func(query string) string {
+			return query + " FOR UPDATE SKIP LOCKED"
+		}).
+		All(dataAddress)
+}
+
+// AdvisoryLock takes the advisory lock of key until the end of the
+// transaction, waiting for the transaction holding it. It serializes the
+// writes which can't be guarded by the locks of the rows they change.
+func (c *DataCollection) AdvisoryLock(key int64) error {
+	_, err := c.Session().SQL().Exec("SELECT pg_advisory_xact_lock(?)", key)
+	return err
+}
+
+// Increment adds the given deltas to the columns of the records that
+// match the given Condition in a single UPDATE statement, so concurrent
+// increments never overwrite each other, and sets their updated_at to
+// the current time. ErrNoMoreRows is returned when no record matches.
+func (c *DataCollection) Increment(cond Condition, deltas map[string]int64) error {
+	var columns []string
+	for column := range deltas {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	var set []interface{}
+	for _, column := range columns {
+		set = append(set, column+" = "+column+" + ?", deltas[column])
+	}
+	set = append(set, "updated_at = ?", time.Now().UTC())
+	res, err := c.Session().SQL().
+		Update(c.Name()).
+		Set(set...).
+		Where(cond).
+		Exec()
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNoMoreRows
+	}
+	return nil
+}
+
 // Delete gets the records that matches the given Condition
 // and deletes them
 func (c *DataCollection) Delete(cond Condition) error {
@@ -109,6 +248,8 @@ func (c *DataCollection) CreateRelated(tableName string, dataAddress interface{}
 		InsertReturning(dataAddress)
 }
 
+// LoadMany2Many loads the records of a many to many relation
+// by joining the given tables
 func (c *DataCollection) LoadMany2Many(columns, from, join, on string, condition Condition, dataAddress interface{}) error {
 	return c.Session().SQL().
 		Select(db.Raw(columns)).From(from).
@@ -116,3 +257,29 @@ func (c *DataCollection) LoadMany2Many(columns, from, join, on string, condition
 		Where(condition).
 		All(dataAddress)
 }
+
+// Related returns a DataTable for the given table, it shares
+// the session and so the transaction of the current DataTable
+func (c *DataCollection) Related(tableName string) DataTable {
+	return &DataCollection{
+		Collection: c.Session().Collection(tableName),
+		inTx:       c.inTx,
+	}
+}
+
+// WithTx runs fn inside a database transaction with a DataTable
+// bound to it. The transaction is rolled back if fn returns an error
+// or panics and committed otherwise. Calling WithTx on a DataTable
+// that is already in a transaction reuses it.
+func (c *DataCollection) WithTx(fn func(tx DataTable) error) error {
+	if c.inTx {
+		return fn(c)
+	}
+	return c.Session().Tx(func(sess db.Session) error {
+		defer rollbackOnPanic(sess)
+		return fn(&DataCollection{
+			Collection: sess.Collection(c.Name()),
+			inTx:       true,
+		})
+	})
+}
@@ -14,3 +14,29 @@ func TestDataCollectionImplementsDataTable(t *testing.T) {
 	assert := assert.New(t)
 	assert.Implements((*DataTable)(nil), new(DataCollection))
 }
+
+func TestDataCollection_WithTxReusesOpenTransaction(t *testing.T) {
+	assert := assert.New(t)
+
+	collection := &DataCollection{inTx: true}
+	var got DataTable
+	err := collection.WithTx(func(tx DataTable) error {
+		got = tx
+		return nil
+	})
+	assert.Nil(err)
+	assert.Same(collection, got)
+}
+
+func TestClient_WithTxReusesOpenTransaction(t *testing.T) {
+	assert := assert.New(t)
+
+	client := &Client{inTx: true}
+	var got DataStorage
+	err := client.WithTx(func(tx DataStorage) error {
+		got = tx
+		return nil
+	})
+	assert.Nil(err)
+	assert.Same(client, got)
+}
@@ -0,0 +1,295 @@
+//go:build integration
+// +build integration
+
+package dbclient
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClient returns the client of the Postgres database given by the
+// TEST_DATABASE_* environment variables, the integration tests can't
+// run without it
+func testClient(t *testing.T) *Client {
+	host := os.Getenv("TEST_DATABASE_HOST")
+	if host == "" {
+		t.Fatal("TEST_DATABASE_HOST is not set")
+	}
+	return NewPostgresClient(
+		host,
+		os.Getenv("TEST_DATABASE_USER"),
+		os.Getenv("TEST_DATABASE_NAME"),
+		os.Getenv("TEST_DATABASE_PASS")).(*Client)
+}
+
+// testTable creates a table with the given columns for the test
+// and drops it when the test is over
+func testTable(t *testing.T, client *Client, columns string) string {
+	sess := *client.Session
+	table := strings.ToLower(fmt.Sprintf("%s_%d", strings.ReplaceAll(t.Name(), "/", "_"), os.Getpid()))
+	_, err := sess.SQL().Exec(fmt.Sprintf("CREATE TABLE %s (id bigserial primary key, %s)", table, columns))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sess.SQL().Exec(fmt.Sprintf("DROP TABLE %s", table))
+	})
+	return table
+}
+
+func TestDataCollection_WithTxRollsBack(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testClient(t)
+	defer client.Close()
+	table := testTable(t, client, "stock bigint not null")
+	collection := client.NewDataCollection(table)
+
+	type row struct {
+		ID    uint64 `db:"id,omitempty"`
+		Stock int64  `db:"stock"`
+	}
+	failure := errors.New("second write failed")
+	err := collection.WithTx(func(tx DataTable) error {
+		if err := tx.InsertReturning(&row{Stock: 10}); err != nil {
+			return err
+		}
+		return failure
+	})
+	assert.Equal(failure, err)
+	var rows []row
+	assert.Nil(collection.FindAll(&rows))
+	assert.Empty(rows, "the insert must be rolled back")
+
+	err = collection.WithTx(func(tx DataTable) error {
+		return tx.InsertReturning(&row{Stock: 10})
+	})
+	assert.Nil(err)
+	assert.Nil(collection.FindAll(&rows))
+	assert.Len(rows, 1)
+}
+
+func TestClient_WithTxRollsBack(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testClient(t)
+	defer client.Close()
+	table := testTable(t, client, "stock bigint not null")
+
+	failure := errors.New("second write failed")
+	err := client.WithTx(func(tx DataStorage) error {
+		stock := struct {
+			Stock int64 `db:"stock"`
+		}{Stock: 3}
+		if err := tx.NewDataCollection(table).InsertReturning(&stock); err != nil {
+			return err
+		}
+		return failure
+	})
+	assert.Equal(failure, err)
+	count, err := client.NewDataCollection(table).(*DataCollection).Find().Count()
+	assert.Nil(err)
+	assert.Zero(count, "the insert must be rolled back")
+}
+
+func TestDataCollection_WithTxRollsBackOnPanic(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testClient(t)
+	defer client.Close()
+	table := testTable(t, client, "stock bigint not null")
+	collection := client.NewDataCollection(table)
+	type row struct {
+		ID    uint64 `db:"id,omitempty"`
+		Stock int64  `db:"stock"`
+	}
+	stock := row{Stock: 10}
+	assert.Nil(collection.InsertReturning(&stock))
+
+	assert.Panics(func() {
+		collection.WithTx(func(tx DataTable) error {
+			var rows []row
+			if err := tx.FindForUpdate(Condition{"id": stock.ID}, &rows); err != nil {
+				return err
+			}
+			panic("handler failed")
+		})
+	})
+	assert.Panics(func() {
+		client.WithTx(func(tx DataStorage) error {
+			if err := tx.NewDataCollection(table).Increment(Condition{"id": stock.ID}, map[string]int64{"stock": -1}); err != nil {
+				return err
+			}
+			panic("handler failed")
+		})
+	})
+
+	// The row is locked by the transactions until they are rolled back
+	done := make(chan error, 1)
+	go func() {
+		done <- collection.WithTx(func(tx DataTable) error {
+			return tx.Increment(Condition{"id": stock.ID}, map[string]int64{"stock": 1})
+		})
+	}()
+	select {
+	case err := <-done:
+		assert.Nil(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the panicking transactions must not keep their locks")
+	}
+	assert.Nil(collection.FindOne(Condition{"id": stock.ID}, &stock))
+	assert.Equal(int64(11), stock.Stock, "the writes of the panicking transactions must be rolled back")
+}
+
+func TestDataCollection_IncrementConcurrently(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testClient(t)
+	defer client.Close()
+	table := testTable(t, client, "stock bigint not null, updated_at timestamp without time zone not null")
+	collection := client.NewDataCollection(table)
+
+	type row struct {
+		ID        uint64    `db:"id,omitempty"`
+		Stock     int64     `db:"stock"`
+		UpdatedAt time.Time `db:"updated_at"`
+	}
+	created := time.Now().UTC().Add(-time.Hour)
+	assert.Nil(collection.InsertReturning(&row{Stock: 1000, UpdatedAt: created}))
+
+	const orders = 500
+	var wg sync.WaitGroup
+	for i := 0; i < orders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(collection.Increment(Condition{"id": 1}, map[string]int64{"stock": -2}))
+		}()
+	}
+	wg.Wait()
+
+	var current row
+	assert.Nil(collection.FindOne(Condition{"id": 1}, &current))
+	assert.Equal(int64(1000-orders*2), current.Stock)
+	assert.True(current.UpdatedAt.After(created), "the increments set updated_at")
+	assert.Equal(ErrNoMoreRows, collection.Increment(Condition{"id": 2}, map[string]int64{"stock": 1}))
+}
+
+func TestDataCollection_FindForUpdateSerializesTheChecks(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testClient(t)
+	defer client.Close()
+	table := testTable(t, client, "stock bigint not null, updated_at timestamp without time zone not null")
+	collection := client.NewDataCollection(table)
+
+	type row struct {
+		ID        uint64    `db:"id,omitempty"`
+		Stock     int64     `db:"stock"`
+		UpdatedAt time.Time `db:"updated_at"`
+	}
+	assert.Nil(collection.InsertReturning(&row{Stock: 100, UpdatedAt: time.Now().UTC()}))
+
+	// Twice as many orders as the stock serves check it before taking
+	// their share, the lock keeps any of them from checking a stale stock
+	const orders = 100
+	errShort := errors.New("short")
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var served, short int
+	for i := 0; i < orders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := collection.WithTx(func(tx DataTable) error {
+				var locked []row
+				if err := tx.FindForUpdate(Condition{"id": 1}, &locked); err != nil {
+					return err
+				}
+				if locked[0].Stock < 2 {
+					return errShort
+				}
+				return tx.Increment(Condition{"id": 1}, map[string]int64{"stock": -2})
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			switch err {
+			case nil:
+				served++
+			case errShort:
+				short++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var current row
+	assert.Nil(collection.FindOne(Condition{"id": 1}, &current))
+	assert.Equal(int64(0), current.Stock)
+	assert.Equal(50, served)
+	assert.Equal(50, short)
+}
+
+func TestDataCollection_AdvisoryLockSerializesTheWrites(t *testing.T) {
+	assert := assert.New(t)
+
+	client := testClient(t)
+	defer client.Close()
+	table := testTable(t, client, "name text not null")
+	collection := client.NewDataCollection(table)
+
+	type row struct {
+		ID   uint64 `db:"id,omitempty"`
+		Name string `db:"name"`
+	}
+
+	// Every writer checks that the name is free before inserting it,
+	// no row is locked by the check so the lock has to serialize them
+	const writers = 20
+	errTaken := errors.New("taken")
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var written, taken int
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := collection.WithTx(func(tx DataTable) error {
+				if err := tx.AdvisoryLock(42); err != nil {
+					return err
+				}
+				var existing []row
+				if err := tx.FindRelated(table, Condition{"name": "leg"}, &existing); err != nil {
+					return err
+				}
+				if len(existing) > 0 {
+					return errTaken
+				}
+				return tx.InsertReturning(&row{Name: "leg"})
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			switch err {
+			case nil:
+				written++
+			case errTaken:
+				taken++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(1, written)
+	assert.Equal(writers-1, taken)
+}
@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateWarehouseStockTable, downCreateWarehouseStockTable)
+}
+
+func upCreateWarehouseStockTable(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	_, err := tx.Exec(`CREATE TABLE warehouse_stock (
+    						id bigserial primary key,
+    						warehouse_id bigint not null,
+    						article_id bigint not null,
+    						created_at  timestamp without time zone DEFAULT now() NOT NULL,
+    						updated_at  timestamp without time zone DEFAULT now() NOT NULL, 
+    						quantity bigint not null,
+
+    						CONSTRAINT uq_warehouse_stock_article
+									UNIQUE(warehouse_id, article_id),
+    						CONSTRAINT fk_warehouse
+									FOREIGN KEY(warehouse_id) 
+									REFERENCES warehouses(id)
+									ON DELETE CASCADE,
+    						CONSTRAINT fk_article
+									FOREIGN KEY(article_id) 
+									REFERENCES articles(id)
+									ON DELETE CASCADE
+						);`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downCreateWarehouseStockTable(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec("DROP TABLE warehouse_stock;")
+	if err != nil {
+		return err
+	}
+	return nil
+}
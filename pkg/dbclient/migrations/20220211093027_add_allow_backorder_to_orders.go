@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddAllowBackorderToOrders, downAddAllowBackorderToOrders)
+}
+
+func upAddAllowBackorderToOrders(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	_, err := tx.Exec(`ALTER TABLE orders
+    						ADD COLUMN allow_backorder boolean DEFAULT false NOT NULL;`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downAddAllowBackorderToOrders(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec("ALTER TABLE orders DROP COLUMN allow_backorder;")
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddStatusToOrdersAndReservedStock, downAddStatusToOrdersAndReservedStock)
+}
+
+func upAddStatusToOrdersAndReservedStock(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// Stock of the existing orders was decreased when they were created,
+	// so they are considered as shipped.
+	_, err := tx.Exec(`ALTER TABLE orders
+    						ADD COLUMN status varchar(32) DEFAULT 'shipped' NOT NULL;`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE orders ALTER COLUMN status SET DEFAULT 'draft';`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE warehouse_stock
+    						ADD COLUMN reserved bigint DEFAULT 0 NOT NULL;`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downAddStatusToOrdersAndReservedStock(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec("ALTER TABLE warehouse_stock DROP COLUMN reserved;")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE orders DROP COLUMN status;")
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateReservationsTable, downCreateReservationsTable)
+}
+
+func upCreateReservationsTable(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	_, err := tx.Exec(`CREATE TABLE reservations (
+    						id bigserial primary key,
+    						product_id bigint not null,
+    						warehouse_id bigint not null,
+    						order_id bigint,
+    						created_at  timestamp without time zone DEFAULT now() NOT NULL,
+    						updated_at  timestamp without time zone DEFAULT now() NOT NULL, 
+    						quantity bigint not null,
+    						status varchar(32) DEFAULT 'active' NOT NULL,
+    						expires_at timestamp without time zone NOT NULL,
+
+    						CONSTRAINT fk_product
+									FOREIGN KEY(product_id) 
+									REFERENCES products(id)
+									ON DELETE CASCADE,
+    						CONSTRAINT fk_warehouse
+									FOREIGN KEY(warehouse_id) 
+									REFERENCES warehouses(id)
+									ON DELETE CASCADE,
+    						CONSTRAINT fk_order
+									FOREIGN KEY(order_id) 
+									REFERENCES orders(id)
+									ON DELETE SET NULL
+						);`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE INDEX idx_reservations_status_expires_at
+							ON reservations(status, expires_at);`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downCreateReservationsTable(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec("DROP TABLE reservations;")
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateStockMovementsTable, downCreateStockMovementsTable)
+}
+
+func upCreateStockMovementsTable(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// Movements outlive the articles, warehouses and orders they refer to,
+	// so that the history can always be traced back.
+	_, err := tx.Exec(`CREATE TABLE stock_movements (
+    						id bigserial primary key,
+    						created_at  timestamp without time zone DEFAULT now() NOT NULL,
+    						article_id bigint not null,
+    						warehouse_id bigint,
+    						delta bigint not null,
+    						reason varchar(32) not null,
+    						order_id bigint,
+    						receipt_id bigint,
+    						actor varchar(255) not null
+						);`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE INDEX idx_stock_movements_article_created_at
+							ON stock_movements(article_id, created_at);`)
+	if err != nil {
+		return err
+	}
+
+	// The ledger is append only
+	_, err = tx.Exec(`CREATE FUNCTION stock_movements_immutable() RETURNS trigger AS $$
+							BEGIN
+								RAISE EXCEPTION 'stock movements can not be changed';
+							END;
+						$$ LANGUAGE plpgsql;`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE TRIGGER tr_stock_movements_immutable
+							BEFORE UPDATE OR DELETE ON stock_movements
+							FOR EACH ROW EXECUTE PROCEDURE stock_movements_immutable();`)
+	if err != nil {
+		return err
+	}
+
+	// Open the ledger with the current stock so that the articles' stock
+	// keeps being the sum of their movements
+	_, err = tx.Exec(`INSERT INTO stock_movements (article_id, warehouse_id, delta, reason, actor)
+							SELECT article_id, warehouse_id, quantity, 'initial', 'system'
+							FROM warehouse_stock
+							WHERE quantity <> 0;`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO stock_movements (article_id, delta, reason, actor)
+							SELECT a.id, a.stock - COALESCE(SUM(ws.quantity), 0), 'initial', 'system'
+							FROM articles a
+							LEFT JOIN warehouse_stock ws ON ws.article_id = a.id
+							GROUP BY a.id, a.stock
+							HAVING a.stock - COALESCE(SUM(ws.quantity), 0) <> 0;`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downCreateStockMovementsTable(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec("DROP TABLE stock_movements;")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DROP FUNCTION stock_movements_immutable();")
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateOutboxTable, downCreateOutboxTable)
+}
+
+func upCreateOutboxTable(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	_, err := tx.Exec(`CREATE TABLE outbox (
+    						id bigserial primary key,
+    						created_at  timestamp without time zone DEFAULT now() NOT NULL,
+    						topic varchar(255) not null,
+    						uuid varchar(36) not null,
+    						payload jsonb not null,
+    						metadata jsonb,
+    						attempts integer DEFAULT 0 NOT NULL,
+    						last_error text DEFAULT '' NOT NULL,
+    						published_at timestamp without time zone
+						);`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE INDEX idx_outbox_unpublished
+							ON outbox(id) WHERE published_at IS NULL;`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downCreateOutboxTable(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec("DROP TABLE outbox;")
+	if err != nil {
+		return err
+	}
+	return nil
+}
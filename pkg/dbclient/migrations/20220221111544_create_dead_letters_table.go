@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateDeadLettersTable, downCreateDeadLettersTable)
+}
+
+func upCreateDeadLettersTable(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	_, err := tx.Exec(`CREATE TABLE dead_letters (
+    						id bigserial primary key,
+    						created_at  timestamp without time zone DEFAULT now() NOT NULL,
+    						topic varchar(255) not null,
+    						handler varchar(255) DEFAULT '' NOT NULL,
+    						reason text DEFAULT '' NOT NULL,
+    						uuid varchar(36) not null,
+    						payload jsonb not null,
+    						metadata jsonb,
+    						replayed_at timestamp without time zone
+						);`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downCreateDeadLettersTable(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec("DROP TABLE dead_letters;")
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateProcessedMessagesTable, downCreateProcessedMessagesTable)
+}
+
+func upCreateProcessedMessagesTable(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	_, err := tx.Exec(`CREATE TABLE processed_messages (
+    						message_uuid varchar(36) not null,
+    						handler varchar(255) not null,
+    						processed_at timestamp without time zone DEFAULT now() NOT NULL,
+
+    						PRIMARY KEY(message_uuid, handler)
+						);`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downCreateProcessedMessagesTable(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec("DROP TABLE processed_messages;")
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateWebhookTables, downCreateWebhookTables)
+}
+
+func upCreateWebhookTables(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	_, err := tx.Exec(`CREATE TABLE webhook_subscriptions (
+    						id bigserial primary key,
+    						created_at  timestamp without time zone DEFAULT now() NOT NULL,
+    						updated_at timestamp without time zone DEFAULT now() NOT NULL,
+    						url text not null,
+    						event_types text[] not null,
+    						secret varchar(255) not null
+						);
+
+						CREATE TABLE webhook_deliveries (
+    						id bigserial primary key,
+    						created_at  timestamp without time zone DEFAULT now() NOT NULL,
+    						subscription_id bigint not null references webhook_subscriptions(id) ON DELETE CASCADE,
+    						event_id varchar(36) not null,
+    						event_name varchar(255) not null,
+    						payload text not null,
+    						status varchar(16) DEFAULT 'pending' NOT NULL,
+    						attempts integer DEFAULT 0 NOT NULL,
+    						response_code integer DEFAULT 0 NOT NULL,
+    						last_error text DEFAULT '' NOT NULL,
+    						next_attempt_at timestamp without time zone DEFAULT now() NOT NULL,
+    						delivered_at timestamp without time zone
+						);
+
+						CREATE INDEX webhook_deliveries_subscription_id_idx ON webhook_deliveries (subscription_id);
+						CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at)
+							WHERE status = 'pending';`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downCreateWebhookTables(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec("DROP TABLE webhook_deliveries; DROP TABLE webhook_subscriptions;")
+	if err != nil {
+		return err
+	}
+	return nil
+}
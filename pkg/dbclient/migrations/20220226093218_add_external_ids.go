@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddExternalIds, downAddExternalIds)
+}
+
+func upAddExternalIds(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// The imported articles and products are matched by the ids they
+	// have in the files they are imported from, the ones created over
+	// the API don't have any.
+	_, err := tx.Exec(`ALTER TABLE articles ADD COLUMN external_id varchar(255);
+						CREATE UNIQUE INDEX articles_external_id_idx ON articles (external_id);
+
+						ALTER TABLE products ADD COLUMN external_id varchar(255);
+						CREATE UNIQUE INDEX products_external_id_idx ON products (external_id);`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downAddExternalIds(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec(`ALTER TABLE products DROP COLUMN external_id;
+						ALTER TABLE articles DROP COLUMN external_id;`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateProductComponentsTable, downCreateProductComponentsTable)
+}
+
+func upCreateProductComponentsTable(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// A product contains amount_of of its component products next to its
+	// own articles, the cycles through several products are checked by the
+	// application.
+	_, err := tx.Exec(`CREATE TABLE product_components (
+    						id bigserial primary key,
+    						product_id bigint not null,
+    						component_id bigint not null,
+    						amount_of bigint not null,
+    						CONSTRAINT fk_products
+									FOREIGN KEY(product_id)
+									REFERENCES products(id)
+									ON DELETE CASCADE,
+
+    						CONSTRAINT fk_components
+									FOREIGN KEY(component_id)
+									REFERENCES products(id)
+									ON DELETE CASCADE,
+
+    						CONSTRAINT chk_product_components_self
+									CHECK (product_id <> component_id)
+						);`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE INDEX idx_product_components_component_id
+							ON product_components(component_id);`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downCreateProductComponentsTable(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec("DROP TABLE product_components;")
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddOrderLineArticles, downAddOrderLineArticles)
+}
+
+func upAddOrderLineArticles(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// The lines keep the articles their stock is reserved with, so the
+	// reserved stock is released and consumed in the same amounts even
+	// when the bill of materials of the product changes in the meantime.
+	_, err := tx.Exec(`ALTER TABLE order_lines ADD COLUMN articles jsonb;`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downAddOrderLineArticles(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec(`ALTER TABLE order_lines DROP COLUMN articles;`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddReservationArticles, downAddReservationArticles)
+}
+
+func upAddReservationArticles(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// The reservations keep the articles their stock is held with, the
+	// order lines they are converted into take them over with the stock.
+	_, err := tx.Exec(`ALTER TABLE reservations ADD COLUMN articles jsonb;`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downAddReservationArticles(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec(`ALTER TABLE reservations DROP COLUMN articles;`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddOutboxClaims, downAddOutboxClaims)
+}
+
+func upAddOutboxClaims(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// The relays claim the messages until claimed_until while they publish
+	// them, the messages failing too often are parked and not relayed anymore.
+	_, err := tx.Exec(`ALTER TABLE outbox
+							ADD COLUMN claimed_until timestamp without time zone DEFAULT now() NOT NULL,
+							ADD COLUMN parked_at timestamp without time zone;`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DROP INDEX idx_outbox_unpublished;`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE INDEX idx_outbox_unpublished
+							ON outbox(id) WHERE published_at IS NULL AND parked_at IS NULL;`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE INDEX idx_outbox_published_at
+							ON outbox(published_at) WHERE published_at IS NOT NULL;`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downAddOutboxClaims(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec(`DROP INDEX idx_outbox_published_at;`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DROP INDEX idx_outbox_unpublished;`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE outbox DROP COLUMN claimed_until, DROP COLUMN parked_at;`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE INDEX idx_outbox_unpublished
+							ON outbox(id) WHERE published_at IS NULL;`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"database/sql"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upRestrictComponentDeletes, downRestrictComponentDeletes)
+}
+
+func upRestrictComponentDeletes(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	// A product used as a component can't be deleted, deleting it would
+	// silently change the bill of materials of the products containing it.
+	_, err := tx.Exec(`ALTER TABLE product_components
+							DROP CONSTRAINT fk_components,
+							ADD CONSTRAINT fk_components
+								FOREIGN KEY(component_id)
+								REFERENCES products(id)
+								ON DELETE RESTRICT;`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downRestrictComponentDeletes(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	_, err := tx.Exec(`ALTER TABLE product_components
+							DROP CONSTRAINT fk_components,
+							ADD CONSTRAINT fk_components
+								FOREIGN KEY(component_id)
+								REFERENCES products(id)
+								ON DELETE CASCADE;`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
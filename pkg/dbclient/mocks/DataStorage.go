@@ -3,6 +3,8 @@
 package mocks
 
 import (
+	sql "database/sql"
+
 	mock "github.com/stretchr/testify/mock"
 	dbclient "github.com/unicod3/horreum/pkg/dbclient"
 )
@@ -12,6 +14,20 @@ type DataStorage struct {
 	mock.Mock
 }
 
+// Close provides a mock function with given fields:
+func (_m *DataStorage) Close() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // NewDataCollection provides a mock function with given fields: tableName
 func (_m *DataStorage) NewDataCollection(tableName string) dbclient.DataTable {
 	ret := _m.Called(tableName)
@@ -27,3 +43,33 @@ func (_m *DataStorage) NewDataCollection(tableName string) dbclient.DataTable {
 
 	return r0
 }
+
+// SQLDB provides a mock function with given fields:
+func (_m *DataStorage) SQLDB() *sql.DB {
+	ret := _m.Called()
+
+	var r0 *sql.DB
+	if rf, ok := ret.Get(0).(func() *sql.DB); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*sql.DB)
+		}
+	}
+
+	return r0
+}
+
+// WithTx provides a mock function with given fields: fn
+func (_m *DataStorage) WithTx(fn func(dbclient.DataStorage) error) error {
+	ret := _m.Called(fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(func(dbclient.DataStorage) error) error); ok {
+		r0 = rf(fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -3,6 +3,7 @@
 package mocks
 
 import (
+	dbclient "github.com/unicod3/horreum/pkg/dbclient"
 	db "github.com/upper/db/v4"
 
 	mock "github.com/stretchr/testify/mock"
@@ -13,6 +14,20 @@ type DataTable struct {
 	mock.Mock
 }
 
+// AdvisoryLock provides a mock function with given fields: key
+func (_m *DataTable) AdvisoryLock(key int64) error {
+	ret := _m.Called(key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int64) error); ok {
+		r0 = rf(key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Count provides a mock function with given fields:
 func (_m *DataTable) Count() (uint64, error) {
 	ret := _m.Called()
@@ -129,6 +144,34 @@ func (_m *DataTable) FindAll(dataAddress interface{}) error {
 	return r0
 }
 
+// FindForUpdate provides a mock function with given fields: cond, dataAddress
+func (_m *DataTable) FindForUpdate(cond db.Cond, dataAddress interface{}) error {
+	ret := _m.Called(cond, dataAddress)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(db.Cond, interface{}) error); ok {
+		r0 = rf(cond, dataAddress)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// FindForUpdateSkipLocked provides a mock function with given fields: cond, limit, dataAddress
+func (_m *DataTable) FindForUpdateSkipLocked(cond db.Cond, limit int, dataAddress interface{}) error {
+	ret := _m.Called(cond, limit, dataAddress)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(db.Cond, int, interface{}) error); ok {
+		r0 = rf(cond, limit, dataAddress)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // FindOne provides a mock function with given fields: cond, dataAddress
 func (_m *DataTable) FindOne(cond db.Cond, dataAddress interface{}) error {
 	ret := _m.Called(cond, dataAddress)
@@ -143,6 +186,27 @@ func (_m *DataTable) FindOne(cond db.Cond, dataAddress interface{}) error {
 	return r0
 }
 
+// FindPage provides a mock function with given fields: query, dataAddress
+func (_m *DataTable) FindPage(query dbclient.PageQuery, dataAddress interface{}) (string, error) {
+	ret := _m.Called(query, dataAddress)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(dbclient.PageQuery, interface{}) string); ok {
+		r0 = rf(query, dataAddress)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbclient.PageQuery, interface{}) error); ok {
+		r1 = rf(query, dataAddress)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FindRelated provides a mock function with given fields: tableName, condition, dataAddress
 func (_m *DataTable) FindRelated(tableName string, condition db.Cond, dataAddress interface{}) error {
 	ret := _m.Called(tableName, condition, dataAddress)
@@ -157,6 +221,20 @@ func (_m *DataTable) FindRelated(tableName string, condition db.Cond, dataAddres
 	return r0
 }
 
+// Increment provides a mock function with given fields: cond, deltas
+func (_m *DataTable) Increment(cond db.Cond, deltas map[string]int64) error {
+	ret := _m.Called(cond, deltas)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(db.Cond, map[string]int64) error); ok {
+		r0 = rf(cond, deltas)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Insert provides a mock function with given fields: _a0
 func (_m *DataTable) Insert(_a0 interface{}) (db.InsertResult, error) {
 	ret := _m.Called(_a0)
@@ -222,6 +300,22 @@ func (_m *DataTable) Name() string {
 	return r0
 }
 
+// Related provides a mock function with given fields: tableName
+func (_m *DataTable) Related(tableName string) dbclient.DataTable {
+	ret := _m.Called(tableName)
+
+	var r0 dbclient.DataTable
+	if rf, ok := ret.Get(0).(func(string) dbclient.DataTable); ok {
+		r0 = rf(tableName)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(dbclient.DataTable)
+		}
+	}
+
+	return r0
+}
+
 // Session provides a mock function with given fields:
 func (_m *DataTable) Session() db.Session {
 	ret := _m.Called()
@@ -265,3 +359,17 @@ func (_m *DataTable) UpdateReturning(_a0 interface{}) error {
 
 	return r0
 }
+
+// WithTx provides a mock function with given fields: fn
+func (_m *DataTable) WithTx(fn func(dbclient.DataTable) error) error {
+	ret := _m.Called(fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(func(dbclient.DataTable) error) error); ok {
+		r0 = rf(fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -0,0 +1,454 @@
+package dbclient
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/upper/db/v4"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPageLimit is the number of records a page holds unless a limit is given
+	DefaultPageLimit = 50
+	// MaxPageLimit is the highest number of records a page can hold
+	MaxPageLimit = 500
+	// NextCursorHeader is the response header holding the cursor of the next page
+	NextCursorHeader = "X-Next-Cursor"
+)
+
+// ErrInvalidPageQuery is returned when the params of a page can't be resolved
+var ErrInvalidPageQuery = errors.New("invalid page query")
+
+// Expr is a condition which can be combined with the others of a query
+type Expr = db.LogicalExpr
+
+// Raw returns an Expr of the given SQL and its arguments
+func Raw(sql string, args ...interface{}) Expr {
+	return db.Raw(sql, args...)
+}
+
+// FieldType tells how the values of a field are parsed
+type FieldType int
+
+const (
+	StringField FieldType = iota
+	IntField
+	TimeField
+)
+
+// Field is a field the records of a listing can be filtered and sorted by
+type Field struct {
+	Type FieldType
+	// Condition builds the condition of a filter on a field which isn't a
+	// column of the table, such fields can't be sorted by
+	Condition func(operator string, value interface{}) Expr
+}
+
+// Fields maps the names of the fields of a listing to their definitions,
+// the id column is always part of it
+type Fields map[string]Field
+
+func (fields Fields) lookup(name string) (Field, bool) {
+	if name == "id" {
+		return Field{Type: IntField}, true
+	}
+	field, ok := fields[name]
+	return field, ok
+}
+
+// Filter compares a field with a value
+type Filter struct {
+	Field    string
+	Operator string
+	Value    interface{}
+}
+
+// SortField is a field the records are ordered by
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+func (s SortField) String() string {
+	if s.Desc {
+		return "-" + s.Field
+	}
+	return s.Field
+}
+
+// PageQuery describes a page of a listing, the records matching the
+// Filters are ordered by Sort and the page starts after the record the
+// cursor it was parsed with points to. The id is always the last field
+// the records are sorted by so every record has a distinct position.
+type PageQuery struct {
+	Fields  Fields
+	Filters []Filter
+	Sort    []SortField
+	Limit   int
+	after   []interface{}
+}
+
+// cursor is the position of the last record of a page
+type cursor struct {
+	Sort   string   `json:"sort"`
+	Values []string `json:"values"`
+}
+
+// ParsePageQuery resolves the page of the listing with given fields from the
+// query params. Besides the limit, the cursor and the comma separated sort
+// fields, which are descending when prefixed with a dash, the params filter
+// the records by their fields:
+//
+//	field=value          equals, repeated values match any of them
+//	field~=value         contains value, case insensitively
+//	field_lt=value       less than, _lte, _gt and _gte are alike
+//	created_after=value  created_at is after value, _before is alike
+func ParsePageQuery(values url.Values, fields Fields) (PageQuery, error) {
+	query := PageQuery{Fields: fields, Limit: DefaultPageLimit}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > MaxPageLimit {
+			return query, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidPageQuery, MaxPageLimit)
+		}
+		query.Limit = n
+	}
+
+	if fieldNames := values.Get("sort"); fieldNames != "" {
+		for _, name := range strings.Split(fieldNames, ",") {
+			s := SortField{Field: strings.TrimPrefix(name, "-"), Desc: strings.HasPrefix(name, "-")}
+			field, ok := fields.lookup(s.Field)
+			if !ok || field.Condition != nil {
+				return query, fmt.Errorf("%w: can't sort by %q", ErrInvalidPageQuery, s.Field)
+			}
+			query.Sort = append(query.Sort, s)
+		}
+	}
+	query.Sort = query.sort()
+
+	var keys []string
+	for key := range values {
+		switch key {
+		case "limit", "sort", "cursor":
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		filters, err := parseFilters(fields, key, values[key])
+		if err != nil {
+			return query, err
+		}
+		query.Filters = append(query.Filters, filters...)
+	}
+
+	if c := values.Get("cursor"); c != "" {
+		after, err := query.decodeCursor(c)
+		if err != nil {
+			return query, err
+		}
+		query.after = after
+	}
+	return query, nil
+}
+
+// filterSuffixes are the suffixes of the params comparing a field other
+// than by equality, the field of a param is its name without the suffix
+// and with the column suffix appended. The order matters as _lt is a
+// suffix of _lte as well.
+var filterSuffixes = []struct {
+	suffix   string
+	operator string
+	column   string
+	types    []FieldType
+}{
+	{"~", "ILIKE", "", []FieldType{StringField}},
+	{"_lte", "<=", "", nil},
+	{"_lt", "<", "", nil},
+	{"_gte", ">=", "", nil},
+	{"_gt", ">", "", nil},
+	{"_after", ">", "_at", []FieldType{TimeField}},
+	{"_before", "<", "_at", []FieldType{TimeField}},
+}
+
+// parseFilters returns the filters of a query param
+func parseFilters(fields Fields, key string, values []string) ([]Filter, error) {
+	name, operator := key, "="
+	field, ok := fields.lookup(key)
+	if !ok {
+		for _, s := range filterSuffixes {
+			if !strings.HasSuffix(key, s.suffix) {
+				continue
+			}
+			name, operator = strings.TrimSuffix(key, s.suffix)+s.column, s.operator
+			field, ok = fields.lookup(name)
+			ok = ok && (s.types == nil || hasType(s.types, field.Type))
+			break
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown filter %q", ErrInvalidPageQuery, key)
+	}
+
+	var parsed []interface{}
+	for _, value := range values {
+		v, err := parseValue(field.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPageQuery, key, err)
+		}
+		if operator == "ILIKE" {
+			v = "%" + likeEscaper.Replace(value) + "%"
+		}
+		parsed = append(parsed, v)
+	}
+
+	if operator == "=" && len(parsed) > 1 {
+		return []Filter{{Field: name, Operator: "IN", Value: parsed}}, nil
+	}
+	var filters []Filter
+	for _, v := range parsed {
+		filters = append(filters, Filter{Field: name, Operator: operator, Value: v})
+	}
+	return filters, nil
+}
+
+func hasType(types []FieldType, fieldType FieldType) bool {
+	for _, t := range types {
+		if t == fieldType {
+			return true
+		}
+	}
+	return false
+}
+
+// likeEscaper escapes the wildcards of the ILIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// parseValue parses a param or a cursor value of a field, the values
+// of the time fields are RFC3339 times or dates
+func parseValue(fieldType FieldType, value string) (interface{}, error) {
+	switch fieldType {
+	case IntField:
+		return strconv.ParseInt(value, 10, 64)
+	case TimeField:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", value); err != nil {
+				return nil, err
+			}
+		}
+		return t.UTC(), nil
+	default:
+		return value, nil
+	}
+}
+
+// sort returns the sort fields ending with the id
+func (query PageQuery) sort() []SortField {
+	for _, s := range query.Sort {
+		if s.Field == "id" {
+			return query.Sort
+		}
+	}
+	return append(append([]SortField(nil), query.Sort...), SortField{Field: "id"})
+}
+
+func (query PageQuery) sortKey() string {
+	var names []string
+	for _, s := range query.sort() {
+		names = append(names, s.String())
+	}
+	return strings.Join(names, ",")
+}
+
+func (query PageQuery) limit() int {
+	if query.Limit < 1 || query.Limit > MaxPageLimit {
+		return DefaultPageLimit
+	}
+	return query.Limit
+}
+
+// Condition returns the condition matching the records of the page,
+// it is nil when every record is matched
+func (query PageQuery) Condition() Expr {
+	var conds []Expr
+	for _, f := range query.Filters {
+		if field, _ := query.Fields.lookup(f.Field); field.Condition != nil {
+			conds = append(conds, field.Condition(f.Operator, f.Value))
+			continue
+		}
+		key := f.Field
+		if f.Operator != "=" {
+			key += " " + f.Operator
+		}
+		conds = append(conds, db.Cond{key: f.Value})
+	}
+	if query.after != nil {
+		conds = append(conds, query.keyset())
+	}
+	if len(conds) == 0 {
+		return nil
+	}
+	return db.And(conds...)
+}
+
+// keyset returns the condition matching the records after the cursor,
+// a record is after it when it is after the cursor on a sort field
+// and equal to it on all the fields before that one
+func (query PageQuery) keyset() Expr {
+	var or []Expr
+	for _, cond := range query.keysetConds() {
+		or = append(or, cond)
+	}
+	return db.Or(or...)
+}
+
+func (query PageQuery) keysetConds() []Condition {
+	fields := query.sort()
+	var conds []Condition
+	for i, s := range fields {
+		cond := Condition{}
+		for j := 0; j < i; j++ {
+			cond[fields[j].Field] = query.after[j]
+		}
+		operator := " >"
+		if s.Desc {
+			operator = " <"
+		}
+		cond[s.Field+operator] = query.after[i]
+		conds = append(conds, cond)
+	}
+	return conds
+}
+
+// OrderBy returns the sort fields in the form the query builder takes them
+func (query PageQuery) OrderBy() []interface{} {
+	var orderBy []interface{}
+	for _, s := range query.sort() {
+		orderBy = append(orderBy, s.String())
+	}
+	return orderBy
+}
+
+// After returns the query of the page the given cursor points to, it is
+// used to walk all the pages of a listing with the same filters and sort
+func (query PageQuery) After(cursor string) (PageQuery, error) {
+	after, err := query.decodeCursor(cursor)
+	if err != nil {
+		return query, err
+	}
+	query.after = after
+	return query, nil
+}
+
+func (query PageQuery) decodeCursor(s string) ([]interface{}, error) {
+	invalid := fmt.Errorf("%w: invalid cursor", ErrInvalidPageQuery)
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, invalid
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, invalid
+	}
+	fields := query.sort()
+	if c.Sort != query.sortKey() || len(c.Values) != len(fields) {
+		return nil, fmt.Errorf("%w: cursor doesn't match the sort", ErrInvalidPageQuery)
+	}
+
+	var after []interface{}
+	for i, s := range fields {
+		field, _ := query.Fields.lookup(s.Field)
+		v, err := parseValue(field.Type, c.Values[i])
+		if err != nil {
+			return nil, invalid
+		}
+		after = append(after, v)
+	}
+	return after, nil
+}
+
+// Next cuts the records written to dataAddress, which were read with one
+// more than the limit, down to the page and returns the cursor of the next
+// page. The cursor is empty when there isn't any record after the page.
+func (query PageQuery) Next(dataAddress interface{}) (string, error) {
+	records := reflect.ValueOf(dataAddress).Elem()
+	if records.Len() <= query.limit() {
+		return "", nil
+	}
+	records.SetLen(query.limit())
+	last := reflect.Indirect(records.Index(query.limit() - 1))
+
+	c := cursor{Sort: query.sortKey()}
+	for _, s := range query.sort() {
+		value, err := columnValue(last, s.Field)
+		if err != nil {
+			return "", err
+		}
+		c.Values = append(c.Values, value)
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// columnValue formats the value of the struct field tagged with the column
+func columnValue(record reflect.Value, column string) (string, error) {
+	for i := 0; i < record.NumField(); i++ {
+		tag := strings.Split(record.Type().Field(i).Tag.Get("db"), ",")[0]
+		if tag != column {
+			continue
+		}
+		switch v := record.Field(i).Interface().(type) {
+		case time.Time:
+			return v.UTC().Format(time.RFC3339Nano), nil
+		default:
+			return fmt.Sprint(v), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no %s column", record.Type(), column)
+}
+
+// FindPage gets the records of the page the PageQuery describes and writes
+// them to given address, the returned cursor points to the next page and
+// is empty when it is the last one
+func (c *DataCollection) FindPage(query PageQuery, dataAddress interface{}) (string, error) {
+	selector := c.Session().SQL().SelectFrom(c.Name())
+	if cond := query.Condition(); cond != nil {
+		selector = selector.Where(cond)
+	}
+	err := selector.
+		OrderBy(query.OrderBy()...).
+		Limit(query.limit() + 1).
+		All(dataAddress)
+	if err != nil {
+		return "", err
+	}
+	return query.Next(dataAddress)
+}
+
+// WritePageHeaders writes the cursor of the next page and the Link
+// to it, based on the URL the page is requested with, to the header
+func WritePageHeaders(header http.Header, requestURL *url.URL, next string) {
+	if next == "" {
+		return
+	}
+	u := *requestURL
+	params := u.Query()
+	params.Set("cursor", next)
+	u.RawQuery = params.Encode()
+
+	header.Set(NextCursorHeader, next)
+	header.Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.String()))
+}
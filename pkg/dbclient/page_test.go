@@ -0,0 +1,201 @@
+package dbclient
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/upper/db/v4"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type pageRecord struct {
+	ID        uint64    `db:"id,omitempty"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at,omitempty"`
+}
+
+var pageFields = Fields{
+	"name":       {Type: StringField},
+	"stock":      {Type: IntField},
+	"status":     {Type: StringField},
+	"created_at": {Type: TimeField},
+}
+
+func TestParsePageQuery_Defaults(t *testing.T) {
+	assert := assert.New(t)
+
+	query, err := ParsePageQuery(url.Values{}, pageFields)
+	assert.Nil(err)
+	assert.Equal(DefaultPageLimit, query.Limit)
+	assert.Equal([]SortField{{Field: "id"}}, query.Sort)
+	assert.Empty(query.Filters)
+	assert.Nil(query.Condition())
+	assert.Equal([]interface{}{"id"}, query.OrderBy())
+}
+
+func TestParsePageQuery_Filters(t *testing.T) {
+	assert := assert.New(t)
+
+	query, err := ParsePageQuery(url.Values{
+		"name~":         {"a_b"},
+		"stock_lt":      {"5"},
+		"stock_gte":     {"1"},
+		"created_after": {"2022-01-02"},
+		"status":        {"draft", "placed"},
+		"limit":         {"10"},
+		"sort":          {"-name,created_at"},
+	}, pageFields)
+	assert.Nil(err)
+	assert.Equal(10, query.Limit)
+	assert.Equal([]SortField{{Field: "name", Desc: true}, {Field: "created_at"}, {Field: "id"}}, query.Sort)
+	assert.Equal([]Filter{
+		{Field: "created_at", Operator: ">", Value: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{Field: "name", Operator: "ILIKE", Value: `%a\_b%`},
+		{Field: "status", Operator: "IN", Value: []interface{}{"draft", "placed"}},
+		{Field: "stock", Operator: ">=", Value: int64(1)},
+		{Field: "stock", Operator: "<", Value: int64(5)},
+	}, query.Filters)
+	assert.Equal([]Expr{
+		db.Cond{"created_at >": time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)},
+		db.Cond{"name ILIKE": `%a\_b%`},
+		db.Cond{"status IN": []interface{}{"draft", "placed"}},
+		db.Cond{"stock >=": int64(1)},
+		db.Cond{"stock <": int64(5)},
+	}, query.Condition().Expressions())
+	assert.Equal([]interface{}{"-name", "created_at", "id"}, query.OrderBy())
+}
+
+func TestParsePageQuery_FieldCondition(t *testing.T) {
+	assert := assert.New(t)
+
+	inWarehouse := func(operator string, value interface{}) Expr {
+		return Raw("id IN (SELECT article_id FROM warehouse_stock WHERE warehouse_id "+operator+" ?)", value)
+	}
+	fields := Fields{"warehouse_id": {Type: IntField, Condition: inWarehouse}}
+
+	query, err := ParsePageQuery(url.Values{"warehouse_id": {"3"}}, fields)
+	assert.Nil(err)
+	conds := query.Condition().Expressions()
+	assert.Len(conds, 1)
+	raw := conds[0].(*db.RawExpr)
+	assert.Equal("id IN (SELECT article_id FROM warehouse_stock WHERE warehouse_id = ?)", raw.Raw())
+	assert.Equal([]interface{}{int64(3)}, raw.Arguments())
+
+	_, err = ParsePageQuery(url.Values{"sort": {"warehouse_id"}}, fields)
+	assert.ErrorIs(err, ErrInvalidPageQuery)
+}
+
+func TestParsePageQuery_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, values := range []url.Values{
+		{"limit": {"0"}},
+		{"limit": {"501"}},
+		{"limit": {"ten"}},
+		{"sort": {"price"}},
+		{"price": {"1"}},
+		{"stock~": {"1"}},
+		{"stock_lt": {"many"}},
+		{"name_after": {"2022-01-02"}},
+		{"created_after": {"yesterday"}},
+		{"cursor": {"not a cursor"}},
+	} {
+		_, err := ParsePageQuery(values, pageFields)
+		assert.ErrorIs(err, ErrInvalidPageQuery, values.Encode())
+	}
+}
+
+func TestPageQuery_Next(t *testing.T) {
+	assert := assert.New(t)
+
+	query, err := ParsePageQuery(url.Values{"sort": {"-name"}, "limit": {"2"}}, pageFields)
+	assert.Nil(err)
+
+	created := time.Date(2022, 1, 2, 3, 4, 5, 6000, time.UTC)
+	records := []pageRecord{
+		{ID: 3, Name: "c", CreatedAt: created},
+		{ID: 2, Name: "b", CreatedAt: created},
+		{ID: 1, Name: "a", CreatedAt: created},
+	}
+	next, err := query.Next(&records)
+	assert.Nil(err)
+	assert.NotEmpty(next)
+	assert.Len(records, 2)
+
+	query, err = ParsePageQuery(url.Values{"sort": {"-name"}, "limit": {"2"}, "cursor": {next}}, pageFields)
+	assert.Nil(err)
+	assert.Len(query.Condition().Expressions(), 1)
+	assert.Equal([]Condition{
+		{"name <": "b"},
+		{"name": "b", "id >": int64(2)},
+	}, query.keysetConds())
+
+	records = records[:1]
+	next, err = query.Next(&records)
+	assert.Nil(err)
+	assert.Empty(next)
+	assert.Len(records, 1)
+}
+
+func TestPageQuery_NextWithTime(t *testing.T) {
+	assert := assert.New(t)
+
+	query, err := ParsePageQuery(url.Values{"sort": {"created_at"}, "limit": {"1"}}, pageFields)
+	assert.Nil(err)
+
+	created := time.Date(2022, 1, 2, 3, 4, 5, 6000, time.UTC)
+	records := []pageRecord{{ID: 1, CreatedAt: created}, {ID: 2, CreatedAt: created}}
+	next, err := query.Next(&records)
+	assert.Nil(err)
+
+	query, err = ParsePageQuery(url.Values{"sort": {"created_at"}, "cursor": {next}}, pageFields)
+	assert.Nil(err)
+	assert.Equal([]interface{}{created, int64(1)}, query.after)
+}
+
+func TestPageQuery_After(t *testing.T) {
+	assert := assert.New(t)
+
+	query, err := ParsePageQuery(url.Values{"name~": {"a"}, "limit": {"1"}}, pageFields)
+	assert.Nil(err)
+	records := []pageRecord{{ID: 1, Name: "a"}, {ID: 2, Name: "ab"}}
+	next, err := query.Next(&records)
+	assert.Nil(err)
+
+	page, err := query.After(next)
+	assert.Nil(err)
+	assert.Equal(query.Filters, page.Filters)
+	assert.Equal([]interface{}{int64(1)}, page.after)
+	assert.Nil(query.after)
+
+	_, err = query.After("invalid")
+	assert.ErrorIs(err, ErrInvalidPageQuery)
+}
+
+func TestParsePageQuery_CursorOfAnotherSort(t *testing.T) {
+	assert := assert.New(t)
+
+	query, err := ParsePageQuery(url.Values{"sort": {"name"}, "limit": {"1"}}, pageFields)
+	assert.Nil(err)
+	records := []pageRecord{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}
+	next, err := query.Next(&records)
+	assert.Nil(err)
+
+	_, err = ParsePageQuery(url.Values{"sort": {"-name"}, "cursor": {next}}, pageFields)
+	assert.ErrorIs(err, ErrInvalidPageQuery)
+}
+
+func TestWritePageHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	u, _ := url.Parse("/api/v1/articles/?name~=leg&cursor=old")
+	header := http.Header{}
+	WritePageHeaders(header, u, "next")
+	assert.Equal("next", header.Get(NextCursorHeader))
+	assert.Equal(`</api/v1/articles/?cursor=next&name~=leg>; rel="next"`, header.Get("Link"))
+
+	header = http.Header{}
+	WritePageHeaders(header, u, "")
+	assert.Empty(header)
+}
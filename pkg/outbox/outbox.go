@@ -0,0 +1,280 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/streamer"
+	"sort"
+	"time"
+)
+
+// TableName is the table the messages are stored in
+const TableName = "outbox"
+
+const (
+	DefaultBatchSize    = 100
+	DefaultMaxAttempts  = 10
+	DefaultClaimTimeout = time.Minute
+	DefaultRetention    = 7 * 24 * time.Hour
+	DefaultPruneEvery   = time.Hour
+)
+
+// Message represents a record from outbox table
+type Message struct {
+	ID           uint64     `db:"id,omitempty"`
+	CreatedAt    time.Time  `db:"created_at,omitempty"`
+	Topic        string     `db:"topic"`
+	UUID         string     `db:"uuid"`
+	Payload      string     `db:"payload"`
+	Metadata     string     `db:"metadata"`
+	Attempts     int        `db:"attempts"`
+	LastError    string     `db:"last_error"`
+	PublishedAt  *time.Time `db:"published_at"`
+	ClaimedUntil time.Time  `db:"claimed_until,omitempty"`
+	ParkedAt     *time.Time `db:"parked_at"`
+}
+
+// Store writes the given message to the outbox within the transaction of tx,
+// so the message is only relayed if the transaction is committed
+func Store(tx dbclient.DataTable, topic string, msg *message.Message) error {
+	metadata, err := json.Marshal(msg.Metadata)
+	if err != nil {
+		return err
+	}
+	return tx.CreateRelated(TableName, &Message{
+		Topic:    topic,
+		UUID:     msg.UUID,
+		Payload:  string(msg.Payload),
+		Metadata: string(metadata),
+	})
+}
+
+// Publisher stores the messages published through it in the outbox within
+// the transaction of its DataTable, so the events of the services working in
+// the transaction are only relayed if it is committed
+type Publisher struct {
+	DataTable dbclient.DataTable
+	// CorrelationID replaces the correlation id of the messages
+	// published through the Publisher unless it is empty
+	CorrelationID string
+}
+
+// NewChannel returns a streamer.Channel publishing to the outbox of tx,
+// the messages carry the correlation id of ctx when it carries one
+func NewChannel(ctx context.Context, tx dbclient.DataTable) streamer.Channel {
+	return streamer.Channel{Publisher: &Publisher{
+		DataTable:     tx,
+		CorrelationID: streamer.CorrelationIDFrom(ctx),
+	}}
+}
+
+// Publish stores the messages of the topic in the outbox
+func (p *Publisher) Publish(topic string, messages ...*message.Message) error {
+	for _, msg := range messages {
+		if p.CorrelationID != "" {
+			streamer.SetCorrelationID(msg, p.CorrelationID)
+		}
+		if err := Store(p.DataTable, topic, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close does nothing, the transaction is ended by its owner
+func (p *Publisher) Close() error {
+	return nil
+}
+
+// toMessage converts the record back to the message it is stored from
+func (m *Message) toMessage() (*message.Message, error) {
+	msg := message.NewMessage(m.UUID, []byte(m.Payload))
+	if err := json.Unmarshal([]byte(m.Metadata), &msg.Metadata); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// History returns the messages stored for the topic from the given time on,
+// published or not, in the order they are stored
+func History(dataTable dbclient.DataTable, topic string, from time.Time) ([]*message.Message, error) {
+	var records []Message
+	cond := dbclient.Condition{"topic": topic, "created_at >=": from.UTC()}
+	if err := dataTable.FindRelated(TableName, cond, &records); err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+
+	messages := make([]*message.Message, 0, len(records))
+	for i := range records {
+		msg, err := records[i].toMessage()
+		if err != nil {
+			return nil, fmt.Errorf("outbox message %d: %w", records[i].ID, err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// Relay publishes the messages of the outbox to the StreamChannel
+type Relay struct {
+	DataTable     dbclient.DataTable
+	StreamChannel streamer.Channel
+	BatchSize     int
+	MaxAttempts   int
+	ClaimTimeout  time.Duration
+	Retention     time.Duration
+}
+
+// NewRelay returns a Relay of the outbox in dataTable to channel with the defaults
+func NewRelay(dataTable dbclient.DataTable, channel streamer.Channel) *Relay {
+	return &Relay{
+		DataTable:     dataTable,
+		StreamChannel: channel,
+		BatchSize:     DefaultBatchSize,
+		MaxAttempts:   DefaultMaxAttempts,
+		ClaimTimeout:  DefaultClaimTimeout,
+		Retention:     DefaultRetention,
+	}
+}
+
+// Drain publishes a batch of the unpublished messages in the order they are
+// stored, marks them as published and returns the number of the published
+// ones. The batch is claimed for ClaimTimeout in a short transaction and
+// published outside of it, concurrent relays skip the claimed messages, so
+// they never publish the same message twice. Draining stops at the first
+// message that can't be published, its attempt is recorded and the rest of
+// the batch is released to be retried on the next drain. A message failing
+// MaxAttempts times is parked, it isn't relayed anymore so it doesn't hold
+// back the ones after it.
+func (relay *Relay) Drain() (int, error) {
+	messages, err := relay.claim()
+	if err != nil {
+		return 0, err
+	}
+
+	var published int
+	for i := range messages {
+		m := &messages[i]
+		msg, err := m.toMessage()
+		if err == nil {
+			err = relay.StreamChannel.Publish(m.Topic, msg)
+		}
+		if err != nil {
+			if err := relay.fail(m, err, messages[i+1:]); err != nil {
+				return published, err
+			}
+			return published, fmt.Errorf("outbox message %d: %w", m.ID, err)
+		}
+
+		now := time.Now().UTC()
+		m.Attempts++
+		m.LastError = ""
+		m.PublishedAt = &now
+		if err := relay.DataTable.UpdateReturning(m); err != nil {
+			return published, err
+		}
+		published++
+	}
+	return published, nil
+}
+
+// Flush drains the outbox batch by batch until it is empty or
+// a message can't be published and returns the number of the published ones
+func (relay *Relay) Flush() (int, error) {
+	var total int
+	for {
+		published, err := relay.Drain()
+		total += published
+		if err != nil || published < relay.BatchSize {
+			return total, err
+		}
+	}
+}
+
+// claim locks the next batch of the unpublished messages which are neither
+// parked nor claimed by another relay and claims them for ClaimTimeout
+func (relay *Relay) claim() ([]Message, error) {
+	var messages []Message
+	err := relay.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		now := time.Now().UTC()
+		err := tx.FindForUpdateSkipLocked(dbclient.Condition{
+			"published_at IS":  nil,
+			"parked_at IS":     nil,
+			"claimed_until <=": now,
+		}, relay.BatchSize, &messages)
+		if err != nil {
+			return err
+		}
+		for i := range messages {
+			messages[i].ClaimedUntil = now.Add(relay.ClaimTimeout)
+			if err := tx.UpdateReturning(&messages[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// fail records the failed attempt of m, parks it once it runs out of
+// attempts and releases the claims of the rest of the batch
+func (relay *Relay) fail(m *Message, publishErr error, rest []Message) error {
+	return relay.DataTable.WithTx(func(tx dbclient.DataTable) error {
+		now := time.Now().UTC()
+		m.Attempts++
+		m.LastError = publishErr.Error()
+		m.ClaimedUntil = now
+		if m.Attempts >= relay.MaxAttempts {
+			m.ParkedAt = &now
+		}
+		if err := tx.UpdateReturning(m); err != nil {
+			return err
+		}
+		for i := range rest {
+			rest[i].ClaimedUntil = now
+			if err := tx.UpdateReturning(&rest[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Prune deletes the messages published longer than Retention ago,
+// the History of a topic only reaches back as far as the Retention
+func (relay *Relay) Prune() error {
+	return relay.DataTable.Delete(dbclient.Condition{
+		"published_at <": time.Now().UTC().Add(-relay.Retention),
+	})
+}
+
+// Run drains the outbox every interval and prunes it every DefaultPruneEvery
+// until ctx is done
+func (relay *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	pruner := time.NewTicker(DefaultPruneEvery)
+	defer pruner.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := relay.Drain(); err != nil {
+				fmt.Println("Error: couldn't relay the outbox: ", err.Error())
+			}
+		case <-pruner.C:
+			if err := relay.Prune(); err != nil {
+				fmt.Println("Error: couldn't prune the outbox: ", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"github.com/unicod3/horreum/pkg/streamer"
+	"testing"
+	"time"
+)
+
+// failingPublisher fails to publish the messages of the failing topic
+type failingPublisher struct {
+	message.Publisher
+	topic string
+}
+
+func (p failingPublisher) Publish(topic string, messages ...*message.Message) error {
+	if topic == p.topic {
+		return errors.New("publisher is down")
+	}
+	return p.Publisher.Publish(topic, messages...)
+}
+
+func TestStore(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	msg, err := streamer.NewMessage(&streamer.Message{EventName: "OrderCreated", Data: 1})
+	assert.Nil(err)
+
+	var stored *Message
+	dataTable.On("CreateRelated", TableName, mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(1).(*Message)
+	}).Return(nil).Once()
+
+	assert.Nil(Store(&dataTable, "orders", msg))
+	assert.Equal("orders", stored.Topic)
+	assert.Equal(msg.UUID, stored.UUID)
+	assert.Equal(string(msg.Payload), stored.Payload)
+
+	restored, err := stored.toMessage()
+	assert.Nil(err)
+	assert.True(msg.Equals(restored))
+}
+
+func TestPublisher_Publish(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	channel := NewChannel(streamer.WithCorrelationID(context.Background(), "request-1"), &dataTable)
+	first, err := streamer.NewMessage(&streamer.Message{EventName: "ArticleCreated", Data: 1})
+	assert.Nil(err)
+	second, err := streamer.NewMessage(&streamer.Message{EventName: "ArticleCreated", Data: 2})
+	assert.Nil(err)
+
+	var stored []string
+	dataTable.On("CreateRelated", TableName, mock.Anything).Run(func(args mock.Arguments) {
+		m := args.Get(1).(*Message)
+		assert.Equal("articles", m.Topic)
+		assert.Contains(m.Metadata, `"correlation_id":"request-1"`, "the message must carry the id of the context")
+		stored = append(stored, m.UUID)
+	}).Return(nil).Twice()
+
+	assert.Nil(channel.Publish("articles", first, second))
+	assert.Equal([]string{first.UUID, second.UUID}, stored)
+	assert.Nil(channel.Publisher.Close())
+	dataTable.AssertExpectations(t)
+}
+
+func TestHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	first, _ := streamer.NewMessage(&streamer.Message{EventName: "OrderCreated", Data: 1})
+	second, _ := streamer.NewMessage(&streamer.Message{EventName: "OrderShipped", Data: 1})
+	from := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	cond := dbclient.Condition{"topic": "orders", "created_at >=": from}
+	dataTable.On("FindRelated", TableName, cond, mock.Anything).Run(func(args mock.Arguments) {
+		*(args.Get(2).(*[]Message)) = []Message{
+			{ID: 2, Topic: "orders", UUID: second.UUID, Payload: string(second.Payload), Metadata: "{}"},
+			{ID: 1, Topic: "orders", UUID: first.UUID, Payload: string(first.Payload), Metadata: "{}"},
+		}
+	}).Return(nil).Once()
+
+	messages, err := History(&dataTable, "orders", from)
+	assert.Nil(err)
+	assert.Len(messages, 2)
+	assert.Equal(first.UUID, messages[0].UUID, "messages must be returned in order")
+	assert.Equal(second.Payload, messages[1].Payload)
+	dataTable.AssertExpectations(t)
+}
+
+func TestRelay_Drain(t *testing.T) {
+	first, _ := streamer.NewMessage(&streamer.Message{EventName: "OrderCreated", Data: 1})
+	second, _ := streamer.NewMessage(&streamer.Message{EventName: "OrderConfirmed", Data: 1})
+	third, _ := streamer.NewMessage(&streamer.Message{EventName: "OrderCreated", Data: 2})
+
+	// mockOutbox mocks a batch of the unpublished messages of the outbox
+	// with the given attempts and returns the records the relay updates
+	// after it claims them
+	mockOutbox := func(dataTable *mocks.DataTable, attempts int, topics ...string) *[]Message {
+		var messages []Message
+		for i, msg := range []*message.Message{first, second, third}[:len(topics)] {
+			m := Message{ID: uint64(i + 1), Topic: topics[i], UUID: msg.UUID, Payload: string(msg.Payload), Metadata: "{}", Attempts: attempts}
+			messages = append(messages, m)
+		}
+		var updated []Message
+		claimed := 0
+		dataTable.On("WithTx", mock.Anything).
+			Return(func(fn func(dbclient.DataTable) error) error {
+				return fn(dataTable)
+			})
+		unclaimed := mock.MatchedBy(func(cond dbclient.Condition) bool {
+			return cond["published_at IS"] == nil && cond["parked_at IS"] == nil && cond["claimed_until <="] != nil
+		})
+		dataTable.On("FindForUpdateSkipLocked", unclaimed, DefaultBatchSize, mock.Anything).Run(func(args mock.Arguments) {
+			*(args.Get(2).(*[]Message)) = messages
+		}).Return(nil).Once()
+		dataTable.On("UpdateReturning", mock.Anything).Run(func(args mock.Arguments) {
+			m := *args.Get(0).(*Message)
+			if claimed < len(messages) {
+				claimed++
+				if !m.ClaimedUntil.After(time.Now().UTC()) {
+					t.Error("the batch must be claimed before it is published")
+				}
+				return
+			}
+			updated = append(updated, m)
+		}).Return(nil)
+		return &updated
+	}
+
+	t.Run("Test can publish in order", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		relay := NewRelay(&dataTable, streamer.NewChannel())
+		received, err := relay.StreamChannel.Subscribe(context.Background(), "orders")
+		assert.Nil(err)
+		updated := mockOutbox(&dataTable, 0, "orders", "orders", "orders")
+
+		published, err := relay.Drain()
+		assert.Nil(err)
+		assert.Equal(3, published)
+		var uuids []string
+		for range []*message.Message{first, second, third} {
+			select {
+			case r := <-received:
+				uuids = append(uuids, r.UUID)
+				r.Ack()
+			case <-time.After(time.Second):
+				t.Fatal("message is not relayed")
+			}
+		}
+		assert.ElementsMatch([]string{first.UUID, second.UUID, third.UUID}, uuids)
+		for i, msg := range []*message.Message{first, second, third} {
+			assert.Equal(msg.UUID, (*updated)[i].UUID, "messages must be published in order")
+			assert.NotNil((*updated)[i].PublishedAt)
+			assert.Equal(1, (*updated)[i].Attempts)
+		}
+	})
+
+	t.Run("Test can stop at failed message", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		channel := streamer.NewChannel()
+		channel.Publisher = failingPublisher{Publisher: channel.Publisher, topic: "warehouses"}
+		relay := NewRelay(&dataTable, channel)
+		updated := mockOutbox(&dataTable, 0, "orders", "warehouses", "orders")
+
+		published, err := relay.Drain()
+		assert.NotNil(err)
+		assert.Equal(1, published)
+		assert.Len(*updated, 3)
+		assert.NotNil((*updated)[0].PublishedAt)
+		assert.Nil((*updated)[1].PublishedAt)
+		assert.Nil((*updated)[1].ParkedAt)
+		assert.Equal(1, (*updated)[1].Attempts)
+		assert.Equal("publisher is down", (*updated)[1].LastError)
+		assert.Nil((*updated)[2].PublishedAt, "the messages after the failed one must wait for the next drain")
+		for _, m := range (*updated)[1:] {
+			assert.False(m.ClaimedUntil.After(time.Now().UTC()), "the rest of the batch must be released")
+		}
+	})
+
+	t.Run("Test can park a message out of attempts", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dataTable := mocks.DataTable{}
+		channel := streamer.NewChannel()
+		channel.Publisher = failingPublisher{Publisher: channel.Publisher, topic: "warehouses"}
+		relay := NewRelay(&dataTable, channel)
+		updated := mockOutbox(&dataTable, DefaultMaxAttempts-1, "warehouses")
+
+		published, err := relay.Drain()
+		assert.NotNil(err)
+		assert.Zero(published)
+		assert.Len(*updated, 1)
+		assert.Equal(DefaultMaxAttempts, (*updated)[0].Attempts)
+		assert.NotNil((*updated)[0].ParkedAt)
+	})
+}
+
+func TestRelay_Flush(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	relay := NewRelay(&dataTable, streamer.NewChannel())
+	relay.BatchSize = 2
+	msg, _ := streamer.NewMessage(&streamer.Message{EventName: "OrderCreated", Data: 1})
+	batch := func(ids ...uint64) func(mock.Arguments) {
+		return func(args mock.Arguments) {
+			var messages []Message
+			for _, id := range ids {
+				messages = append(messages, Message{ID: id, Topic: "orders", UUID: msg.UUID, Payload: string(msg.Payload), Metadata: "{}"})
+			}
+			*(args.Get(2).(*[]Message)) = messages
+		}
+	}
+	dataTable.On("WithTx", mock.Anything).
+		Return(func(fn func(dbclient.DataTable) error) error {
+			return fn(&dataTable)
+		})
+	dataTable.On("FindForUpdateSkipLocked", mock.Anything, 2, mock.Anything).Run(batch(1, 2)).Return(nil).Once()
+	dataTable.On("FindForUpdateSkipLocked", mock.Anything, 2, mock.Anything).Run(batch(3)).Return(nil).Once()
+	dataTable.On("UpdateReturning", mock.Anything).Return(nil)
+
+	published, err := relay.Flush()
+	assert.Nil(err)
+	assert.Equal(3, published)
+	dataTable.AssertExpectations(t)
+}
+
+func TestRelay_Prune(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTable := mocks.DataTable{}
+	relay := NewRelay(&dataTable, streamer.NewChannel())
+	expired := mock.MatchedBy(func(cond dbclient.Condition) bool {
+		before, ok := cond["published_at <"].(time.Time)
+		return ok && len(cond) == 1 && time.Since(before) >= DefaultRetention
+	})
+	dataTable.On("Delete", expired).Return(nil).Once()
+
+	assert.Nil(relay.Prune())
+	dataTable.AssertExpectations(t)
+}
@@ -0,0 +1,44 @@
+package streamer
+
+import (
+	"context"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+)
+
+type correlationIDContextKey struct{}
+
+// WithCorrelationID returns a copy of ctx carrying the correlation id
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDContextKey{}, id)
+}
+
+// CorrelationIDFrom returns the correlation id ctx carries, it is
+// empty when ctx doesn't carry one
+func CorrelationIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDContextKey{}).(string)
+	return id
+}
+
+// MessageCorrelationID returns the correlation id of the message
+func MessageCorrelationID(msg *message.Message) string {
+	return middleware.MessageCorrelationID(msg)
+}
+
+// SetCorrelationID sets the correlation id of the message, replacing
+// the one it already has
+func SetCorrelationID(msg *message.Message, id string) {
+	msg.Metadata.Set(middleware.CorrelationIDMetadataKey, id)
+}
+
+// CorrelationID is the router middleware which puts the correlation id of the
+// incoming message into its context, so the handlers can carry it on, and
+// copies it to the messages the handler produces
+func CorrelationID(h message.HandlerFunc) message.HandlerFunc {
+	return func(msg *message.Message) ([]*message.Message, error) {
+		if id := MessageCorrelationID(msg); id != "" {
+			msg.SetContext(WithCorrelationID(msg.Context(), id))
+		}
+		return middleware.CorrelationID(h)(msg)
+	}
+}
@@ -0,0 +1,40 @@
+package streamer
+
+import (
+	"context"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCorrelationID(t *testing.T) {
+	t.Run("Test can carry the correlation id of the envelope", func(t *testing.T) {
+		assert := assert.New(t)
+
+		msg, err := NewMessage(&Message{EventName: "OrderCreated", CorrelationID: "request-1"})
+		assert.Nil(err)
+		assert.Equal("request-1", MessageCorrelationID(msg))
+		assert.NotContains(string(msg.Payload), "request-1")
+
+		generated, err := NewMessage(&Message{EventName: "OrderCreated"})
+		assert.Nil(err)
+		assert.NotEmpty(MessageCorrelationID(generated))
+	})
+
+	t.Run("Test can pass the correlation id to the handler", func(t *testing.T) {
+		assert := assert.New(t)
+
+		msg, err := NewMessage(&Message{EventName: "OrderCreated", CorrelationID: "request-1"})
+		assert.Nil(err)
+
+		var handled string
+		produced, err := CorrelationID(func(msg *message.Message) ([]*message.Message, error) {
+			handled = CorrelationIDFrom(msg.Context())
+			return []*message.Message{message.NewMessage("produced", nil)}, nil
+		})(msg)
+		assert.Nil(err)
+		assert.Equal("request-1", handled)
+		assert.Equal("request-1", MessageCorrelationID(produced[0]))
+		assert.Empty(CorrelationIDFrom(context.Background()))
+	})
+}
@@ -0,0 +1,83 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"time"
+)
+
+// ProcessedMessagesTable is the table the processed messages are tracked in
+const ProcessedMessagesTable = "processed_messages"
+
+// ProcessedMessage represents a record from processed_messages table
+type ProcessedMessage struct {
+	MessageUUID string    `db:"message_uuid"`
+	Handler     string    `db:"handler"`
+	ProcessedAt time.Time `db:"processed_at"`
+}
+
+type txContextKey struct{}
+
+// Tx returns the transaction the message is handled in
+// by the Idempotent middleware, ok is false outside of it
+func Tx(msg *message.Message) (tx dbclient.DataStorage, ok bool) {
+	tx, ok = msg.Context().Value(txContextKey{}).(dbclient.DataStorage)
+	return tx, ok
+}
+
+// Idempotent returns a middleware which runs the handler with given name
+// at most once for a message UUID. The message is marked as processed and
+// handled in a single transaction of the storage, which the handler gets
+// by Tx, so the effects of a redelivered message are never applied twice
+// as long as the handler writes them within that transaction. A panicking
+// handler rolls the transaction back on its way to the Recoverer, which
+// wraps the middleware, so its row locks are released before the retry.
+func Idempotent(storage dbclient.DataStorage, handlerName string) message.HandlerMiddleware {
+	return func(h message.HandlerFunc) message.HandlerFunc {
+		return func(msg *message.Message) ([]*message.Message, error) {
+			var produced []*message.Message
+			err := storage.WithTx(func(tx dbclient.DataStorage) error {
+				processedMessages := tx.NewDataCollection(ProcessedMessagesTable)
+				var processed ProcessedMessage
+				err := processedMessages.FindOne(dbclient.Condition{
+					"message_uuid": msg.UUID,
+					"handler":      handlerName,
+				}, &processed)
+				if err == nil {
+					logger.Info("Skipping processed message", map[string]interface{}{
+						"message_uuid": msg.UUID,
+						"handler":      handlerName,
+					})
+					return nil
+				}
+				if !errors.Is(err, dbclient.ErrNoMoreRows) {
+					return err
+				}
+
+				// A concurrent delivery of the same message fails on the
+				// primary key here and is retried once this one commits
+				_, err = processedMessages.Insert(&ProcessedMessage{
+					MessageUUID: msg.UUID,
+					Handler:     handlerName,
+					ProcessedAt: time.Now().UTC(),
+				})
+				if err != nil {
+					return err
+				}
+
+				ctx := msg.Context()
+				msg.SetContext(context.WithValue(ctx, txContextKey{}, tx))
+				defer msg.SetContext(ctx)
+
+				produced, err = h(msg)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			return produced, nil
+		}
+	}
+}
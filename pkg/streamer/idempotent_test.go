@@ -0,0 +1,127 @@
+package streamer
+
+import (
+	"errors"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"github.com/unicod3/horreum/pkg/dbclient/mocks"
+	"testing"
+)
+
+func TestIdempotent(t *testing.T) {
+	condition := dbclient.Condition{"message_uuid": "uuid", "handler": "order_events"}
+
+	// mockStorage mocks a transaction of the storage with its processed_messages table
+	mockStorage := func(storage *mocks.DataStorage, processedMessages *mocks.DataTable) {
+		storage.On("WithTx", mock.Anything).
+			Return(func(fn func(dbclient.DataStorage) error) error {
+				return fn(storage)
+			}).Once()
+		storage.On("NewDataCollection", ProcessedMessagesTable).Return(processedMessages).Once()
+	}
+
+	t.Run("Test can handle new message in transaction", func(t *testing.T) {
+		assert := assert.New(t)
+
+		storage := mocks.DataStorage{}
+		processedMessages := mocks.DataTable{}
+		mockStorage(&storage, &processedMessages)
+		processedMessages.On("FindOne", condition, mock.Anything).Return(dbclient.ErrNoMoreRows).Once()
+		processedMessages.On("Insert", mock.Anything).Return(nil, nil).Once()
+
+		var handlerTx dbclient.DataStorage
+		handler := Idempotent(&storage, "order_events")(func(msg *message.Message) ([]*message.Message, error) {
+			handlerTx, _ = Tx(msg)
+			return nil, nil
+		})
+
+		msg := message.NewMessage("uuid", nil)
+		_, err := handler(msg)
+		assert.Nil(err)
+		assert.Same(&storage, handlerTx)
+		processedMessages.AssertExpectations(t)
+
+		_, ok := Tx(msg)
+		assert.False(ok, "the transaction must not outlive the handler")
+	})
+
+	t.Run("Test can skip processed message", func(t *testing.T) {
+		assert := assert.New(t)
+
+		storage := mocks.DataStorage{}
+		processedMessages := mocks.DataTable{}
+		mockStorage(&storage, &processedMessages)
+		processedMessages.On("FindOne", condition, mock.Anything).Return(nil).Once()
+
+		calls := 0
+		handler := Idempotent(&storage, "order_events")(func(msg *message.Message) ([]*message.Message, error) {
+			calls++
+			return nil, nil
+		})
+
+		_, err := handler(message.NewMessage("uuid", nil))
+		assert.Nil(err)
+		assert.Equal(0, calls)
+		processedMessages.AssertNotCalled(t, "Insert", mock.Anything)
+	})
+
+	t.Run("Test can roll back failed handler", func(t *testing.T) {
+		assert := assert.New(t)
+
+		storage := mocks.DataStorage{}
+		processedMessages := mocks.DataTable{}
+		handlerErr := errors.New("insufficient stock")
+		var txErr error
+		storage.On("WithTx", mock.Anything).
+			Return(func(fn func(dbclient.DataStorage) error) error {
+				txErr = fn(&storage)
+				return txErr
+			}).Once()
+		storage.On("NewDataCollection", ProcessedMessagesTable).Return(&processedMessages).Once()
+		processedMessages.On("FindOne", condition, mock.Anything).Return(dbclient.ErrNoMoreRows).Once()
+		processedMessages.On("Insert", mock.Anything).Return(nil, nil).Once()
+
+		handler := Idempotent(&storage, "order_events")(func(msg *message.Message) ([]*message.Message, error) {
+			return nil, handlerErr
+		})
+
+		_, err := handler(message.NewMessage("uuid", nil))
+		assert.Equal(handlerErr, err)
+		assert.Equal(handlerErr, txErr, "the processed mark must be rolled back with the handler")
+	})
+
+	t.Run("Test can roll back panicking handler", func(t *testing.T) {
+		assert := assert.New(t)
+
+		storage := mocks.DataStorage{}
+		processedMessages := mocks.DataTable{}
+		var rolledBack interface{}
+		storage.On("WithTx", mock.Anything).
+			Return(func(fn func(dbclient.DataStorage) error) error {
+				defer func() {
+					rolledBack = recover()
+					panic(rolledBack)
+				}()
+				return fn(&storage)
+			}).Once()
+		storage.On("NewDataCollection", ProcessedMessagesTable).Return(&processedMessages).Once()
+		processedMessages.On("FindOne", condition, mock.Anything).Return(dbclient.ErrNoMoreRows).Once()
+		processedMessages.On("Insert", mock.Anything).Return(nil, nil).Once()
+
+		handler := Idempotent(&storage, "order_events")(func(msg *message.Message) ([]*message.Message, error) {
+			panic("nil map")
+		})
+
+		// The Recoverer wraps the middleware, the panic has to reach it
+		// through the transaction so the transaction is rolled back
+		msg := message.NewMessage("uuid", nil)
+		_, err := middleware.Recoverer(handler)(msg)
+		assert.Error(err)
+		assert.Equal("nil map", rolledBack, "the transaction must see the panic of the handler")
+		_, ok := Tx(msg)
+		assert.False(ok, "the transaction must not outlive the handler")
+	})
+}
@@ -30,7 +30,26 @@ func (_m *Streamer) NewChannel() *streamer.Channel {
 	return r0
 }
 
-// RegisterHandler provides a mock function with given fields: channel, topicName, handlerFunc
-func (_m *Streamer) RegisterHandler(channel streamer.Channel, topicName string, handlerFunc message.NoPublishHandlerFunc) {
-	_m.Called(channel, topicName, handlerFunc)
+// RegisterDeadLetterHandler provides a mock function with given fields: channel, topicName, handlerFunc, middlewares
+func (_m *Streamer) RegisterDeadLetterHandler(channel streamer.Channel, topicName string, handlerFunc message.NoPublishHandlerFunc, middlewares ...message.HandlerMiddleware) {
+	_va := make([]interface{}, len(middlewares))
+	for _i := range middlewares {
+		_va[_i] = middlewares[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, channel, topicName, handlerFunc)
+	_ca = append(_ca, _va...)
+	_m.Called(_ca...)
+}
+
+// RegisterHandler provides a mock function with given fields: channel, topicName, handlerFunc, middlewares
+func (_m *Streamer) RegisterHandler(channel streamer.Channel, topicName string, handlerFunc message.NoPublishHandlerFunc, middlewares ...message.HandlerMiddleware) {
+	_va := make([]interface{}, len(middlewares))
+	for _i := range middlewares {
+		_va[_i] = middlewares[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, channel, topicName, handlerFunc)
+	_ca = append(_ca, _va...)
+	_m.Called(_ca...)
 }
@@ -0,0 +1,157 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	watermillNATS "github.com/ThreeDotsLabs/watermill-nats/v2/pkg/nats"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+)
+
+// NewNATSChannel returns a Channel which stores the messages in the NATS
+// JetStream server at url, every topic gets its own stream. The subscribers
+// of a consumer group share a durable consumer of the stream as a queue group,
+// so every message is handled once by the group over all the instances
+// connected to the server and the consumption continues where it was left
+// after a restart. The published messages are deduplicated by their UUID.
+func NewNATSChannel(url, consumerGroup string, options ...nats.Option) (Channel, error) {
+	publisher, err := watermillNATS.NewPublisher(watermillNATS.PublisherConfig{
+		URL:         url,
+		NatsOptions: options,
+		JetStream: watermillNATS.JetStreamConfig{
+			AutoProvision: true,
+			TrackMsgId:    true,
+		},
+	}, logger)
+	if err != nil {
+		return Channel{}, err
+	}
+
+	newSubscriber := func(consumerGroup string) (message.Subscriber, error) {
+		return newNATSSubscriber(url, consumerGroup, options)
+	}
+	subscriber, err := newSubscriber(consumerGroup)
+	if err != nil {
+		publisher.Close()
+		return Channel{}, err
+	}
+
+	return Channel{
+		Publisher:     publisher,
+		Subscriber:    subscriber,
+		newSubscriber: newSubscriber,
+		newEphemeralSubscriber: func() (message.Subscriber, error) {
+			return newNATSEphemeralSubscriber(url, options)
+		},
+		consumerGroup: consumerGroup,
+	}, nil
+}
+
+// natsSubscriber subscribes to the topics through the durable consumers of
+// its consumer group. It creates the streams and the consumers itself before
+// subscribing, since nats.go deletes the consumers it creates once they are
+// unsubscribed, which would lose the position of the group on a restart.
+type natsSubscriber struct {
+	*watermillNATS.Subscriber
+	conn          *nats.Conn
+	js            nats.JetStreamContext
+	consumerGroup string
+}
+
+// newNATSSubscriber returns the natsSubscriber of the consumer group
+func newNATSSubscriber(url, consumerGroup string, options []nats.Option) (*natsSubscriber, error) {
+	conn, err := nats.Connect(url, options...)
+	if err != nil {
+		return nil, err
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	subscriber, err := watermillNATS.NewSubscriber(watermillNATS.SubscriberConfig{
+		URL:              url,
+		NatsOptions:      options,
+		QueueGroupPrefix: consumerGroup,
+		JetStream: watermillNATS.JetStreamConfig{
+			DurablePrefix: consumerGroup,
+			// The subscriber acks the messages once the handler is done with them
+			SubscribeOptions: []nats.SubOpt{nats.DeliverAll(), nats.AckExplicit(), nats.ManualAck()},
+		},
+	}, logger)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &natsSubscriber{
+		Subscriber:    subscriber,
+		conn:          conn,
+		js:            js,
+		consumerGroup: consumerGroup,
+	}, nil
+}
+
+// Subscribe makes sure the stream of the topic and the durable consumer of
+// the consumer group exist and subscribes to the topic through them
+func (s *natsSubscriber) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	if err := s.ensureConsumer(topic); err != nil {
+		return nil, err
+	}
+	return s.Subscriber.Subscribe(ctx, topic)
+}
+
+// ensureConsumer creates the stream of the topic and the durable consumer of
+// the consumer group delivering to the group, unless they exist. Another
+// instance creating them at the same time is not an error.
+func (s *natsSubscriber) ensureConsumer(topic string) error {
+	if _, err := s.js.StreamInfo(topic); errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = s.js.AddStream(&nats.StreamConfig{Name: topic, Subjects: []string{topic}})
+		if err != nil && !errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	_, err := s.js.ConsumerInfo(topic, s.consumerGroup)
+	if !errors.Is(err, nats.ErrConsumerNotFound) {
+		return err
+	}
+	_, err = s.js.AddConsumer(topic, &nats.ConsumerConfig{
+		Durable:        s.consumerGroup,
+		DeliverSubject: nats.NewInbox(),
+		DeliverGroup:   s.consumerGroup,
+		DeliverPolicy:  nats.DeliverAllPolicy,
+		AckPolicy:      nats.AckExplicitPolicy,
+	})
+	if err != nil {
+		// The consumer may be created by another instance in the meantime
+		if _, infoErr := s.js.ConsumerInfo(topic, s.consumerGroup); infoErr == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Close closes the subscriber and its connection
+func (s *natsSubscriber) Close() error {
+	defer s.conn.Close()
+	return s.Subscriber.Close()
+}
+
+// newNATSEphemeralSubscriber returns a subscriber which subscribes to the
+// topics through ephemeral consumers delivering the messages published from
+// then on, nats.go deletes them once the subscriber is closed
+func newNATSEphemeralSubscriber(url string, options []nats.Option) (message.Subscriber, error) {
+	return watermillNATS.NewSubscriber(watermillNATS.SubscriberConfig{
+		URL:         url,
+		NatsOptions: options,
+		JetStream: watermillNATS.JetStreamConfig{
+			AutoProvision: true,
+			// The subscriber acks the messages once the handler is done with them
+			SubscribeOptions: []nats.SubOpt{nats.DeliverNew(), nats.AckExplicit(), nats.ManualAck()},
+		},
+	}, logger)
+}
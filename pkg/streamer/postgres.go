@@ -0,0 +1,87 @@
+package streamer
+
+import (
+	"context"
+	"database/sql"
+	watermillSQL "github.com/ThreeDotsLabs/watermill-sql/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"sync"
+)
+
+// postgresEphemeralSubscriber subscribes to the topics from their last
+// message on and keeps the offsets of the topics in memory, so no consumer
+// group is stored in the database for it
+type postgresEphemeralSubscriber struct {
+	*watermillSQL.Subscriber
+	db      *sql.DB
+	offsets *ephemeralOffsetsAdapter
+}
+
+// newPostgresEphemeralSubscriber returns the postgresEphemeralSubscriber of db
+func newPostgresEphemeralSubscriber(db *sql.DB) (*postgresEphemeralSubscriber, error) {
+	offsets := &ephemeralOffsetsAdapter{acked: map[string]int{}}
+	subscriber, err := watermillSQL.NewSubscriber(db, watermillSQL.SubscriberConfig{
+		SchemaAdapter:  watermillSQL.DefaultPostgreSQLSchema{},
+		OffsetsAdapter: offsets,
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresEphemeralSubscriber{
+		Subscriber: subscriber,
+		db:         db,
+		offsets:    offsets,
+	}, nil
+}
+
+// Subscribe makes sure the messages table of the topic exists and
+// subscribes to the topic after the last message stored in it
+func (s *postgresEphemeralSubscriber) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	if err := s.SubscribeInitialize(topic); err != nil {
+		return nil, err
+	}
+	var offset int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX("offset"), 0) FROM `+watermillSQL.DefaultPostgreSQLSchema{}.MessagesTable(topic),
+	).Scan(&offset)
+	if err != nil {
+		return nil, err
+	}
+	s.offsets.ack(topic, offset)
+	return s.Subscriber.Subscribe(ctx, topic)
+}
+
+// ephemeralOffsetsAdapter keeps the acked offsets of the topics in memory.
+// An offset is taken as acked once its message is acked, a message whose
+// ack fails to be committed is not delivered again.
+type ephemeralOffsetsAdapter struct {
+	mu    sync.Mutex
+	acked map[string]int
+}
+
+func (a *ephemeralOffsetsAdapter) ack(topic string, offset int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked[topic] = offset
+}
+
+func (a *ephemeralOffsetsAdapter) AckMessageQuery(topic string, offset int, consumerGroup string) (string, []interface{}) {
+	a.ack(topic, offset)
+	return `SELECT 1`, nil
+}
+
+// ConsumedMessageQuery is empty, so it is not executed
+func (a *ephemeralOffsetsAdapter) ConsumedMessageQuery(topic string, offset int, consumerGroup string, consumerULID []byte) (string, []interface{}) {
+	return "", nil
+}
+
+func (a *ephemeralOffsetsAdapter) NextOffsetQuery(topic, consumerGroup string) (string, []interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return `SELECT $1::BIGINT`, []interface{}{a.acked[topic]}
+}
+
+// SchemaInitializingQueries is empty, the offsets have no table
+func (a *ephemeralOffsetsAdapter) SchemaInitializingQueries(topic string) []string {
+	return nil
+}
@@ -0,0 +1,101 @@
+package streamer
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// EventType identifies the schema of the data of an event
+type EventType struct {
+	Name    string
+	Version int
+}
+
+// Upcaster converts the data of an event to the next version of its schema
+type Upcaster func(data json.RawMessage) (json.RawMessage, error)
+
+// UnknownEventError is returned when the data of an event
+// can't be decoded since its type is not registered
+type UnknownEventError struct {
+	EventType
+}
+
+func (e *UnknownEventError) Error() string {
+	return fmt.Sprintf("event %s v%d is not registered", e.Name, e.Version)
+}
+
+// Registry decodes the data of the events into the
+// Go types registered for their name and version
+type Registry struct {
+	types     map[EventType]reflect.Type
+	upcasters map[EventType]Upcaster
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		types:     make(map[EventType]reflect.Type),
+		upcasters: make(map[EventType]Upcaster),
+	}
+}
+
+// Register registers the type of data, which needs to be a struct value,
+// as the schema of the given version of the event
+func (r *Registry) Register(name string, version int, data interface{}) {
+	r.types[EventType{Name: name, Version: version}] = reflect.TypeOf(data)
+}
+
+// RegisterUpcaster registers the upcaster which converts
+// the data of the given version of the event to the next version
+func (r *Registry) RegisterUpcaster(name string, fromVersion int, upcaster Upcaster) {
+	r.upcasters[EventType{Name: name, Version: fromVersion}] = upcaster
+}
+
+// rawMessage is the Message with its data left undecoded
+type rawMessage struct {
+	Message
+	Data json.RawMessage
+}
+
+// Decode decodes the given payload into a Message whose Data is a pointer
+// to the type registered for the event. Older versions of the data are
+// upcasted to the latest version registered an upcaster for first. The fields
+// of the data its type doesn't know of are ignored, so a field can be added
+// to an event without a new version while its consumers are still older.
+func (r *Registry) Decode(payload []byte) (*Message, error) {
+	var raw rawMessage
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, err
+	}
+	m := raw.Message
+	// Messages published before the envelope was versioned
+	if m.Version == 0 {
+		m.Version = 1
+	}
+
+	data := raw.Data
+	for {
+		upcaster, ok := r.upcasters[EventType{Name: m.EventName, Version: m.Version}]
+		if !ok {
+			break
+		}
+		var err error
+		if data, err = upcaster(data); err != nil {
+			return nil, fmt.Errorf("upcasting %s v%d: %w", m.EventName, m.Version, err)
+		}
+		m.Version++
+	}
+
+	eventType := EventType{Name: m.EventName, Version: m.Version}
+	t, ok := r.types[eventType]
+	if !ok {
+		return nil, &UnknownEventError{EventType: eventType}
+	}
+	value := reflect.New(t)
+	if err := json.Unmarshal(data, value.Interface()); err != nil {
+		return nil, fmt.Errorf("decoding %s v%d: %w", m.EventName, m.Version, err)
+	}
+	m.Data = value.Interface()
+	return &m, nil
+}
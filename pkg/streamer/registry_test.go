@@ -0,0 +1,101 @@
+package streamer
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testItemV1 struct {
+	Name string `json:"name"`
+}
+
+type testItemV2 struct {
+	Name  string `json:"name"`
+	Stock int64  `json:"stock"`
+}
+
+func newTestRegistry() *Registry {
+	registry := NewRegistry()
+	registry.Register("ItemCreated", 1, testItemV1{})
+	return registry
+}
+
+func TestRegistry_Decode(t *testing.T) {
+	t.Run("Test can decode registered event", func(t *testing.T) {
+		assert := assert.New(t)
+
+		msg, err := NewMessage(&Message{
+			EventName:     "ItemCreated",
+			AggregateType: "item",
+			AggregateID:   3,
+			Data:          testItemV1{Name: "table"},
+		})
+		assert.Nil(err)
+
+		decoded, err := newTestRegistry().Decode(msg.Payload)
+		assert.Nil(err)
+		assert.Equal(msg.UUID, decoded.ID)
+		assert.Equal(1, decoded.Version)
+		assert.Equal("item", decoded.AggregateType)
+		assert.Equal(uint64(3), decoded.AggregateID)
+		assert.False(decoded.OccurredAt.IsZero())
+		assert.Equal(&testItemV1{Name: "table"}, decoded.Data)
+	})
+
+	t.Run("Test can't decode unknown event", func(t *testing.T) {
+		assert := assert.New(t)
+
+		msg, _ := NewMessage(&Message{EventName: "ItemDeleted", Data: testItemV1{}})
+		_, err := newTestRegistry().Decode(msg.Payload)
+		var unknownErr *UnknownEventError
+		assert.True(errors.As(err, &unknownErr))
+		assert.Equal(EventType{Name: "ItemDeleted", Version: 1}, unknownErr.EventType)
+	})
+
+	t.Run("Test can ignore fields added to the same version", func(t *testing.T) {
+		assert := assert.New(t)
+
+		msg, _ := NewMessage(&Message{EventName: "ItemCreated", Data: testItemV2{Name: "table", Stock: 1}})
+		decoded, err := newTestRegistry().Decode(msg.Payload)
+		assert.Nil(err)
+		assert.Equal(&testItemV1{Name: "table"}, decoded.Data)
+	})
+
+	t.Run("Test can reject data of another type", func(t *testing.T) {
+		assert := assert.New(t)
+
+		_, err := newTestRegistry().Decode([]byte(`{"EventName":"ItemCreated","Version":1,"Data":{"name":3}}`))
+		assert.NotNil(err)
+	})
+
+	t.Run("Test can upcast older versions", func(t *testing.T) {
+		assert := assert.New(t)
+
+		registry := newTestRegistry()
+		registry.Register("ItemCreated", 2, testItemV2{})
+		registry.RegisterUpcaster("ItemCreated", 1, func(data json.RawMessage) (json.RawMessage, error) {
+			var v1 testItemV1
+			if err := json.Unmarshal(data, &v1); err != nil {
+				return nil, err
+			}
+			return json.Marshal(testItemV2{Name: v1.Name})
+		})
+
+		msg, _ := NewMessage(&Message{EventName: "ItemCreated", Data: testItemV1{Name: "table"}})
+		decoded, err := registry.Decode(msg.Payload)
+		assert.Nil(err)
+		assert.Equal(2, decoded.Version)
+		assert.Equal(&testItemV2{Name: "table"}, decoded.Data)
+	})
+
+	t.Run("Test can decode messages published before versioning", func(t *testing.T) {
+		assert := assert.New(t)
+
+		decoded, err := newTestRegistry().Decode([]byte(`{"EventName":"ItemCreated","Data":{"name":"table"}}`))
+		assert.Nil(err)
+		assert.Equal(1, decoded.Version)
+		assert.Equal(&testItemV1{Name: "table"}, decoded.Data)
+	})
+}
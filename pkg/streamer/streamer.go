@@ -1,19 +1,20 @@
 package streamer
 
 import (
+	"database/sql"
 	"encoding/json"
-	"fmt"
 	"github.com/ThreeDotsLabs/watermill"
+	watermillSQL "github.com/ThreeDotsLabs/watermill-sql/pkg/sql"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
-	"github.com/ThreeDotsLabs/watermill/message/router/plugin"
 	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
 	"time"
 )
 
 type Streamer interface {
 	NewChannel() *Channel
-	RegisterHandler(channel Channel, topicName string, handlerFunc message.NoPublishHandlerFunc)
+	RegisterHandler(channel Channel, topicName string, handlerFunc message.NoPublishHandlerFunc, middlewares ...message.HandlerMiddleware)
+	RegisterDeadLetterHandler(channel Channel, topicName string, handlerFunc message.NoPublishHandlerFunc, middlewares ...message.HandlerMiddleware)
 }
 
 type Stream struct {
@@ -24,8 +25,99 @@ type WaterMillRouter struct {
 	*message.Router
 }
 
+// Publisher publishes the messages of the topics
+type Publisher interface {
+	Publish(topic string, messages ...*message.Message) error
+	Close() error
+}
+
+// Channel publishes and subscribes the messages of the topics
 type Channel struct {
-	*gochannel.GoChannel
+	Publisher
+	message.Subscriber
+	// newSubscriber returns a subscriber of the given consumer group, it is
+	// nil for the channels delivering every message to every subscriber
+	newSubscriber func(consumerGroup string) (message.Subscriber, error)
+	// newEphemeralSubscriber returns a subscriber which gets the messages
+	// published from its subscription on, it is nil for the same channels
+	newEphemeralSubscriber func() (message.Subscriber, error)
+	consumerGroup          string
+	// consumer names the consumer the channel is returned for by
+	// ForConsumer, it is empty for the channel of the service
+	consumer string
+}
+
+// ForConsumer returns a Channel whose subscribers get every message of the
+// topics independently of the other consumers of the channel, under their
+// own consumer group suffixed with name. The channels which already deliver
+// every message to every subscriber are returned with the name only. The
+// new subscriber is closed by the router it is registered to.
+func (c Channel) ForConsumer(name string) (Channel, error) {
+	if c.consumer != "" {
+		name = c.consumer + "_" + name
+	}
+	if c.newSubscriber == nil {
+		c.consumer = name
+		return c, nil
+	}
+	consumerGroup := c.consumerGroup + "_" + name
+	subscriber, err := c.newSubscriber(consumerGroup)
+	if err != nil {
+		return Channel{}, err
+	}
+	return Channel{
+		Publisher:              c.Publisher,
+		Subscriber:             subscriber,
+		newSubscriber:          c.newSubscriber,
+		newEphemeralSubscriber: c.newEphemeralSubscriber,
+		consumerGroup:          consumerGroup,
+		consumer:               name,
+	}, nil
+}
+
+// Ephemeral returns a Channel whose subscribers get the messages of the
+// topics published from their subscription on, independently of the other
+// consumers of the channel. Unlike ForConsumer, no position is kept for
+// name, so nothing is left behind by an instance that goes away and the
+// messages published before the subscription are never delivered. The
+// channels which already deliver every message to every subscriber are
+// returned with the name only. The new subscriber is closed by the router
+// it is registered to.
+func (c Channel) Ephemeral(name string) (Channel, error) {
+	if c.consumer != "" {
+		name = c.consumer + "_" + name
+	}
+	if c.newEphemeralSubscriber == nil {
+		c.consumer = name
+		return c, nil
+	}
+	subscriber, err := c.newEphemeralSubscriber()
+	if err != nil {
+		return Channel{}, err
+	}
+	return Channel{
+		Publisher:  c.Publisher,
+		Subscriber: subscriber,
+		consumer:   name,
+	}, nil
+}
+
+// Durable reports whether the channel keeps the published messages until
+// their consumer groups take them, the in-memory channel drops the messages
+// published while no subscriber runs
+func (c Channel) Durable() bool {
+	return c.newSubscriber != nil
+}
+
+// Close closes the publisher and the subscriber of the channel
+func (c Channel) Close() error {
+	if err := c.Publisher.Close(); err != nil {
+		return err
+	}
+	if interface{}(c.Subscriber) == interface{}(c.Publisher) {
+		return nil
+	}
+	return c.Subscriber.Close()
 }
 
 func NewStreamer() *Stream {
@@ -36,34 +128,30 @@ func NewStreamer() *Stream {
 
 var logger = watermill.NewStdLogger(false, false)
 
+// Logger returns the logger of the streams, the event handlers log through it
+func Logger() watermill.LoggerAdapter {
+	return logger
+}
+
+// CloseTimeout is how long a closed router waits for its handlers to finish
+const CloseTimeout = 30 * time.Second
+
+// NewRouter returns a router which runs until its context is cancelled or
+// it is closed, the shutdown is left to the application so the handlers
+// can be waited for in order with the rest of it
 func NewRouter() *WaterMillRouter {
 	router, err := message.NewRouter(message.RouterConfig{
-		CloseTimeout: time.Hour,
+		CloseTimeout: CloseTimeout,
 	}, logger)
 	if err != nil {
 		panic(err)
 	}
 
-	// SignalsHandler will gracefully shutdown Router when SIGTERM is received.
-	// You can also close the router by just calling `r.Close()`.
-	router.AddPlugin(plugin.SignalsHandler)
-
 	// Router level middleware are executed for every message sent to the router
 	router.AddMiddleware(
-		// CorrelationID will copy the correlation id from the incoming message's metadata to the produced messages
-		middleware.CorrelationID,
-
-		// The handler function is retried if it returns an error.
-		// After MaxRetries, the message is Nacked and it's up to the PubSub to resend it.
-		middleware.Retry{
-			MaxRetries:      3,
-			InitialInterval: time.Millisecond * 100,
-			Logger:          logger,
-		}.Middleware,
-
-		// Recoverer handles panics from handlers.
-		// In this case, it passes them as errors to the Retry middleware.
-		middleware.Recoverer,
+		// CorrelationID will copy the correlation id from the incoming message's metadata
+		// to its context and to the produced messages
+		CorrelationID,
 	)
 
 	return &WaterMillRouter{
@@ -71,41 +159,169 @@ func NewRouter() *WaterMillRouter {
 	}
 }
 
+// NewChannel returns an in-memory Channel, messages that are
+// not consumed yet are lost when the application stops
 func NewChannel() Channel {
+	goChannel := gochannel.NewGoChannel(gochannel.Config{}, logger)
 	return Channel{
-		gochannel.NewGoChannel(gochannel.Config{}, logger),
+		Publisher:  goChannel,
+		Subscriber: goChannel,
 	}
 }
 
-func (s *Stream) RegisterHandler(channel Channel, topicName string, handlerFunc message.NoPublishHandlerFunc) {
-	fmt.Println(topicName)
-	s.Router.AddNoPublisherHandler(
-		topicName+"_"+time.Now().UTC().Format(time.RFC3339),
+// NewPostgresChannel returns a Channel which stores the messages in the
+// given Postgres database, every topic gets its own messages table.
+// Subscribers acknowledge the messages under the given consumer group
+// and the offsets are stored in the database as well, so the messages
+// are delivered at least once and the consumption continues where it
+// was left after a restart.
+func NewPostgresChannel(db *sql.DB, consumerGroup string) (Channel, error) {
+	publisher, err := watermillSQL.NewPublisher(db, watermillSQL.PublisherConfig{
+		SchemaAdapter:        watermillSQL.DefaultPostgreSQLSchema{},
+		AutoInitializeSchema: true,
+	}, logger)
+	if err != nil {
+		return Channel{}, err
+	}
+
+	newSubscriber := func(consumerGroup string) (message.Subscriber, error) {
+		return watermillSQL.NewSubscriber(db, watermillSQL.SubscriberConfig{
+			ConsumerGroup:    consumerGroup,
+			SchemaAdapter:    watermillSQL.DefaultPostgreSQLSchema{},
+			OffsetsAdapter:   watermillSQL.DefaultPostgreSQLOffsetsAdapter{},
+			InitializeSchema: true,
+		}, logger)
+	}
+	subscriber, err := newSubscriber(consumerGroup)
+	if err != nil {
+		return Channel{}, err
+	}
+
+	return Channel{
+		Publisher:     publisher,
+		Subscriber:    subscriber,
+		newSubscriber: newSubscriber,
+		newEphemeralSubscriber: func() (message.Subscriber, error) {
+			return newPostgresEphemeralSubscriber(db)
+		},
+		consumerGroup: consumerGroup,
+	}, nil
+}
+
+// DeadLetterTopic returns the topic the messages of the given topic
+// are published to when their handler keeps failing
+func DeadLetterTopic(topic string) string {
+	return topic + "_dead_letter"
+}
+
+// RegisterHandler registers handlerFunc for the messages of the topic, the
+// messages it still fails on after the retries are published to the
+// DeadLetterTopic of the topic with the failure reason in their metadata.
+// The given middlewares run within the retries, once for every attempt.
+func (s *Stream) RegisterHandler(channel Channel, topicName string, handlerFunc message.NoPublishHandlerFunc, middlewares ...message.HandlerMiddleware) {
+	handler := s.Router.AddNoPublisherHandler(
+		handlerName(channel, topicName),
 		topicName,
 		channel,
 		handlerFunc,
 	)
+
+	// The dead letter middleware needs to wrap the retries,
+	// so it is added before them
+	deadLetter, err := middleware.PoisonQueue(channel, DeadLetterTopic(topicName))
+	if err != nil {
+		panic(err)
+	}
+	handler.AddMiddleware(deadLetter)
+	handler.AddMiddleware(retryMiddleware()...)
+	handler.AddMiddleware(middlewares...)
+}
+
+// RegisterDeadLetterHandler registers handlerFunc for the
+// messages published to the DeadLetterTopic of the topic
+func (s *Stream) RegisterDeadLetterHandler(channel Channel, topicName string, handlerFunc message.NoPublishHandlerFunc, middlewares ...message.HandlerMiddleware) {
+	deadLetterTopic := DeadLetterTopic(topicName)
+	handler := s.Router.AddNoPublisherHandler(
+		handlerName(channel, deadLetterTopic),
+		deadLetterTopic,
+		channel,
+		handlerFunc,
+	)
+	handler.AddMiddleware(retryMiddleware()...)
+	handler.AddMiddleware(middlewares...)
+}
+
+// handlerName returns the name of the handler of the topic on the channel,
+// a topic can have a handler for every consumer of the channel. The name
+// is the same on every start so the handlers can be told apart in the logs.
+func handlerName(channel Channel, topicName string) string {
+	if channel.consumer == "" {
+		return topicName
+	}
+	return channel.consumer + "_" + topicName
+}
+
+// retryMiddleware returns the middleware every handler is run with
+func retryMiddleware() []message.HandlerMiddleware {
+	return []message.HandlerMiddleware{
+		// The handler function is retried if it returns an error.
+		// After MaxRetries, the message is Nacked and it's up to the PubSub to resend it.
+		middleware.Retry{
+			MaxRetries:      3,
+			InitialInterval: time.Millisecond * 100,
+			Logger:          logger,
+		}.Middleware,
+
+		// Recoverer handles panics from handlers.
+		// In this case, it passes them as errors to the Retry middleware.
+		middleware.Recoverer,
+	}
 }
 
+// Message is the envelope of the events published on the channels,
+// Data holds the payload whose schema is identified by the EventName
+// and the Version of the event. CorrelationID links the event to the
+// request it is caused by, it goes to the metadata of the message.
 type Message struct {
-	EventName string
-	Data      interface{}
+	ID            string
+	CorrelationID string `json:"-"`
+	EventName     string
+	Version       int
+	OccurredAt    time.Time
+	AggregateType string
+	AggregateID   uint64
+	Data          interface{}
 }
 
+// NewMessage returns the watermill message of the given envelope, the
+// envelope gets the message's UUID as its ID and the defaults of its
+// Version, OccurredAt and CorrelationID when they are not set
 func NewMessage(m *Message) (*message.Message, error) {
+	if m.ID == "" {
+		m.ID = watermill.NewUUID()
+	}
+	if m.Version == 0 {
+		m.Version = 1
+	}
+	if m.OccurredAt.IsZero() {
+		m.OccurredAt = time.Now().UTC()
+	}
+	if m.CorrelationID == "" {
+		m.CorrelationID = watermill.NewUUID()
+	}
 	data, err := json.Marshal(m)
 	if err != nil {
 		return nil, err
 	}
-	msg := message.NewMessage(watermill.NewUUID(), data)
-	middleware.SetCorrelationID(watermill.NewUUID(), msg)
+	msg := message.NewMessage(m.ID, data)
+	middleware.SetCorrelationID(m.CorrelationID, msg)
 	return msg, nil
 }
 
 func PublishMessage(channel Channel, topic string, msg *message.Message) {
 	if err := channel.Publish(topic, msg); err != nil {
 		logger.Error("Couldn't publish the message", err, watermill.LogFields{
-			"CorrelationID": msg.UUID,
+			"CorrelationID": MessageCorrelationID(msg),
 		})
 	}
 }
@@ -0,0 +1,395 @@
+package streamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	natsServer "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/unicod3/horreum/pkg/dbclient"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewChannel_PublishSubscribe(t *testing.T) {
+	assert := assert.New(t)
+
+	channel := NewChannel()
+	messages, err := channel.Subscribe(context.Background(), "orders")
+	assert.Nil(err)
+
+	msg, err := NewMessage(&Message{EventName: "OrderCreated", Data: 1})
+	assert.Nil(err)
+	PublishMessage(channel, "orders", msg)
+
+	select {
+	case received := <-messages:
+		assert.Equal(msg.UUID, received.UUID)
+		received.Ack()
+	case <-time.After(time.Second):
+		t.Fatal("message is not delivered")
+	}
+	assert.Nil(channel.Close())
+}
+
+// TestNewPostgresChannel_ResumesAfterRestart runs against the database
+// given by the TEST_DATABASE_* environment variables and is skipped without it
+func TestNewPostgresChannel_ResumesAfterRestart(t *testing.T) {
+	host := os.Getenv("TEST_DATABASE_HOST")
+	if host == "" {
+		t.Skip("TEST_DATABASE_HOST is not set")
+	}
+	assert := assert.New(t)
+
+	client := dbclient.NewPostgresClient(
+		host,
+		os.Getenv("TEST_DATABASE_USER"),
+		os.Getenv("TEST_DATABASE_NAME"),
+		os.Getenv("TEST_DATABASE_PASS"))
+	topic := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	consumerGroup := watermill.NewShortUUID()
+
+	receive := func(channel Channel) string {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		messages, err := channel.Subscribe(ctx, topic)
+		assert.Nil(err)
+		select {
+		case received := <-messages:
+			received.Ack()
+			return received.UUID
+		case <-ctx.Done():
+			t.Fatal("message is not delivered")
+			return ""
+		}
+	}
+
+	channel, err := NewPostgresChannel(client.SQLDB(), consumerGroup)
+	assert.Nil(err)
+	first, _ := NewMessage(&Message{EventName: "OrderCreated", Data: 1})
+	second, _ := NewMessage(&Message{EventName: "OrderCreated", Data: 2})
+	assert.Nil(channel.Publish(topic, first, second))
+	assert.Equal(first.UUID, receive(channel))
+	assert.Nil(channel.Close())
+
+	// A new channel of the same consumer group continues after the acked message
+	channel, err = NewPostgresChannel(client.SQLDB(), consumerGroup)
+	assert.Nil(err)
+	assert.Equal(second.UUID, receive(channel))
+
+	// Another consumer gets the messages from the beginning
+	consumer, err := channel.ForConsumer("other")
+	assert.Nil(err)
+	assert.Equal(first.UUID, receive(consumer))
+	assert.Nil(consumer.Subscriber.Close())
+
+	// An ephemeral consumer only gets the messages published after it subscribed
+	ephemeral, err := channel.Ephemeral("sse")
+	assert.Nil(err)
+	third, _ := NewMessage(&Message{EventName: "OrderCreated", Data: 3})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		assert.Nil(channel.Publish(topic, third))
+	}()
+	assert.Equal(third.UUID, receive(ephemeral))
+	assert.Nil(ephemeral.Subscriber.Close())
+	assert.Nil(channel.Close())
+}
+
+func TestChannel_ForConsumer(t *testing.T) {
+	assert := assert.New(t)
+
+	channel := NewChannel()
+	consumer, err := channel.ForConsumer("webhooks")
+	assert.Nil(err)
+	assert.Equal(channel.Subscriber, consumer.Subscriber, "every subscriber of a gochannel gets every message")
+	assert.Equal("webhooks", consumer.consumer)
+	assert.False(consumer.Durable(), "a gochannel drops the messages nobody subscribes to")
+	assert.Nil(channel.Close())
+}
+
+func TestChannel_Ephemeral(t *testing.T) {
+	assert := assert.New(t)
+
+	channel := NewChannel()
+	consumer, err := channel.Ephemeral("sse")
+	assert.Nil(err)
+	assert.Equal(channel.Subscriber, consumer.Subscriber, "every subscriber of a gochannel gets the new messages")
+	assert.Equal("sse", consumer.consumer)
+	assert.Nil(channel.Close())
+}
+
+func TestEphemeralOffsetsAdapter(t *testing.T) {
+	assert := assert.New(t)
+
+	offsets := &ephemeralOffsetsAdapter{acked: map[string]int{}}
+	assert.Empty(offsets.SchemaInitializingQueries("orders"), "the offsets must not be stored")
+	query, _ := offsets.ConsumedMessageQuery("orders", 1, "", nil)
+	assert.Empty(query)
+
+	offsets.ack("orders", 7)
+	query, args := offsets.NextOffsetQuery("orders", "")
+	assert.Equal(`SELECT $1::BIGINT`, query)
+	assert.Equal([]interface{}{7}, args)
+
+	offsets.AckMessageQuery("orders", 8, "")
+	_, args = offsets.NextOffsetQuery("orders", "")
+	assert.Equal([]interface{}{8}, args)
+	_, args = offsets.NextOffsetQuery("products", "")
+	assert.Equal([]interface{}{0}, args, "the topics must have their own offsets")
+}
+
+func TestStream_RegisterHandlerTwiceOnTopic(t *testing.T) {
+	stream := NewStreamer()
+	channel := NewChannel()
+	webhooks, err := channel.ForConsumer("webhooks")
+	assert.Nil(t, err)
+	handler := func(msg *message.Message) error { return nil }
+
+	assert.NotPanics(t, func() {
+		stream.RegisterHandler(channel, "orders", handler)
+		stream.RegisterHandler(webhooks, "orders", handler)
+	}, "a topic can have a handler for every consumer")
+	assert.Equal(t, "orders", handlerName(channel, "orders"))
+	assert.Equal(t, "webhooks_orders", handlerName(webhooks, "orders"))
+	assert.Nil(t, channel.Close())
+}
+
+func TestStream_RegisterHandlerPublishesDeadLetters(t *testing.T) {
+	assert := assert.New(t)
+
+	stream := NewStreamer()
+	channel := NewChannel()
+	handlerErr := errors.New("insufficient stock")
+
+	var attempts int32
+	stream.RegisterHandler(channel, "orders", func(msg *message.Message) error {
+		atomic.AddInt32(&attempts, 1)
+		return handlerErr
+	})
+	deadLetters := make(chan *message.Message, 1)
+	stream.RegisterDeadLetterHandler(channel, "orders", func(msg *message.Message) error {
+		deadLetters <- msg
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go stream.Router.Run(ctx)
+	<-stream.Router.Running()
+
+	msg, err := NewMessage(&Message{EventName: "OrderShipped", Data: 1})
+	assert.Nil(err)
+	PublishMessage(channel, "orders", msg)
+
+	select {
+	case deadLetter := <-deadLetters:
+		assert.Equal(msg.UUID, deadLetter.UUID)
+		assert.Equal(handlerErr.Error(), deadLetter.Metadata.Get(middleware.ReasonForPoisonedKey))
+		assert.Equal("orders", deadLetter.Metadata.Get(middleware.PoisonedTopicKey))
+		assert.Equal(int32(4), atomic.LoadInt32(&attempts), "the handler must be retried first")
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not dead lettered")
+	}
+}
+
+// runNATSServer runs an embedded NATS server with JetStream
+// enabled for the test and returns its url
+func runNATSServer(t *testing.T) string {
+	server, err := natsServer.NewServer(&natsServer.Options{
+		Host:      "127.0.0.1",
+		Port:      natsServer.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoSigs:    true,
+		NoLog:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Start()
+	if !server.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats server is not ready")
+	}
+	t.Cleanup(server.Shutdown)
+	return server.ClientURL()
+}
+
+func TestNewNATSChannel(t *testing.T) {
+	url := runNATSServer(t)
+	topic := "orders"
+
+	conn, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// acked returns the number of the messages the durable consumer
+	// of the channel's consumer group got acked
+	acked := func(channel Channel) uint64 {
+		info, err := js.ConsumerInfo(topic, channel.consumerGroup)
+		if err != nil {
+			return 0
+		}
+		return info.AckFloor.Consumer
+	}
+
+	// receive returns the next message the channel gets,
+	// once its ack reached the durable consumer
+	receive := func(t *testing.T, channel Channel) (string, bool) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		before := acked(channel)
+		messages, err := channel.Subscribe(ctx, topic)
+		assert.Nil(t, err)
+		select {
+		case received := <-messages:
+			received.Ack()
+			for acked(channel) == before {
+				select {
+				case <-ctx.Done():
+					t.Fatal("message is not acked")
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+			return received.UUID, true
+		case <-ctx.Done():
+			return "", false
+		}
+	}
+
+	first, _ := NewMessage(&Message{EventName: "OrderCreated", Data: 1})
+	second, _ := NewMessage(&Message{EventName: "OrderCreated", Data: 2})
+
+	t.Run("Test can resume after restart", func(t *testing.T) {
+		assert := assert.New(t)
+
+		channel, err := NewNATSChannel(url, "horreum")
+		assert.Nil(err)
+		assert.Nil(channel.Publish(topic, first))
+		// A republished message is dropped as a duplicate
+		assert.Nil(channel.Publish(topic, first))
+		uuid, ok := receive(t, channel)
+		assert.True(ok)
+		assert.Equal(first.UUID, uuid)
+		assert.Nil(channel.Close())
+
+		// A new channel of the same consumer group continues after the acked message
+		channel, err = NewNATSChannel(url, "horreum")
+		assert.Nil(err)
+		assert.True(channel.Durable())
+		assert.Nil(channel.Publish(topic, second))
+		uuid, ok = receive(t, channel)
+		assert.True(ok)
+		assert.Equal(second.UUID, uuid)
+		_, ok = receive(t, channel)
+		assert.False(ok, "the duplicate must not be delivered")
+
+		// Another consumer gets the messages from the beginning
+		consumer, err := channel.ForConsumer("webhooks")
+		assert.Nil(err)
+		uuid, ok = receive(t, consumer)
+		assert.True(ok)
+		assert.Equal(first.UUID, uuid)
+		assert.Nil(consumer.Subscriber.Close())
+		assert.Nil(channel.Close())
+	})
+
+	t.Run("Test can handle once per consumer group", func(t *testing.T) {
+		assert := assert.New(t)
+
+		// Two instances of the same consumer group share the messages
+		instances := make([]Channel, 2)
+		received := make(chan string, 10)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		for i := range instances {
+			channel, err := NewNATSChannel(url, "cluster")
+			assert.Nil(err)
+			instances[i] = channel
+			messages, err := channel.Subscribe(ctx, topic)
+			assert.Nil(err)
+			go func() {
+				for msg := range messages {
+					received <- msg.UUID
+					msg.Ack()
+				}
+			}()
+		}
+
+		var uuids []string
+		for len(uuids) < 2 {
+			select {
+			case uuid := <-received:
+				uuids = append(uuids, uuid)
+			case <-time.After(5 * time.Second):
+				t.Fatal("messages are not delivered")
+			}
+		}
+		select {
+		case uuid := <-received:
+			t.Fatalf("message %s is handled twice", uuid)
+		case <-time.After(500 * time.Millisecond):
+		}
+		assert.ElementsMatch([]string{first.UUID, second.UUID}, uuids)
+
+		cancel()
+		for _, channel := range instances {
+			assert.Nil(channel.Close())
+		}
+	})
+
+	t.Run("Test ephemeral consumer gets the new messages only", func(t *testing.T) {
+		assert := assert.New(t)
+		topic := "article_stock"
+
+		channel, err := NewNATSChannel(url, "horreum")
+		assert.Nil(err)
+		old, _ := NewMessage(&Message{EventName: "ArticleStockChanged", Data: 1})
+		assert.Nil(channel.Publish(topic, old))
+
+		ephemeral, err := channel.Ephemeral("sse")
+		assert.Nil(err)
+		assert.False(ephemeral.Durable())
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		messages, err := ephemeral.Subscribe(ctx, topic)
+		assert.Nil(err)
+
+		latest, _ := NewMessage(&Message{EventName: "ArticleStockChanged", Data: 2})
+		assert.Nil(channel.Publish(topic, latest))
+		select {
+		case received := <-messages:
+			received.Ack()
+			assert.Equal(latest.UUID, received.UUID, "the messages published before must not be delivered")
+		case <-ctx.Done():
+			t.Fatal("message is not delivered")
+		}
+
+		assert.Nil(ephemeral.Subscriber.Close())
+		for {
+			info, err := js.StreamInfo(topic)
+			assert.Nil(err)
+			if info.State.Consumers == 0 {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				t.Fatal("the consumer is left behind")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		assert.Nil(channel.Close())
+	})
+}
@@ -0,0 +1,159 @@
+// Package xlsx writes single sheet XLSX workbooks row by row, the rows are
+// streamed into the archive as they are written so a workbook of any size
+// can be written without holding it in memory
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentType is the media type of the XLSX workbooks
+const ContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// Writer writes the rows of the sheet of a workbook, Close has to be
+// called after the last row to complete the workbook
+type Writer struct {
+	archive *zip.Writer
+	sheet   io.Writer
+	rows    int
+}
+
+// parts are the files of the workbook besides its sheet, a %s in their
+// content is replaced by the name of the sheet
+var parts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	// The second cell format shows the times, numFmtId 22 is the built-in "m/d/yy h:mm"
+	{"xl/styles.xml", xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="22" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs>` +
+		`</styleSheet>`},
+}
+
+// NewWriter starts a workbook on w whose only sheet has the given name
+func NewWriter(w io.Writer, sheetName string) (*Writer, error) {
+	archive := zip.NewWriter(w)
+	for _, part := range parts {
+		content := part.content
+		if strings.Contains(content, "%s") {
+			content = fmt.Sprintf(content, escape(sheetName))
+		}
+		f, err := archive.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, content); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(sheet, xml.Header+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{archive: archive, sheet: sheet}, nil
+}
+
+// Write writes a row of the sheet, the numbers, the booleans and the times
+// are written as such and any other value as the text fmt formats it to
+func (w *Writer) Write(values ...interface{}) error {
+	w.rows++
+	var row strings.Builder
+	fmt.Fprintf(&row, `<row r="%d">`, w.rows)
+	for i, v := range values {
+		ref := columnName(i) + strconv.Itoa(w.rows)
+		switch v := v.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			fmt.Fprintf(&row, `<c r="%s"><v>%v</v></c>`, ref, v)
+		case bool:
+			b := 0
+			if v {
+				b = 1
+			}
+			fmt.Fprintf(&row, `<c r="%s" t="b"><v>%d</v></c>`, ref, b)
+		case time.Time:
+			if v.IsZero() {
+				fmt.Fprintf(&row, `<c r="%s"/>`, ref)
+				continue
+			}
+			fmt.Fprintf(&row, `<c r="%s" s="1"><v>%s</v></c>`, ref, strconv.FormatFloat(serial(v), 'f', -1, 64))
+		default:
+			fmt.Fprintf(&row, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, escape(fmt.Sprint(v)))
+		}
+	}
+	row.WriteString(`</row>`)
+	_, err := io.WriteString(w.sheet, row.String())
+	return err
+}
+
+// Flush writes the buffered rows to the underlying writer
+func (w *Writer) Flush() error {
+	return w.archive.Flush()
+}
+
+// Close completes the sheet and the workbook, it doesn't close the underlying writer
+func (w *Writer) Close() error {
+	if _, err := io.WriteString(w.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return w.archive.Close()
+}
+
+// columnName returns the name of the column with given zero based index
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// epoch is the day the serial numbers of the times count from
+var epoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// serial returns the serial number of a time, the days since the epoch
+// with the time of the day as the fraction, times are written in UTC
+func serial(t time.Time) float64 {
+	d := t.UTC().Sub(epoch)
+	// Milliseconds are as precise as the spreadsheets get
+	return float64(d.Milliseconds()) / float64(24*time.Hour/time.Millisecond)
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
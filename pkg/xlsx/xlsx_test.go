@@ -0,0 +1,67 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "articles & co")
+	assert.Nil(err)
+	assert.Nil(w.Write("id", "name", "created_at"))
+	assert.Nil(w.Write(uint64(1), "leg <small>", time.Date(2022, 1, 2, 12, 0, 0, 0, time.UTC)))
+	assert.Nil(w.Write(int64(-2), true, time.Time{}))
+	assert.Nil(w.Flush())
+	assert.Nil(w.Close())
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(err)
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		assert.Nil(err)
+		content, err := io.ReadAll(r)
+		assert.Nil(err)
+		files[f.Name] = string(content)
+		// Every part is well formed
+		decoder := xml.NewDecoder(bytes.NewReader(content))
+		for {
+			_, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			assert.Nil(err, f.Name)
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	assert.Len(files, 6)
+	assert.Contains(files["xl/workbook.xml"], `<sheet name="articles &amp; co" sheetId="1" r:id="rId1"/>`)
+	sheet := files["xl/worksheets/sheet1.xml"]
+	assert.Contains(sheet, `<row r="1"><c r="A1" t="inlineStr"><is><t xml:space="preserve">id</t></is></c>`)
+	assert.Contains(sheet, `<c r="A2"><v>1</v></c><c r="B2" t="inlineStr"><is><t xml:space="preserve">leg &lt;small&gt;</t></is></c>`)
+	assert.Contains(sheet, `<c r="C2" s="1"><v>44563.5</v></c>`)
+	assert.Contains(sheet, `<row r="3"><c r="A3"><v>-2</v></c><c r="B3" t="b"><v>1</v></c><c r="C3"/></row>`)
+}
+
+func TestColumnName(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("A", columnName(0))
+	assert.Equal("Z", columnName(25))
+	assert.Equal("AA", columnName(26))
+	assert.Equal("AZ", columnName(51))
+	assert.Equal("BA", columnName(52))
+	assert.Equal("ZZ", columnName(701))
+	assert.Equal("AAA", columnName(702))
+}